@@ -0,0 +1,86 @@
+package mosaic
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/farshidrezaei/mosaic/encoder"
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// defaultAudioDurationTolerance is how far a sidecar audio track may end from
+// the end of the video before the job is rejected.
+const defaultAudioDurationTolerance = 2 * time.Second
+
+// prepareAudioInputs probes every sidecar audio input, checks that it lines up
+// with the video, and converts it for the encoder. Tracks that run past the end
+// of the video within the tolerance are trimmed to it.
+func prepareAudioInputs(
+	ctx context.Context,
+	inputs []AudioInput,
+	info probe.VideoInfo,
+	exec executor.CommandExecutor,
+	opts *options,
+) ([]encoder.AudioInput, error) {
+	var out []encoder.AudioInput
+	for _, in := range inputs {
+		if in.Path == "" {
+			return nil, fmt.Errorf("audio input: path is required")
+		}
+
+		a, err := probe.AudioWithExecutor(ctx, in.Path, exec)
+		if err != nil {
+			return nil, fmt.Errorf("probe audio input %q: %w", in.Path, err)
+		}
+
+		offset := in.Offset.Seconds()
+		enc := encoder.AudioInput{
+			Path:     in.Path,
+			Language: in.Language,
+			Title:    in.Title,
+			Role:     in.Role,
+			Offset:   offset,
+		}
+
+		// Durations are unknown for some live and piped sources; skip the check then.
+		if info.Duration > 0 && a.Duration > 0 {
+			end := offset + a.Duration
+			if opts.audioDurationTolerance >= 0 && math.Abs(end-info.Duration) > opts.audioDurationTolerance.Seconds() {
+				return nil, fmt.Errorf(
+					"audio input %q: ends at %.3fs but the video is %.3fs long (tolerance %s)",
+					in.Path, end, info.Duration, opts.audioDurationTolerance,
+				)
+			}
+			if end > info.Duration {
+				enc.Duration = info.Duration - max(offset, 0)
+				opts.logger.Debug("trimming audio input", "path", in.Path, "duration", enc.Duration)
+			}
+		}
+
+		out = append(out, enc)
+	}
+	return out, nil
+}
+
+// withAudioInputs returns info with a track for each audio input added to its
+// audio streams, so cost estimates count every audio track a job encodes.
+func withAudioInputs(info probe.VideoInfo, inputs []AudioInput) probe.VideoInfo {
+	if len(inputs) == 0 {
+		return info
+	}
+	streams := slices.Clone(info.AudioStreams)
+	if len(streams) == 0 && info.HasAudio {
+		// A source audio track that was not described.
+		streams = append(streams, probe.AudioStream{})
+	}
+	for _, in := range inputs {
+		streams = append(streams, probe.AudioStream{Language: in.Language})
+	}
+	info.AudioStreams = streams
+	info.HasAudio = true
+	return info
+}
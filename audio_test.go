@@ -0,0 +1,161 @@
+package mosaic
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// audioInputMock answers ffprobe calls by input path and records ffmpeg calls.
+type audioInputMock struct {
+	probes      map[string]string
+	ffmpegCalls [][]string
+}
+
+func (m *audioInputMock) Execute(ctx context.Context, name string, args ...string) ([]byte, *executor.Usage, error) {
+	return m.ExecuteWithProgress(ctx, nil, name, args...)
+}
+
+func (m *audioInputMock) ExecuteWithProgress(ctx context.Context, progress chan<- string, name string, args ...string) ([]byte, *executor.Usage, error) {
+	if progress != nil {
+		close(progress)
+	}
+	if name == "ffmpeg" {
+		m.ffmpegCalls = append(m.ffmpegCalls, args)
+		return nil, &executor.Usage{}, nil
+	}
+	out, ok := m.probes[args[len(args)-1]]
+	if !ok {
+		return nil, nil, context.DeadlineExceeded
+	}
+	return []byte(out), nil, nil
+}
+
+func TestPrepareAudioInputs(t *testing.T) {
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, Duration: 60}
+	mock := &audioInputMock{probes: map[string]string{
+		"exact.wav":  `{"streams":[{"index":0,"codec_type":"audio","codec_name":"pcm_s16le","channels":2}],"format":{"duration":"60.000"}}`,
+		"long.wav":   `{"streams":[{"index":0,"codec_type":"audio","codec_name":"aac","channels":2}],"format":{"duration":"61.500"}}`,
+		"short.wav":  `{"streams":[{"index":0,"codec_type":"audio","codec_name":"aac","channels":2}],"format":{"duration":"50.000"}}`,
+		"silent.mp4": `{"streams":[],"format":{"duration":"60.000"}}`,
+	}}
+
+	tests := []struct {
+		name         string
+		input        AudioInput
+		tolerance    time.Duration
+		wantDuration float64
+		wantErr      bool
+	}{
+		{name: "aligned", input: AudioInput{Path: "exact.wav"}, tolerance: defaultAudioDurationTolerance},
+		{name: "longer within tolerance is trimmed", input: AudioInput{Path: "long.wav"}, tolerance: defaultAudioDurationTolerance, wantDuration: 60},
+		{name: "offset trims the remainder", input: AudioInput{Path: "exact.wav", Offset: time.Second}, tolerance: defaultAudioDurationTolerance, wantDuration: 59},
+		{name: "negative offset", input: AudioInput{Path: "long.wav", Offset: -1500 * time.Millisecond}, tolerance: defaultAudioDurationTolerance},
+		{name: "duration mismatch", input: AudioInput{Path: "short.wav"}, tolerance: defaultAudioDurationTolerance, wantErr: true},
+		{name: "check disabled", input: AudioInput{Path: "short.wav"}, tolerance: -1},
+		{name: "no audio stream", input: AudioInput{Path: "silent.mp4"}, tolerance: defaultAudioDurationTolerance, wantErr: true},
+		{name: "probe failure", input: AudioInput{Path: "missing.wav"}, tolerance: defaultAudioDurationTolerance, wantErr: true},
+		{name: "missing path", input: AudioInput{}, tolerance: defaultAudioDurationTolerance, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := defaultOptions()
+			o.audioDurationTolerance = tt.tolerance
+
+			got, err := prepareAudioInputs(context.Background(), []AudioInput{tt.input}, info, mock, o)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("prepareAudioInputs() err=%v wantErr=%v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got[0].Duration != tt.wantDuration {
+				t.Errorf("Duration=%v want %v", got[0].Duration, tt.wantDuration)
+			}
+			if got[0].Offset != tt.input.Offset.Seconds() {
+				t.Errorf("Offset=%v want %v", got[0].Offset, tt.input.Offset.Seconds())
+			}
+		})
+	}
+}
+
+func TestWithAudioInputs(t *testing.T) {
+	inputs := []AudioInput{{Path: "deu.m4a", Language: "deu"}, {Path: "ad.m4a", Language: "eng"}}
+	tests := []struct {
+		name   string
+		info   probe.VideoInfo
+		inputs []AudioInput
+		want   int
+	}{
+		{name: "no inputs", info: probe.VideoInfo{HasAudio: true}, want: 0},
+		{name: "described source tracks", info: probe.VideoInfo{HasAudio: true, AudioStreams: []probe.AudioStream{{Index: 1}}}, inputs: inputs, want: 3},
+		{name: "undescribed source track", info: probe.VideoInfo{HasAudio: true}, inputs: inputs, want: 3},
+		{name: "silent source", inputs: inputs, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := withAudioInputs(tt.info, tt.inputs)
+			if len(got.AudioStreams) != tt.want {
+				t.Errorf("got %d audio streams, want %d", len(got.AudioStreams), tt.want)
+			}
+			if len(tt.inputs) > 0 && (!got.HasAudio || got.AudioStreams[len(got.AudioStreams)-1].Language != "eng") {
+				t.Errorf("audio inputs not added: %+v", got)
+			}
+		})
+	}
+}
+
+func TestEncodeWithAudioInputs(t *testing.T) {
+	newMock := func() *audioInputMock {
+		return &audioInputMock{probes: map[string]string{
+			"in.mp4":  `{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1"}],"format":{"duration":"60.0"}}`,
+			"dub.wav": `{"streams":[{"index":0,"codec_type":"audio","codec_name":"pcm_s16le","channels":2}],"format":{"duration":"60.2"}}`,
+			"ad.wav":  `{"streams":[{"index":0,"codec_type":"audio","codec_name":"aac","channels":2}],"format":{"duration":"60.0"}}`,
+		}}
+	}
+	job := Job{
+		Input:     "in.mp4",
+		OutputDir: t.TempDir(),
+		AudioInputs: []AudioInput{
+			{Path: "dub.wav", Language: "deu", Role: config.AudioRoleDub},
+			{Path: "ad.wav", Language: "eng", Role: config.AudioRoleDescription},
+		},
+	}
+
+	for name, encode := range map[string]func(context.Context, Job, executor.CommandExecutor, ...Option) (*executor.Usage, error){
+		"HLS":  EncodeHlsWithExecutor,
+		"DASH": EncodeDashWithExecutor,
+	} {
+		t.Run(name, func(t *testing.T) {
+			mock := newMock()
+			if _, err := encode(context.Background(), job, mock); err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+			if len(mock.ffmpegCalls) != 1 {
+				t.Fatalf("expected one ffmpeg call, got %d", len(mock.ffmpegCalls))
+			}
+			args := strings.Join(mock.ffmpegCalls[0], " ")
+			for _, want := range []string{"-t 60.000 -i dub.wav -i ad.wav", "-map 1:a:0", "-map 2:a:0"} {
+				if !strings.Contains(args, want) {
+					t.Errorf("expected args to contain %q, got %s", want, args)
+				}
+			}
+		})
+	}
+
+	t.Run("mismatch fails before encoding", func(t *testing.T) {
+		mock := newMock()
+		if _, err := EncodeHlsWithExecutor(context.Background(), job, mock, WithAudioDurationTolerance(100*time.Millisecond)); err == nil {
+			t.Fatal("expected duration mismatch error")
+		}
+		if len(mock.ffmpegCalls) != 0 {
+			t.Error("expected no ffmpeg call")
+		}
+	})
+}
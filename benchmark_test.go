@@ -14,7 +14,7 @@ func BenchmarkInitialize(b *testing.B) {
 	mock := &executor.MockCommandExecutor{
 		Responses: map[string]executor.MockResponse{
 			"ffprobe": {
-				Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
+				Output: []byte(`{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
 			},
 		},
 	}
@@ -48,7 +48,7 @@ func BenchmarkProbe(b *testing.B) {
 	mock := &executor.MockCommandExecutor{
 		Responses: map[string]executor.MockResponse{
 			"ffprobe": {
-				Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
+				Output: []byte(`{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
 			},
 		},
 	}
@@ -0,0 +1,17 @@
+package config
+
+// AudioRole describes the purpose of an audio track in the packaged output.
+type AudioRole string
+
+const (
+	// AudioRoleMain is the primary program audio.
+	AudioRoleMain AudioRole = "main"
+	// AudioRoleAlternate is an additional original-language track, such as a
+	// second embedded audio stream in the source.
+	AudioRoleAlternate AudioRole = "alternate"
+	// AudioRoleDub is a dubbed, translated version of the program audio.
+	AudioRoleDub AudioRole = "dub"
+	// AudioRoleDescription is an audio description track for blind and visually
+	// impaired viewers. It is signalled as an accessibility track in HLS and DASH.
+	AudioRoleDescription AudioRole = "description"
+)
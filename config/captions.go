@@ -0,0 +1,17 @@
+package config
+
+// CaptionService describes a CEA-608 channel or CEA-708 service carried in
+// the video elementary stream.
+type CaptionService struct {
+	// InstreamID is "CC1" to "CC4" for CEA-608 or "SERVICE1" to "SERVICE63" for CEA-708.
+	InstreamID string
+	// Language is the language tag of the service (e.g., "eng"), empty if unknown.
+	Language string
+	// Name is the human-readable name shown by players. It defaults to the
+	// language, then the InstreamID.
+	Name string
+}
+
+// DefaultCaptionService is signalled when embedded captions are detected but
+// no services are configured.
+var DefaultCaptionService = CaptionService{InstreamID: "CC1"}
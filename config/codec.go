@@ -0,0 +1,13 @@
+package config
+
+// Codec represents the video codec family used for the encoded renditions.
+type Codec string
+
+const (
+	// CodecH264 encodes renditions as H.264/AVC. This is the default.
+	CodecH264 Codec = "h264"
+	// CodecHEVC encodes renditions as H.265/HEVC, tagged as hvc1 for Apple player compatibility.
+	CodecHEVC Codec = "hevc"
+	// CodecAV1 encodes renditions as AV1 using SVT-AV1, falling back to libaom.
+	CodecAV1 Codec = "av1"
+)
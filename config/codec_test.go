@@ -0,0 +1,12 @@
+package config
+
+import "testing"
+
+func TestCodecValues(t *testing.T) {
+	if CodecH264 != "h264" {
+		t.Errorf("expected CodecH264 to be h264, got %s", CodecH264)
+	}
+	if CodecHEVC != "hevc" {
+		t.Errorf("expected CodecHEVC to be hevc, got %s", CodecHEVC)
+	}
+}
@@ -0,0 +1,14 @@
+package config
+
+// VideoRange is the dynamic range of a video stream, named after the HLS
+// VIDEO-RANGE attribute values.
+type VideoRange string
+
+const (
+	// VideoRangeSDR is standard dynamic range (BT.709/BT.601 transfer).
+	VideoRangeSDR VideoRange = "SDR"
+	// VideoRangePQ is HDR with the SMPTE ST 2084 (PQ) transfer, as used by HDR10.
+	VideoRangePQ VideoRange = "PQ"
+	// VideoRangeHLG is HDR with the ARIB STD-B67 hybrid log-gamma transfer.
+	VideoRangeHLG VideoRange = "HLG"
+)
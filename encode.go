@@ -7,7 +7,9 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/farshidrezaei/mosaic/config"
 	"github.com/farshidrezaei/mosaic/encoder"
@@ -22,19 +24,42 @@ import (
 type Option func(*options)
 
 type options struct {
-	logger               *slog.Logger
-	gpu                  config.GPUType
-	logLevel             string
-	threads              int
-	normalizeOrientation bool
+	logger                 *slog.Logger
+	sourceCap              *bool
+	optimizer              optimize.Strategy
+	ladder                 []ladder.Rendition
+	extraCodecs            []codecFamily
+	captions               []config.CaptionService
+	gpu                    config.GPUType
+	codec                  config.Codec
+	logLevel               string
+	ladderPreset           string
+	threads                int
+	av1Preset              int
+	ladderPolicy           ladder.SourcePolicy
+	audioDurationTolerance time.Duration
+	sourceHeadroom         float64
+	normalizeOrientation   bool
+	hdr                    bool
+	padding                bool
+	lowBandwidth           bool
+}
+
+// codecFamily is an additional codec encoded for the ladder rungs at or above minHeight.
+type codecFamily struct {
+	codec     config.Codec
+	minHeight int
 }
 
 func defaultOptions() *options {
 	return &options{
-		threads:  0, // auto
-		gpu:      "",
-		logLevel: "warning",
-		logger:   slog.Default(),
+		threads:   0, // auto
+		gpu:       "",
+		logLevel:  "warning",
+		logger:    slog.Default(),
+		optimizer: optimize.Static{},
+
+		audioDurationTolerance: defaultAudioDurationTolerance,
 	}
 }
 
@@ -50,6 +75,52 @@ func WithNormalizeOrientation(enabled ...bool) Option {
 	}
 }
 
+// WithHDR keeps PQ (HDR10) and HLG sources in HDR. HEVC and AV1 renditions are
+// encoded as 10-bit BT.2020 with the source's transfer, mastering display and
+// content light level metadata, and signalled with VIDEO-RANGE in the HLS
+// master playlist and CICP color descriptors in the DASH manifest. When no
+// codec is selected, HDR sources are encoded as HEVC. A tone-mapped SDR H.264
+// copy of the ladder is added for devices without HDR support. SDR sources are
+// unaffected. If called without arguments, it enables HDR mode.
+func WithHDR(enabled ...bool) Option {
+	return func(o *options) {
+		if len(enabled) == 0 {
+			o.hdr = true
+			return
+		}
+		o.hdr = enabled[0]
+	}
+}
+
+// WithPadding keeps every rendition of the built-in ladder at its full 16:9
+// (or 9:16) size and letterboxes or pillarboxes sources of other aspect
+// ratios into it. By default, renditions are sized to the source display
+// aspect ratio (ladder.FitAspect) and encoded without black bars. If called
+// without arguments, it enables padding.
+func WithPadding(enabled ...bool) Option {
+	return func(o *options) {
+		if len(enabled) == 0 {
+			o.padding = true
+			return
+		}
+		o.padding = enabled[0]
+	}
+}
+
+// WithLowBandwidthRungs adds 270p, 234p and 144p renditions below the
+// built-in ladder (ladder.AddLowBandwidth) for viewers on 2G and congested
+// mobile networks. Sources below 360p get them without this option. If
+// called without arguments, it enables the low-bandwidth rungs.
+func WithLowBandwidthRungs(enabled ...bool) Option {
+	return func(o *options) {
+		if len(enabled) == 0 {
+			o.lowBandwidth = true
+			return
+		}
+		o.lowBandwidth = enabled[0]
+	}
+}
+
 // WithThreads sets the number of CPU threads to use for encoding.
 // Set to 0 (default) to let FFmpeg auto-detect the optimal number of threads.
 func WithThreads(n int) Option {
@@ -91,6 +162,111 @@ func WithVideoToolbox() Option {
 	}
 }
 
+// WithCodec selects the video codec for all renditions.
+// The default is config.CodecH264.
+func WithCodec(c config.Codec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// WithLadder replaces the built-in ladder with l. The ladder is checked with
+// ladder.Validate before encoding, rotated to match the source orientation and
+// fitted to the source with ladder.Fit; policy selects what happens to
+// renditions larger than the source and defaults to ladder.DropLarger.
+// Renditions are then shrunk to the source display aspect ratio with
+// ladder.FitAspect, unless WithPadding is set.
+// Custom ladders are encoded as given, without bitrate capping or rung trimming.
+func WithLadder(l []ladder.Rendition, policy ...ladder.SourcePolicy) Option {
+	return func(o *options) {
+		o.ladder = slices.Clone(l)
+		o.ladderPreset = ""
+		o.ladderPolicy = ladder.DropLarger
+		if len(policy) > 0 {
+			o.ladderPolicy = policy[0]
+		}
+	}
+}
+
+// WithLadderPreset is like WithLadder with the renditions of the named preset
+// from the ladder package registry, e.g. "apple-hls-authoring". Encoding fails
+// when no preset with that name is registered.
+func WithLadderPreset(name string, policy ...ladder.SourcePolicy) Option {
+	return func(o *options) {
+		WithLadder(nil, policy...)(o)
+		o.ladderPreset = name
+	}
+}
+
+// WithOptimizer selects the Strategy that sets the bitrates of the built-in
+// ladder. The default, optimize.Static, caps bitrates by height;
+// optimize.PerTitle runs trial encodes of sampled scenes to fit the bitrates to
+// the content. Custom ladders from WithLadder are not optimized.
+func WithOptimizer(s optimize.Strategy) Option {
+	return func(o *options) {
+		o.optimizer = s
+	}
+}
+
+// WithSourceBitrateCap caps the bitrates of the built-in ladder relative to
+// the source video bitrate with optimize.CapToSource, so a low-bitrate source
+// is not re-encoded at more bits than it has, and drops the rungs that would
+// be bit-starved duplicates. headroom is the factor by which a rung may exceed
+// the source and defaults to optimize.DefaultSourceHeadroom. The cap is on by
+// default for VOD and off for live; it has no effect when the source bitrate
+// is unknown or on custom ladders from WithLadder.
+func WithSourceBitrateCap(enabled bool, headroom ...float64) Option {
+	return func(o *options) {
+		o.sourceCap = &enabled
+		o.sourceHeadroom = 0
+		if len(headroom) > 0 {
+			o.sourceHeadroom = headroom[0]
+		}
+	}
+}
+
+// WithAdditionalCodec encodes every ladder rung whose shorter side is at least
+// minHeight a second time with codec, in the same output. The master playlist
+// lists all variants with their CODECS and the DASH manifest places each codec
+// in its own AdaptationSet, so players pick the best codec they support.
+// It can be called multiple times to add several codec families.
+func WithAdditionalCodec(codec config.Codec, minHeight int) Option {
+	return func(o *options) {
+		o.extraCodecs = append(o.extraCodecs, codecFamily{codec: codec, minHeight: minHeight})
+	}
+}
+
+// WithAV1Preset sets the SVT-AV1 speed preset (0-13, lower is slower and better)
+// used with config.CodecAV1. When falling back to libaom-av1 it is clamped to cpu-used 0-8.
+// The default is encoder.DefaultAV1Preset.
+func WithAV1Preset(preset int) Option {
+	return func(o *options) {
+		o.av1Preset = preset
+	}
+}
+
+// WithAudioDurationTolerance sets how far the end of a Job.AudioInputs track
+// may differ from the end of the video before encoding fails. Tracks that run
+// longer within the tolerance are trimmed. A negative value disables the check.
+// The default is 2 seconds.
+func WithAudioDurationTolerance(d time.Duration) Option {
+	return func(o *options) {
+		o.audioDurationTolerance = d
+	}
+}
+
+// WithClosedCaptions declares the CEA-608 channels and CEA-708 services embedded
+// in the source video, so they are signalled with their language and name.
+// Captions are carried through the encode and signalled in the HLS master
+// playlist and DASH manifest. Without this option, detected captions are
+// signalled as config.DefaultCaptionService (CC1). Declaring services also
+// signals captions the probe did not detect, e.g. when they start late.
+func WithClosedCaptions(services ...config.CaptionService) Option {
+	return func(o *options) {
+		o.captions = append(o.captions, services...)
+	}
+}
+
 // WithLogLevel sets the FFmpeg log level (e.g., "quiet", "error", "warning", "info", "debug").
 // The default is "warning".
 func WithLogLevel(level string) Option {
@@ -113,16 +289,115 @@ func initialize(ctx context.Context, job Job, opts *options) (probe.VideoInfo, c
 
 func initializeWithExecutor(ctx context.Context, job Job, exec executor.CommandExecutor, opts *options) (probe.VideoInfo, config.Profile, []ladder.Rendition, error) {
 	// 1. Probe
-	info, err := probe.InputWithExecutor(ctx, job.Input, exec)
+	res, err := probe.RunWithExecutor(ctx, job.Input, exec)
 	if err != nil {
 		return probe.VideoInfo{}, config.Profile{}, []ladder.Rendition{}, err
 	}
+	return initializeFromProbe(ctx, job, res, exec, opts)
+}
+
+// ladderChecker is implemented by strategies, such as optimize.Budget, with
+// constraints the final ladder must still meet.
+type ladderChecker interface {
+	Check(l []ladder.Rendition, info probe.VideoInfo) error
+}
+
+// initializeFromProbe builds the ladder and profile of a job from the probe
+// of its input.
+func initializeFromProbe(ctx context.Context, job Job, res probe.Result, exec executor.CommandExecutor, opts *options) (probe.VideoInfo, config.Profile, []ladder.Rendition, error) {
+	info, err := res.VideoInfo()
+	if err != nil {
+		return probe.VideoInfo{}, config.Profile{}, []ladder.Rendition{}, err
+	}
+	opts.logger.Debug("probed source",
+		"format", info.Format,
+		"codec", info.Codec,
+		"profile", info.Profile,
+		"level", info.LevelName(),
+		"width", info.Width,
+		"height", info.Height,
+		"fps", info.FPS,
+		"interlaced", info.Interlaced(),
+		"vfr", info.VariableFrameRate(),
+	)
+
+	// HDR survives only on HEVC and AV1, so HDR mode defaults HDR sources to HEVC.
+	if opts.hdr && opts.codec == "" && info.VideoRange() != config.VideoRangeSDR {
+		opts.codec = config.CodecHEVC
+	}
 
 	// build ladder
-	l := ladder.Build(info)
+	var l []ladder.Rendition
+	if opts.ladderPreset != "" {
+		p, ok := ladder.LookupPreset(opts.ladderPreset)
+		if !ok {
+			return probe.VideoInfo{}, config.Profile{}, []ladder.Rendition{}, fmt.Errorf("unknown ladder preset %q", opts.ladderPreset)
+		}
+		opts.ladder = p.Renditions
+	}
+	if opts.ladder != nil {
+		if err := ladder.Validate(opts.ladder); err != nil {
+			return probe.VideoInfo{}, config.Profile{}, []ladder.Rendition{}, fmt.Errorf("invalid ladder: %w", err)
+		}
+		l = ladder.Fit(opts.ladder, info, opts.ladderPolicy)
+		if !opts.padding {
+			l = ladder.FitAspect(l, info)
+		}
+	} else {
+		if opts.padding {
+			l, err = ladder.BuildPadded(info)
+		} else {
+			l, err = ladder.Build(info)
+		}
+		if err != nil {
+			return probe.VideoInfo{}, config.Profile{}, []ladder.Rendition{}, fmt.Errorf("build ladder: %w", err)
+		}
+
+		// cost optimizer
+		l, err = opts.optimizer.Optimize(ctx, exec, job.Input, withAudioInputs(info, job.AudioInputs), l)
+		if err != nil {
+			return probe.VideoInfo{}, config.Profile{}, []ladder.Rendition{}, fmt.Errorf("optimize ladder: %w", err)
+		}
 
-	// cost optimizer
-	l = optimize.Apply(l)
+		// source bitrate cap, on by default for VOD
+		capped := job.Profile != ProfileLive
+		if opts.sourceCap != nil {
+			capped = *opts.sourceCap
+		}
+		if capped {
+			l = optimize.CapToSource(l, info, opts.sourceHeadroom)
+		}
+
+		if opts.lowBandwidth {
+			l, err = ladder.AddLowBandwidth(l)
+			if err != nil {
+				return probe.VideoInfo{}, config.Profile{}, []ladder.Rendition{}, fmt.Errorf("build ladder: %w", err)
+			}
+		}
+	}
+
+	// additional codec families
+	for _, f := range opts.extraCodecs {
+		l = ladder.AddCodec(l, f.codec, f.minHeight)
+	}
+
+	// tone-mapped SDR fallback for devices without HDR support
+	if opts.hdr && info.VideoRange() != config.VideoRangeSDR && opts.codec != config.CodecH264 {
+		l = ladder.AddSDR(l)
+	}
+
+	// levels at the frame rate each rendition is encoded at
+	l, err = ladder.FitLevels(l, opts.codec, info.FPS)
+	if err != nil {
+		return probe.VideoInfo{}, config.Profile{}, []ladder.Rendition{}, fmt.Errorf("invalid ladder: %w", err)
+	}
+
+	// budgets cover custom ladders and the rungs added after the optimizer
+	if c, ok := opts.optimizer.(ladderChecker); ok {
+		if err := c.Check(l, withAudioInputs(info, job.AudioInputs)); err != nil {
+			return probe.VideoInfo{}, config.Profile{}, []ladder.Rendition{}, fmt.Errorf("optimize ladder: %w", err)
+		}
+	}
 
 	// profile
 	var profile config.Profile
@@ -154,7 +429,7 @@ func EncodeHlsWithExecutor(ctx context.Context, job Job, exec executor.CommandEx
 		opt(o)
 	}
 
-	effectiveInput, cleanupInput, err := prepareInputForEncoding(ctx, job.Input, exec, o)
+	effectiveInput, res, cleanupInput, err := prepareInputForEncoding(ctx, job.Input, exec, o)
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +438,11 @@ func EncodeHlsWithExecutor(ctx context.Context, job Job, exec executor.CommandEx
 	effectiveJob := job
 	effectiveJob.Input = effectiveInput
 
-	info, profile, l, err := initializeWithExecutor(ctx, effectiveJob, exec, o)
+	info, profile, l, err := initializeFromProbe(ctx, effectiveJob, res, exec, o)
+	if err != nil {
+		return nil, err
+	}
+	audioInputs, err := prepareAudioInputs(ctx, job.AudioInputs, info, exec, o)
 	if err != nil {
 		return nil, err
 	}
@@ -187,9 +466,16 @@ func EncodeHlsWithExecutor(ctx context.Context, job Job, exec executor.CommandEx
 			}
 		},
 		encoder.EncoderOptions{
-			Threads:  o.threads,
-			GPU:      o.gpu,
-			LogLevel: o.logLevel,
+			Threads:        o.threads,
+			GPU:            o.gpu,
+			Codec:          o.codec,
+			LogLevel:       o.logLevel,
+			AV1Preset:      o.av1Preset,
+			AudioInputs:    audioInputs,
+			SubtitleInputs: subtitleInputs(job.SubtitleInputs),
+			ClosedCaptions: o.captions,
+			HDR:            o.hdr,
+			Pad:            o.padding,
 		},
 	)
 }
@@ -209,7 +495,7 @@ func EncodeDashWithExecutor(ctx context.Context, job Job, exec executor.CommandE
 		opt(o)
 	}
 
-	effectiveInput, cleanupInput, err := prepareInputForEncoding(ctx, job.Input, exec, o)
+	effectiveInput, res, cleanupInput, err := prepareInputForEncoding(ctx, job.Input, exec, o)
 	if err != nil {
 		return nil, err
 	}
@@ -218,7 +504,11 @@ func EncodeDashWithExecutor(ctx context.Context, job Job, exec executor.CommandE
 	effectiveJob := job
 	effectiveJob.Input = effectiveInput
 
-	info, profile, l, err := initializeWithExecutor(ctx, effectiveJob, exec, o)
+	info, profile, l, err := initializeFromProbe(ctx, effectiveJob, res, exec, o)
+	if err != nil {
+		return nil, err
+	}
+	audioInputs, err := prepareAudioInputs(ctx, job.AudioInputs, info, exec, o)
 	if err != nil {
 		return nil, err
 	}
@@ -242,40 +532,55 @@ func EncodeDashWithExecutor(ctx context.Context, job Job, exec executor.CommandE
 			}
 		},
 		encoder.EncoderOptions{
-			Threads:  o.threads,
-			GPU:      o.gpu,
-			LogLevel: o.logLevel,
+			Threads:        o.threads,
+			GPU:            o.gpu,
+			Codec:          o.codec,
+			LogLevel:       o.logLevel,
+			AV1Preset:      o.av1Preset,
+			AudioInputs:    audioInputs,
+			SubtitleInputs: subtitleInputs(job.SubtitleInputs),
+			ClosedCaptions: o.captions,
+			HDR:            o.hdr,
+			Pad:            o.padding,
 		},
 	)
 }
 
+// prepareInputForEncoding returns the input to encode, normalized when
+// WithNormalizeOrientation is set, with its probe, so each input is probed
+// once.
 func prepareInputForEncoding(
 	ctx context.Context,
 	inputPath string,
 	exec executor.CommandExecutor,
 	opts *options,
-) (string, func(), error) {
+) (string, probe.Result, func(), error) {
 	if !opts.normalizeOrientation {
-		return inputPath, func() {}, nil
+		res, err := probe.RunWithExecutor(ctx, inputPath, exec)
+		if err != nil {
+			return "", probe.Result{}, nil, err
+		}
+		return inputPath, res, func() {}, nil
 	}
 
 	tmpFile, err := os.CreateTemp(os.TempDir(), "mosaic-normalized-*"+normalizedInputExt(inputPath))
 	if err != nil {
-		return "", nil, fmt.Errorf("create temp normalized input: %w", err)
+		return "", probe.Result{}, nil, fmt.Errorf("create temp normalized input: %w", err)
 	}
 	tmpPath := tmpFile.Name()
 	if err := tmpFile.Close(); err != nil {
 		_ = os.Remove(tmpPath)
-		return "", nil, fmt.Errorf("close temp normalized input: %w", err)
+		return "", probe.Result{}, nil, fmt.Errorf("close temp normalized input: %w", err)
 	}
 
 	cleanup := func() { _ = os.Remove(tmpPath) }
-	if err := normalizeRotationWithExecutor(ctx, inputPath, tmpPath, exec); err != nil {
+	res, err := normalizeRotationWithExecutor(ctx, inputPath, tmpPath, exec)
+	if err != nil {
 		cleanup()
-		return "", nil, fmt.Errorf("normalize input orientation: %w", err)
+		return "", probe.Result{}, nil, fmt.Errorf("normalize input orientation: %w", err)
 	}
 
-	return tmpPath, cleanup, nil
+	return tmpPath, res, cleanup, nil
 }
 
 func normalizedInputExt(inputPath string) string {
@@ -285,3 +590,18 @@ func normalizedInputExt(inputPath string) string {
 	}
 	return ext
 }
+
+// subtitleInputs converts the job's sidecar subtitles for the encoder.
+func subtitleInputs(inputs []SubtitleInput) []encoder.SubtitleInput {
+	out := make([]encoder.SubtitleInput, 0, len(inputs))
+	for _, in := range inputs {
+		out = append(out, encoder.SubtitleInput{
+			Path:     in.Path,
+			Language: in.Language,
+			Title:    in.Title,
+			Forced:   in.Forced,
+			SDH:      in.SDH,
+		})
+	}
+	return out
+}
@@ -7,12 +7,22 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 
 	"github.com/farshidrezaei/mosaic/config"
 	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/optimize"
+	"github.com/farshidrezaei/mosaic/probe"
 )
 
+// probeJSON returns a typical ffprobe response for a file with the given
+// video stream fields and one stereo AAC track.
+func probeJSON(video string) string {
+	return `{"streams":[{"index":0,"codec_type":"video",` + video + `},{"index":1,"codec_type":"audio","codec_name":"aac","channels":2}]}`
+}
+
 func TestInitializeWithExecutor(t *testing.T) {
 	tests := []struct {
 		responses map[string]executor.MockResponse
@@ -29,7 +39,7 @@ func TestInitializeWithExecutor(t *testing.T) {
 			},
 			responses: map[string]executor.MockResponse{
 				"ffprobe": {
-					Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
+					Output: []byte(probeJSON(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`)),
 					Err:    nil,
 				},
 			},
@@ -44,7 +54,7 @@ func TestInitializeWithExecutor(t *testing.T) {
 			},
 			responses: map[string]executor.MockResponse{
 				"ffprobe": {
-					Output: []byte(`{"streams":[{"width":1280,"height":720,"avg_frame_rate":"25/1"}]}`),
+					Output: []byte(probeJSON(`"width":1280,"height":720,"avg_frame_rate":"25/1"`)),
 					Err:    nil,
 				},
 			},
@@ -70,8 +80,7 @@ func TestInitializeWithExecutor(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mock := &sequentialMock{
-				videoResponse:  tt.responses["ffprobe"],
-				audioResponse:  executor.MockResponse{Output: []byte("0"), Err: nil},
+				probeResponse:  tt.responses["ffprobe"],
 				ffmpegResponse: executor.MockResponse{Output: []byte(""), Err: nil},
 			}
 
@@ -91,6 +100,338 @@ func TestInitializeWithExecutor(t *testing.T) {
 	}
 }
 
+// initializeTest builds the ladder of a job with the given profile and opts
+// for a source with the given ffprobe video stream fields. FFmpeg calls, made
+// by optimizers, get ffmpeg.
+func initializeTest(video string, profile Profile, ffmpeg executor.MockResponse, opts ...Option) (*options, []ladder.Rendition, error) {
+	mock := &sequentialMock{
+		probeResponse:  executor.MockResponse{Output: []byte(probeJSON(video))},
+		ffmpegResponse: ffmpeg,
+	}
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	job := Job{Input: "test.mp4", OutputDir: "/output", Profile: profile}
+	_, _, renditions, err := initializeWithExecutor(context.Background(), job, mock, o)
+	return o, renditions, err
+}
+
+func TestInitializeWithAdditionalCodec(t *testing.T) {
+	_, renditions, err := initializeTest(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`, ProfileVOD, executor.MockResponse{},
+		WithAdditionalCodec(config.CodecHEVC, 1080))
+	if err != nil {
+		t.Fatalf("initializeWithExecutor() error = %v", err)
+	}
+
+	last := renditions[len(renditions)-1]
+	if last.Codec != config.CodecHEVC || last.Height != 1080 {
+		t.Errorf("expected trailing HEVC 1080p rendition, got %+v", last)
+	}
+	for _, r := range renditions[:len(renditions)-1] {
+		if r.Codec != "" {
+			t.Errorf("expected primary rungs to use the job codec, got %+v", r)
+		}
+	}
+}
+
+func TestInitializeWithLadder(t *testing.T) {
+	custom := []ladder.Rendition{
+		{Width: 3840, Height: 2160, MaxRate: 16000, BufSize: 32000, Profile: "high", Level: "5.1"},
+		{Width: 1920, Height: 1080, MaxRate: 7000, BufSize: 14000, Profile: "high", Level: "4.0"},
+		{Width: 960, Height: 540, MaxRate: 2000, BufSize: 4000, Profile: "main", Level: "3.1"},
+	}
+	lowBandwidth, _ := ladder.LookupPreset("low-bandwidth")
+	tests := []struct {
+		name    string
+		preset  string
+		ladder  []ladder.Rendition
+		policy  []ladder.SourcePolicy
+		want    []ladder.Rendition
+		wantErr bool
+	}{
+		{name: "drops rungs above the source", ladder: custom, want: custom[1:]},
+		{name: "keeps rungs above the source", ladder: custom, policy: []ladder.SourcePolicy{ladder.KeepLarger}, want: custom},
+		{name: "invalid ladder", ladder: []ladder.Rendition{{Width: 1921, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "high", Level: "4.0"}}, wantErr: true},
+		{name: "empty ladder", ladder: []ladder.Rendition{}, wantErr: true},
+		{name: "preset", preset: "low-bandwidth", want: lowBandwidth.Renditions},
+		{name: "preset after ladder", ladder: custom, preset: "low-bandwidth", want: lowBandwidth.Renditions},
+		{name: "unknown preset", preset: "missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []Option{WithLadder(tt.ladder, tt.policy...)}
+			if tt.preset != "" {
+				opts = append(opts, WithLadderPreset(tt.preset))
+			}
+
+			_, renditions, err := initializeTest(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`, ProfileVOD, executor.MockResponse{}, opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initializeWithExecutor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(renditions) != len(tt.want) {
+				t.Fatalf("expected %d renditions, got %+v", len(tt.want), renditions)
+			}
+			for i, r := range renditions {
+				if r != tt.want[i] {
+					t.Errorf("rendition %d = %+v, want %+v", i, r, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// stubStrategy halves every MaxRate, or fails with err.
+type stubStrategy struct {
+	err error
+}
+
+func (s stubStrategy) Optimize(_ context.Context, _ executor.CommandExecutor, _ string, _ probe.VideoInfo, l []ladder.Rendition) ([]ladder.Rendition, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	out := slices.Clone(l)
+	for i := range out {
+		out[i].MaxRate /= 2
+	}
+	return out, nil
+}
+
+func TestInitializeWithOptimizer(t *testing.T) {
+	progress := "out_time_us=4000000\ntotal_size=1000000\nprogress=end\n"
+	tests := []struct {
+		name     string
+		opts     []Option
+		ffmpeg   executor.MockResponse
+		expected []int
+		wantErr  bool
+	}{
+		{name: "static by default", expected: []int{5000, 3000, 1000}},
+		{name: "custom strategy", opts: []Option{WithOptimizer(stubStrategy{})}, expected: []int{2600, 1500, 500}},
+		{name: "per-title", opts: []Option{WithOptimizer(optimize.PerTitle{Samples: 1})}, ffmpeg: executor.MockResponse{Output: []byte(progress)}, expected: []int{2000, 1089, 385}},
+		{name: "strategy error", opts: []Option{WithOptimizer(stubStrategy{err: errors.New("boom")})}, wantErr: true},
+		{name: "custom ladder is not optimized", opts: []Option{WithOptimizer(stubStrategy{}), WithLadderPreset("low-bandwidth")}, expected: []int{600, 350, 200, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, renditions, err := initializeTest(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`, ProfileVOD, tt.ffmpeg, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initializeWithExecutor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			rates := make([]int, len(renditions))
+			for i, r := range renditions {
+				rates[i] = r.MaxRate
+			}
+			if !slices.Equal(rates, tt.expected) {
+				t.Errorf("MaxRates = %v, want %v", rates, tt.expected)
+			}
+		})
+	}
+}
+
+func TestInitializeWithBudget(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		want    int
+		wantErr bool
+	}{
+		{name: "budget", opts: []Option{WithOptimizer(optimize.Budget{MaxRungs: 2})}, want: 2},
+		{name: "low-bandwidth rungs counted", opts: []Option{WithOptimizer(optimize.Budget{MaxRungs: 4}), WithLowBandwidthRungs()}, wantErr: true},
+		{name: "low-bandwidth rungs within budget", opts: []Option{WithOptimizer(&optimize.Budget{MaxRungs: 6}), WithLowBandwidthRungs()}, want: 6},
+		{name: "additional codec counted", opts: []Option{WithOptimizer(optimize.Budget{MaxTotalBitrate: 9000}), WithAdditionalCodec(config.CodecHEVC, 720)}, wantErr: true},
+		{name: "custom ladder checked", opts: []Option{WithOptimizer(optimize.Budget{MaxRungs: 1}), WithLadderPreset("low-bandwidth")}, wantErr: true},
+		{name: "custom ladder within budget", opts: []Option{WithOptimizer(optimize.Budget{MaxRungs: 4}), WithLadderPreset("low-bandwidth")}, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, renditions, err := initializeTest(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`, ProfileLive, executor.MockResponse{}, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initializeWithExecutor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(renditions) != tt.want {
+				t.Errorf("got %d renditions, want %d", len(renditions), tt.want)
+			}
+		})
+	}
+}
+
+func TestInitializeLadderSizes(t *testing.T) {
+	custom := []ladder.Rendition{{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"}}
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected [][2]int
+	}{
+		{name: "fitted to the source aspect ratio", expected: [][2]int{{1440, 1080}, {960, 720}, {480, 360}}},
+		{name: "padded", opts: []Option{WithPadding()}, expected: [][2]int{{1920, 1080}, {1280, 720}, {640, 360}}},
+		{name: "custom ladder fitted", opts: []Option{WithLadder(custom)}, expected: [][2]int{{960, 720}}},
+		{name: "custom ladder padded", opts: []Option{WithLadder(custom), WithPadding(true)}, expected: [][2]int{{1280, 720}}},
+		{
+			name:     "low-bandwidth rungs",
+			opts:     []Option{WithLowBandwidthRungs()},
+			expected: [][2]int{{1440, 1080}, {960, 720}, {480, 360}, {360, 270}, {312, 234}, {192, 144}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, renditions, err := initializeTest(`"width":1440,"height":1080,"avg_frame_rate":"25/1"`, ProfileVOD, executor.MockResponse{}, tt.opts...)
+			if err != nil {
+				t.Fatalf("initializeWithExecutor() error = %v", err)
+			}
+			sizes := make([][2]int, len(renditions))
+			for i, r := range renditions {
+				sizes[i] = [2]int{r.Width, r.Height}
+			}
+			if !slices.Equal(sizes, tt.expected) {
+				t.Errorf("sizes = %v, want %v", sizes, tt.expected)
+			}
+		})
+	}
+}
+
+func TestInitializeWithSourceBitrateCap(t *testing.T) {
+	tests := []struct {
+		name     string
+		profile  Profile
+		opts     []Option
+		expected []int
+	}{
+		{name: "on by default for VOD", profile: ProfileVOD, expected: []int{1800, 980, 346}},
+		{name: "off by default for live", profile: ProfileLive, expected: []int{5000, 3000, 1000}},
+		{name: "disabled", profile: ProfileVOD, opts: []Option{WithSourceBitrateCap(false)}, expected: []int{5000, 3000, 1000}},
+		{name: "enabled for live", profile: ProfileLive, opts: []Option{WithSourceBitrateCap(true)}, expected: []int{1800, 980, 346}},
+		{name: "headroom", profile: ProfileVOD, opts: []Option{WithSourceBitrateCap(true, 2)}, expected: []int{3000, 1633, 577}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, renditions, err := initializeTest(`"width":1920,"height":1080,"avg_frame_rate":"30/1","bit_rate":"1500000"`, tt.profile, executor.MockResponse{}, tt.opts...)
+			if err != nil {
+				t.Fatalf("initializeWithExecutor() error = %v", err)
+			}
+			rates := make([]int, len(renditions))
+			for i, r := range renditions {
+				rates[i] = r.MaxRate
+			}
+			if !slices.Equal(rates, tt.expected) {
+				t.Errorf("MaxRates = %v, want %v", rates, tt.expected)
+			}
+		})
+	}
+}
+
+func TestInitializeFrameRates(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantFPS   []float64
+		wantRates []int
+	}{
+		{name: "high frame rate rungs", wantFPS: []float64{60, 60, 30}, wantRates: []int{7071, 4243, 1000}},
+		{
+			name:      "low-bandwidth rungs halved",
+			opts:      []Option{WithLowBandwidthRungs()},
+			wantFPS:   []float64{60, 60, 30, 30, 30, 30},
+			wantRates: []int{7071, 4243, 1000, 600, 400, 200},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, renditions, err := initializeTest(`"width":1920,"height":1080,"avg_frame_rate":"60/1"`, ProfileVOD, executor.MockResponse{}, tt.opts...)
+			if err != nil {
+				t.Fatalf("initializeWithExecutor() error = %v", err)
+			}
+			fps := make([]float64, len(renditions))
+			rates := make([]int, len(renditions))
+			for i, r := range renditions {
+				fps[i], rates[i] = r.FPS, r.MaxRate
+			}
+			if !slices.Equal(fps, tt.wantFPS) {
+				t.Errorf("FPS = %v, want %v", fps, tt.wantFPS)
+			}
+			if !slices.Equal(rates, tt.wantRates) {
+				t.Errorf("MaxRates = %v, want %v", rates, tt.wantRates)
+			}
+		})
+	}
+}
+
+func TestInitializeLevels(t *testing.T) {
+	custom := []ladder.Rendition{{Width: 1920, Height: 1080, MaxRate: 6000, BufSize: 12000, Profile: "high", Level: "4.0"}}
+	tests := []struct {
+		name    string
+		fps     string
+		opts    []Option
+		want    []string
+		wantErr bool
+	}{
+		{name: "custom ladder raised to the source frame rate", fps: "60/1", opts: []Option{WithLadder(custom)}, want: []string{"4.2"}},
+		{name: "custom ladder no level allows", fps: "2400/1", opts: []Option{WithLadder(custom)}, wantErr: true},
+		{name: "built ladder no level allows", fps: "2400/1", wantErr: true},
+		{name: "additional codec", fps: "60/1", opts: []Option{WithLadder(custom), WithAdditionalCodec(config.CodecHEVC, 720)}, want: []string{"4.2", "4.0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, renditions, err := initializeTest(`"width":1920,"height":1080,"avg_frame_rate":"`+tt.fps+`"`, ProfileVOD, executor.MockResponse{}, tt.opts...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("initializeWithExecutor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			levels := make([]string, len(renditions))
+			for i, r := range renditions {
+				levels[i] = r.Level
+			}
+			if !slices.Equal(levels, tt.want) {
+				t.Errorf("levels = %v, want %v", levels, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitializeWithHDR(t *testing.T) {
+	pq := `"width":3840,"height":2160,"avg_frame_rate":"24/1","pix_fmt":"yuv420p10le","color_transfer":"smpte2084","color_primaries":"bt2020"`
+	sdr := `"width":1920,"height":1080,"avg_frame_rate":"30/1"`
+	tests := []struct {
+		name    string
+		video   string
+		opts    []Option
+		want    config.Codec
+		wantSDR int
+	}{
+		{name: "HDR source defaults to HEVC", video: pq, opts: []Option{WithHDR()}, want: config.CodecHEVC, wantSDR: 5},
+		{name: "explicit codec kept", video: pq, opts: []Option{WithHDR(), WithCodec(config.CodecAV1)}, want: config.CodecAV1, wantSDR: 5},
+		{name: "explicit H.264 has no fallback", video: pq, opts: []Option{WithHDR(), WithCodec(config.CodecH264)}, want: config.CodecH264},
+		{name: "HDR mode off", video: pq, want: ""},
+		{name: "SDR source", video: sdr, opts: []Option{WithHDR()}, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o, renditions, err := initializeTest(tt.video, ProfileVOD, executor.MockResponse{}, tt.opts...)
+			if err != nil {
+				t.Fatalf("initializeWithExecutor() error = %v", err)
+			}
+			if o.codec != tt.want {
+				t.Errorf("codec = %q, want %q", o.codec, tt.want)
+			}
+			sdr := 0
+			for _, r := range renditions {
+				if r.Range == config.VideoRangeSDR && r.Codec == config.CodecH264 {
+					sdr++
+				}
+			}
+			if sdr != tt.wantSDR {
+				t.Errorf("expected %d tone-mapped SDR renditions, got %d in %+v", tt.wantSDR, sdr, renditions)
+			}
+		})
+	}
+}
+
 func TestEncodeHlsWithExecutor(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -120,16 +461,15 @@ func TestEncodeHlsWithExecutor(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mock := &fullMock{
-				probeVideoResponse: executor.MockResponse{
-					Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
+				probeResponse: executor.MockResponse{
+					Output: []byte(probeJSON(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`)),
 					Err:    nil,
 				},
-				probeAudioResponse: executor.MockResponse{Output: []byte("0"), Err: nil},
-				ffmpegResponse:     executor.MockResponse{Output: []byte(""), Err: nil},
+				ffmpegResponse: executor.MockResponse{Output: []byte(""), Err: nil},
 			}
 
 			if tt.wantErr {
-				mock.probeVideoResponse.Err = errors.New("file not found")
+				mock.probeResponse.Err = errors.New("file not found")
 			}
 
 			_, err := EncodeHlsWithExecutor(context.Background(), tt.job, mock)
@@ -175,12 +515,11 @@ func TestEncodeDashWithExecutor(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			mock := &fullMock{
-				probeVideoResponse: executor.MockResponse{
-					Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
+				probeResponse: executor.MockResponse{
+					Output: []byte(probeJSON(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`)),
 					Err:    nil,
 				},
-				probeAudioResponse: executor.MockResponse{Output: []byte("0"), Err: nil},
-				ffmpegResponse:     executor.MockResponse{Output: []byte(""), Err: nil},
+				ffmpegResponse: executor.MockResponse{Output: []byte(""), Err: nil},
 			}
 
 			if tt.wantErr {
@@ -207,7 +546,7 @@ func TestPrepareInputForEncoding(t *testing.T) {
 		o.normalizeOrientation = false
 		mock := &orientationMockExecutor{}
 
-		got, cleanup, err := prepareInputForEncoding(context.Background(), "input.mp4", mock, o)
+		got, res, cleanup, err := prepareInputForEncoding(context.Background(), "input.mp4", mock, o)
 		if err != nil {
 			t.Fatalf("prepareInputForEncoding() err=%v", err)
 		}
@@ -219,6 +558,9 @@ func TestPrepareInputForEncoding(t *testing.T) {
 		if mock.ffmpegCalls != 0 {
 			t.Fatalf("expected no ffmpeg calls, got %d", mock.ffmpegCalls)
 		}
+		if mock.ffprobeCalls != 1 || len(res.Streams) != 1 {
+			t.Fatalf("expected one probe of the input, got %d calls and %d streams", mock.ffprobeCalls, len(res.Streams))
+		}
 	})
 
 	t.Run("normalization enabled", func(t *testing.T) {
@@ -232,16 +574,19 @@ func TestPrepareInputForEncoding(t *testing.T) {
 		o.normalizeOrientation = true
 		mock := &orientationMockExecutor{
 			ffprobeOutputs: [][]byte{
-				[]byte(`{"streams":[{"width":1920,"height":1080,"codec_name":"h264","side_data_list":[{"rotation":90}]}]}`),
-				[]byte(`{"streams":[{"width":1080,"height":1920,"codec_name":"h264"}]}`),
+				[]byte(`{"streams":[{"codec_type":"video","width":1920,"height":1080,"codec_name":"h264","side_data_list":[{"rotation":90}]}]}`),
+				[]byte(`{"streams":[{"codec_type":"video","width":1080,"height":1920,"codec_name":"h264"}]}`),
 			},
 			createFFmpegOutput: true,
 		}
 
-		got, cleanup, err := prepareInputForEncoding(context.Background(), inputPath, mock, o)
+		got, res, cleanup, err := prepareInputForEncoding(context.Background(), inputPath, mock, o)
 		if err != nil {
 			t.Fatalf("prepareInputForEncoding() err=%v", err)
 		}
+		if s, ok := res.VideoStream(); !ok || s.Width != 1080 {
+			t.Fatalf("expected the probe of the normalized output, got %+v", res)
+		}
 		if got == inputPath {
 			t.Fatalf("expected temp normalized path, got original")
 		}
@@ -255,10 +600,9 @@ func TestPrepareInputForEncoding(t *testing.T) {
 	})
 }
 
-// sequentialMock handles sequential ffprobe calls
+// sequentialMock answers the ffprobe call, then the ffmpeg call
 type sequentialMock struct {
-	videoResponse  executor.MockResponse
-	audioResponse  executor.MockResponse
+	probeResponse  executor.MockResponse
 	ffmpegResponse executor.MockResponse
 	callCount      int
 }
@@ -273,25 +617,20 @@ func (m *sequentialMock) ExecuteWithProgress(ctx context.Context, progress chan<
 	}
 	m.callCount++
 	if m.callCount == 1 {
-		return m.videoResponse.Output, m.videoResponse.Usage, m.videoResponse.Err
+		return m.probeResponse.Output, m.probeResponse.Usage, m.probeResponse.Err
 	}
 	if m.callCount == 2 {
-		return m.audioResponse.Output, m.audioResponse.Usage, m.audioResponse.Err
-	}
-	if m.callCount == 3 {
 		return m.ffmpegResponse.Output, m.ffmpegResponse.Usage, m.ffmpegResponse.Err
 	}
 	return nil, nil, fmt.Errorf("unexpected call to Execute: %s %v", name, args)
 }
 
-// fullMock handles all commands (ffprobe x2, ffmpeg)
+// fullMock handles all commands (ffprobe, ffmpeg)
 type fullMock struct {
-	probeVideoResponse executor.MockResponse
-	probeAudioResponse executor.MockResponse
-	ffmpegResponse     executor.MockResponse
-	progressData       []string
-	callCount          int
-	ffmpegCallCount    int
+	probeResponse   executor.MockResponse
+	ffmpegResponse  executor.MockResponse
+	progressData    []string
+	ffmpegCallCount int
 }
 
 func (m *fullMock) Execute(ctx context.Context, name string, args ...string) ([]byte, *executor.Usage, error) {
@@ -299,16 +638,13 @@ func (m *fullMock) Execute(ctx context.Context, name string, args ...string) ([]
 }
 
 func (m *fullMock) ExecuteWithProgress(ctx context.Context, progress chan<- string, name string, args ...string) ([]byte, *executor.Usage, error) {
-	if name == "ffprobe" {
+	switch name {
+	case "ffprobe":
 		if progress != nil {
 			close(progress)
 		}
-		return m.probeVideoResponse.Output, m.probeVideoResponse.Usage, m.probeVideoResponse.Err
-	}
-
-	m.callCount++
-
-	if name == "ffmpeg" {
+		return m.probeResponse.Output, m.probeResponse.Usage, m.probeResponse.Err
+	case "ffmpeg":
 		m.ffmpegCallCount++
 		if progress != nil {
 			for _, p := range m.progressData {
@@ -322,28 +658,16 @@ func (m *fullMock) ExecuteWithProgress(ctx context.Context, progress chan<- stri
 	if progress != nil {
 		close(progress)
 	}
-
-	// ffprobe calls
-	if m.callCount <= 2 {
-		if m.callCount == 1 {
-			// Video probe
-			return m.probeVideoResponse.Output, m.probeVideoResponse.Usage, m.probeVideoResponse.Err
-		}
-		// Audio probe
-		return m.probeAudioResponse.Output, m.probeAudioResponse.Usage, m.probeAudioResponse.Err
-	}
-
 	return nil, nil, errors.New("unexpected call")
 }
 
 func TestProgressReporting(t *testing.T) {
 	mock := &fullMock{
-		probeVideoResponse: executor.MockResponse{
-			Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
+		probeResponse: executor.MockResponse{
+			Output: []byte(probeJSON(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`)),
 			Err:    nil,
 		},
-		probeAudioResponse: executor.MockResponse{Output: []byte("0"), Err: nil},
-		ffmpegResponse:     executor.MockResponse{Output: []byte(""), Err: nil},
+		ffmpegResponse: executor.MockResponse{Output: []byte(""), Err: nil},
 		progressData: []string{
 			"frame=100\nfps=30.0\nstream_0_0_q=28.0\nbitrate=1000.0kbits/s\ntotal_size=1000000\nout_time_us=10000000\nout_time_ms=10000\nout_time=00:00:10.000000\ndup_frames=0\ndrop_frames=0\nspeed=1.5x\nprogress=continue\n",
 			"frame=200\nfps=30.0\nstream_0_0_q=28.0\nbitrate=1200.0kbits/s\ntotal_size=2000000\nout_time_us=20000000\nout_time_ms=20000\nout_time=00:00:20.000000\ndup_frames=0\ndrop_frames=0\nspeed=1.6x\nprogress=end\n",
@@ -396,12 +720,8 @@ func TestEncodeHls(t *testing.T) {
 	// The sequentialMock in this file implements that interface.
 
 	seqMock := &sequentialMock{
-		videoResponse: executor.MockResponse{
-			Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
-			Err:    nil,
-		},
-		audioResponse: executor.MockResponse{
-			Output: []byte("0"),
+		probeResponse: executor.MockResponse{
+			Output: []byte(probeJSON(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`)),
 			Err:    nil,
 		},
 		ffmpegResponse: executor.MockResponse{
@@ -431,12 +751,8 @@ func TestEncodeDash(t *testing.T) {
 	defer func() { executor.DefaultExecutor = origExec }()
 
 	seqMock := &sequentialMock{
-		videoResponse: executor.MockResponse{
-			Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
-			Err:    nil,
-		},
-		audioResponse: executor.MockResponse{
-			Output: []byte("0"),
+		probeResponse: executor.MockResponse{
+			Output: []byte(probeJSON(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`)),
 			Err:    nil,
 		},
 		ffmpegResponse: executor.MockResponse{
@@ -477,6 +793,36 @@ func TestOptions(t *testing.T) {
 		t.Errorf("expected GPU_VAAPI, got %s", o.gpu)
 	}
 
+	WithCodec(config.CodecHEVC)(o)
+	if o.codec != config.CodecHEVC {
+		t.Errorf("expected CodecHEVC, got %s", o.codec)
+	}
+
+	WithAdditionalCodec(config.CodecHEVC, 720)(o)
+	WithAdditionalCodec(config.CodecAV1, 1080)(o)
+	if len(o.extraCodecs) != 2 || o.extraCodecs[1].codec != config.CodecAV1 || o.extraCodecs[1].minHeight != 1080 {
+		t.Errorf("expected two additional codec families, got %+v", o.extraCodecs)
+	}
+
+	WithAV1Preset(10)(o)
+	if o.av1Preset != 10 {
+		t.Errorf("expected AV1 preset 10, got %d", o.av1Preset)
+	}
+
+	WithClosedCaptions(config.CaptionService{InstreamID: "CC1", Language: "eng"}, config.CaptionService{InstreamID: "SERVICE1", Language: "spa"})(o)
+	if len(o.captions) != 2 || o.captions[1].InstreamID != "SERVICE1" {
+		t.Errorf("expected two caption services, got %+v", o.captions)
+	}
+
+	WithHDR()(o)
+	if !o.hdr {
+		t.Error("expected HDR mode enabled")
+	}
+	WithHDR(false)(o)
+	if o.hdr {
+		t.Error("expected HDR mode disabled")
+	}
+
 	WithLogLevel("debug")(o)
 	if o.logLevel != "debug" {
 		t.Errorf("expected loglevel debug, got %s", o.logLevel)
@@ -491,12 +837,11 @@ func TestOptions(t *testing.T) {
 
 func TestProgressReportingDash(t *testing.T) {
 	mock := &fullMock{
-		probeVideoResponse: executor.MockResponse{
-			Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
+		probeResponse: executor.MockResponse{
+			Output: []byte(probeJSON(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`)),
 			Err:    nil,
 		},
-		probeAudioResponse: executor.MockResponse{Output: []byte("0"), Err: nil},
-		ffmpegResponse:     executor.MockResponse{Output: []byte(""), Err: nil},
+		ffmpegResponse: executor.MockResponse{Output: []byte(""), Err: nil},
 		progressData: []string{
 			"frame=100\nout_time=00:00:10.000000\nprogress=continue\n",
 		},
@@ -528,12 +873,8 @@ func TestInitialize(t *testing.T) {
 	defer func() { executor.DefaultExecutor = origExec }()
 
 	seqMock := &sequentialMock{
-		videoResponse: executor.MockResponse{
-			Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
-			Err:    nil,
-		},
-		audioResponse: executor.MockResponse{
-			Output: []byte("0"),
+		probeResponse: executor.MockResponse{
+			Output: []byte(probeJSON(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`)),
 			Err:    nil,
 		},
 		ffmpegResponse: executor.MockResponse{
@@ -555,12 +896,8 @@ func TestInitialize(t *testing.T) {
 		t.Errorf("initialize() error = %v", err)
 	}
 	seqMock2 := &sequentialMock{
-		videoResponse: executor.MockResponse{
-			Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
-			Err:    nil,
-		},
-		audioResponse: executor.MockResponse{
-			Output: []byte("0"),
+		probeResponse: executor.MockResponse{
+			Output: []byte(probeJSON(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`)),
 			Err:    nil,
 		},
 		ffmpegResponse: executor.MockResponse{
@@ -580,7 +917,7 @@ func TestInitialize(t *testing.T) {
 }
 func TestEncodeHlsError(t *testing.T) {
 	mock := &fullMock{
-		probeVideoResponse: executor.MockResponse{Err: errors.New("probe failed")},
+		probeResponse: executor.MockResponse{Err: errors.New("probe failed")},
 	}
 	job := Job{Input: "test.mp4", OutputDir: "/out", Profile: ProfileVOD}
 	_, err := EncodeHlsWithExecutor(context.Background(), job, mock)
@@ -591,7 +928,7 @@ func TestEncodeHlsError(t *testing.T) {
 
 func TestEncodeDashError(t *testing.T) {
 	mock := &fullMock{
-		probeVideoResponse: executor.MockResponse{Err: errors.New("probe failed")},
+		probeResponse: executor.MockResponse{Err: errors.New("probe failed")},
 	}
 	job := Job{Input: "test.mp4", OutputDir: "/out", Profile: ProfileVOD}
 	_, err := EncodeDashWithExecutor(context.Background(), job, mock)
@@ -601,12 +938,11 @@ func TestEncodeDashError(t *testing.T) {
 }
 func TestNilProgressHandler(t *testing.T) {
 	mock := &fullMock{
-		probeVideoResponse: executor.MockResponse{
-			Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
+		probeResponse: executor.MockResponse{
+			Output: []byte(probeJSON(`"width":1920,"height":1080,"avg_frame_rate":"30/1"`)),
 			Err:    nil,
 		},
-		probeAudioResponse: executor.MockResponse{Output: []byte("0"), Err: nil},
-		ffmpegResponse:     executor.MockResponse{Output: []byte(""), Err: nil},
+		ffmpegResponse: executor.MockResponse{Output: []byte(""), Err: nil},
 		progressData: []string{
 			"frame=100\nout_time=00:00:10.000000\nprogress=continue\n",
 		},
@@ -629,3 +965,34 @@ func TestNilProgressHandler(t *testing.T) {
 		t.Fatalf("EncodeDashWithExecutor failed: %v", err)
 	}
 }
+
+func TestEncodeWithSubtitleInputs(t *testing.T) {
+	dir := t.TempDir()
+	ttml := filepath.Join(dir, "en.ttml")
+	doc := `<tt xmlns="http://www.w3.org/ns/ttml"><body><div><p begin="1s" end="2s">Hello</p></div></body></tt>`
+	if err := os.WriteFile(ttml, []byte(doc), 0o644); err != nil {
+		t.Fatalf("write ttml: %v", err)
+	}
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	mock := &audioInputMock{probes: map[string]string{
+		"in.mp4": `{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1"}],"format":{"duration":"10.0"}}`,
+	}}
+	job := Job{
+		Input:          "in.mp4",
+		OutputDir:      out,
+		SubtitleInputs: []SubtitleInput{{Path: ttml, Language: "eng", SDH: true}},
+	}
+	if _, err := EncodeHlsWithExecutor(context.Background(), job, mock); err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	for _, name := range []string{"subs_0.vtt", "subs_0.m3u8", "subs_0_0.vtt", "subs_0_1.vtt"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+			t.Errorf("expected %s: %v", name, err)
+		}
+	}
+}
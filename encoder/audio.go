@@ -0,0 +1,175 @@
+package encoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+const (
+	// dashRoleScheme is the DASH role scheme for AdaptationSet Role descriptors.
+	dashRoleScheme = "urn:mpeg:dash:role:2011"
+	// dashAudioPurposeScheme is the DVB/TV-Anytime scheme used by DASH
+	// Accessibility descriptors; value 1 marks audio description.
+	dashAudioPurposeScheme = "urn:tva:metadata:cs:AudioPurposeCS:2007"
+	// hlsDescribesVideo is the HLS CHARACTERISTICS value for audio description.
+	hlsDescribesVideo = "public.accessibility.describes-video"
+)
+
+// AudioInput is an external audio file, such as a dub or an audio description,
+// encoded and packaged alongside the audio of the primary input.
+type AudioInput struct {
+	// Path is the file path or URL of the audio file. Its first audio stream is used.
+	Path string
+	// Language is the BCP-47 or ISO 639 language tag of the track.
+	Language string
+	// Title is the human-readable track name shown by players.
+	Title string
+	// Role is the purpose of the track. Empty is treated as config.AudioRoleDub.
+	Role config.AudioRole
+	// Offset shifts the track on the video timeline, in seconds. Positive values
+	// delay it; negative values skip the start of the file.
+	Offset float64
+	// Duration trims the track to this many seconds when positive.
+	Duration float64
+}
+
+// audioTrack is a single audio rendition in the output, taken either from the
+// primary input (input 0) or from a sidecar AudioInput (input 1 and up).
+type audioTrack struct {
+	language  string
+	title     string
+	role      config.AudioRole
+	input     int
+	stream    int
+	isDefault bool
+}
+
+// audioTracks returns the audio tracks to package: the primary input's audio
+// streams followed by the sidecar inputs. Callers that only set
+// VideoInfo.HasAudio get a single untagged track mapped from a:0.
+func audioTracks(info probe.VideoInfo, opts EncoderOptions) []audioTrack {
+	src := info.AudioStreams
+	if len(src) == 0 && info.HasAudio {
+		src = []probe.AudioStream{{}}
+	}
+
+	var tracks []audioTrack
+	for k, a := range src {
+		tracks = append(tracks, audioTrack{
+			language: a.Language,
+			title:    a.Title,
+			role:     config.AudioRoleAlternate,
+			stream:   k,
+		})
+	}
+	for j, in := range opts.AudioInputs {
+		role := in.Role
+		if role == "" {
+			role = config.AudioRoleDub
+		}
+		tracks = append(tracks, audioTrack{
+			language: in.Language,
+			title:    in.Title,
+			role:     role,
+			input:    j + 1,
+		})
+	}
+	if len(tracks) == 0 {
+		return nil
+	}
+
+	def := defaultAudioTrack(src, tracks)
+	tracks[def].isDefault = true
+	if def < len(src) {
+		tracks[def].role = config.AudioRoleMain
+	}
+	return tracks
+}
+
+// defaultAudioTrack returns the index of the track to mark as default: the
+// source stream flagged as default, else the first source stream, else the
+// first sidecar with the main role, else 0.
+func defaultAudioTrack(src []probe.AudioStream, tracks []audioTrack) int {
+	for k, a := range src {
+		if a.Default {
+			return k
+		}
+	}
+	if len(src) > 0 {
+		return 0
+	}
+	for k, t := range tracks {
+		if t.role == config.AudioRoleMain {
+			return k
+		}
+	}
+	return 0
+}
+
+// audioName returns a human-readable rendition name for the k-th track.
+func audioName(t audioTrack, k int) string {
+	switch {
+	case t.title != "":
+		return t.title
+	case t.language != "":
+		return t.language
+	default:
+		return fmt.Sprintf("Audio %d", k+1)
+	}
+}
+
+// audioInputArgs returns the input arguments for the sidecar audio files.
+// They follow the primary input, so the j-th sidecar is FFmpeg input j+1.
+func audioInputArgs(inputs []AudioInput) []string {
+	var args []string
+	for _, in := range inputs {
+		switch {
+		case in.Offset > 0:
+			args = append(args, "-itsoffset", formatSeconds(in.Offset))
+		case in.Offset < 0:
+			args = append(args, "-ss", formatSeconds(-in.Offset))
+		}
+		if in.Duration > 0 {
+			args = append(args, "-t", formatSeconds(in.Duration))
+		}
+		args = append(args, "-i", in.Path)
+	}
+	return args
+}
+
+// audioArgs maps every audio track once and encodes it to stereo AAC,
+// preserving its language tag.
+func audioArgs(tracks []audioTrack) []string {
+	var args []string
+	for k, t := range tracks {
+		args = append(args,
+			"-map", fmt.Sprintf("%d:a:%d", t.input, t.stream),
+			fmt.Sprintf("-c:a:%d", k), "aac",
+			fmt.Sprintf("-b:a:%d", k), audioBitrate,
+			fmt.Sprintf("-ac:a:%d", k), "2",
+		)
+		if lang := varStreamValue(t.language); lang != "" {
+			args = append(args, fmt.Sprintf("-metadata:s:a:%d", k), "language="+lang)
+		}
+	}
+	return args
+}
+
+// formatSeconds formats a duration in seconds for FFmpeg time options.
+func formatSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
+
+// varStreamValue strips characters that would break var_stream_map parsing.
+func varStreamValue(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == ',' || r == ':' {
+			return -1
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,121 @@
+package encoder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+const (
+	// captionGroupID is the HLS GROUP-ID of the closed caption renditions.
+	captionGroupID = "cc"
+	// dashCEA608Scheme and dashCEA708Scheme are the SCTE 214 Accessibility
+	// schemes for captions carried in the video stream.
+	dashCEA608Scheme = "urn:scte:dash:cc:cea-608:2015"
+	dashCEA708Scheme = "urn:scte:dash:cc:cea-708:2015"
+)
+
+// captionServices returns the embedded caption services to signal: the
+// configured services, or config.DefaultCaptionService when the probe detected
+// captions. It returns nil when there is nothing to carry.
+func captionServices(info probe.VideoInfo, opts EncoderOptions) []config.CaptionService {
+	if len(opts.ClosedCaptions) > 0 {
+		return opts.ClosedCaptions
+	}
+	if info.ClosedCaptions {
+		return []config.CaptionService{config.DefaultCaptionService}
+	}
+	return nil
+}
+
+// carriesCaptions reports whether FFmpeg can embed A/53 captions in the codec.
+// FFmpeg's AV1 encoders drop them.
+func carriesCaptions(codec config.Codec) bool {
+	return codec != config.CodecAV1
+}
+
+// renditionCarriesCaptions reports whether rendition r keeps the source's
+// A/53 captions: its codec must embed them and it must keep the source frame
+// rate, since the frames fpsFilter drops take their caption data with them.
+func renditionCarriesCaptions(r ladder.Rendition, opts EncoderOptions, sourceFPS float64) bool {
+	return carriesCaptions(renditionCodec(r, opts)) && fpsFilter(r, sourceFPS) == ""
+}
+
+// groupCarriesCaptions reports whether every rendition of l in video group g
+// carries captions, since DASH signals them once per AdaptationSet.
+func groupCarriesCaptions(g videoGroup, l []ladder.Rendition, opts EncoderOptions, sourceFPS float64) bool {
+	for _, r := range l {
+		if (videoGroup{codec: renditionCodec(r, opts), videoRange: r.Range}) == g && !renditionCarriesCaptions(r, opts, sourceFPS) {
+			return false
+		}
+	}
+	return true
+}
+
+// captionArgs returns the flags that make the i-th output video stream carry
+// the source's A/53 captions. VAAPI encoders insert them by default.
+func captionArgs(i int, enc string) []string {
+	switch enc {
+	case "libx264", "libx265",
+		"h264_nvenc", "hevc_nvenc",
+		"h264_videotoolbox", "hevc_videotoolbox":
+		return []string{fmt.Sprintf("-a53cc:v:%d", i), "1"}
+	}
+	return nil
+}
+
+// is708 reports whether the service is a CEA-708 service rather than a CEA-608 channel.
+func is708(s config.CaptionService) bool {
+	return strings.HasPrefix(strings.ToUpper(s.InstreamID), "SERVICE")
+}
+
+// captionMedia returns the EXT-X-MEDIA tags for the closed caption services.
+func captionMedia(services []config.CaptionService) []string {
+	lines := make([]string, 0, len(services))
+	for _, s := range services {
+		name := s.Name
+		if name == "" {
+			name = s.Language
+		}
+		if name == "" {
+			name = s.InstreamID
+		}
+		attrs := fmt.Sprintf("TYPE=CLOSED-CAPTIONS,GROUP-ID=%s,NAME=%s", quoteAttr(captionGroupID), quoteAttr(name))
+		if s.Language != "" {
+			attrs += ",LANGUAGE=" + quoteAttr(s.Language)
+		}
+		attrs += ",DEFAULT=NO,AUTOSELECT=YES,INSTREAM-ID=" + quoteAttr(strings.ToUpper(s.InstreamID))
+		lines = append(lines, "#EXT-X-MEDIA:"+attrs)
+	}
+	return lines
+}
+
+// captionAccessibility returns the DASH Accessibility descriptors for the
+// caption services, one per scheme, e.g. value="CC1=eng;CC3=spa".
+func captionAccessibility(services []config.CaptionService) string {
+	var cea608, cea708 []string
+	for _, s := range services {
+		lang := xmlEscape(s.Language)
+		if lang == "" {
+			lang = "und"
+		}
+		id := xmlEscape(strings.ToUpper(s.InstreamID))
+		if is708(s) {
+			cea708 = append(cea708, strings.TrimPrefix(id, "SERVICE")+"=lang:"+lang)
+		} else {
+			cea608 = append(cea608, id+"="+lang)
+		}
+	}
+
+	var b strings.Builder
+	if len(cea608) > 0 {
+		fmt.Fprintf(&b, `<Accessibility schemeIdUri="%s" value="%s"/>`, dashCEA608Scheme, strings.Join(cea608, ";"))
+	}
+	if len(cea708) > 0 {
+		fmt.Fprintf(&b, `<Accessibility schemeIdUri="%s" value="%s"/>`, dashCEA708Scheme, strings.Join(cea708, ";"))
+	}
+	return b.String()
+}
@@ -0,0 +1,234 @@
+package encoder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+func TestCaptionServices(t *testing.T) {
+	custom := []config.CaptionService{{InstreamID: "CC3", Language: "spa"}}
+	tests := []struct {
+		name string
+		info probe.VideoInfo
+		opts EncoderOptions
+		want []config.CaptionService
+	}{
+		{name: "none", info: probe.VideoInfo{}},
+		{name: "detected", info: probe.VideoInfo{ClosedCaptions: true}, want: []config.CaptionService{config.DefaultCaptionService}},
+		{name: "configured", info: probe.VideoInfo{ClosedCaptions: true}, opts: EncoderOptions{ClosedCaptions: custom}, want: custom},
+		{name: "configured but not detected", opts: EncoderOptions{ClosedCaptions: custom}, want: custom},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := captionServices(tt.info, tt.opts)
+			if len(got) != len(tt.want) {
+				t.Fatalf("captionServices() = %+v, want %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("service %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCaptionArgs(t *testing.T) {
+	for enc, want := range map[string]bool{
+		"libx264":           true,
+		"libx265":           true,
+		"h264_nvenc":        true,
+		"hevc_videotoolbox": true,
+		"h264_vaapi":        false,
+		"libsvtav1":         false,
+	} {
+		if got := hasArgPair(captionArgs(2, enc), "-a53cc:v:2", "1"); got != want {
+			t.Errorf("captionArgs(%s) sets a53cc = %v, want %v", enc, got, want)
+		}
+	}
+}
+
+func TestCaptionSignalling(t *testing.T) {
+	services := []config.CaptionService{
+		{InstreamID: "CC1", Language: "eng", Name: "English"},
+		{InstreamID: "cc3", Language: "spa"},
+		{InstreamID: "SERVICE1"},
+	}
+
+	media := strings.Join(captionMedia(services), "\n")
+	for _, want := range []string{
+		`#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID="cc",NAME="English",LANGUAGE="eng",DEFAULT=NO,AUTOSELECT=YES,INSTREAM-ID="CC1"`,
+		`NAME="spa",LANGUAGE="spa",DEFAULT=NO,AUTOSELECT=YES,INSTREAM-ID="CC3"`,
+		`NAME="SERVICE1",DEFAULT=NO,AUTOSELECT=YES,INSTREAM-ID="SERVICE1"`,
+	} {
+		if !strings.Contains(media, want) {
+			t.Errorf("expected media to contain %s, got:\n%s", want, media)
+		}
+	}
+
+	want := `<Accessibility schemeIdUri="urn:scte:dash:cc:cea-608:2015" value="CC1=eng;CC3=spa"/>` +
+		`<Accessibility schemeIdUri="urn:scte:dash:cc:cea-708:2015" value="1=lang:und"/>`
+	if got := captionAccessibility(services); got != want {
+		t.Errorf("captionAccessibility() = %s, want %s", got, want)
+	}
+}
+
+func TestEncodeClosedCaptions(t *testing.T) {
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, ClosedCaptions: true}
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "high", Level: "4.0"},
+		{Codec: config.CodecAV1, Width: 1920, Height: 1080, MaxRate: 3000, BufSize: 6000, Profile: "high", Level: "4.0"},
+	}
+
+	t.Run("HLS", func(t *testing.T) {
+		dir := t.TempDir()
+		master := "#EXTM3U\n" +
+			`#EXT-X-STREAM-INF:BANDWIDTH=5500000,RESOLUTION=1920x1080,CODECS="avc1.640028"` + "\nstream_0.m3u8\n" +
+			`#EXT-X-STREAM-INF:BANDWIDTH=3300000,RESOLUTION=1920x1080,CODECS="av01"` + "\nstream_1.m3u8\n"
+		if err := os.WriteFile(filepath.Join(dir, "master.m3u8"), []byte(master), 0o644); err != nil {
+			t.Fatalf("write master: %v", err)
+		}
+
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+		if _, err := EncodeHLSCMAFWithExecutor(context.Background(), "in", dir, info, config.VOD, l, mock, nil, EncoderOptions{}); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		args := mock.CallLog[0].Args
+		if !hasArgPair(args, "-a53cc:v:0", "1") {
+			t.Error("expected captions carried on the H.264 variant")
+		}
+		if hasArgPair(args, "-a53cc:v:1", "1") {
+			t.Error("expected no a53cc on the AV1 variant")
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "master.m3u8"))
+		if err != nil {
+			t.Fatalf("read master: %v", err)
+		}
+		got := string(data)
+		for _, want := range []string{
+			`#EXT-X-MEDIA:TYPE=CLOSED-CAPTIONS,GROUP-ID="cc",NAME="CC1",DEFAULT=NO,AUTOSELECT=YES,INSTREAM-ID="CC1"` + "\n#EXT-X-STREAM-INF:",
+			`CODECS="avc1.640028",CLOSED-CAPTIONS="cc"` + "\nstream_0.m3u8",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("expected master to contain %s, got:\n%s", want, got)
+			}
+		}
+		if strings.Count(got, "CLOSED-CAPTIONS=") != 1 {
+			t.Errorf("expected only the H.264 variant to reference captions, got:\n%s", got)
+		}
+	})
+
+	t.Run("DASH", func(t *testing.T) {
+		dir := t.TempDir()
+		mpd := `<MPD><Period>` +
+			`<AdaptationSet id="0" contentType="video"></AdaptationSet>` +
+			`<AdaptationSet id="1" contentType="video"></AdaptationSet>` +
+			`</Period></MPD>`
+		if err := os.WriteFile(filepath.Join(dir, "manifest.mpd"), []byte(mpd), 0o644); err != nil {
+			t.Fatalf("write mpd: %v", err)
+		}
+
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+		opts := EncoderOptions{ClosedCaptions: []config.CaptionService{{InstreamID: "CC1", Language: "eng"}}}
+		if _, err := EncodeDASHCMAFWithExecutor(context.Background(), "in", dir, info, config.VOD, l, mock, nil, opts); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		if !hasArgPair(mock.CallLog[0].Args, "-a53cc:v:0", "1") {
+			t.Error("expected captions carried on the H.264 representation")
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "manifest.mpd"))
+		if err != nil {
+			t.Fatalf("read mpd: %v", err)
+		}
+		want := `<AdaptationSet id="0" contentType="video"><Accessibility schemeIdUri="urn:scte:dash:cc:cea-608:2015" value="CC1=eng"/></AdaptationSet>` +
+			`<AdaptationSet id="1" contentType="video"></AdaptationSet>`
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected manifest to contain %s, got:\n%s", want, data)
+		}
+	})
+}
+
+func TestEncodeClosedCaptionsReducedFrameRate(t *testing.T) {
+	// The 360p rung drops to 30 fps and with it half the caption data.
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 60, ClosedCaptions: true}
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 7500, BufSize: 15000, Profile: "high", Level: "4.2"},
+		{Width: 640, Height: 360, MaxRate: 800, BufSize: 1600, Profile: "main", Level: "3.0", FPS: 30},
+	}
+
+	t.Run("HLS", func(t *testing.T) {
+		dir := t.TempDir()
+		master := "#EXTM3U\n" +
+			`#EXT-X-STREAM-INF:BANDWIDTH=8250000,RESOLUTION=1920x1080,CODECS="avc1.64002a"` + "\nstream_0.m3u8\n" +
+			`#EXT-X-STREAM-INF:BANDWIDTH=880000,RESOLUTION=640x360,CODECS="avc1.4d401e"` + "\nstream_1.m3u8\n"
+		if err := os.WriteFile(filepath.Join(dir, "master.m3u8"), []byte(master), 0o644); err != nil {
+			t.Fatalf("write master: %v", err)
+		}
+
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+		if _, err := EncodeHLSCMAFWithExecutor(context.Background(), "in", dir, info, config.VOD, l, mock, nil, EncoderOptions{}); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		args := mock.CallLog[0].Args
+		if !hasArgPair(args, "-a53cc:v:0", "1") {
+			t.Error("expected captions carried on the full-rate variant")
+		}
+		if hasArgPair(args, "-a53cc:v:1", "1") {
+			t.Error("expected no a53cc on the 30 fps variant")
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "master.m3u8"))
+		if err != nil {
+			t.Fatalf("read master: %v", err)
+		}
+		got := string(data)
+		if !strings.Contains(got, `CODECS="avc1.64002a",CLOSED-CAPTIONS="cc"`+"\nstream_0.m3u8") {
+			t.Errorf("expected the full-rate variant to reference captions, got:\n%s", got)
+		}
+		if strings.Count(got, "CLOSED-CAPTIONS=") != 1 {
+			t.Errorf("expected only the full-rate variant to reference captions, got:\n%s", got)
+		}
+	})
+
+	t.Run("DASH", func(t *testing.T) {
+		dir := t.TempDir()
+		mpd := `<MPD><Period><AdaptationSet id="0" contentType="video"></AdaptationSet></Period></MPD>`
+		if err := os.WriteFile(filepath.Join(dir, "manifest.mpd"), []byte(mpd), 0o644); err != nil {
+			t.Fatalf("write mpd: %v", err)
+		}
+
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+		if _, err := EncodeDASHCMAFWithExecutor(context.Background(), "in", dir, info, config.VOD, l, mock, nil, EncoderOptions{}); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		args := mock.CallLog[0].Args
+		if !hasArgPair(args, "-a53cc:v:0", "1") || hasArgPair(args, "-a53cc:v:1", "1") {
+			t.Errorf("expected a53cc on the full-rate representation only, got %v", args)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "manifest.mpd"))
+		if err != nil {
+			t.Fatalf("read mpd: %v", err)
+		}
+		// The AdaptationSet holds a rung without captions, so it cannot signal them.
+		if strings.Contains(string(data), "Accessibility") {
+			t.Errorf("expected no caption Accessibility, got:\n%s", data)
+		}
+	})
+}
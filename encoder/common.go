@@ -1,9 +1,16 @@
 package encoder
 
 import (
+	"context"
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
 )
 
 // calcGOP calculates the Group of Pictures (GOP) size based on FPS and segment duration.
@@ -24,20 +31,79 @@ func calcGOP(fps float64, segmentSec int) int {
 	return gop
 }
 
+// scaleFilter returns the filter that scales the source to rendition r with
+// square pixels. With pad, the source keeps its display aspect ratio and is
+// letterboxed or pillarboxed into the rendition's frame; an anamorphic source
+// is first stretched to square pixels, which the fit does not account for.
+func scaleFilter(r ladder.Rendition, info probe.VideoInfo, pad bool) string {
+	if !pad {
+		return fmt.Sprintf("scale=%d:%d,setsar=1", r.Width, r.Height)
+	}
+	square := ""
+	if info.Anamorphic() {
+		square = "scale=trunc(iw*sar/2)*2:ih,setsar=1,"
+	}
+	return fmt.Sprintf("%sscale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1",
+		square, r.Width, r.Height, r.Width, r.Height)
+}
+
+// fpsFilter returns the filter that drops rendition r to its frame rate, or an
+// empty string when it keeps the source rate.
+func fpsFilter(r ladder.Rendition, sourceFPS float64) string {
+	if fps := r.FrameRate(sourceFPS); fps != sourceFPS {
+		return "fps=" + strconv.FormatFloat(fps, 'f', -1, 64)
+	}
+	return ""
+}
+
+// gopArgs returns the keyframe interval flags of the i-th output video stream.
+// The interval is the GOP of the source rate scaled to the rendition's frame
+// rate, so keyframes of every rendition fall at the same times and segments
+// stay aligned across variants, e.g. a GOP of 125 frames at 25 fps next to
+// 250 at 50 fps rather than an even 126.
+func gopArgs(i int, r ladder.Rendition, sourceFPS float64, segmentSec int) []string {
+	gop := calcGOP(sourceFPS, segmentSec)
+	if fps := r.FrameRate(sourceFPS); fps != sourceFPS {
+		gop = max(int(math.Round(float64(gop)*fps/sourceFPS)), 1)
+	}
+	return []string{
+		fmt.Sprintf("-g:v:%d", i), strconv.Itoa(gop),
+		fmt.Sprintf("-keyint_min:v:%d", i), strconv.Itoa(gop),
+	}
+}
+
+// audioGroupID is the EXT-X-MEDIA GROUP-ID shared by every video variant.
+const audioGroupID = "audio"
+
+// audioBitrate is the AAC bitrate of the shared audio rendition.
+const audioBitrate = "96k"
+
 // buildVarStreamMap generates the var_stream_map string for FFmpeg's HLS muxer.
-// It maps video and audio streams to variant groups (e.g., "v:0,a:0 v:1,a:1").
-func buildVarStreamMap(variants int, hasAudio bool) string {
+// Every video variant references the audio renditions through one EXT-X-MEDIA
+// group (e.g., "v:0,agroup:audio v:1,agroup:audio a:0,agroup:audio,language:eng,default:yes").
+// Audio entries come last so variant playlist indexes match ladder indexes.
+func buildVarStreamMap(variants int, tracks []audioTrack) string {
 	var parts []string
 
 	for i := 0; i < variants; i++ {
-		if hasAudio {
-			// Map video stream i and audio stream i to the same variant.
-			parts = append(parts, fmt.Sprintf("v:%d,a:%d", i, i))
+		if len(tracks) > 0 {
+			parts = append(parts, fmt.Sprintf("v:%d,agroup:%s", i, audioGroupID))
 		} else {
 			parts = append(parts, fmt.Sprintf("v:%d", i))
 		}
 	}
 
+	for k, t := range tracks {
+		part := fmt.Sprintf("a:%d,agroup:%s", k, audioGroupID)
+		if lang := varStreamValue(t.language); lang != "" {
+			part += ",language:" + lang
+		}
+		if t.isDefault {
+			part += ",default:yes"
+		}
+		parts = append(parts, part)
+	}
+
 	return strings.Join(parts, " ")
 }
 
@@ -55,3 +121,254 @@ func ParseProgress(raw string) map[string]string {
 	}
 	return progress
 }
+
+// DefaultAV1Preset is the SVT-AV1 preset used when EncoderOptions.AV1Preset is zero.
+const DefaultAV1Preset = 8
+
+// av1CRF is the quality target for capped-CRF AV1 encodes; MaxRate/BufSize cap it.
+const av1CRF = 35
+
+// videoEncoder returns the FFmpeg encoder name for the given codec and GPU backend.
+// An empty codec selects H.264.
+func videoEncoder(codec config.Codec, gpu config.GPUType) string {
+	switch codec {
+	case config.CodecHEVC:
+		switch gpu {
+		case config.GPU_NVENC:
+			return "hevc_nvenc"
+		case config.GPU_VAAPI:
+			return "hevc_vaapi"
+		case config.GPU_VIDEOTOOLBOX:
+			return "hevc_videotoolbox"
+		}
+		return "libx265"
+	case config.CodecAV1:
+		switch gpu {
+		case config.GPU_NVENC:
+			return "av1_nvenc"
+		case config.GPU_VAAPI:
+			return "av1_vaapi"
+		}
+		// VideoToolbox has no AV1 encoder, so it uses the software path too.
+		return "libsvtav1"
+	}
+
+	switch gpu {
+	case config.GPU_NVENC:
+		return "h264_nvenc"
+	case config.GPU_VAAPI:
+		return "h264_vaapi"
+	case config.GPU_VIDEOTOOLBOX:
+		return "h264_videotoolbox"
+	}
+	return "libx264"
+}
+
+// renditionCodec returns the codec for r, defaulting to the job-wide codec.
+func renditionCodec(r ladder.Rendition, opts EncoderOptions) config.Codec {
+	if r.Codec != "" {
+		return r.Codec
+	}
+	return opts.Codec
+}
+
+// selectVideoEncoder is like videoEncoder but honors the libaom-av1 fallback.
+func selectVideoEncoder(codec config.Codec, opts EncoderOptions) string {
+	enc := videoEncoder(codec, opts.GPU)
+	if enc == "libsvtav1" && opts.av1Fallback {
+		return "libaom-av1"
+	}
+	return enc
+}
+
+// usesSVTAV1 reports whether any rendition runs on libsvtav1 and can still fall back to libaom-av1.
+func usesSVTAV1(l []ladder.Rendition, opts EncoderOptions) bool {
+	for _, r := range l {
+		if selectVideoEncoder(renditionCodec(r, opts), opts) == "libsvtav1" {
+			return true
+		}
+	}
+	return false
+}
+
+// svtAV1Unavailable reports whether an FFmpeg run failed because the build
+// lacks libsvtav1, rather than because ctx ended or the input or output failed.
+// It asks FFmpeg for its encoders once the run has failed, since the failure
+// text depends on the log level and the build.
+func svtAV1Unavailable(ctx context.Context, exec executor.CommandExecutor, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	out, _, listErr := exec.Execute(ctx, "ffmpeg", "-hide_banner", "-encoders")
+	return listErr == nil && !hasEncoder(out, "libsvtav1")
+}
+
+// hasEncoder reports whether the output of ffmpeg -encoders lists the encoder
+// name, e.g. " V....D libsvtav1            SVT-AV1(...)".
+func hasEncoder(encoders []byte, name string) bool {
+	for line := range strings.Lines(string(encoders)) {
+		if f := strings.Fields(line); len(f) >= 2 && f[1] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// codecProfile maps a ladder profile to a valid profile for the target codec.
+// HEVC has no baseline/high distinction, so every 8-bit profile becomes "main".
+func codecProfile(codec config.Codec, profile string) string {
+	if codec != config.CodecHEVC {
+		return profile
+	}
+	switch profile {
+	case "main10", "high10":
+		return "main10"
+	default:
+		return "main"
+	}
+}
+
+// codecLevel returns the level of rendition r at fps for the target codec:
+// its H.264 level, or for HEVC the lowest HEVC level that allows it. An H.264
+// rendition without a level gets the lowest that allows it. An empty result,
+// for a rendition no level allows, leaves the level to the encoder.
+func codecLevel(codec config.Codec, r ladder.Rendition, fps float64) string {
+	if codec != config.CodecHEVC {
+		if r.Level != "" {
+			return r.Level
+		}
+		if l, err := ladder.H264Level(r, fps); err == nil {
+			return l
+		}
+		return ""
+	}
+	if l, err := ladder.HEVCLevel(r, fps); err == nil {
+		return l
+	}
+	return ""
+}
+
+// videoCodecArgs returns the encoder, profile, level, preset, pixel format,
+// color and codec-specific flags for the i-th output video stream, encoded at
+// fps. hdr is the source's HDR format, or nil for an SDR source.
+func videoCodecArgs(i int, r ladder.Rendition, fps float64, opts EncoderOptions, hdr *hdrFormat) []string {
+	codec := renditionCodec(r, opts)
+	enc := selectVideoEncoder(codec, opts)
+	vr := renditionRange(codec, r.Range, hdr)
+	toneMapped := toneMaps(vr, hdr)
+	if vr == config.VideoRangeSDR {
+		hdr = nil
+	}
+	args := []string{fmt.Sprintf("-c:v:%d", i), enc}
+
+	switch codec {
+	case config.CodecAV1:
+		args = append(args, av1Args(i, r, enc, opts.AV1Preset, hdr)...)
+	case config.CodecHEVC:
+		profile := codecProfile(codec, r.Profile)
+		if hdr != nil {
+			profile = "main10"
+		}
+		args = append(args,
+			fmt.Sprintf("-profile:v:%d", i), profile,
+			fmt.Sprintf("-preset:v:%d", i), "medium",
+		)
+		level := codecLevel(codec, r, fps)
+		if enc == "libx265" {
+			// x265 ignores -level and -sc_threshold; keep GOPs closed and fixed
+			// so segments stay independently decodable.
+			params := []string{"scenecut=0", "open-gop=0"}
+			if level != "" {
+				params = append([]string{"level-idc=" + level}, params...)
+			}
+			if hdr != nil {
+				params = append(params, x265HDRParams(hdr)...)
+			}
+			args = append(args,
+				fmt.Sprintf("-x265-params:v:%d", i),
+				strings.Join(params, ":"),
+			)
+		} else if level != "" {
+			args = append(args, fmt.Sprintf("-level:v:%d", i), level)
+		}
+		// Apple players only accept HEVC in fMP4 when the sample entry is hvc1.
+		args = append(args, fmt.Sprintf("-tag:v:%d", i), "hvc1")
+	default:
+		args = append(args, fmt.Sprintf("-profile:v:%d", i), r.Profile)
+		if level := codecLevel(codec, r, fps); level != "" {
+			args = append(args, fmt.Sprintf("-level:v:%d", i), level)
+		}
+		args = append(args, fmt.Sprintf("-preset:v:%d", i), "medium")
+	}
+	return append(args, colorArgs(i, enc, vr, toneMapped)...)
+}
+
+// av1Args returns the speed and rate-control flags for an AV1 stream.
+// The ladder's H.264 profile/level do not apply; encoders derive the AV1
+// level from resolution and frame rate. Software encoders run capped CRF
+// bounded by the rendition's MaxRate/BufSize. A non-nil hdr makes SVT-AV1
+// write the HDR color config and metadata.
+func av1Args(i int, r ladder.Rendition, enc string, preset int, hdr *hdrFormat) []string {
+	if preset <= 0 {
+		preset = DefaultAV1Preset
+	}
+
+	switch enc {
+	case "libsvtav1":
+		args := []string{
+			fmt.Sprintf("-preset:v:%d", i), strconv.Itoa(preset),
+			fmt.Sprintf("-crf:v:%d", i), strconv.Itoa(av1CRF),
+		}
+		if hdr != nil {
+			args = append(args, fmt.Sprintf("-svtav1-params:v:%d", i), strings.Join(svtAV1HDRParams(hdr), ":"))
+		}
+		return args
+	case "libaom-av1":
+		// libaom treats -b:v as the ceiling in constrained-quality mode.
+		return []string{
+			fmt.Sprintf("-cpu-used:v:%d", i), strconv.Itoa(min(preset, 8)),
+			fmt.Sprintf("-row-mt:v:%d", i), "1",
+			fmt.Sprintf("-crf:v:%d", i), strconv.Itoa(av1CRF),
+			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", r.MaxRate),
+		}
+	default:
+		// Hardware AV1 encoders run VBR towards MaxRate.
+		return []string{
+			fmt.Sprintf("-b:v:%d", i), fmt.Sprintf("%dk", r.MaxRate),
+		}
+	}
+}
+
+// runFFmpeg executes ffmpeg with the given arguments. When progressHandler is
+// non-nil, progress is streamed from -progress pipe:1 and parsed for the handler.
+func runFFmpeg(
+	ctx context.Context,
+	exec executor.CommandExecutor,
+	args []string,
+	progressHandler func(map[string]string),
+) (*executor.Usage, error) {
+	if progressHandler == nil {
+		_, usage, err := exec.Execute(ctx, "ffmpeg", args...)
+		return usage, err
+	}
+
+	args = append(args, "-progress", "pipe:1")
+	progressChan := make(chan string)
+	errChan := make(chan error, 1)
+	var usage *executor.Usage
+
+	go func() {
+		var err error
+		_, usage, err = exec.ExecuteWithProgress(ctx, progressChan, "ffmpeg", args...)
+		errChan <- err
+	}()
+
+	for raw := range progressChan {
+		progressHandler(ParseProgress(raw))
+	}
+
+	if err := <-errChan; err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
@@ -2,7 +2,12 @@ package encoder
 
 import (
 	"reflect"
+	"slices"
 	"testing"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
 )
 
 func TestParseProgress(t *testing.T) {
@@ -88,3 +93,165 @@ func TestCalcGOPProperties(t *testing.T) {
 		}
 	}
 }
+
+func TestGOPArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		want       []string
+		rendition  ladder.Rendition
+		fps        float64
+		segmentSec int
+	}{
+		{name: "source rate", fps: 30, want: []string{"-g:v:1", "60", "-keyint_min:v:1", "60"}},
+		{name: "cap above source", fps: 30, rendition: ladder.Rendition{MaxFPS: 60}, want: []string{"-g:v:1", "60", "-keyint_min:v:1", "60"}},
+		{name: "capped", fps: 60, rendition: ladder.Rendition{MaxFPS: 30}, want: []string{"-g:v:1", "60", "-keyint_min:v:1", "60"}},
+		{name: "low rate", fps: 60, rendition: ladder.Rendition{MaxFPS: 15}, want: []string{"-g:v:1", "30", "-keyint_min:v:1", "30"}},
+		{name: "target rate", fps: 60, rendition: ladder.Rendition{FPS: 30}, want: []string{"-g:v:1", "60", "-keyint_min:v:1", "60"}},
+		{name: "target above cap", fps: 60, rendition: ladder.Rendition{FPS: 60, MaxFPS: 24}, want: []string{"-g:v:1", "48", "-keyint_min:v:1", "48"}},
+		{name: "target above source", fps: 30, rendition: ladder.Rendition{FPS: 60}, want: []string{"-g:v:1", "60", "-keyint_min:v:1", "60"}},
+		// An even GOP of 26 at 25 fps would drift from 50 at 50 fps.
+		{name: "halved odd GOP", fps: 50, segmentSec: 1, rendition: ladder.Rendition{FPS: 25}, want: []string{"-g:v:1", "25", "-keyint_min:v:1", "25"}},
+		{name: "unknown source rate", rendition: ladder.Rendition{FPS: 30}, want: []string{"-g:v:1", "24", "-keyint_min:v:1", "24"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segmentSec := tt.segmentSec
+			if segmentSec == 0 {
+				segmentSec = 2
+			}
+			got := gopArgs(1, tt.rendition, tt.fps, segmentSec)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("gopArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVideoEncoder(t *testing.T) {
+	tests := []struct {
+		codec config.Codec
+		gpu   config.GPUType
+		want  string
+	}{
+		{codec: "", gpu: "", want: "libx264"},
+		{codec: config.CodecH264, gpu: config.GPU_NVENC, want: "h264_nvenc"},
+		{codec: config.CodecH264, gpu: config.GPU_VAAPI, want: "h264_vaapi"},
+		{codec: config.CodecH264, gpu: config.GPU_VIDEOTOOLBOX, want: "h264_videotoolbox"},
+		{codec: config.CodecHEVC, gpu: "", want: "libx265"},
+		{codec: config.CodecHEVC, gpu: config.GPU_NVENC, want: "hevc_nvenc"},
+		{codec: config.CodecHEVC, gpu: config.GPU_VAAPI, want: "hevc_vaapi"},
+		{codec: config.CodecHEVC, gpu: config.GPU_VIDEOTOOLBOX, want: "hevc_videotoolbox"},
+		{codec: config.CodecAV1, gpu: "", want: "libsvtav1"},
+		{codec: config.CodecAV1, gpu: config.GPU_NVENC, want: "av1_nvenc"},
+		{codec: config.CodecAV1, gpu: config.GPU_VAAPI, want: "av1_vaapi"},
+		{codec: config.CodecAV1, gpu: config.GPU_VIDEOTOOLBOX, want: "libsvtav1"},
+	}
+
+	for _, tt := range tests {
+		got := videoEncoder(tt.codec, tt.gpu)
+		if got != tt.want {
+			t.Errorf("videoEncoder(%q, %q) = %q, want %q", tt.codec, tt.gpu, got, tt.want)
+		}
+	}
+}
+
+func TestCodecProfile(t *testing.T) {
+	tests := []struct {
+		codec       config.Codec
+		profile     string
+		wantProfile string
+	}{
+		{codec: config.CodecH264, profile: "baseline", wantProfile: "baseline"},
+		{codec: config.CodecHEVC, profile: "baseline", wantProfile: "main"},
+		{codec: config.CodecHEVC, profile: "main", wantProfile: "main"},
+		{codec: config.CodecHEVC, profile: "high", wantProfile: "main"},
+		{codec: config.CodecHEVC, profile: "high10", wantProfile: "main10"},
+	}
+
+	for _, tt := range tests {
+		if got := codecProfile(tt.codec, tt.profile); got != tt.wantProfile {
+			t.Errorf("codecProfile(%q, %q) = %q, want %q", tt.codec, tt.profile, got, tt.wantProfile)
+		}
+	}
+}
+
+func TestCodecLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		codec     config.Codec
+		rendition ladder.Rendition
+		fps       float64
+		wantLevel string
+	}{
+		{name: "H.264 keeps its level", codec: config.CodecH264, rendition: ladder.Rendition{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Level: "3.0"}, fps: 30, wantLevel: "3.0"},
+		{name: "H.264 without a level", codec: config.CodecH264, rendition: ladder.Rendition{Width: 1920, Height: 1080, MaxRate: 7354, BufSize: 14708, Profile: "main"}, fps: 60, wantLevel: "4.2"},
+		{name: "H.264 beyond every level", codec: config.CodecH264, rendition: ladder.Rendition{Width: 3840, Height: 2160, MaxRate: 16000, BufSize: 32000, Profile: "high"}, fps: 600, wantLevel: ""},
+		{name: "HEVC beyond every level without a level", codec: config.CodecHEVC, rendition: ladder.Rendition{Width: 7680, Height: 4320, MaxRate: 500000, BufSize: 500000}, fps: 60, wantLevel: ""},
+		{name: "HEVC 360p", codec: config.CodecHEVC, rendition: ladder.Rendition{Width: 640, Height: 360, MaxRate: 700, BufSize: 1400, Level: "3.0"}, fps: 30, wantLevel: "2.1"},
+		{name: "HEVC 720p", codec: config.CodecHEVC, rendition: ladder.Rendition{Width: 1280, Height: 720, MaxRate: 2100, BufSize: 4200, Level: "3.1"}, fps: 30, wantLevel: "3.1"},
+		{name: "HEVC 1080p", codec: config.CodecHEVC, rendition: ladder.Rendition{Width: 1920, Height: 1080, MaxRate: 3500, BufSize: 7000, Level: "4.0"}, fps: 30, wantLevel: "4"},
+		{name: "HEVC 1080p60", codec: config.CodecHEVC, rendition: ladder.Rendition{Width: 1920, Height: 1080, MaxRate: 5148, BufSize: 10296, Level: "4.2"}, fps: 60, wantLevel: "4.1"},
+		{name: "HEVC 2160p", codec: config.CodecHEVC, rendition: ladder.Rendition{Width: 3840, Height: 2160, MaxRate: 11200, BufSize: 22400, Level: "5.1"}, fps: 30, wantLevel: "5"},
+		{name: "HEVC beyond every level ignores its H.264 level", codec: config.CodecHEVC, rendition: ladder.Rendition{Width: 7680, Height: 4320, MaxRate: 500000, BufSize: 500000, Level: "6.2"}, fps: 60, wantLevel: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := codecLevel(tt.codec, tt.rendition, tt.fps); got != tt.wantLevel {
+				t.Errorf("codecLevel() = %q, want %q", got, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestVideoCodecArgsWithoutLevel(t *testing.T) {
+	r := ladder.Rendition{Width: 3840, Height: 2160, MaxRate: 16000, BufSize: 32000, Profile: "high"}
+	for _, arg := range videoCodecArgs(0, r, 600, EncoderOptions{}, nil) {
+		if arg == "-level:v:0" {
+			t.Fatal("expected no -level for a rendition no level allows")
+		}
+	}
+}
+
+func TestBuildVarStreamMap(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		tracks   []probe.AudioStream
+		inputs   []AudioInput
+		variants int
+	}{
+		{name: "video only", variants: 2, expected: "v:0 v:1"},
+		{name: "shared audio group", variants: 3, tracks: []probe.AudioStream{{}}, expected: "v:0,agroup:audio v:1,agroup:audio v:2,agroup:audio a:0,agroup:audio,default:yes"},
+		{name: "single variant with audio", variants: 1, tracks: []probe.AudioStream{{}}, expected: "v:0,agroup:audio a:0,agroup:audio,default:yes"},
+		{
+			name:     "languages with explicit default",
+			variants: 1,
+			tracks:   []probe.AudioStream{{Language: "eng"}, {Language: "spa", Default: true}, {Language: "fr ca"}},
+			expected: "v:0,agroup:audio a:0,agroup:audio,language:eng a:1,agroup:audio,language:spa,default:yes a:2,agroup:audio,language:frca",
+		},
+		{
+			name:     "sidecar after source audio",
+			variants: 1,
+			tracks:   []probe.AudioStream{{Language: "eng"}},
+			inputs:   []AudioInput{{Path: "dub.wav", Language: "deu"}},
+			expected: "v:0,agroup:audio a:0,agroup:audio,language:eng,default:yes a:1,agroup:audio,language:deu",
+		},
+		{
+			name:     "sidecar main track without source audio",
+			variants: 1,
+			inputs:   []AudioInput{{Path: "ad.wav", Role: config.AudioRoleDescription}, {Path: "main.wav", Role: config.AudioRoleMain}},
+			expected: "v:0,agroup:audio a:0,agroup:audio a:1,agroup:audio,default:yes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracks := audioTracks(probe.VideoInfo{AudioStreams: tt.tracks}, EncoderOptions{AudioInputs: tt.inputs})
+			if got := buildVarStreamMap(tt.variants, tracks); got != tt.expected {
+				t.Errorf("buildVarStreamMap() = %q, want %q", got, tt.expected)
+			}
+		})
+	}
+}
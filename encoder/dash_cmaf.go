@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/farshidrezaei/mosaic/config"
 	"github.com/farshidrezaei/mosaic/internal/executor"
@@ -38,8 +39,39 @@ func EncodeDASHCMAFWithExecutor(
 	progressHandler func(map[string]string),
 	opts EncoderOptions,
 ) (*executor.Usage, error) {
+	args := buildDASHArgs(input, outDir, info, profile, l, opts)
+	usage, err := runFFmpeg(ctx, exec, args, progressHandler)
+	if usesSVTAV1(l, opts) && svtAV1Unavailable(ctx, exec, err) {
+		// libsvtav1 is missing from many FFmpeg builds; retry with libaom-av1.
+		opts.av1Fallback = true
+		args = buildDASHArgs(input, outDir, info, profile, l, opts)
+		usage, err = runFFmpeg(ctx, exec, args, progressHandler)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg DASH failed: %w", err)
+	}
+
+	if err := writeSubtitles(ctx, exec, input, outDir, subtitleTracks(info, opts), opts); err != nil {
+		return nil, err
+	}
 
-	gop := calcGOP(info.FPS, profile.SegmentDuration)
+	if err := patchManifest(filepath.Join(outDir, "manifest.mpd"), l, info, opts); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// buildDASHArgs assembles the FFmpeg arguments for a single-pass DASH CMAF encode.
+func buildDASHArgs(
+	input string,
+	outDir string,
+	info probe.VideoInfo,
+	profile config.Profile,
+	l []ladder.Rendition,
+	opts EncoderOptions,
+) []string {
+	captions := len(captionServices(info, opts)) > 0
+	hdr := sourceHDR(info, opts)
 
 	args := []string{
 		"-y",
@@ -51,6 +83,7 @@ func EncodeDASHCMAFWithExecutor(
 
 		"-i", input,
 	}
+	args = append(args, audioInputArgs(opts.AudioInputs)...)
 
 	if opts.Threads > 0 {
 		args = append(args, "-threads", strconv.Itoa(opts.Threads))
@@ -58,28 +91,24 @@ func EncodeDASHCMAFWithExecutor(
 
 	// ---------- VIDEO ----------
 	for i, r := range l {
-		codec := "libx264"
-		switch opts.GPU {
-		case config.GPU_NVENC:
-			codec = "h264_nvenc"
-		case config.GPU_VAAPI:
-			codec = "h264_vaapi"
-		case config.GPU_VIDEOTOOLBOX:
-			codec = "h264_videotoolbox"
+		args = append(args, "-map", "0:v:0")
+		args = append(args, videoCodecArgs(i, r, r.FrameRate(info.FPS), opts, hdr)...)
+		var filters []string
+		if fps := fpsFilter(r, info.FPS); fps != "" {
+			filters = append(filters, fps)
 		}
-
+		// An explicit scale keeps square pixels: the scaler -s inserts would
+		// carry an anamorphic SAR over to the output.
+		filters = append(filters, scaleFilter(r, info, opts.Pad))
+		if toneMaps(renditionRange(renditionCodec(r, opts), r.Range, hdr), hdr) {
+			filters = append(filters, toneMapFilter)
+		}
+		args = append(args, fmt.Sprintf("-filter:v:%d", i), strings.Join(filters, ","))
+		if captions && renditionCarriesCaptions(r, opts, info.FPS) {
+			args = append(args, captionArgs(i, selectVideoEncoder(renditionCodec(r, opts), opts))...)
+		}
+		args = append(args, gopArgs(i, r, info.FPS, profile.SegmentDuration)...)
 		args = append(args,
-			"-map", "0:v:0",
-
-			fmt.Sprintf("-c:v:%d", i), codec,
-			fmt.Sprintf("-profile:v:%d", i), r.Profile,
-			fmt.Sprintf("-level:v:%d", i), r.Level,
-
-			"-pix_fmt", "yuv420p",
-			"-preset", "medium",
-
-			"-g", strconv.Itoa(gop),
-			"-keyint_min", strconv.Itoa(gop),
 			"-sc_threshold", "0",
 
 			fmt.Sprintf("-maxrate:v:%d", i), fmt.Sprintf("%dk", r.MaxRate),
@@ -90,16 +119,9 @@ func EncodeDASHCMAFWithExecutor(
 	}
 
 	// ---------- AUDIO ----------
-	if info.HasAudio {
-		for i := range l {
-			args = append(args,
-				"-map", "0:a:0",
-				fmt.Sprintf("-c:a:%d", i), "aac",
-				fmt.Sprintf("-b:a:%d", i), "96k",
-				"-ac", "2",
-			)
-		}
-	}
+	// Each audio track is encoded once, in its own AdaptationSet.
+	tracks := audioTracks(info, opts)
+	args = append(args, audioArgs(tracks)...)
 
 	// ---------- DASH ----------
 	args = append(args,
@@ -112,42 +134,57 @@ func EncodeDASHCMAFWithExecutor(
 		"-init_seg_name", "init-stream$RepresentationID$.m4s",
 		"-media_seg_name", "chunk-stream$RepresentationID$-$Number$.m4s",
 
-		"-adaptation_sets", func() string {
-			if info.HasAudio {
-				return "id=0,streams=v id=1,streams=a"
-			}
-			return "id=0,streams=v"
-		}(),
+		"-adaptation_sets", buildAdaptationSets(l, opts, len(tracks)),
 
 		filepath.Join(outDir, "manifest.mpd"),
 	)
+	return args
+}
 
-	if progressHandler != nil {
-		args = append(args, "-progress", "pipe:1")
-		progressChan := make(chan string)
-		errChan := make(chan error, 1)
-		var usage *executor.Usage
-
-		go func() {
-			var err error
-			_, usage, err = exec.ExecuteWithProgress(ctx, progressChan, "ffmpeg", args...)
-			errChan <- err
-		}()
+// videoGroup is the codec and target range (ladder.Rendition.Range) shared by
+// the renditions of one video AdaptationSet.
+type videoGroup struct {
+	codec      config.Codec
+	videoRange config.VideoRange
+}
 
-		for raw := range progressChan {
-			progressHandler(ParseProgress(raw))
+// videoGroups returns the ladder's codec and range combinations in order of
+// first appearance and the output stream indexes encoded with each.
+func videoGroups(l []ladder.Rendition, opts EncoderOptions) ([]videoGroup, map[videoGroup][]string) {
+	var groups []videoGroup
+	streams := map[videoGroup][]string{}
+	for i, r := range l {
+		g := videoGroup{codec: renditionCodec(r, opts), videoRange: r.Range}
+		if _, ok := streams[g]; !ok {
+			groups = append(groups, g)
 		}
+		streams[g] = append(streams[g], strconv.Itoa(i))
+	}
+	return groups, streams
+}
 
-		if err := <-errChan; err != nil {
-			return nil, fmt.Errorf("ffmpeg DASH failed: %w", err)
-		}
-		return usage, nil
+// buildAdaptationSets groups video streams into one AdaptationSet per codec
+// and range, in order of first appearance, so players can pick a supported
+// codec family and dynamic range before switching between its rungs. Each
+// audio track gets its own AdaptationSet so it can carry its own lang and Role.
+func buildAdaptationSets(l []ladder.Rendition, opts EncoderOptions, audioCount int) string {
+	groups, streams := videoGroups(l, opts)
+
+	var sets []string
+	if len(groups) <= 1 {
+		sets = append(sets, "id=0,streams=v")
 	} else {
-		_, usage, err := exec.Execute(ctx, "ffmpeg", args...)
-		if err != nil {
-			return nil, fmt.Errorf("ffmpeg DASH failed: %w", err)
+		for id, g := range groups {
+			sets = append(sets, fmt.Sprintf("id=%d,streams=%s", id, strings.Join(streams[g], ",")))
 		}
-		return usage, nil
 	}
 
+	if audioCount == 1 {
+		sets = append(sets, fmt.Sprintf("id=%d,streams=a", len(sets)))
+	} else {
+		for k := 0; k < audioCount; k++ {
+			sets = append(sets, fmt.Sprintf("id=%d,streams=%d", len(sets), len(l)+k))
+		}
+	}
+	return strings.Join(sets, " ")
 }
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/farshidrezaei/mosaic/config"
@@ -451,6 +453,44 @@ func TestEncodeLowLatency(t *testing.T) {
 	})
 }
 
+func TestEncodeDASHScaling(t *testing.T) {
+	l := []ladder.Rendition{{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"}}
+	tests := []struct {
+		name     string
+		expected string
+		info     probe.VideoInfo
+		pad      bool
+	}{
+		{name: "scaled", info: probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30}, expected: "scale=1280:720,setsar=1"},
+		{
+			name:     "padded",
+			info:     probe.VideoInfo{Width: 1440, Height: 1080, FPS: 30},
+			pad:      true,
+			expected: "scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1",
+		},
+		{
+			name:     "padded anamorphic",
+			info:     probe.VideoInfo{Width: 720, Height: 576, FPS: 25, SampleAspectRatio: probe.AspectRatio{Num: 16, Den: 15}},
+			pad:      true,
+			expected: "scale=trunc(iw*sar/2)*2:ih,setsar=1,scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+			if _, err := EncodeDASHCMAFWithExecutor(context.Background(), "in", t.TempDir(), tt.info, config.VOD, l, mock, nil, EncoderOptions{Pad: tt.pad}); err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+			args := mock.CallLog[0].Args
+			if !hasArgPair(args, "-filter:v:0", tt.expected) || !hasArgPair(args, "-s:v:0", "1280x720") {
+				t.Errorf("expected -filter:v:0 %s, got %v", tt.expected, args)
+			}
+		})
+	}
+}
+
 func TestEncodeEncoderError(t *testing.T) {
 	mock := executor.NewMockExecutor()
 	mock.Responses["ffmpeg"] = executor.MockResponse{Err: errors.New("ffmpeg failed")}
@@ -486,3 +526,439 @@ func TestEncodeEncoderError(t *testing.T) {
 		}
 	})
 }
+
+func TestEncodeHEVC(t *testing.T) {
+	// 1080p60 needs HEVC level 4.1 for its sample rate.
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 60, HasAudio: true}
+	l := []ladder.Rendition{{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "high", Level: "4.2"}}
+
+	encoders := map[string]func(*executor.MockCommandExecutor, EncoderOptions) error{
+		"HLS": func(m *executor.MockCommandExecutor, o EncoderOptions) error {
+			_, err := EncodeHLSCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, m, nil, o)
+			return err
+		},
+		"DASH": func(m *executor.MockCommandExecutor, o EncoderOptions) error {
+			_, err := EncodeDASHCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, m, nil, o)
+			return err
+		},
+	}
+
+	for name, encode := range encoders {
+		t.Run(name+" software", func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+
+			if err := encode(mock, EncoderOptions{Codec: config.CodecHEVC}); err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+
+			args := mock.CallLog[0].Args
+			if !hasArgPair(args, "-c:v:0", "libx265") {
+				t.Error("expected libx265 encoder")
+			}
+			if !hasArgPair(args, "-tag:v:0", "hvc1") {
+				t.Error("expected hvc1 tag")
+			}
+			if !hasArgPair(args, "-profile:v:0", "main") {
+				t.Error("expected HEVC main profile")
+			}
+			if !hasArgPair(args, "-x265-params:v:0", "level-idc=4.1:scenecut=0:open-gop=0") {
+				t.Error("expected x265 level and closed GOP params")
+			}
+		})
+
+		t.Run(name+" NVENC", func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+
+			if err := encode(mock, EncoderOptions{Codec: config.CodecHEVC, GPU: config.GPU_NVENC}); err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+
+			args := mock.CallLog[0].Args
+			if !hasArgPair(args, "-c:v:0", "hevc_nvenc") {
+				t.Error("expected hevc_nvenc encoder")
+			}
+			if !hasArgPair(args, "-level:v:0", "4.1") {
+				t.Error("expected HEVC level 4.1")
+			}
+			if !hasArgPair(args, "-tag:v:0", "hvc1") {
+				t.Error("expected hvc1 tag")
+			}
+		})
+	}
+}
+
+// hasArgPair reports whether args contains key immediately followed by value.
+func hasArgPair(args []string, key, value string) bool {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == key && args[i+1] == value {
+			return true
+		}
+	}
+	return false
+}
+
+// encodersMock answers ffmpeg -encoders with its list and passes every other
+// command to MockCommandExecutor.
+type encodersMock struct {
+	*executor.MockCommandExecutor
+	encoders string
+	listed   int
+}
+
+func newEncodersMock(encoders string, encode executor.MockResponse) *encodersMock {
+	mock := executor.NewMockExecutor()
+	mock.Responses["ffmpeg"] = encode
+	return &encodersMock{MockCommandExecutor: mock, encoders: encoders}
+}
+
+func (m *encodersMock) Execute(ctx context.Context, name string, args ...string) ([]byte, *executor.Usage, error) {
+	if slices.Contains(args, "-encoders") {
+		m.listed++
+		return []byte(m.encoders), nil, nil
+	}
+	return m.MockCommandExecutor.Execute(ctx, name, args...)
+}
+
+const (
+	encodersWithSVT = " V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)\n" +
+		" V....D libsvtav1            SVT-AV1(Scalable Video Technology for AV1) encoder (codec av1)\n"
+	encodersWithoutSVT = " V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)\n" +
+		" V....D libaom-av1           libaom AV1 (codec av1)\n"
+)
+
+func TestHasEncoder(t *testing.T) {
+	if !hasEncoder([]byte(encodersWithSVT), "libsvtav1") {
+		t.Error("expected libsvtav1 in the list")
+	}
+	if hasEncoder([]byte(encodersWithoutSVT), "libsvtav1") {
+		t.Error("expected no libsvtav1 in the list")
+	}
+	if hasEncoder([]byte(" V....D libsvtav1_fork  other\n"), "libsvtav1") {
+		t.Error("expected an exact encoder name match")
+	}
+}
+
+func TestEncodeAV1(t *testing.T) {
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, HasAudio: true}
+	l := []ladder.Rendition{{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"}}
+
+	t.Run("SVT-AV1 rate control", func(t *testing.T) {
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+
+		_, err := EncodeHLSCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, mock, nil, EncoderOptions{Codec: config.CodecAV1, AV1Preset: 6})
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		args := mock.CallLog[0].Args
+		if !hasArgPair(args, "-c:v:0", "libsvtav1") {
+			t.Error("expected libsvtav1 encoder")
+		}
+		if !hasArgPair(args, "-preset:v:0", "6") {
+			t.Error("expected SVT-AV1 preset 6")
+		}
+		if !hasArgPair(args, "-maxrate:v:0", "5000k") || !hasArgPair(args, "-bufsize:v:0", "10000k") {
+			t.Error("expected capped CRF bounds from rendition")
+		}
+		for _, arg := range args {
+			if arg == "-profile:v:0" || arg == "-level:v:0" {
+				t.Errorf("unexpected H.264 %s for AV1", arg)
+			}
+		}
+	})
+
+	t.Run("falls back to libaom-av1", func(t *testing.T) {
+		// With -v quiet the failure carries no message to recognise.
+		mock := newEncodersMock(encodersWithoutSVT, executor.MockResponse{Err: errors.New("exit status 8")})
+
+		_, err := EncodeDASHCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, mock, nil, EncoderOptions{Codec: config.CodecAV1})
+		if err == nil {
+			t.Fatal("expected error but got none")
+		}
+		if mock.GetCallCount("ffmpeg") != 2 || mock.listed != 1 {
+			t.Fatalf("expected 2 ffmpeg encodes and 1 encoder list, got %d and %d", mock.GetCallCount("ffmpeg"), mock.listed)
+		}
+
+		args := mock.CallLog[1].Args
+		if !hasArgPair(args, "-c:v:0", "libaom-av1") {
+			t.Error("expected libaom-av1 on retry")
+		}
+		if !hasArgPair(args, "-cpu-used:v:0", "8") {
+			t.Error("expected default preset mapped to cpu-used 8")
+		}
+		if !hasArgPair(args, "-b:v:0", "5000k") {
+			t.Error("expected constrained-quality ceiling from MaxRate")
+		}
+	})
+
+	t.Run("no fallback for other failures", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		tests := []struct {
+			name     string
+			ctx      context.Context
+			err      error
+			encoders string
+			listed   int
+		}{
+			{name: "bad input", ctx: context.Background(), err: errors.New("in: No such file or directory"), encoders: encodersWithSVT, listed: 1},
+			{name: "cancelled", ctx: cancelled, err: errors.New("signal: killed"), encoders: encodersWithoutSVT},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				mock := newEncodersMock(tt.encoders, executor.MockResponse{Err: tt.err})
+
+				_, err := EncodeHLSCMAFWithExecutor(tt.ctx, "in", "out", info, config.VOD, l, mock, nil, EncoderOptions{Codec: config.CodecAV1})
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected the first error, got %v", err)
+				}
+				if mock.GetCallCount("ffmpeg") != 1 || mock.listed != tt.listed {
+					t.Fatalf("expected 1 ffmpeg encode and %d encoder lists, got %d and %d", tt.listed, mock.GetCallCount("ffmpeg"), mock.listed)
+				}
+			})
+		}
+	})
+
+	t.Run("no fallback for hardware AV1", func(t *testing.T) {
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Err: errors.New("ffmpeg failed")}
+
+		_, err := EncodeHLSCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, mock, nil, EncoderOptions{Codec: config.CodecAV1, GPU: config.GPU_NVENC})
+		if err == nil {
+			t.Fatal("expected error but got none")
+		}
+		if mock.GetCallCount("ffmpeg") != 1 {
+			t.Fatalf("expected 1 ffmpeg call, got %d", mock.GetCallCount("ffmpeg"))
+		}
+		if !hasArgPair(mock.CallLog[0].Args, "-c:v:0", "av1_nvenc") {
+			t.Error("expected av1_nvenc encoder")
+		}
+	})
+}
+
+func TestEncodeMultiCodec(t *testing.T) {
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, HasAudio: true}
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
+		{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+		{Codec: config.CodecHEVC, Width: 1920, Height: 1080, MaxRate: 3500, BufSize: 7000, Profile: "main", Level: "4.0"},
+	}
+
+	t.Run("HLS per-rendition encoders", func(t *testing.T) {
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+
+		_, err := EncodeHLSCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, mock, nil, EncoderOptions{})
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		args := mock.CallLog[0].Args
+		if !hasArgPair(args, "-c:v:0", "libx264") || !hasArgPair(args, "-c:v:1", "libx264") {
+			t.Error("expected libx264 for H.264 rungs")
+		}
+		if !hasArgPair(args, "-c:v:2", "libx265") || !hasArgPair(args, "-tag:v:2", "hvc1") {
+			t.Error("expected libx265 with hvc1 tag for HEVC rung")
+		}
+		if !hasArgPair(args, "-var_stream_map", "v:0,agroup:audio v:1,agroup:audio v:2,agroup:audio a:0,agroup:audio,default:yes") {
+			t.Error("expected every rendition in the master playlist")
+		}
+	})
+
+	t.Run("DASH adaptation set per codec", func(t *testing.T) {
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+
+		_, err := EncodeDASHCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, mock, nil, EncoderOptions{})
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		if !hasArgPair(mock.CallLog[0].Args, "-adaptation_sets", "id=0,streams=0,1 id=1,streams=2 id=2,streams=a") {
+			t.Errorf("expected per-codec adaptation sets, got args %v", mock.CallLog[0].Args)
+		}
+	})
+}
+
+func TestBuildAdaptationSets(t *testing.T) {
+	tests := []struct {
+		name       string
+		expected   string
+		ladder     []ladder.Rendition
+		opts       EncoderOptions
+		audioCount int
+	}{
+		{
+			name:       "single codec with audio",
+			ladder:     []ladder.Rendition{{}, {}},
+			audioCount: 1,
+			expected:   "id=0,streams=v id=1,streams=a",
+		},
+		{
+			name:       "audio set per language",
+			ladder:     []ladder.Rendition{{}, {}},
+			audioCount: 2,
+			expected:   "id=0,streams=v id=1,streams=2 id=2,streams=3",
+		},
+		{
+			name:     "single codec without audio",
+			ladder:   []ladder.Rendition{{}},
+			expected: "id=0,streams=v",
+		},
+		{
+			name:     "explicit codec matching job default",
+			ladder:   []ladder.Rendition{{}, {Codec: config.CodecHEVC}},
+			opts:     EncoderOptions{Codec: config.CodecHEVC},
+			expected: "id=0,streams=v",
+		},
+		{
+			name:       "three codecs interleaved",
+			ladder:     []ladder.Rendition{{}, {Codec: config.CodecAV1}, {}, {Codec: config.CodecHEVC}, {Codec: config.CodecAV1}},
+			audioCount: 1,
+			expected:   "id=0,streams=0,2 id=1,streams=1,4 id=2,streams=3 id=3,streams=a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := buildAdaptationSets(tt.ladder, tt.opts, tt.audioCount); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestEncodeSharedAudio(t *testing.T) {
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, HasAudio: true}
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
+		{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
+		{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+	}
+
+	encoders := map[string]func(*executor.MockCommandExecutor) error{
+		"HLS": func(m *executor.MockCommandExecutor) error {
+			_, err := EncodeHLSCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, m, nil, EncoderOptions{})
+			return err
+		},
+		"DASH": func(m *executor.MockCommandExecutor) error {
+			_, err := EncodeDASHCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, m, nil, EncoderOptions{})
+			return err
+		},
+	}
+
+	for name, encode := range encoders {
+		t.Run(name, func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+
+			if err := encode(mock); err != nil {
+				t.Fatalf("encode failed: %v", err)
+			}
+
+			args := mock.CallLog[0].Args
+			audioMaps := 0
+			for i := 0; i < len(args)-1; i++ {
+				if args[i] == "-map" && (args[i+1] == "a:0" || args[i+1] == "0:a:0") {
+					audioMaps++
+				}
+			}
+			if audioMaps != 1 {
+				t.Errorf("expected audio to be mapped once, got %d", audioMaps)
+			}
+			if !hasArgPair(args, "-c:a:0", "aac") {
+				t.Error("expected AAC audio")
+			}
+			if hasArgPair(args, "-c:a:1", "aac") {
+				t.Error("expected no duplicated audio streams")
+			}
+		})
+	}
+}
+
+func TestEncodeMultipleAudioTracks(t *testing.T) {
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, HasAudio: true, AudioStreams: []probe.AudioStream{
+		{Index: 1, Language: "eng", Default: true},
+		{Index: 2, Language: "spa"},
+	}}
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
+		{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+	}
+
+	t.Run("HLS alternate audio renditions", func(t *testing.T) {
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+
+		_, err := EncodeHLSCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, mock, nil, EncoderOptions{})
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		args := mock.CallLog[0].Args
+		if !hasArgPair(args, "-map", "0:a:0") || !hasArgPair(args, "-map", "0:a:1") {
+			t.Error("expected every audio track mapped")
+		}
+		if !hasArgPair(args, "-metadata:s:a:1", "language=spa") {
+			t.Error("expected language metadata on second track")
+		}
+		want := "v:0,agroup:audio v:1,agroup:audio a:0,agroup:audio,language:eng,default:yes a:1,agroup:audio,language:spa"
+		if !hasArgPair(args, "-var_stream_map", want) {
+			t.Errorf("expected var_stream_map %q, got args %v", want, args)
+		}
+	})
+
+	t.Run("DASH adaptation set per track", func(t *testing.T) {
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+
+		_, err := EncodeDASHCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, mock, nil, EncoderOptions{})
+		if err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		if !hasArgPair(mock.CallLog[0].Args, "-adaptation_sets", "id=0,streams=v id=1,streams=2 id=2,streams=3") {
+			t.Errorf("expected one audio adaptation set per track, got args %v", mock.CallLog[0].Args)
+		}
+	})
+}
+
+func TestEncodeSidecarAudio(t *testing.T) {
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, HasAudio: true, AudioStreams: []probe.AudioStream{
+		{Index: 1, Language: "eng", Default: true},
+	}}
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
+	}
+	opts := EncoderOptions{AudioInputs: []AudioInput{
+		{Path: "dub.wav", Language: "deu", Role: config.AudioRoleDub, Duration: 60},
+		{Path: "ad.wav", Language: "eng", Role: config.AudioRoleDescription, Offset: 1.5},
+		{Path: "late.wav", Offset: -0.25},
+	}}
+
+	mock := executor.NewMockExecutor()
+	mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+
+	_, err := EncodeHLSCMAFWithExecutor(context.Background(), "in", "out", info, config.VOD, l, mock, nil, opts)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+
+	args := strings.Join(mock.CallLog[0].Args, " ")
+	for _, want := range []string{
+		"-i in -t 60.000 -i dub.wav -itsoffset 1.500 -i ad.wav -ss 0.250 -i late.wav",
+		"-map 0:a:0 -c:a:0 aac",
+		"-map 1:a:0 -c:a:1 aac",
+		"-map 2:a:0 -c:a:2 aac",
+		"-map 3:a:0 -c:a:3 aac",
+		"-metadata:s:a:1 language=deu",
+		"v:0,agroup:audio a:0,agroup:audio,language:eng,default:yes a:1,agroup:audio,language:deu a:2,agroup:audio,language:eng a:3,agroup:audio",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("expected args to contain %q, got %s", want, args)
+		}
+	}
+}
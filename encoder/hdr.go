@@ -0,0 +1,204 @@
+package encoder
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+const (
+	// cicpBT2020 is the ITU-T H.273 code for BT.2020 primaries and for
+	// BT.2020 non-constant luminance matrix coefficients.
+	cicpBT2020 = 9
+	// cicpBT2020Transfer is the H.273 BT.2020 10-bit transfer code HLG streams
+	// advertise for SDR players.
+	cicpBT2020Transfer = 14
+	// dashCICPScheme prefixes the MPEG-B CICP DASH descriptor schemes.
+	dashCICPScheme = "urn:mpeg:mpegB:cicp:"
+)
+
+// toneMapFilter converts HDR frames to BT.709 SDR: it linearizes the source
+// transfer, converts BT.2020 primaries to BT.709, compresses highlights with
+// the Hable curve and re-applies the BT.709 transfer.
+const toneMapFilter = "zscale=transfer=linear:npl=100,format=gbrpf32le,zscale=primaries=bt709," +
+	"tonemap=tonemap=hable:desat=0,zscale=transfer=bt709:matrix=bt709:range=tv,format=yuv420p"
+
+// hdrFormat is the HDR signalling of the source.
+type hdrFormat struct {
+	mastering  *probe.MasteringDisplay
+	light      *probe.ContentLightLevel
+	videoRange config.VideoRange
+	// preserve is true in HDR mode, where HEVC and AV1 renditions keep the
+	// HDR format. SDR renditions are tone-mapped either way.
+	preserve bool
+}
+
+// sourceHDR returns the source's HDR format, or nil when the source is SDR.
+func sourceHDR(info probe.VideoInfo, opts EncoderOptions) *hdrFormat {
+	vr := info.VideoRange()
+	if vr == config.VideoRangeSDR {
+		return nil
+	}
+	return &hdrFormat{
+		mastering:  info.MasteringDisplay,
+		light:      info.ContentLightLevel,
+		videoRange: vr,
+		preserve:   opts.HDR,
+	}
+}
+
+// carriesHDR reports whether renditions of codec keep the HDR format.
+// H.264 renditions stay 8-bit SDR.
+func carriesHDR(codec config.Codec) bool {
+	return codec == config.CodecHEVC || codec == config.CodecAV1
+}
+
+// renditionRange returns the dynamic range of a rendition encoded with codec
+// that targets vr (see ladder.Rendition.Range). Only HEVC and AV1 renditions
+// of an HDR source in HDR mode stay HDR.
+func renditionRange(codec config.Codec, vr config.VideoRange, hdr *hdrFormat) config.VideoRange {
+	if hdr == nil || !hdr.preserve || vr == config.VideoRangeSDR || !carriesHDR(codec) {
+		return config.VideoRangeSDR
+	}
+	return hdr.videoRange
+}
+
+// toneMaps reports whether a rendition with the given output range is an SDR
+// rendition of an HDR source.
+func toneMaps(vr config.VideoRange, hdr *hdrFormat) bool {
+	return hdr != nil && vr == config.VideoRangeSDR
+}
+
+// colorTransfer returns the FFmpeg color_trc name of an HDR range.
+func colorTransfer(vr config.VideoRange) string {
+	if vr == config.VideoRangeHLG {
+		return "arib-std-b67"
+	}
+	return "smpte2084"
+}
+
+// cicpTransfer returns the ITU-T H.273 transfer characteristics code of an HDR range.
+func cicpTransfer(vr config.VideoRange) int {
+	if vr == config.VideoRangeHLG {
+		return 18
+	}
+	return 16
+}
+
+// pixelFormat returns the pixel format fed to enc: 8-bit for SDR, and the
+// 10-bit layout the encoder accepts for HDR.
+func pixelFormat(enc string, vr config.VideoRange) string {
+	if vr == config.VideoRangeSDR {
+		return "yuv420p"
+	}
+	for _, hw := range []string{"_nvenc", "_vaapi", "_videotoolbox"} {
+		if strings.HasSuffix(enc, hw) {
+			return "p010le"
+		}
+	}
+	return "yuv420p10le"
+}
+
+// colorArgs returns the pixel format of the i-th output video stream and its
+// color tags: BT.2020 for HDR, BT.709 for a tone-mapped SDR rendition, none
+// otherwise.
+func colorArgs(i int, enc string, vr config.VideoRange, toneMapped bool) []string {
+	args := []string{fmt.Sprintf("-pix_fmt:v:%d", i), pixelFormat(enc, vr)}
+	if vr == config.VideoRangeSDR {
+		if toneMapped {
+			args = append(args,
+				fmt.Sprintf("-color_primaries:v:%d", i), "bt709",
+				fmt.Sprintf("-color_trc:v:%d", i), "bt709",
+				fmt.Sprintf("-colorspace:v:%d", i), "bt709",
+				fmt.Sprintf("-color_range:v:%d", i), "tv",
+			)
+		}
+		return args
+	}
+	return append(args,
+		fmt.Sprintf("-color_primaries:v:%d", i), "bt2020",
+		fmt.Sprintf("-color_trc:v:%d", i), colorTransfer(vr),
+		fmt.Sprintf("-colorspace:v:%d", i), "bt2020nc",
+		fmt.Sprintf("-color_range:v:%d", i), "tv",
+	)
+}
+
+// x265HDRParams returns the x265 parameters that write the HDR VUI and, for
+// PQ, the HDR10 mastering display and content light level SEI.
+func x265HDRParams(hdr *hdrFormat) []string {
+	params := []string{
+		"colorprim=bt2020",
+		"transfer=" + colorTransfer(hdr.videoRange),
+		"colormatrix=bt2020nc",
+		"range=limited",
+	}
+	if hdr.videoRange != config.VideoRangePQ {
+		return params
+	}
+	params = append(params, "hdr10=1", "hdr10-opt=1", "repeat-headers=1")
+	if m := hdr.mastering; m != nil {
+		// x265 takes chromaticity in 0.00002 and luminance in 0.0001 cd/m² units.
+		xy := func(v float64) string { return strconv.Itoa(int(math.Round(v * 50000))) }
+		lum := func(v float64) string { return strconv.Itoa(int(math.Round(v * 10000))) }
+		params = append(params, "master-display="+masterDisplay(m, xy, lum))
+	}
+	if c := hdr.light; c != nil {
+		params = append(params, fmt.Sprintf("max-cll=%d,%d", c.MaxCLL, c.MaxFALL))
+	}
+	return params
+}
+
+// svtAV1HDRParams returns the SVT-AV1 parameters that write the HDR color
+// config and metadata OBUs.
+func svtAV1HDRParams(hdr *hdrFormat) []string {
+	params := []string{
+		"enable-hdr=1",
+		fmt.Sprintf("color-primaries=%d", cicpBT2020),
+		fmt.Sprintf("transfer-characteristics=%d", cicpTransfer(hdr.videoRange)),
+		fmt.Sprintf("matrix-coefficients=%d", cicpBT2020),
+		"color-range=0",
+	}
+	if m := hdr.mastering; m != nil {
+		xy := func(v float64) string { return strconv.FormatFloat(v, 'f', 4, 64) }
+		params = append(params, "mastering-display="+masterDisplay(m, xy, xy))
+	}
+	if c := hdr.light; c != nil {
+		params = append(params, fmt.Sprintf("content-light=%d,%d", c.MaxCLL, c.MaxFALL))
+	}
+	return params
+}
+
+// masterDisplay formats m as "G(x,y)B(x,y)R(x,y)WP(x,y)L(max,min)".
+func masterDisplay(m *probe.MasteringDisplay, xy, lum func(float64) string) string {
+	return fmt.Sprintf("G(%s,%s)B(%s,%s)R(%s,%s)WP(%s,%s)L(%s,%s)",
+		xy(m.GreenX), xy(m.GreenY),
+		xy(m.BlueX), xy(m.BlueY),
+		xy(m.RedX), xy(m.RedY),
+		xy(m.WhiteX), xy(m.WhiteY),
+		lum(m.MaxLuminance), lum(m.MinLuminance),
+	)
+}
+
+// hdrDescriptors returns the DASH CICP color descriptors for a video
+// AdaptationSet. PQ requires BT.2020-aware players, so every descriptor is
+// essential; HLG advertises the SDR-compatible BT.2020 transfer as essential
+// and the HLG transfer as supplemental.
+func hdrDescriptors(vr config.VideoRange) string {
+	if vr == config.VideoRangeSDR {
+		return ""
+	}
+	prop := func(kind, name string, value int) string {
+		return fmt.Sprintf(`<%s schemeIdUri="%s%s" value="%d"/>`, kind, dashCICPScheme, name, value)
+	}
+	s := prop("EssentialProperty", "ColourPrimaries", cicpBT2020) +
+		prop("EssentialProperty", "MatrixCoefficients", cicpBT2020)
+	if vr == config.VideoRangeHLG {
+		return s + prop("EssentialProperty", "TransferCharacteristics", cicpBT2020Transfer) +
+			prop("SupplementalProperty", "TransferCharacteristics", cicpTransfer(vr))
+	}
+	return s + prop("EssentialProperty", "TransferCharacteristics", cicpTransfer(vr))
+}
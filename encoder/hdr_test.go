@@ -0,0 +1,343 @@
+package encoder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// hdr10Info is a 1080p PQ source with HDR10 static metadata.
+var hdr10Info = probe.VideoInfo{
+	Width: 1920, Height: 1080, FPS: 24,
+	ColorTransfer: "smpte2084", ColorPrimaries: "bt2020", ColorSpace: "bt2020nc", BitDepth: 10,
+	MasteringDisplay: &probe.MasteringDisplay{
+		RedX: 0.68, RedY: 0.32, GreenX: 0.265, GreenY: 0.69, BlueX: 0.15, BlueY: 0.06,
+		WhiteX: 0.3127, WhiteY: 0.329, MinLuminance: 0.005, MaxLuminance: 1000,
+	},
+	ContentLightLevel: &probe.ContentLightLevel{MaxCLL: 1000, MaxFALL: 400},
+}
+
+func TestSourceHDR(t *testing.T) {
+	hlg := probe.VideoInfo{ColorTransfer: "arib-std-b67"}
+	tests := []struct {
+		name string
+		info probe.VideoInfo
+		opts EncoderOptions
+		want config.VideoRange
+	}{
+		{name: "HDR mode off", info: hdr10Info, want: config.VideoRangeSDR},
+		{name: "SDR source", info: probe.VideoInfo{ColorTransfer: "bt709"}, opts: EncoderOptions{HDR: true}, want: config.VideoRangeSDR},
+		{name: "PQ source", info: hdr10Info, opts: EncoderOptions{HDR: true}, want: config.VideoRangePQ},
+		{name: "HLG source", info: hlg, opts: EncoderOptions{HDR: true}, want: config.VideoRangeHLG},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hdr := sourceHDR(tt.info, tt.opts)
+			if got := renditionRange(config.CodecHEVC, "", hdr); got != tt.want {
+				t.Errorf("HEVC range = %s, want %s", got, tt.want)
+			}
+			if got := renditionRange(config.CodecHEVC, config.VideoRangeSDR, hdr); got != config.VideoRangeSDR {
+				t.Errorf("HEVC range labelled SDR = %s, want SDR", got)
+			}
+			if got := renditionRange(config.CodecH264, "", hdr); got != config.VideoRangeSDR {
+				t.Errorf("H.264 range = %s, want SDR", got)
+			}
+		})
+	}
+}
+
+func TestVideoCodecArgsHDR(t *testing.T) {
+	r := ladder.Rendition{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "high", Level: "4.0"}
+	hdr := sourceHDR(hdr10Info, EncoderOptions{HDR: true})
+	hlg := &hdrFormat{videoRange: config.VideoRangeHLG, preserve: true}
+	sdr := r
+	sdr.Range = config.VideoRangeSDR
+
+	tests := []struct {
+		name    string
+		opts    EncoderOptions
+		hdr     *hdrFormat
+		r       *ladder.Rendition
+		want    [][2]string
+		wantNot []string
+	}{
+		{
+			name: "x265 HDR10",
+			opts: EncoderOptions{Codec: config.CodecHEVC},
+			hdr:  hdr,
+			want: [][2]string{
+				{"-profile:v:0", "main10"},
+				{"-x265-params:v:0", "level-idc=4:scenecut=0:open-gop=0:colorprim=bt2020:transfer=smpte2084:colormatrix=bt2020nc:range=limited:" +
+					"hdr10=1:hdr10-opt=1:repeat-headers=1:master-display=G(13250,34500)B(7500,3000)R(34000,16000)WP(15635,16450)L(10000000,50):max-cll=1000,400"},
+				{"-pix_fmt:v:0", "yuv420p10le"},
+				{"-color_primaries:v:0", "bt2020"},
+				{"-color_trc:v:0", "smpte2084"},
+				{"-colorspace:v:0", "bt2020nc"},
+			},
+		},
+		{
+			name: "x265 HLG",
+			opts: EncoderOptions{Codec: config.CodecHEVC},
+			hdr:  hlg,
+			want: [][2]string{
+				{"-x265-params:v:0", "level-idc=4:scenecut=0:open-gop=0:colorprim=bt2020:transfer=arib-std-b67:colormatrix=bt2020nc:range=limited"},
+				{"-color_trc:v:0", "arib-std-b67"},
+			},
+		},
+		{
+			name: "NVENC HEVC",
+			opts: EncoderOptions{Codec: config.CodecHEVC, GPU: config.GPU_NVENC},
+			hdr:  hdr,
+			want: [][2]string{
+				{"-profile:v:0", "main10"},
+				{"-pix_fmt:v:0", "p010le"},
+			},
+		},
+		{
+			name: "SVT-AV1 HDR10",
+			opts: EncoderOptions{Codec: config.CodecAV1},
+			hdr:  hdr,
+			want: [][2]string{
+				{"-svtav1-params:v:0", "enable-hdr=1:color-primaries=9:transfer-characteristics=16:matrix-coefficients=9:color-range=0:" +
+					"mastering-display=G(0.2650,0.6900)B(0.1500,0.0600)R(0.6800,0.3200)WP(0.3127,0.3290)L(1000.0000,0.0050):content-light=1000,400"},
+				{"-pix_fmt:v:0", "yuv420p10le"},
+			},
+		},
+		{
+			name: "H.264 is tone-mapped",
+			opts: EncoderOptions{Codec: config.CodecH264},
+			hdr:  hdr,
+			want: [][2]string{
+				{"-pix_fmt:v:0", "yuv420p"},
+				{"-color_primaries:v:0", "bt709"},
+				{"-color_trc:v:0", "bt709"},
+				{"-colorspace:v:0", "bt709"},
+			},
+		},
+		{
+			name: "HEVC labelled SDR is tone-mapped",
+			opts: EncoderOptions{Codec: config.CodecHEVC},
+			hdr:  hdr,
+			r:    &sdr,
+			want: [][2]string{{"-profile:v:0", "main"}, {"-x265-params:v:0", "level-idc=4:scenecut=0:open-gop=0"}, {"-color_trc:v:0", "bt709"}},
+		},
+		{
+			name:    "SDR HEVC",
+			opts:    EncoderOptions{Codec: config.CodecHEVC},
+			want:    [][2]string{{"-profile:v:0", "main"}, {"-pix_fmt:v:0", "yuv420p"}},
+			wantNot: []string{"-color_trc:v:0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rendition := r
+			if tt.r != nil {
+				rendition = *tt.r
+			}
+			args := videoCodecArgs(0, rendition, 30, tt.opts, tt.hdr)
+			for _, w := range tt.want {
+				if !hasArgPair(args, w[0], w[1]) {
+					t.Errorf("expected %s %s in %v", w[0], w[1], args)
+				}
+			}
+			for _, key := range tt.wantNot {
+				for _, a := range args {
+					if a == key {
+						t.Errorf("unexpected %s in %v", key, args)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestHDRDescriptors(t *testing.T) {
+	tests := []struct {
+		vr   config.VideoRange
+		want string
+	}{
+		{vr: config.VideoRangeSDR, want: ""},
+		{
+			vr: config.VideoRangePQ,
+			want: `<EssentialProperty schemeIdUri="urn:mpeg:mpegB:cicp:ColourPrimaries" value="9"/>` +
+				`<EssentialProperty schemeIdUri="urn:mpeg:mpegB:cicp:MatrixCoefficients" value="9"/>` +
+				`<EssentialProperty schemeIdUri="urn:mpeg:mpegB:cicp:TransferCharacteristics" value="16"/>`,
+		},
+		{
+			vr: config.VideoRangeHLG,
+			want: `<EssentialProperty schemeIdUri="urn:mpeg:mpegB:cicp:ColourPrimaries" value="9"/>` +
+				`<EssentialProperty schemeIdUri="urn:mpeg:mpegB:cicp:MatrixCoefficients" value="9"/>` +
+				`<EssentialProperty schemeIdUri="urn:mpeg:mpegB:cicp:TransferCharacteristics" value="14"/>` +
+				`<SupplementalProperty schemeIdUri="urn:mpeg:mpegB:cicp:TransferCharacteristics" value="18"/>`,
+		},
+	}
+	for _, tt := range tests {
+		if got := hdrDescriptors(tt.vr); got != tt.want {
+			t.Errorf("hdrDescriptors(%s) = %s, want %s", tt.vr, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeHDR(t *testing.T) {
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "high", Level: "4.0"},
+		{Codec: config.CodecAV1, Width: 1920, Height: 1080, MaxRate: 3000, BufSize: 6000, Profile: "high", Level: "4.0"},
+		{Codec: config.CodecH264, Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "high", Level: "3.1"},
+	}
+	opts := EncoderOptions{Codec: config.CodecHEVC, HDR: true}
+
+	t.Run("HLS", func(t *testing.T) {
+		dir := t.TempDir()
+		master := "#EXTM3U\n" +
+			`#EXT-X-STREAM-INF:BANDWIDTH=5500000,RESOLUTION=1920x1080,CODECS="hvc1.2.4.L120.90"` + "\nstream_0.m3u8\n" +
+			`#EXT-X-STREAM-INF:BANDWIDTH=3300000,RESOLUTION=1920x1080,CODECS="av01"` + "\nstream_1.m3u8\n" +
+			`#EXT-X-STREAM-INF:BANDWIDTH=3300000,RESOLUTION=1280x720,CODECS="avc1.64001f"` + "\nstream_2.m3u8\n"
+		if err := os.WriteFile(filepath.Join(dir, "master.m3u8"), []byte(master), 0o644); err != nil {
+			t.Fatalf("write master: %v", err)
+		}
+
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+		if _, err := EncodeHLSCMAFWithExecutor(context.Background(), "in", dir, hdr10Info, config.VOD, l, mock, nil, opts); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		args := mock.CallLog[0].Args
+		for _, w := range [][2]string{{"-pix_fmt:v:0", "yuv420p10le"}, {"-pix_fmt:v:1", "yuv420p10le"}, {"-pix_fmt:v:2", "yuv420p"}} {
+			if !hasArgPair(args, w[0], w[1]) {
+				t.Errorf("expected %s %s", w[0], w[1])
+			}
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "master.m3u8"))
+		if err != nil {
+			t.Fatalf("read master: %v", err)
+		}
+		got := string(data)
+		for _, want := range []string{
+			`CODECS="hvc1.2.4.L120.90",VIDEO-RANGE=PQ` + "\nstream_0.m3u8",
+			`CODECS="av01.0.08M.10.0.110.09.16.09.0",VIDEO-RANGE=PQ` + "\nstream_1.m3u8",
+			`CODECS="avc1.64001f",VIDEO-RANGE=SDR` + "\nstream_2.m3u8",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("expected master to contain %s, got:\n%s", want, got)
+			}
+		}
+	})
+
+	t.Run("DASH", func(t *testing.T) {
+		dir := t.TempDir()
+		mpd := `<MPD><Period>` +
+			`<AdaptationSet id="0" contentType="video"></AdaptationSet>` +
+			`<AdaptationSet id="1" contentType="video"><Representation id="1" codecs="av01"></Representation></AdaptationSet>` +
+			`<AdaptationSet id="2" contentType="video"></AdaptationSet>` +
+			`</Period></MPD>`
+		if err := os.WriteFile(filepath.Join(dir, "manifest.mpd"), []byte(mpd), 0o644); err != nil {
+			t.Fatalf("write mpd: %v", err)
+		}
+
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+		if _, err := EncodeDASHCMAFWithExecutor(context.Background(), "in", dir, hdr10Info, config.VOD, l, mock, nil, opts); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "manifest.mpd"))
+		if err != nil {
+			t.Fatalf("read mpd: %v", err)
+		}
+		got := string(data)
+		pq := hdrDescriptors(config.VideoRangePQ)
+		for _, want := range []string{
+			`<AdaptationSet id="0" contentType="video">` + pq,
+			`<AdaptationSet id="1" contentType="video">` + pq,
+			`codecs="av01.0.08M.10.0.110.09.16.09.0"`,
+			`<AdaptationSet id="2" contentType="video"></AdaptationSet>`,
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("expected mpd to contain %s, got:\n%s", want, got)
+			}
+		}
+	})
+}
+
+func TestEncodeToneMappedFallback(t *testing.T) {
+	l := ladder.AddSDR([]ladder.Rendition{
+		{Range: config.VideoRangePQ, Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
+		{Range: config.VideoRangePQ, Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
+	})
+	opts := EncoderOptions{Codec: config.CodecHEVC, HDR: true}
+
+	t.Run("HLS", func(t *testing.T) {
+		dir := t.TempDir()
+		var master strings.Builder
+		master.WriteString("#EXTM3U\n")
+		for i := range l {
+			master.WriteString("#EXT-X-STREAM-INF:BANDWIDTH=1000000\nstream_" + strconv.Itoa(i) + ".m3u8\n")
+		}
+		if err := os.WriteFile(filepath.Join(dir, "master.m3u8"), []byte(master.String()), 0o644); err != nil {
+			t.Fatalf("write master: %v", err)
+		}
+
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+		if _, err := EncodeHLSCMAFWithExecutor(context.Background(), "in", dir, hdr10Info, config.VOD, l, mock, nil, opts); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		args := mock.CallLog[0].Args
+		filter := ""
+		for i, a := range args {
+			if a == "-filter_complex" {
+				filter = args[i+1]
+			}
+		}
+		if strings.Count(filter, toneMapFilter) != 2 {
+			t.Errorf("expected the two SDR branches to be tone-mapped, got %s", filter)
+		}
+		if !strings.Contains(filter, "setsar=1[v0o]") || !strings.Contains(filter, "setsar=1,"+toneMapFilter+"[v2o]") {
+			t.Errorf("expected only SDR branches to be tone-mapped, got %s", filter)
+		}
+		for _, w := range [][2]string{{"-c:v:0", "libx265"}, {"-c:v:2", "libx264"}, {"-pix_fmt:v:2", "yuv420p"}, {"-color_trc:v:3", "bt709"}} {
+			if !hasArgPair(args, w[0], w[1]) {
+				t.Errorf("expected %s %s", w[0], w[1])
+			}
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "master.m3u8"))
+		if err != nil {
+			t.Fatalf("read master: %v", err)
+		}
+		got := string(data)
+		for i, want := range []string{"PQ", "PQ", "SDR", "SDR"} {
+			line := "VIDEO-RANGE=" + want + "\nstream_" + strconv.Itoa(i) + ".m3u8"
+			if !strings.Contains(got, line) {
+				t.Errorf("expected master to contain %q, got:\n%s", line, got)
+			}
+		}
+	})
+
+	t.Run("DASH", func(t *testing.T) {
+		if got, want := buildAdaptationSets(l, opts, 0), "id=0,streams=0,1 id=1,streams=2,3"; got != want {
+			t.Errorf("buildAdaptationSets() = %s, want %s", got, want)
+		}
+
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+		if _, err := EncodeDASHCMAFWithExecutor(context.Background(), "in", t.TempDir(), hdr10Info, config.VOD, l, mock, nil, opts); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		args := mock.CallLog[0].Args
+		if !hasArgPair(args, "-filter:v:0", "scale=1920:1080,setsar=1") || !hasArgPair(args, "-filter:v:2", "scale=1920:1080,setsar=1,"+toneMapFilter) {
+			t.Errorf("expected only the SDR streams to be tone-mapped, got %v", args)
+		}
+	})
+}
@@ -16,8 +16,30 @@ import (
 // EncoderOptions defines options for the encoder.
 type EncoderOptions struct {
 	GPU      config.GPUType
+	Codec    config.Codec
 	LogLevel string
 	Threads  int
+	// AV1Preset is the SVT-AV1 preset (0-13, lower is slower and better).
+	// Zero selects DefaultAV1Preset. It is clamped to cpu-used 0-8 for libaom-av1.
+	AV1Preset int
+	// AudioInputs are sidecar audio files packaged after the input's own audio tracks.
+	AudioInputs []AudioInput
+	// SubtitleInputs are sidecar subtitle files packaged after the input's own text subtitle streams.
+	SubtitleInputs []SubtitleInput
+	// ClosedCaptions lists the CEA-608/708 services embedded in the input's video.
+	// When empty, config.DefaultCaptionService is used if the probe detected captions.
+	ClosedCaptions []config.CaptionService
+	// HDR keeps a PQ or HLG source in HDR: HEVC and AV1 renditions are encoded
+	// as 10-bit BT.2020 with the source's transfer and mastering metadata.
+	// H.264 renditions and renditions labelled config.VideoRangeSDR are
+	// tone-mapped to BT.709, as is every rendition when HDR is false.
+	HDR bool
+	// Pad letterboxes or pillarboxes the source into each rendition's frame.
+	// Without it, the source is scaled to the rendition's size, which should
+	// match the source display aspect ratio (see ladder.FitAspect).
+	Pad bool
+
+	av1Fallback bool
 }
 
 // EncodeHLSCMAF encodes the input video to HLS with CMAF segments.
@@ -46,9 +68,44 @@ func EncodeHLSCMAFWithExecutor(
 	progressHandler func(map[string]string),
 	opts EncoderOptions,
 ) (*executor.Usage, error) {
+	args := buildHLSArgs(input, outDir, info, profile, l, opts)
+	usage, err := runFFmpeg(ctx, exec, args, progressHandler)
+	if usesSVTAV1(l, opts) && svtAV1Unavailable(ctx, exec, err) {
+		// libsvtav1 is missing from many FFmpeg builds; retry with libaom-av1.
+		opts.av1Fallback = true
+		args = buildHLSArgs(input, outDir, info, profile, l, opts)
+		usage, err = runFFmpeg(ctx, exec, args, progressHandler)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg HLS failed: %w", err)
+	}
+
+	subs := subtitleTracks(info, opts)
+	if err := writeSubtitles(ctx, exec, input, outDir, subs, opts); err != nil {
+		return nil, err
+	}
+	if err := segmentSubtitles(outDir, len(subs), profile.SegmentDuration, info.Duration); err != nil {
+		return nil, err
+	}
+
+	if err := patchMasterPlaylist(filepath.Join(outDir, "master.m3u8"), l, info, opts); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
 
-	filter := buildFilterGraph(l)
-	gop := calcGOP(info.FPS, profile.SegmentDuration)
+// buildHLSArgs assembles the FFmpeg arguments for a single-pass HLS CMAF encode.
+func buildHLSArgs(
+	input string,
+	outDir string,
+	info probe.VideoInfo,
+	profile config.Profile,
+	l []ladder.Rendition,
+	opts EncoderOptions,
+) []string {
+	hdr := sourceHDR(info, opts)
+	filter := buildFilterGraph(l, info, opts, hdr)
+	captions := len(captionServices(info, opts)) > 0
 
 	args := []string{
 		"-y",
@@ -61,6 +118,7 @@ func EncodeHLSCMAFWithExecutor(
 
 		"-i", input,
 	}
+	args = append(args, audioInputArgs(opts.AudioInputs)...)
 
 	if opts.Threads > 0 {
 		args = append(args, "-threads", strconv.Itoa(opts.Threads))
@@ -70,28 +128,13 @@ func EncodeHLSCMAFWithExecutor(
 
 	// ---------- VIDEO ----------
 	for i, r := range l {
-		codec := "libx264"
-		switch opts.GPU {
-		case config.GPU_NVENC:
-			codec = "h264_nvenc"
-		case config.GPU_VAAPI:
-			codec = "h264_vaapi"
-		case config.GPU_VIDEOTOOLBOX:
-			codec = "h264_videotoolbox"
+		args = append(args, "-map", fmt.Sprintf("[v%do]", i))
+		args = append(args, videoCodecArgs(i, r, r.FrameRate(info.FPS), opts, hdr)...)
+		if captions && renditionCarriesCaptions(r, opts, info.FPS) {
+			args = append(args, captionArgs(i, selectVideoEncoder(renditionCodec(r, opts), opts))...)
 		}
-
+		args = append(args, gopArgs(i, r, info.FPS, profile.SegmentDuration)...)
 		args = append(args,
-			"-map", fmt.Sprintf("[v%do]", i),
-
-			fmt.Sprintf("-c:v:%d", i), codec,
-			fmt.Sprintf("-profile:v:%d", i), r.Profile,
-			fmt.Sprintf("-level:v:%d", i), r.Level,
-
-			"-pix_fmt", "yuv420p",
-			"-preset", "medium",
-
-			"-g", strconv.Itoa(gop),
-			"-keyint_min", strconv.Itoa(gop),
 			"-sc_threshold", "0",
 			"-bf", fmt.Sprintf("%d", r.BFrames),
 
@@ -101,16 +144,9 @@ func EncodeHLSCMAFWithExecutor(
 	}
 
 	// ---------- AUDIO ----------
-	if info.HasAudio {
-		for i := range l {
-			args = append(args,
-				"-map", "a:0",
-				fmt.Sprintf("-c:a:%d", i), "aac",
-				fmt.Sprintf("-b:a:%d", i), "96k",
-				"-ac", "2",
-			)
-		}
-	}
+	// Each audio track is encoded once and shared by every video variant.
+	tracks := audioTracks(info, opts)
+	args = append(args, audioArgs(tracks)...)
 
 	// ---------- HLS / CMAF ----------
 	args = append(args,
@@ -137,44 +173,20 @@ func EncodeHLSCMAFWithExecutor(
 		filepath.Join(outDir, "seg_%v_%d.m4s"),
 
 		"-master_pl_name", "master.m3u8",
-		"-var_stream_map", buildVarStreamMap(len(l), info.HasAudio),
+		"-var_stream_map", buildVarStreamMap(len(l), tracks),
 
 		filepath.Join(outDir, "stream_%v.m3u8"),
 	)
-
-	if progressHandler != nil {
-		args = append(args, "-progress", "pipe:1")
-		progressChan := make(chan string)
-		errChan := make(chan error, 1)
-		var usage *executor.Usage
-
-		go func() {
-			var err error
-			_, usage, err = exec.ExecuteWithProgress(ctx, progressChan, "ffmpeg", args...)
-			errChan <- err
-		}()
-
-		for raw := range progressChan {
-			progressHandler(ParseProgress(raw))
-		}
-
-		if err := <-errChan; err != nil {
-			return nil, fmt.Errorf("ffmpeg HLS failed: %w", err)
-		}
-		return usage, nil
-	} else {
-		_, usage, err := exec.Execute(ctx, "ffmpeg", args...)
-		if err != nil {
-			return nil, fmt.Errorf("ffmpeg HLS failed: %w", err)
-		}
-		return usage, nil
-	}
-
+	return args
 }
 
 // ---------- FILTER GRAPH ----------
 
-func buildFilterGraph(l []ladder.Rendition) string {
+// buildFilterGraph splits the input video into one scaled branch per
+// rendition, padded to the rendition's frame with opts.Pad. Renditions with a
+// frame rate below the source rate drop frames before scaling, and SDR
+// renditions of an HDR source are tone-mapped after scaling.
+func buildFilterGraph(l []ladder.Rendition, info probe.VideoInfo, opts EncoderOptions, hdr *hdrFormat) string {
 	var b strings.Builder
 
 	// split
@@ -185,16 +197,17 @@ func buildFilterGraph(l []ladder.Rendition) string {
 	}
 	b.WriteString(";")
 
-	// scale + pad + SAR
+	// (frame rate +) scale (+ pad) + SAR (+ tone mapping)
 	for i, r := range l {
-		b.WriteString(fmt.Sprintf(
-			"[v%d]scale=%d:%d:force_original_aspect_ratio=decrease,"+
-				"pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1[v%do];",
-			i,
-			r.Width, r.Height,
-			r.Width, r.Height,
-			i,
-		))
+		fps := fpsFilter(r, info.FPS)
+		if fps != "" {
+			fps += ","
+		}
+		toneMap := ""
+		if toneMaps(renditionRange(renditionCodec(r, opts), r.Range, hdr), hdr) {
+			toneMap = "," + toneMapFilter
+		}
+		b.WriteString(fmt.Sprintf("[v%d]%s%s%s[v%do];", i, fps, scaleFilter(r, info, opts.Pad), toneMap, i))
 	}
 
 	return strings.TrimSuffix(b.String(), ";")
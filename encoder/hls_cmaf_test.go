@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
 )
 
 func TestBuildFilterGraph(t *testing.T) {
@@ -11,13 +12,14 @@ func TestBuildFilterGraph(t *testing.T) {
 		name       string
 		expected   string
 		renditions []ladder.Rendition
+		pad        bool
 	}{
 		{
 			name: "single rendition",
 			renditions: []ladder.Rendition{
 				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
 			},
-			expected: "[0:v]split=1[v0];[v0]scale=640:360:force_original_aspect_ratio=decrease,pad=640:360:(ow-iw)/2:(oh-ih)/2,setsar=1[v0o]",
+			expected: "[0:v]split=1[v0];[v0]scale=640:360,setsar=1[v0o]",
 		},
 		{
 			name: "three renditions",
@@ -26,21 +28,38 @@ func TestBuildFilterGraph(t *testing.T) {
 				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
 				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
 			},
-			expected: "[0:v]split=3[v0][v1][v2];[v0]scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2,setsar=1[v0o];[v1]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1[v1o];[v2]scale=640:360:force_original_aspect_ratio=decrease,pad=640:360:(ow-iw)/2:(oh-ih)/2,setsar=1[v2o]",
+			expected: "[0:v]split=3[v0][v1][v2];[v0]scale=1920:1080,setsar=1[v0o];[v1]scale=1280:720,setsar=1[v1o];[v2]scale=640:360,setsar=1[v2o]",
 		},
 		{
-			name: "two renditions",
+			name: "two renditions padded",
+			pad:  true,
 			renditions: []ladder.Rendition{
 				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
 				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
 			},
 			expected: "[0:v]split=2[v0][v1];[v0]scale=1280:720:force_original_aspect_ratio=decrease,pad=1280:720:(ow-iw)/2:(oh-ih)/2,setsar=1[v0o];[v1]scale=640:360:force_original_aspect_ratio=decrease,pad=640:360:(ow-iw)/2:(oh-ih)/2,setsar=1[v1o]",
 		},
+		{
+			name: "frame rate caps",
+			renditions: []ladder.Rendition{
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", MaxFPS: 60},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", MaxFPS: 15},
+			},
+			expected: "[0:v]split=2[v0][v1];[v0]scale=1280:720,setsar=1[v0o];[v1]fps=15,scale=640:360,setsar=1[v1o]",
+		},
+		{
+			name: "target frame rates",
+			renditions: []ladder.Rendition{
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", FPS: 30},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 14.985},
+			},
+			expected: "[0:v]split=2[v0][v1];[v0]scale=1280:720,setsar=1[v0o];[v1]fps=14.985,scale=640:360,setsar=1[v1o]",
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := buildFilterGraph(tt.renditions)
+			result := buildFilterGraph(tt.renditions, probe.VideoInfo{FPS: 30}, EncoderOptions{Pad: tt.pad}, nil)
 			if result != tt.expected {
 				t.Errorf("filter graph mismatch:\nexpected: %s\ngot:      %s", tt.expected, result)
 			}
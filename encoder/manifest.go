@@ -0,0 +1,380 @@
+package encoder
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// aacCodecString is the RFC 6381 codecs value for AAC-LC audio.
+const aacCodecString = "mp4a.40.2"
+
+// av1Level describes the limits of an AV1 seq_level_idx (AV1 spec Annex A).
+type av1Level struct {
+	maxPicSize     int
+	maxDisplayRate int
+	maxKbps        int
+	idx            int
+}
+
+var av1Levels = []av1Level{
+	{idx: 0, maxPicSize: 147456, maxDisplayRate: 4423680, maxKbps: 1500},
+	{idx: 1, maxPicSize: 278784, maxDisplayRate: 8363520, maxKbps: 3000},
+	{idx: 4, maxPicSize: 665856, maxDisplayRate: 19975680, maxKbps: 6000},
+	{idx: 5, maxPicSize: 1065024, maxDisplayRate: 31950720, maxKbps: 10000},
+	{idx: 8, maxPicSize: 2359296, maxDisplayRate: 70778880, maxKbps: 12000},
+	{idx: 9, maxPicSize: 2359296, maxDisplayRate: 141557760, maxKbps: 20000},
+	{idx: 12, maxPicSize: 8912896, maxDisplayRate: 267386880, maxKbps: 30000},
+	{idx: 13, maxPicSize: 8912896, maxDisplayRate: 534773760, maxKbps: 40000},
+	{idx: 14, maxPicSize: 8912896, maxDisplayRate: 1069547520, maxKbps: 60000},
+	{idx: 16, maxPicSize: 35651584, maxDisplayRate: 1069547520, maxKbps: 60000},
+	{idx: 17, maxPicSize: 35651584, maxDisplayRate: 2139095040, maxKbps: 100000},
+	{idx: 18, maxPicSize: 35651584, maxDisplayRate: 4278190080, maxKbps: 160000},
+}
+
+// av1LevelIndex returns the lowest main-tier seq_level_idx that fits the rendition.
+func av1LevelIndex(r ladder.Rendition, fps float64) int {
+	picSize := r.Width * r.Height
+	displayRate := int(float64(picSize) * fps)
+	for _, l := range av1Levels {
+		if picSize <= l.maxPicSize && displayRate <= l.maxDisplayRate && r.MaxRate <= l.maxKbps {
+			return l.idx
+		}
+	}
+	return av1Levels[len(av1Levels)-1].idx
+}
+
+// av1CodecString returns the codecs value for a 4:2:0 main-profile AV1
+// rendition: 8-bit for SDR, and 10-bit with the BT.2020 color fields for HDR.
+func av1CodecString(r ladder.Rendition, fps float64, vr config.VideoRange) string {
+	switch vr {
+	case config.VideoRangePQ, config.VideoRangeHLG:
+		return fmt.Sprintf("av01.0.%02dM.10.0.110.%02d.%02d.%02d.0",
+			av1LevelIndex(r, fps), cicpBT2020, cicpTransfer(vr), cicpBT2020)
+	default:
+		return fmt.Sprintf("av01.0.%02dM.08", av1LevelIndex(r, fps))
+	}
+}
+
+// patchMasterPlaylist fills in attributes FFmpeg's HLS muxer cannot derive on
+// its own: CODECS for AV1 variants, VIDEO-RANGE when any variant is HDR, and
+// NAME, LANGUAGE, DEFAULT, AUTOSELECT and CHARACTERISTICS for audio renditions. It also adds the subtitle
+// renditions written by segmentSubtitles and the embedded closed caption
+// services. It is a no-op when the playlist does not exist.
+func patchMasterPlaylist(path string, l []ladder.Rendition, info probe.VideoInfo, opts EncoderOptions) error {
+	tracks := audioTracks(info, opts)
+	subs := subtitleTracks(info, opts)
+	captions := captionServices(info, opts)
+	hdr := sourceHDR(info, opts)
+
+	return rewriteFile(path, func(content string) string {
+		content = insertBeforeStreamInf(content, append(subtitleMedia(subs), captionMedia(captions)...))
+		content = rewriteStreamInf(content, func(uri, attrs string) string {
+			if len(subs) > 0 {
+				attrs = setAttr(attrs, "SUBTITLES", quoteAttr(subtitleGroupID))
+			}
+			i, ok := variantIndex(uri)
+			if !ok || i >= len(l) {
+				return attrs
+			}
+			// Variants without captions omit the attribute; NONE would have to apply to all.
+			if len(captions) > 0 && renditionCarriesCaptions(l[i], opts, info.FPS) {
+				attrs = setAttr(attrs, "CLOSED-CAPTIONS", quoteAttr(captionGroupID))
+			}
+			vr := renditionRange(renditionCodec(l[i], opts), l[i].Range, hdr)
+			if hdr != nil {
+				attrs = setAttr(attrs, "VIDEO-RANGE", string(vr))
+			}
+			if renditionCodec(l[i], opts) != config.CodecAV1 {
+				return attrs
+			}
+			codecs := av1CodecString(l[i], l[i].FrameRate(info.FPS), vr)
+			if len(tracks) > 0 {
+				codecs += "," + aacCodecString
+			}
+			return setAttr(attrs, "CODECS", quoteAttr(codecs))
+		})
+
+		return rewriteMedia(content, func(attrs string) string {
+			if getAttr(attrs, "TYPE") != "AUDIO" {
+				return attrs
+			}
+			i, ok := variantIndex(unquoteAttr(getAttr(attrs, "URI")))
+			k := i - len(l)
+			if !ok || k < 0 || k >= len(tracks) {
+				return attrs
+			}
+			t := tracks[k]
+			attrs = setAttr(attrs, "NAME", quoteAttr(audioName(t, k)))
+			if t.language != "" {
+				attrs = setAttr(attrs, "LANGUAGE", quoteAttr(t.language))
+			}
+			attrs = setAttr(attrs, "DEFAULT", yesNo(t.isDefault))
+			attrs = setAttr(attrs, "AUTOSELECT", "YES")
+			if t.role == config.AudioRoleDescription {
+				attrs = setAttr(attrs, "CHARACTERISTICS", quoteAttr(hlsDescribesVideo))
+			}
+			return attrs
+		})
+	})
+}
+
+// patchManifest fills in DASH MPD attributes FFmpeg's DASH muxer may leave
+// incomplete: full av01 codecs strings, CICP color descriptors on HDR video
+// AdaptationSets, caption Accessibility on video AdaptationSets, and lang,
+// Role and Accessibility on audio AdaptationSets.
+// It also adds a text AdaptationSet for every subtitle file written by
+// writeSubtitles. It is a no-op when the manifest does not exist.
+func patchManifest(path string, l []ladder.Rendition, info probe.VideoInfo, opts EncoderOptions) error {
+	tracks := audioTracks(info, opts)
+	groups, _ := videoGroups(l, opts)
+	videoSets := max(len(groups), 1)
+	subs := subtitleAdaptationSets(subtitleTracks(info, opts), videoSets+len(tracks))
+	captions := captionAccessibility(captionServices(info, opts))
+	hdr := sourceHDR(info, opts)
+
+	return rewriteFile(path, func(content string) string {
+		content = strings.Replace(content, "</Period>", subs+"</Period>", 1)
+		content = rewriteRepresentations(content, func(id int, tag string) string {
+			if id >= len(l) || renditionCodec(l[id], opts) != config.CodecAV1 {
+				return tag
+			}
+			return setXMLAttr(tag, "codecs", av1CodecString(l[id], l[id].FrameRate(info.FPS), renditionRange(config.CodecAV1, l[id].Range, hdr)))
+		})
+
+		return rewriteAdaptationSets(content, func(id int, tag string) string {
+			if id < len(groups) {
+				g := groups[id]
+				tag += hdrDescriptors(renditionRange(g.codec, g.videoRange, hdr))
+				if captions != "" && groupCarriesCaptions(g, l, opts, info.FPS) {
+					tag += captions
+				}
+				return tag
+			}
+			k := id - videoSets
+			if k < 0 || k >= len(tracks) {
+				return tag
+			}
+			t := tracks[k]
+			if t.language != "" {
+				tag = setXMLAttr(tag, "lang", xmlEscape(t.language))
+			}
+			tag += fmt.Sprintf(`<Role schemeIdUri="%s" value="%s"/>`, dashRoleScheme, t.role)
+			if t.role == config.AudioRoleDescription {
+				tag += fmt.Sprintf(`<Accessibility schemeIdUri="%s" value="1"/>`, dashAudioPurposeScheme)
+			}
+			return tag
+		})
+	})
+}
+
+// rewriteFile applies fn to the contents of path and writes the result back.
+// A missing file is not an error.
+func rewriteFile(path string, fn func(string) string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+
+	out := fn(string(data))
+	if out == string(data) {
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// rewriteStreamInf calls fn for every EXT-X-STREAM-INF tag with the URI on the
+// following line and the tag's attribute list, replacing the attributes with the result.
+func rewriteStreamInf(content string, fn func(uri, attrs string) string) string {
+	const tag = "#EXT-X-STREAM-INF:"
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if !strings.HasPrefix(line, tag) {
+			continue
+		}
+		uri := ""
+		for _, next := range lines[i+1:] {
+			next = strings.TrimSpace(next)
+			if next != "" && !strings.HasPrefix(next, "#") {
+				uri = next
+				break
+			}
+		}
+		lines[i] = tag + fn(uri, strings.TrimPrefix(line, tag))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// insertBeforeStreamInf inserts lines before the first EXT-X-STREAM-INF tag,
+// or at the end when there is none.
+func insertBeforeStreamInf(content string, lines []string) string {
+	if len(lines) == 0 {
+		return content
+	}
+	block := strings.Join(lines, "\n") + "\n"
+	if i := strings.Index(content, "#EXT-X-STREAM-INF:"); i >= 0 {
+		return content[:i] + block + content[i:]
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content + block
+}
+
+// rewriteMedia calls fn for every EXT-X-MEDIA tag's attribute list,
+// replacing the attributes with the result.
+func rewriteMedia(content string, fn func(attrs string) string) string {
+	const tag = "#EXT-X-MEDIA:"
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, tag) {
+			lines[i] = tag + fn(strings.TrimPrefix(line, tag))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+var variantURIPattern = regexp.MustCompile(`stream_(\d+)\.m3u8$`)
+
+// variantIndex extracts the var_stream_map index from a variant playlist URI.
+func variantIndex(uri string) (int, bool) {
+	m := variantURIPattern.FindStringSubmatch(uri)
+	if m == nil {
+		return 0, false
+	}
+	i, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0, false
+	}
+	return i, true
+}
+
+// splitAttrs splits an HLS attribute list on commas outside quoted strings.
+func splitAttrs(attrs string) []string {
+	var parts []string
+	var b strings.Builder
+	quoted := false
+	for _, c := range attrs {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			b.WriteRune(c)
+		case c == ',' && !quoted:
+			parts = append(parts, b.String())
+			b.Reset()
+		default:
+			b.WriteRune(c)
+		}
+	}
+	if b.Len() > 0 {
+		parts = append(parts, b.String())
+	}
+	return parts
+}
+
+// getAttr returns the raw value of key in an HLS attribute list, or "".
+func getAttr(attrs, key string) string {
+	for _, p := range splitAttrs(attrs) {
+		if v, ok := strings.CutPrefix(p, key+"="); ok {
+			return v
+		}
+	}
+	return ""
+}
+
+// quoteAttr returns s as an HLS quoted-string, which cannot contain
+// double quotes or line breaks.
+func quoteAttr(s string) string {
+	return `"` + strings.NewReplacer(`"`, "'", "\n", " ", "\r", " ").Replace(s) + `"`
+}
+
+// unquoteAttr strips the double quotes from an HLS quoted-string.
+func unquoteAttr(s string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(s, `"`), `"`)
+}
+
+// yesNo returns the HLS enumerated-string for b.
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+// setAttr sets key to value in an HLS attribute list, replacing an existing
+// value or appending a new attribute. value must already be quoted if needed.
+func setAttr(attrs, key, value string) string {
+	parts := splitAttrs(attrs)
+	for i, p := range parts {
+		if strings.HasPrefix(p, key+"=") {
+			parts[i] = key + "=" + value
+			return strings.Join(parts, ",")
+		}
+	}
+	return strings.Join(append(parts, key+"="+value), ",")
+}
+
+var representationPattern = regexp.MustCompile(`<Representation id="(\d+)"[^>]*>`)
+
+// rewriteRepresentations calls fn for every MPD Representation start tag with
+// its numeric id, replacing the tag with the result.
+func rewriteRepresentations(content string, fn func(id int, tag string) string) string {
+	return representationPattern.ReplaceAllStringFunc(content, func(tag string) string {
+		id, err := strconv.Atoi(representationPattern.FindStringSubmatch(tag)[1])
+		if err != nil {
+			return tag
+		}
+		return fn(id, tag)
+	})
+}
+
+var adaptationSetPattern = regexp.MustCompile(`<AdaptationSet id="(\d+)"[^>]*>`)
+
+// rewriteAdaptationSets calls fn for every MPD AdaptationSet start tag with
+// its numeric id, replacing the tag with the result. fn may append child
+// elements after the tag.
+func rewriteAdaptationSets(content string, fn func(id int, tag string) string) string {
+	return adaptationSetPattern.ReplaceAllStringFunc(content, func(tag string) string {
+		id, err := strconv.Atoi(adaptationSetPattern.FindStringSubmatch(tag)[1])
+		if err != nil {
+			return tag
+		}
+		return fn(id, tag)
+	})
+}
+
+// xmlEscape escapes s for use in XML attribute values and text.
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// setXMLAttr sets an attribute on an XML start tag, replacing an existing value.
+func setXMLAttr(tag, key, value string) string {
+	attr := regexp.MustCompile(`\s` + regexp.QuoteMeta(key) + `="[^"]*"`)
+	repl := fmt.Sprintf(` %s="%s"`, key, value)
+	if attr.MatchString(tag) {
+		return attr.ReplaceAllLiteralString(tag, repl)
+	}
+	end := strings.LastIndex(tag, ">")
+	if strings.HasSuffix(tag, "/>") {
+		end--
+	}
+	return tag[:end] + repl + tag[end:]
+}
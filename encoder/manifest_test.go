@@ -0,0 +1,335 @@
+package encoder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+func TestAV1CodecString(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+		vr       config.VideoRange
+		r        ladder.Rendition
+		fps      float64
+	}{
+		{"360p30", "av01.0.01M.08", "", ladder.Rendition{Width: 640, Height: 360, MaxRate: 1000}, 30},
+		{"720p30", "av01.0.05M.08", "", ladder.Rendition{Width: 1280, Height: 720, MaxRate: 3000}, 30},
+		{"1080p30", "av01.0.08M.08", "", ladder.Rendition{Width: 1920, Height: 1080, MaxRate: 5000}, 30},
+		{"1080p60", "av01.0.09M.08", "", ladder.Rendition{Width: 1920, Height: 1080, MaxRate: 5000}, 60},
+		{"1080p30 over 4.0 bitrate", "av01.0.09M.08", "", ladder.Rendition{Width: 1920, Height: 1080, MaxRate: 15000}, 30},
+		{"2160p60", "av01.0.13M.08", "", ladder.Rendition{Width: 3840, Height: 2160, MaxRate: 20000}, 60},
+		{"2160p24 PQ", "av01.0.12M.10.0.110.09.16.09.0", config.VideoRangePQ, ladder.Rendition{Width: 3840, Height: 2160, MaxRate: 16000}, 24},
+		{"1080p50 HLG", "av01.0.09M.10.0.110.09.18.09.0", config.VideoRangeHLG, ladder.Rendition{Width: 1920, Height: 1080, MaxRate: 5000}, 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := av1CodecString(tt.r, tt.fps, tt.vr); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSetAttr(t *testing.T) {
+	tests := []struct {
+		name     string
+		attrs    string
+		expected string
+	}{
+		{"replace quoted", `BANDWIDTH=100,CODECS="avc1,mp4a"`, `BANDWIDTH=100,CODECS="x"`},
+		{"append", `BANDWIDTH=100,RESOLUTION=640x360`, `BANDWIDTH=100,RESOLUTION=640x360,CODECS="x"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setAttr(tt.attrs, "CODECS", `"x"`); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestSetXMLAttr(t *testing.T) {
+	tests := []struct {
+		name     string
+		tag      string
+		expected string
+	}{
+		{"replace", `<Representation id="0" codecs="av01">`, `<Representation id="0" codecs="x">`},
+		{"append", `<Representation id="0" bandwidth="1">`, `<Representation id="0" bandwidth="1" codecs="x">`},
+		{"self closing", `<Representation id="0"/>`, `<Representation id="0" codecs="x"/>`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := setXMLAttr(tt.tag, "codecs", "x"); got != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestPatchMasterPlaylistAV1(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "master.m3u8")
+	master := "#EXTM3U\n#EXT-X-VERSION:7\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=5500000,RESOLUTION=1920x1080\nstream_0.m3u8\n\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1100000,RESOLUTION=640x360\nstream_1.m3u8\n"
+	if err := os.WriteFile(path, []byte(master), 0o644); err != nil {
+		t.Fatalf("write master: %v", err)
+	}
+
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000},
+		{Width: 640, Height: 360, MaxRate: 1000},
+	}
+	info := probe.VideoInfo{FPS: 30, HasAudio: true}
+
+	if err := patchMasterPlaylist(path, l, info, EncoderOptions{Codec: config.CodecAV1}); err != nil {
+		t.Fatalf("patchMasterPlaylist() err=%v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read master: %v", err)
+	}
+	got := string(data)
+	for _, want := range []string{
+		`RESOLUTION=1920x1080,CODECS="av01.0.08M.08,mp4a.40.2"`,
+		`RESOLUTION=640x360,CODECS="av01.0.01M.08,mp4a.40.2"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected master to contain %s, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestPatchManifestAV1(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.mpd")
+	mpd := `<MPD><Period><AdaptationSet id="0" contentType="video">` +
+		`<Representation id="0" mimeType="video/mp4" codecs="av01" bandwidth="5000000" width="1920" height="1080">` +
+		`</Representation></AdaptationSet><AdaptationSet id="1" contentType="audio">` +
+		`<Representation id="1" mimeType="audio/mp4" codecs="mp4a.40.2" bandwidth="96000"></Representation>` +
+		`</AdaptationSet></Period></MPD>`
+	if err := os.WriteFile(path, []byte(mpd), 0o644); err != nil {
+		t.Fatalf("write mpd: %v", err)
+	}
+
+	l := []ladder.Rendition{{Width: 1920, Height: 1080, MaxRate: 5000}}
+	if err := patchManifest(path, l, probe.VideoInfo{FPS: 30, HasAudio: true}, EncoderOptions{Codec: config.CodecAV1}); err != nil {
+		t.Fatalf("patchManifest() err=%v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read mpd: %v", err)
+	}
+	got := string(data)
+	if !strings.Contains(got, `<Representation id="0" mimeType="video/mp4" codecs="av01.0.08M.08"`) {
+		t.Errorf("expected full av01 codecs string, got:\n%s", got)
+	}
+	if !strings.Contains(got, `codecs="mp4a.40.2"`) {
+		t.Errorf("expected audio codecs untouched, got:\n%s", got)
+	}
+}
+
+func TestPatchMissingFile(t *testing.T) {
+	opts := EncoderOptions{Codec: config.CodecAV1}
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	if err := patchMasterPlaylist(missing, nil, probe.VideoInfo{}, opts); err != nil {
+		t.Errorf("patchMasterPlaylist() err=%v, want nil for missing file", err)
+	}
+	if err := patchManifest(missing, nil, probe.VideoInfo{}, opts); err != nil {
+		t.Errorf("patchManifest() err=%v, want nil for missing file", err)
+	}
+}
+
+func TestPatchMasterPlaylistMixedCodecs(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "master.m3u8")
+	master := "#EXTM3U\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=5500000,RESOLUTION=1920x1080,CODECS=\"avc1.4d4028,mp4a.40.2\"\nstream_0.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=3300000,RESOLUTION=1920x1080\nstream_1.m3u8\n"
+	if err := os.WriteFile(path, []byte(master), 0o644); err != nil {
+		t.Fatalf("write master: %v", err)
+	}
+
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000},
+		{Codec: config.CodecAV1, Width: 1920, Height: 1080, MaxRate: 3000},
+	}
+	if err := patchMasterPlaylist(path, l, probe.VideoInfo{FPS: 30, HasAudio: true}, EncoderOptions{}); err != nil {
+		t.Fatalf("patchMasterPlaylist() err=%v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read master: %v", err)
+	}
+	got := string(data)
+	if !strings.Contains(got, `CODECS="avc1.4d4028,mp4a.40.2"`) {
+		t.Errorf("expected H.264 CODECS untouched, got:\n%s", got)
+	}
+	if !strings.Contains(got, `RESOLUTION=1920x1080,CODECS="av01.0.08M.08,mp4a.40.2"`) {
+		t.Errorf("expected AV1 CODECS added, got:\n%s", got)
+	}
+}
+
+func TestPatchMasterPlaylistAudio(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "master.m3u8")
+	master := "#EXTM3U\n#EXT-X-VERSION:7\n" +
+		`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="group_audio",NAME="audio_1",DEFAULT=NO,LANGUAGE="eng",URI="stream_1.m3u8"` + "\n" +
+		`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="group_audio",NAME="audio_2",DEFAULT=YES,URI="stream_2.m3u8"` + "\n" +
+		`#EXT-X-STREAM-INF:BANDWIDTH=5500000,RESOLUTION=1920x1080,CODECS="avc1.640028,mp4a.40.2",AUDIO="group_audio"` + "\n" +
+		"stream_0.m3u8\n"
+	if err := os.WriteFile(path, []byte(master), 0o644); err != nil {
+		t.Fatalf("write master: %v", err)
+	}
+
+	l := []ladder.Rendition{{Width: 1920, Height: 1080, MaxRate: 5000}}
+	info := probe.VideoInfo{FPS: 30, HasAudio: true, AudioStreams: []probe.AudioStream{
+		{Language: "eng", Title: "English", Default: true},
+		{Language: "spa"},
+	}}
+
+	if err := patchMasterPlaylist(path, l, info, EncoderOptions{}); err != nil {
+		t.Fatalf("patchMasterPlaylist() err=%v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read master: %v", err)
+	}
+	got := string(data)
+	for _, want := range []string{
+		`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="group_audio",NAME="English",DEFAULT=YES,LANGUAGE="eng",URI="stream_1.m3u8",AUTOSELECT=YES`,
+		`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="group_audio",NAME="spa",DEFAULT=NO,URI="stream_2.m3u8",LANGUAGE="spa",AUTOSELECT=YES`,
+		`CODECS="avc1.640028,mp4a.40.2",AUDIO="group_audio"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected master to contain %s, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestPatchManifestAudioRoles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "manifest.mpd")
+	mpd := `<MPD><Period>` +
+		`<AdaptationSet id="0" contentType="video"><Representation id="0" mimeType="video/mp4" codecs="avc1.640028"></Representation></AdaptationSet>` +
+		`<AdaptationSet id="1" contentType="audio"><Representation id="1" mimeType="audio/mp4" codecs="mp4a.40.2"></Representation></AdaptationSet>` +
+		`<AdaptationSet id="2" contentType="audio" lang="und"><Representation id="2" mimeType="audio/mp4" codecs="mp4a.40.2"></Representation></AdaptationSet>` +
+		`<AdaptationSet id="3" contentType="audio"><Representation id="3" mimeType="audio/mp4" codecs="mp4a.40.2"></Representation></AdaptationSet>` +
+		`</Period></MPD>`
+	if err := os.WriteFile(path, []byte(mpd), 0o644); err != nil {
+		t.Fatalf("write mpd: %v", err)
+	}
+
+	l := []ladder.Rendition{{Width: 1920, Height: 1080, MaxRate: 5000}}
+	info := probe.VideoInfo{FPS: 30, HasAudio: true, AudioStreams: []probe.AudioStream{
+		{Language: "eng"},
+		{Language: "spa", Default: true},
+		{Language: `x"><y`},
+	}}
+
+	if err := patchManifest(path, l, info, EncoderOptions{}); err != nil {
+		t.Fatalf("patchManifest() err=%v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read mpd: %v", err)
+	}
+	got := string(data)
+	for _, want := range []string{
+		`<AdaptationSet id="0" contentType="video"><Representation`,
+		`<AdaptationSet id="1" contentType="audio" lang="eng"><Role schemeIdUri="urn:mpeg:dash:role:2011" value="alternate"/>`,
+		`<AdaptationSet id="2" contentType="audio" lang="spa"><Role schemeIdUri="urn:mpeg:dash:role:2011" value="main"/>`,
+		`<AdaptationSet id="3" contentType="audio" lang="x&#34;&gt;&lt;y"><Role`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected manifest to contain %s, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestPatchAudioDescription(t *testing.T) {
+	l := []ladder.Rendition{{Width: 1920, Height: 1080, MaxRate: 5000}}
+	info := probe.VideoInfo{FPS: 30, HasAudio: true}
+	opts := EncoderOptions{AudioInputs: []AudioInput{
+		{Path: "dub.wav", Language: "deu", Title: "Deutsch"},
+		{Path: "ad.wav", Language: "eng", Title: "English AD", Role: config.AudioRoleDescription},
+	}}
+
+	t.Run("HLS characteristics", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "master.m3u8")
+		master := "#EXTM3U\n" +
+			`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="group_audio",NAME="audio_1",DEFAULT=YES,URI="stream_1.m3u8"` + "\n" +
+			`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="group_audio",NAME="audio_2",DEFAULT=NO,URI="stream_2.m3u8"` + "\n" +
+			`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="group_audio",NAME="audio_3",DEFAULT=NO,URI="stream_3.m3u8"` + "\n"
+		if err := os.WriteFile(path, []byte(master), 0o644); err != nil {
+			t.Fatalf("write master: %v", err)
+		}
+		if err := patchMasterPlaylist(path, l, info, opts); err != nil {
+			t.Fatalf("patchMasterPlaylist() err=%v", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read master: %v", err)
+		}
+		got := string(data)
+		for _, want := range []string{
+			`NAME="Audio 1",DEFAULT=YES,URI="stream_1.m3u8",AUTOSELECT=YES` + "\n",
+			`NAME="Deutsch",DEFAULT=NO,URI="stream_2.m3u8",LANGUAGE="deu",AUTOSELECT=YES` + "\n",
+			`NAME="English AD",DEFAULT=NO,URI="stream_3.m3u8",LANGUAGE="eng",AUTOSELECT=YES,CHARACTERISTICS="public.accessibility.describes-video"`,
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("expected master to contain %s, got:\n%s", want, got)
+			}
+		}
+	})
+
+	t.Run("DASH accessibility", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "manifest.mpd")
+		mpd := `<MPD><Period>` +
+			`<AdaptationSet id="0" contentType="video"></AdaptationSet>` +
+			`<AdaptationSet id="1" contentType="audio"></AdaptationSet>` +
+			`<AdaptationSet id="2" contentType="audio"></AdaptationSet>` +
+			`<AdaptationSet id="3" contentType="audio"></AdaptationSet>` +
+			`</Period></MPD>`
+		if err := os.WriteFile(path, []byte(mpd), 0o644); err != nil {
+			t.Fatalf("write mpd: %v", err)
+		}
+		if err := patchManifest(path, l, info, opts); err != nil {
+			t.Fatalf("patchManifest() err=%v", err)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read mpd: %v", err)
+		}
+		got := string(data)
+		for _, want := range []string{
+			`<AdaptationSet id="1" contentType="audio"><Role schemeIdUri="urn:mpeg:dash:role:2011" value="main"/>`,
+			`<AdaptationSet id="2" contentType="audio" lang="deu"><Role schemeIdUri="urn:mpeg:dash:role:2011" value="dub"/>`,
+			`<AdaptationSet id="3" contentType="audio" lang="eng"><Role schemeIdUri="urn:mpeg:dash:role:2011" value="description"/>` +
+				`<Accessibility schemeIdUri="urn:tva:metadata:cs:AudioPurposeCS:2007" value="1"/>`,
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("expected manifest to contain %s, got:\n%s", want, got)
+			}
+		}
+	})
+}
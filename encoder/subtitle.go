@@ -0,0 +1,252 @@
+package encoder
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+const (
+	// subtitleGroupID is the HLS GROUP-ID shared by all subtitle renditions.
+	subtitleGroupID = "subs"
+	// hlsSDHCharacteristics is the HLS CHARACTERISTICS value for SDH subtitles.
+	hlsSDHCharacteristics = "public.accessibility.transcribes-spoken-dialog,public.accessibility.describes-music-and-sound"
+	// hlsTimestampMap aligns WebVTT cue times with the media timeline, which starts at zero.
+	hlsTimestampMap = "X-TIMESTAMP-MAP=MPEGTS:0,LOCAL:00:00:00.000"
+)
+
+// SubtitleInput is an external subtitle file packaged as a text track.
+// SRT and WebVTT files are converted by FFmpeg; TTML and DFXP files
+// (.ttml, .dfxp, .xml) must be local and are converted in-process.
+type SubtitleInput struct {
+	// Path is the file path or URL of the subtitle file.
+	Path string
+	// Language is the BCP-47 or ISO 639 language tag of the track.
+	Language string
+	// Title is the human-readable track name shown by players.
+	Title string
+	// Forced marks subtitles that only cover foreign-language or on-screen text.
+	Forced bool
+	// SDH marks subtitles for the deaf and hard of hearing.
+	SDH bool
+}
+
+// subtitleTrack is a single text rendition in the output, taken either from a
+// text subtitle stream of the primary input or from a sidecar SubtitleInput.
+type subtitleTrack struct {
+	// path is the sidecar file, empty for a stream of the primary input.
+	path     string
+	language string
+	title    string
+	stream   int
+	forced   bool
+	sdh      bool
+}
+
+// subtitleTracks returns the subtitle tracks to package: the primary input's
+// text subtitle streams followed by the sidecar inputs. Bitmap subtitle
+// streams cannot be converted to WebVTT and are skipped.
+func subtitleTracks(info probe.VideoInfo, opts EncoderOptions) []subtitleTrack {
+	var tracks []subtitleTrack
+	for k, s := range info.SubtitleStreams {
+		if !s.IsText() {
+			continue
+		}
+		tracks = append(tracks, subtitleTrack{
+			language: s.Language,
+			title:    s.Title,
+			stream:   k,
+			forced:   s.Forced,
+			sdh:      s.HearingImpaired,
+		})
+	}
+	for _, in := range opts.SubtitleInputs {
+		tracks = append(tracks, subtitleTrack{
+			path:     in.Path,
+			language: in.Language,
+			title:    in.Title,
+			forced:   in.Forced,
+			sdh:      in.SDH,
+		})
+	}
+	return tracks
+}
+
+// subtitleName returns a human-readable rendition name for the k-th track.
+func subtitleName(t subtitleTrack, k int) string {
+	switch {
+	case t.title != "":
+		return t.title
+	case t.language != "":
+		return t.language
+	default:
+		return fmt.Sprintf("Subtitles %d", k+1)
+	}
+}
+
+// subtitleRole returns the DASH role of a subtitle track.
+func subtitleRole(t subtitleTrack) string {
+	switch {
+	case t.forced:
+		return "forced-subtitle"
+	case t.sdh:
+		return "caption"
+	default:
+		return "subtitle"
+	}
+}
+
+// subtitleFile is the name of the complete WebVTT file for the k-th track.
+func subtitleFile(k int) string {
+	return fmt.Sprintf("subs_%d.vtt", k)
+}
+
+// subtitlePlaylist is the name of the HLS media playlist for the k-th track.
+func subtitlePlaylist(k int) string {
+	return fmt.Sprintf("subs_%d.m3u8", k)
+}
+
+// isTTML reports whether path names a TTML or DFXP document.
+func isTTML(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".ttml", ".dfxp", ".xml":
+		return true
+	}
+	return false
+}
+
+// writeSubtitles converts every subtitle track to a complete WebVTT file in outDir.
+func writeSubtitles(
+	ctx context.Context,
+	exec executor.CommandExecutor,
+	input string,
+	outDir string,
+	tracks []subtitleTrack,
+	opts EncoderOptions,
+) error {
+	for k, t := range tracks {
+		out := filepath.Join(outDir, subtitleFile(k))
+
+		if isTTML(t.path) {
+			data, err := os.ReadFile(t.path)
+			if err != nil {
+				return fmt.Errorf("read subtitle %q: %w", t.path, err)
+			}
+			cues, err := parseTTML(data)
+			if err != nil {
+				return fmt.Errorf("convert subtitle %q: %w", t.path, err)
+			}
+			if err := os.WriteFile(out, []byte(formatWebVTT(cues)), 0o644); err != nil {
+				return fmt.Errorf("write %s: %w", out, err)
+			}
+			continue
+		}
+
+		src, stream := input, t.stream
+		if t.path != "" {
+			src, stream = t.path, 0
+		}
+		args := []string{
+			"-y",
+			"-loglevel", opts.LogLevel,
+			"-i", src,
+			"-map", fmt.Sprintf("0:s:%d", stream),
+			"-c:s", "webvtt",
+			"-f", "webvtt",
+			out,
+		}
+		if _, _, err := exec.Execute(ctx, "ffmpeg", args...); err != nil {
+			return fmt.Errorf("ffmpeg subtitle %d failed: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// segmentSubtitles splits the WebVTT file of each of count tracks into
+// segments of segDur seconds and writes a VOD media playlist for it. duration
+// is the media duration in seconds; zero derives it from the last cue.
+func segmentSubtitles(outDir string, count, segDur int, duration float64) error {
+	segLen := time.Duration(segDur) * time.Second
+	total := time.Duration(duration * float64(time.Second))
+
+	for k := range count {
+		path := filepath.Join(outDir, subtitleFile(k))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", path, err)
+		}
+		cues, err := parseWebVTT(string(data))
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", path, err)
+		}
+
+		segments := segmentCues(cues, segLen, total)
+		end := total
+		for _, c := range cues {
+			end = max(end, c.end)
+		}
+
+		var pl strings.Builder
+		pl.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+		pl.WriteString(fmt.Sprintf("#EXT-X-TARGETDURATION:%d\n", segDur))
+		pl.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+		for n, seg := range segments {
+			name := fmt.Sprintf("subs_%d_%d.vtt", k, n)
+			if err := os.WriteFile(filepath.Join(outDir, name), []byte(formatWebVTT(seg, hlsTimestampMap)), 0o644); err != nil {
+				return fmt.Errorf("write %s: %w", name, err)
+			}
+			length := min(segLen, end-segLen*time.Duration(n))
+			pl.WriteString(fmt.Sprintf("#EXTINF:%.3f,\n%s\n", math.Max(length.Seconds(), 0.001), name))
+		}
+		pl.WriteString("#EXT-X-ENDLIST\n")
+
+		if err := os.WriteFile(filepath.Join(outDir, subtitlePlaylist(k)), []byte(pl.String()), 0o644); err != nil {
+			return fmt.Errorf("write %s: %w", subtitlePlaylist(k), err)
+		}
+	}
+	return nil
+}
+
+// subtitleMedia returns the EXT-X-MEDIA tags for the subtitle renditions.
+func subtitleMedia(tracks []subtitleTrack) []string {
+	lines := make([]string, 0, len(tracks))
+	for k, t := range tracks {
+		attrs := fmt.Sprintf("TYPE=SUBTITLES,GROUP-ID=%s,NAME=%s", quoteAttr(subtitleGroupID), quoteAttr(subtitleName(t, k)))
+		if t.language != "" {
+			attrs += ",LANGUAGE=" + quoteAttr(t.language)
+		}
+		attrs += ",DEFAULT=NO,AUTOSELECT=YES"
+		if t.forced {
+			attrs += ",FORCED=YES"
+		}
+		if t.sdh {
+			attrs += ",CHARACTERISTICS=" + quoteAttr(hlsSDHCharacteristics)
+		}
+		attrs += ",URI=" + quoteAttr(subtitlePlaylist(k))
+		lines = append(lines, "#EXT-X-MEDIA:"+attrs)
+	}
+	return lines
+}
+
+// subtitleAdaptationSets returns one DASH text AdaptationSet per track,
+// referencing its complete WebVTT file, numbered from firstID.
+func subtitleAdaptationSets(tracks []subtitleTrack, firstID int) string {
+	var b strings.Builder
+	for k, t := range tracks {
+		fmt.Fprintf(&b, `<AdaptationSet id="%d" contentType="text" mimeType="text/vtt"`, firstID+k)
+		if t.language != "" {
+			fmt.Fprintf(&b, ` lang="%s"`, xmlEscape(t.language))
+		}
+		fmt.Fprintf(&b, `><Role schemeIdUri="%s" value="%s"/>`, dashRoleScheme, subtitleRole(t))
+		fmt.Fprintf(&b, `<Representation id="subs_%d" bandwidth="256"><BaseURL>%s</BaseURL></Representation>`, k, subtitleFile(k))
+		b.WriteString("</AdaptationSet>")
+	}
+	return b.String()
+}
@@ -0,0 +1,241 @@
+package encoder
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+func TestSubtitleTracks(t *testing.T) {
+	info := probe.VideoInfo{SubtitleStreams: []probe.SubtitleStream{
+		{Codec: "hdmv_pgs_subtitle", Language: "eng"},
+		{Codec: "subrip", Language: "eng", HearingImpaired: true},
+		{Codec: "mov_text", Language: "fra", Forced: true},
+	}}
+	opts := EncoderOptions{SubtitleInputs: []SubtitleInput{{Path: "de.srt", Language: "deu", Title: "Deutsch"}}}
+
+	got := subtitleTracks(info, opts)
+	want := []subtitleTrack{
+		{language: "eng", stream: 1, sdh: true},
+		{language: "fra", stream: 2, forced: true},
+		{path: "de.srt", language: "deu", title: "Deutsch"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d tracks, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("track %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	for i, role := range []string{"caption", "forced-subtitle", "subtitle"} {
+		if r := subtitleRole(got[i]); r != role {
+			t.Errorf("subtitleRole(track %d) = %q, want %q", i, r, role)
+		}
+	}
+	if n := subtitleName(subtitleTrack{}, 2); n != "Subtitles 3" {
+		t.Errorf("subtitleName() = %q", n)
+	}
+}
+
+func TestWriteSubtitles(t *testing.T) {
+	dir := t.TempDir()
+	ttml := filepath.Join(dir, "es.ttml")
+	doc := `<tt xmlns="http://www.w3.org/ns/ttml"><body><div><p begin="1s" end="2s">Hola</p></div></body></tt>`
+	if err := os.WriteFile(ttml, []byte(doc), 0o644); err != nil {
+		t.Fatalf("write ttml: %v", err)
+	}
+	tracks := []subtitleTrack{
+		{language: "eng", stream: 1},
+		{path: "de.srt"},
+		{path: ttml},
+	}
+
+	mock := executor.NewMockExecutor()
+	mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+	if err := writeSubtitles(context.Background(), mock, "in.mkv", dir, tracks, EncoderOptions{LogLevel: "error"}); err != nil {
+		t.Fatalf("writeSubtitles() err=%v", err)
+	}
+
+	if len(mock.CallLog) != 2 {
+		t.Fatalf("expected 2 ffmpeg calls, got %d", len(mock.CallLog))
+	}
+	for i, want := range []string{
+		"-i in.mkv -map 0:s:1 -c:s webvtt -f webvtt " + filepath.Join(dir, "subs_0.vtt"),
+		"-i de.srt -map 0:s:0 -c:s webvtt -f webvtt " + filepath.Join(dir, "subs_1.vtt"),
+	} {
+		if args := strings.Join(mock.CallLog[i].Args, " "); !strings.Contains(args, want) {
+			t.Errorf("call %d args = %s, want %s", i, args, want)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "subs_2.vtt"))
+	if err != nil {
+		t.Fatalf("read converted TTML: %v", err)
+	}
+	if want := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHola\n"; string(data) != want {
+		t.Errorf("converted TTML = %q, want %q", data, want)
+	}
+
+	t.Run("ffmpeg failure", func(t *testing.T) {
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Err: errors.New("boom")}
+		if err := writeSubtitles(context.Background(), mock, "in.mkv", dir, tracks[:1], EncoderOptions{}); err == nil {
+			t.Error("expected error")
+		}
+	})
+
+	t.Run("missing TTML", func(t *testing.T) {
+		err := writeSubtitles(context.Background(), executor.NewMockExecutor(), "in.mkv", dir, []subtitleTrack{{path: filepath.Join(dir, "missing.dfxp")}}, EncoderOptions{})
+		if err == nil {
+			t.Error("expected error")
+		}
+	})
+}
+
+func TestSegmentSubtitles(t *testing.T) {
+	dir := t.TempDir()
+	vtt := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nOne\n\n00:00:04.500 --> 00:00:05.500\nTwo\n"
+	if err := os.WriteFile(filepath.Join(dir, "subs_0.vtt"), []byte(vtt), 0o644); err != nil {
+		t.Fatalf("write vtt: %v", err)
+	}
+
+	if err := segmentSubtitles(dir, 1, 5, 12); err != nil {
+		t.Fatalf("segmentSubtitles() err=%v", err)
+	}
+
+	playlist, err := os.ReadFile(filepath.Join(dir, "subs_0.m3u8"))
+	if err != nil {
+		t.Fatalf("read playlist: %v", err)
+	}
+	want := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:5\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n" +
+		"#EXTINF:5.000,\nsubs_0_0.vtt\n#EXTINF:5.000,\nsubs_0_1.vtt\n#EXTINF:2.000,\nsubs_0_2.vtt\n#EXT-X-ENDLIST\n"
+	if string(playlist) != want {
+		t.Errorf("playlist =\n%s\nwant\n%s", playlist, want)
+	}
+
+	seg, err := os.ReadFile(filepath.Join(dir, "subs_0_1.vtt"))
+	if err != nil {
+		t.Fatalf("read segment: %v", err)
+	}
+	if want := "WEBVTT\n" + hlsTimestampMap + "\n\n00:00:04.500 --> 00:00:05.500\nTwo\n"; string(seg) != want {
+		t.Errorf("segment = %q, want %q", seg, want)
+	}
+
+	if err := segmentSubtitles(dir, 2, 5, 12); err == nil {
+		t.Error("expected error for missing WebVTT file")
+	}
+}
+
+func TestEncodeSubtitles(t *testing.T) {
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, Duration: 8, HasAudio: true, SubtitleStreams: []probe.SubtitleStream{
+		{Codec: "subrip", Language: "eng"},
+	}}
+	l := []ladder.Rendition{{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"}}
+	opts := EncoderOptions{SubtitleInputs: []SubtitleInput{{Path: "fr.srt", Language: "fra", Forced: true}}}
+
+	// The mock does not run FFmpeg, so provide the files it would write.
+	setup := func(t *testing.T) string {
+		dir := t.TempDir()
+		for _, name := range []string{"subs_0.vtt", "subs_1.vtt"} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHi\n"), 0o644); err != nil {
+				t.Fatalf("write vtt: %v", err)
+			}
+		}
+		return dir
+	}
+
+	t.Run("HLS", func(t *testing.T) {
+		dir := setup(t)
+		master := "#EXTM3U\n#EXT-X-VERSION:7\n" +
+			`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="group_audio",NAME="audio_0",DEFAULT=YES,URI="stream_1.m3u8"` + "\n" +
+			`#EXT-X-STREAM-INF:BANDWIDTH=5500000,RESOLUTION=1920x1080,CODECS="avc1.640028,mp4a.40.2",AUDIO="group_audio"` + "\n" +
+			"stream_0.m3u8\n"
+		if err := os.WriteFile(filepath.Join(dir, "master.m3u8"), []byte(master), 0o644); err != nil {
+			t.Fatalf("write master: %v", err)
+		}
+
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+		if _, err := EncodeHLSCMAFWithExecutor(context.Background(), "in.mkv", dir, info, config.VOD, l, mock, nil, opts); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+		if len(mock.CallLog) != 3 {
+			t.Errorf("expected encode plus 2 subtitle conversions, got %d calls", len(mock.CallLog))
+		}
+
+		for _, name := range []string{"subs_0.m3u8", "subs_0_0.vtt", "subs_0_1.vtt", "subs_1.m3u8"} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Errorf("expected %s: %v", name, err)
+			}
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "master.m3u8"))
+		if err != nil {
+			t.Fatalf("read master: %v", err)
+		}
+		got := string(data)
+		for _, want := range []string{
+			`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="eng",LANGUAGE="eng",DEFAULT=NO,AUTOSELECT=YES,URI="subs_0.m3u8"` + "\n",
+			`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="fra",LANGUAGE="fra",DEFAULT=NO,AUTOSELECT=YES,FORCED=YES,URI="subs_1.m3u8"` + "\n#EXT-X-STREAM-INF:",
+			`AUDIO="group_audio",SUBTITLES="subs"` + "\nstream_0.m3u8",
+		} {
+			if !strings.Contains(got, want) {
+				t.Errorf("expected master to contain %s, got:\n%s", want, got)
+			}
+		}
+	})
+
+	t.Run("DASH", func(t *testing.T) {
+		dir := setup(t)
+		mpd := `<MPD><Period id="0">` +
+			`<AdaptationSet id="0" contentType="video"></AdaptationSet>` +
+			`<AdaptationSet id="1" contentType="audio"></AdaptationSet>` +
+			`</Period></MPD>`
+		if err := os.WriteFile(filepath.Join(dir, "manifest.mpd"), []byte(mpd), 0o644); err != nil {
+			t.Fatalf("write mpd: %v", err)
+		}
+
+		mock := executor.NewMockExecutor()
+		mock.Responses["ffmpeg"] = executor.MockResponse{Output: []byte("")}
+		if _, err := EncodeDASHCMAFWithExecutor(context.Background(), "in.mkv", dir, info, config.VOD, l, mock, nil, opts); err != nil {
+			t.Fatalf("encode failed: %v", err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, "manifest.mpd"))
+		if err != nil {
+			t.Fatalf("read mpd: %v", err)
+		}
+		want := `<AdaptationSet id="2" contentType="text" mimeType="text/vtt" lang="eng">` +
+			`<Role schemeIdUri="urn:mpeg:dash:role:2011" value="subtitle"/>` +
+			`<Representation id="subs_0" bandwidth="256"><BaseURL>subs_0.vtt</BaseURL></Representation></AdaptationSet>` +
+			`<AdaptationSet id="3" contentType="text" mimeType="text/vtt" lang="fra">` +
+			`<Role schemeIdUri="urn:mpeg:dash:role:2011" value="forced-subtitle"/>` +
+			`<Representation id="subs_1" bandwidth="256"><BaseURL>subs_1.vtt</BaseURL></Representation></AdaptationSet></Period>`
+		if !strings.Contains(string(data), want) {
+			t.Errorf("expected manifest to contain %s, got:\n%s", want, data)
+		}
+	})
+}
+
+func TestInsertBeforeStreamInf(t *testing.T) {
+	lines := []string{"#EXT-X-MEDIA:TYPE=SUBTITLES"}
+	if got := insertBeforeStreamInf("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\na.m3u8\n", lines); got != "#EXTM3U\n#EXT-X-MEDIA:TYPE=SUBTITLES\n#EXT-X-STREAM-INF:BANDWIDTH=1\na.m3u8\n" {
+		t.Errorf("insertBeforeStreamInf() = %q", got)
+	}
+	if got := insertBeforeStreamInf("#EXTM3U", lines); got != "#EXTM3U\n#EXT-X-MEDIA:TYPE=SUBTITLES\n" {
+		t.Errorf("insertBeforeStreamInf() without variants = %q", got)
+	}
+	if got := insertBeforeStreamInf("#EXTM3U\n", nil); got != "#EXTM3U\n" {
+		t.Errorf("insertBeforeStreamInf() with no lines = %q", got)
+	}
+}
@@ -0,0 +1,313 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// cue is a single timed text cue.
+type cue struct {
+	id       string
+	settings string
+	text     string
+	start    time.Duration
+	end      time.Duration
+}
+
+var vttBlockSeparator = regexp.MustCompile(`\n{2,}`)
+
+// parseWebVTT parses the cues of a WebVTT document. NOTE, STYLE and REGION
+// blocks are skipped.
+func parseWebVTT(doc string) ([]cue, error) {
+	doc = strings.ReplaceAll(strings.TrimPrefix(doc, "\ufeff"), "\r\n", "\n")
+	blocks := vttBlockSeparator.Split(strings.TrimSpace(doc), -1)
+	if len(blocks) == 0 || !strings.HasPrefix(blocks[0], "WEBVTT") {
+		return nil, errors.New("missing WEBVTT header")
+	}
+
+	var cues []cue
+	for _, block := range blocks[1:] {
+		lines := strings.Split(block, "\n")
+		id := ""
+		if !strings.Contains(lines[0], "-->") {
+			if len(lines) < 2 || !strings.Contains(lines[1], "-->") {
+				continue
+			}
+			id, lines = lines[0], lines[1:]
+		}
+
+		start, rest, _ := strings.Cut(lines[0], "-->")
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid cue timing %q", lines[0])
+		}
+		s, err := parseVTTTimestamp(start)
+		if err != nil {
+			return nil, err
+		}
+		e, err := parseVTTTimestamp(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		cues = append(cues, cue{
+			id:       id,
+			start:    s,
+			end:      e,
+			settings: strings.Join(fields[1:], " "),
+			text:     strings.Join(lines[1:], "\n"),
+		})
+	}
+	return cues, nil
+}
+
+// parseVTTTimestamp parses "hh:mm:ss.ttt" or "mm:ss.ttt". A comma decimal
+// separator, as in SRT, is accepted.
+func parseVTTTimestamp(s string) (time.Duration, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", ".")
+	parts := strings.Split(s, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	var total float64
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		total = total*60 + v
+	}
+	return time.Duration(math.Round(total*1000)) * time.Millisecond, nil
+}
+
+// formatVTTTimestamp formats d as "hh:mm:ss.ttt".
+func formatVTTTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// formatWebVTT renders cues as a WebVTT document. Extra header lines, such as
+// X-TIMESTAMP-MAP, follow the WEBVTT signature.
+func formatWebVTT(cues []cue, header ...string) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for _, h := range header {
+		b.WriteString(h + "\n")
+	}
+	for _, c := range cues {
+		b.WriteString("\n")
+		if c.id != "" {
+			b.WriteString(c.id + "\n")
+		}
+		b.WriteString(formatVTTTimestamp(c.start) + " --> " + formatVTTTimestamp(c.end))
+		if c.settings != "" {
+			b.WriteString(" " + c.settings)
+		}
+		b.WriteString("\n" + c.text + "\n")
+	}
+	return b.String()
+}
+
+// segmentCues splits cues into segments of segDur covering total. A cue that
+// spans a segment boundary is repeated in every segment it overlaps.
+func segmentCues(cues []cue, segDur, total time.Duration) [][]cue {
+	for _, c := range cues {
+		total = max(total, c.end)
+	}
+	n := max(int((total+segDur-1)/segDur), 1)
+
+	segments := make([][]cue, n)
+	for _, c := range cues {
+		first := int(c.start / segDur)
+		last := min(int((c.end-1)/segDur), n-1)
+		for i := max(first, 0); i <= last; i++ {
+			segments[i] = append(segments[i], c)
+		}
+	}
+	return segments
+}
+
+// parseTTML converts the timed paragraphs of a TTML (or DFXP) document to
+// cues. Span timing and styling are ignored; <br/> becomes a line break.
+func parseTTML(data []byte) ([]cue, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	rates := parseTTMLRates(nil)
+
+	var (
+		cues   []cue
+		begins []time.Duration // effective begin of every open element
+		cur    *cue
+		text   strings.Builder
+	)
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parse TTML: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "tt" {
+				rates = parseTTMLRates(t.Attr)
+			}
+			parent := time.Duration(0)
+			if len(begins) > 0 {
+				parent = begins[len(begins)-1]
+			}
+			begin := parent
+			if v := xmlAttr(t.Attr, "begin"); v != "" {
+				off, err := parseTTMLTime(v, rates)
+				if err != nil {
+					return nil, err
+				}
+				begin += off
+			}
+			begins = append(begins, begin)
+
+			switch t.Name.Local {
+			case "p":
+				end, err := ttmlEnd(t.Attr, parent, begin, rates)
+				if err != nil {
+					return nil, err
+				}
+				cur = &cue{start: begin, end: end}
+				text.Reset()
+			case "br":
+				if cur != nil {
+					text.WriteString("\n")
+				}
+			}
+		case xml.EndElement:
+			begins = begins[:len(begins)-1]
+			if t.Name.Local == "p" && cur != nil {
+				cur.text = ttmlText(text.String())
+				if cur.text != "" && cur.end > cur.start {
+					cues = append(cues, *cur)
+				}
+				cur = nil
+			}
+		case xml.CharData:
+			if cur != nil {
+				// Source line breaks are whitespace; only <br/> breaks a line.
+				text.WriteString(strings.Map(func(r rune) rune {
+					if unicode.IsSpace(r) {
+						return ' '
+					}
+					return r
+				}, string(t)))
+			}
+		}
+	}
+
+	sort.SliceStable(cues, func(i, j int) bool { return cues[i].start < cues[j].start })
+	return cues, nil
+}
+
+// ttmlRates holds the frame and tick rates used to resolve TTML time expressions.
+type ttmlRates struct {
+	frameRate float64
+	tickRate  float64
+}
+
+func parseTTMLRates(attrs []xml.Attr) ttmlRates {
+	r := ttmlRates{frameRate: 30, tickRate: 1}
+	if v, err := strconv.ParseFloat(xmlAttr(attrs, "frameRate"), 64); err == nil && v > 0 {
+		r.frameRate = v
+		r.tickRate = v
+	}
+	if v, err := strconv.ParseFloat(xmlAttr(attrs, "tickRate"), 64); err == nil && v > 0 {
+		r.tickRate = v
+	}
+	return r
+}
+
+// ttmlEnd resolves a paragraph's end from its end or dur attribute.
+// A paragraph with neither yields an end equal to its begin and is dropped.
+func ttmlEnd(attrs []xml.Attr, parent, begin time.Duration, rates ttmlRates) (time.Duration, error) {
+	if v := xmlAttr(attrs, "end"); v != "" {
+		end, err := parseTTMLTime(v, rates)
+		return parent + end, err
+	}
+	if v := xmlAttr(attrs, "dur"); v != "" {
+		dur, err := parseTTMLTime(v, rates)
+		return begin + dur, err
+	}
+	return begin, nil
+}
+
+var ttmlOffsetPattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)(h|ms|m|s|f|t)$`)
+
+// parseTTMLTime parses a TTML clock time ("hh:mm:ss.fff", "hh:mm:ss:ff") or
+// offset time ("1.5s", "500ms", "25f", "10000t").
+func parseTTMLTime(s string, rates ttmlRates) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	seconds := func(v float64) time.Duration {
+		return time.Duration(math.Round(v*1000)) * time.Millisecond
+	}
+
+	if m := ttmlOffsetPattern.FindStringSubmatch(s); m != nil {
+		v, _ := strconv.ParseFloat(m[1], 64)
+		switch m[2] {
+		case "h":
+			return seconds(v * 3600), nil
+		case "m":
+			return seconds(v * 60), nil
+		case "s":
+			return seconds(v), nil
+		case "ms":
+			return seconds(v / 1000), nil
+		case "f":
+			return seconds(v / rates.frameRate), nil
+		default:
+			return seconds(v / rates.tickRate), nil
+		}
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) == 3 || len(parts) == 4 {
+		var vals [4]float64
+		for i, p := range parts {
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil || v < 0 {
+				return 0, fmt.Errorf("invalid TTML time %q", s)
+			}
+			vals[i] = v
+		}
+		return seconds(vals[0]*3600 + vals[1]*60 + vals[2] + vals[3]/rates.frameRate), nil
+	}
+	return 0, fmt.Errorf("invalid TTML time %q", s)
+}
+
+// ttmlText collapses whitespace in every line of a paragraph, drops empty lines and escapes
+// the characters WebVTT cue text reserves.
+func ttmlText(s string) string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(strings.Join(lines, "\n"))
+}
+
+// xmlAttr returns the value of the attribute with the given local name, ignoring its namespace.
+func xmlAttr(attrs []xml.Attr, local string) string {
+	for _, a := range attrs {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,154 @@
+package encoder
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWebVTT(t *testing.T) {
+	doc := "\ufeffWEBVTT\r\nKind: captions\r\n\r\n" +
+		"NOTE produced by ffmpeg\r\n\r\n" +
+		"intro\r\n00:00:01.000 --> 00:00:02.500 line:90%\r\nHello\r\nworld\r\n\r\n" +
+		"01:02.000 --> 01:04,250\r\n<i>Later</i>\r\n"
+
+	cues, err := parseWebVTT(doc)
+	if err != nil {
+		t.Fatalf("parseWebVTT() err=%v", err)
+	}
+	want := []cue{
+		{id: "intro", start: time.Second, end: 2500 * time.Millisecond, settings: "line:90%", text: "Hello\nworld"},
+		{start: 62 * time.Second, end: 64250 * time.Millisecond, text: "<i>Later</i>"},
+	}
+	if len(cues) != len(want) {
+		t.Fatalf("got %d cues, want %d", len(cues), len(want))
+	}
+	for i := range want {
+		if cues[i] != want[i] {
+			t.Errorf("cue %d = %+v, want %+v", i, cues[i], want[i])
+		}
+	}
+
+	if _, err := parseWebVTT("1\n00:00:01,000 --> 00:00:02,000\nSRT"); err == nil {
+		t.Error("expected error for missing header")
+	}
+	if _, err := parseWebVTT("WEBVTT\n\n00:00:xx.000 --> 00:00:02.000\nbad"); err == nil {
+		t.Error("expected error for invalid timestamp")
+	}
+}
+
+func TestFormatWebVTT(t *testing.T) {
+	cues := []cue{
+		{id: "1", start: 3723004 * time.Millisecond, end: 3724 * time.Second, settings: "align:start", text: "Hi"},
+		{start: 0, end: 500 * time.Millisecond, text: "Two\nlines"},
+	}
+	got := formatWebVTT(cues, hlsTimestampMap)
+	want := "WEBVTT\n" + hlsTimestampMap + "\n" +
+		"\n1\n01:02:03.004 --> 01:02:04.000 align:start\nHi\n" +
+		"\n00:00:00.000 --> 00:00:00.500\nTwo\nlines\n"
+	if got != want {
+		t.Errorf("formatWebVTT() =\n%q\nwant\n%q", got, want)
+	}
+
+	parsed, err := parseWebVTT(got)
+	if err != nil || len(parsed) != 2 || parsed[0] != cues[0] || parsed[1] != cues[1] {
+		t.Errorf("round trip = %+v, err=%v", parsed, err)
+	}
+}
+
+func TestSegmentCues(t *testing.T) {
+	cues := []cue{
+		{start: time.Second, end: 2 * time.Second, text: "a"},
+		{start: 4 * time.Second, end: 6 * time.Second, text: "spans"},
+		{start: 10 * time.Second, end: 11 * time.Second, text: "b"},
+	}
+
+	segments := segmentCues(cues, 5*time.Second, 12*time.Second)
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+	texts := func(seg []cue) string {
+		var s []string
+		for _, c := range seg {
+			s = append(s, c.text)
+		}
+		return strings.Join(s, ",")
+	}
+	for i, want := range []string{"a,spans", "spans", "b"} {
+		if got := texts(segments[i]); got != want {
+			t.Errorf("segment %d = %q, want %q", i, got, want)
+		}
+	}
+
+	if got := segmentCues(nil, 5*time.Second, 0); len(got) != 1 {
+		t.Errorf("expected one empty segment, got %d", len(got))
+	}
+	if got := segmentCues(cues, 5*time.Second, 0); len(got) != 3 {
+		t.Errorf("expected duration from last cue, got %d segments", len(got))
+	}
+}
+
+func TestParseTTML(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<tt xmlns="http://www.w3.org/ns/ttml" xmlns:ttp="http://www.w3.org/ns/ttml#parameter" ttp:frameRate="25" ttp:tickRate="10000000">
+  <body>
+    <div begin="10s">
+      <p begin="00:00:02.000" end="00:00:04.000">Second
+        line <span tts:color="red">styled</span><br/>break &amp; &lt;tag&gt;</p>
+      <p begin="0s" dur="25f">First</p>
+      <p begin="20000000t" end="30000000t">Ticks</p>
+      <p begin="00:00:05:12" end="00:00:06:00">Frames</p>
+      <p begin="1s">No end</p>
+    </div>
+  </body>
+</tt>`
+
+	cues, err := parseTTML([]byte(doc))
+	if err != nil {
+		t.Fatalf("parseTTML() err=%v", err)
+	}
+	want := []cue{
+		{start: 10 * time.Second, end: 11 * time.Second, text: "First"},
+		{start: 12 * time.Second, end: 14 * time.Second, text: "Second line styled\nbreak &amp; &lt;tag&gt;"},
+		{start: 12 * time.Second, end: 13 * time.Second, text: "Ticks"},
+		{start: 15480 * time.Millisecond, end: 16 * time.Second, text: "Frames"},
+	}
+	if len(cues) != len(want) {
+		t.Fatalf("got %d cues %+v, want %d", len(cues), cues, len(want))
+	}
+	for i := range want {
+		if cues[i] != want[i] {
+			t.Errorf("cue %d = %+v, want %+v", i, cues[i], want[i])
+		}
+	}
+
+	if _, err := parseTTML([]byte(`<tt><body><p begin="soon" end="1s">x</p></body></tt>`)); err == nil {
+		t.Error("expected error for invalid time expression")
+	}
+	if _, err := parseTTML([]byte(`<tt><body><p>`)); err == nil {
+		t.Error("expected error for truncated document")
+	}
+}
+
+func TestParseTTMLTime(t *testing.T) {
+	rates := parseTTMLRates(nil)
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"1.5s", 1500 * time.Millisecond},
+		{"250ms", 250 * time.Millisecond},
+		{"2m", 2 * time.Minute},
+		{"1h", time.Hour},
+		{"15f", 500 * time.Millisecond},
+		{"3t", 3 * time.Second},
+		{"01:00:00.250", time.Hour + 250*time.Millisecond},
+		{"00:00:01:15", 1500 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		got, err := parseTTMLTime(tt.in, rates)
+		if err != nil || got != tt.want {
+			t.Errorf("parseTTMLTime(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
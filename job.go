@@ -1,5 +1,11 @@
 package mosaic
 
+import (
+	"time"
+
+	"github.com/farshidrezaei/mosaic/config"
+)
+
 // Profile represents an encoding profile that determines segment duration and latency settings.
 type Profile string
 
@@ -35,4 +41,42 @@ type Job struct {
 	ProgressHandler ProgressHandler
 	// Profile determines the segment duration and latency characteristics of the output.
 	Profile Profile
+	// AudioInputs are external audio files, such as dubs or audio description
+	// tracks, packaged after the input's own audio tracks.
+	AudioInputs []AudioInput
+	// SubtitleInputs are external subtitle files packaged after the input's
+	// own text subtitle streams.
+	SubtitleInputs []SubtitleInput
+}
+
+// AudioInput is an external audio file encoded and muxed into the same
+// package as the job's primary input.
+type AudioInput struct {
+	// Path is the absolute path or public URL to the audio file. Its first audio stream is used.
+	Path string
+	// Language is the language tag of the track (e.g., "deu", "es-419").
+	Language string
+	// Title is the human-readable track name shown by players.
+	Title string
+	// Role is the purpose of the track. Empty is treated as config.AudioRoleDub.
+	// config.AudioRoleDescription tracks are signalled as accessibility audio.
+	Role config.AudioRole
+	// Offset aligns the track with the video. Positive values delay it;
+	// negative values skip the start of the file.
+	Offset time.Duration
+}
+
+// SubtitleInput is an external subtitle file (SRT, WebVTT, or a local TTML/DFXP
+// file) packaged as segmented WebVTT for HLS and a WebVTT text track for DASH.
+type SubtitleInput struct {
+	// Path is the absolute path or public URL to the subtitle file.
+	Path string
+	// Language is the language tag of the track (e.g., "eng", "pt-BR").
+	Language string
+	// Title is the human-readable track name shown by players.
+	Title string
+	// Forced marks subtitles that only cover foreign-language or on-screen text.
+	Forced bool
+	// SDH marks subtitles for the deaf and hard of hearing.
+	SDH bool
 }
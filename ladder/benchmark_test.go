@@ -15,6 +15,6 @@ func BenchmarkBuild(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		Build(info)
+		_, _ = Build(info)
 	}
 }
@@ -0,0 +1,68 @@
+package ladder
+
+import (
+	"math"
+
+	"github.com/farshidrezaei/mosaic/config"
+)
+
+// codecEfficiency is the bitrate of each codec relative to H.264 at similar quality.
+var codecEfficiency = map[config.Codec]float64{
+	config.CodecH264: 1.0,
+	config.CodecHEVC: 0.7,
+	config.CodecAV1:  0.6,
+}
+
+// AddCodec appends a copy of every rendition whose shorter side is at least
+// minHeight, encoded with codec. Bitrates are scaled by the codec's efficiency
+// relative to H.264, so an HEVC copy of a 5000 kbps rung targets 3500 kbps.
+// The input renditions are expected to be H.264.
+func AddCodec(l []Rendition, codec config.Codec, minHeight int) []Rendition {
+	factor, ok := codecEfficiency[codec]
+	if !ok {
+		factor = 1.0
+	}
+
+	out := append([]Rendition(nil), l...)
+	for _, r := range l {
+		if min(r.Width, r.Height) < minHeight {
+			continue
+		}
+		r.Codec = codec
+		r.MaxRate = int(float64(r.MaxRate) * factor)
+		r.BufSize = int(float64(r.BufSize) * factor)
+		out = append(out, r)
+	}
+	return out
+}
+
+// AddSDR appends a tone-mapped SDR H.264 copy of every HDR-capable rendition,
+// so devices without HDR support still get correct colors. H.264 renditions
+// and renditions already labelled SDR are skipped, as are resolutions that
+// already have an H.264 rendition. Bitrates of HEVC and AV1 renditions are
+// scaled back to H.264 by the codec's efficiency.
+func AddSDR(l []Rendition) []Rendition {
+	out := append([]Rendition(nil), l...)
+	has := map[[2]int]bool{}
+	for _, r := range l {
+		if r.Codec == config.CodecH264 {
+			has[[2]int{r.Width, r.Height}] = true
+		}
+	}
+
+	for _, r := range l {
+		size := [2]int{r.Width, r.Height}
+		if r.Codec == config.CodecH264 || r.Range == config.VideoRangeSDR || has[size] {
+			continue
+		}
+		if factor, ok := codecEfficiency[r.Codec]; ok {
+			r.MaxRate = int(math.Round(float64(r.MaxRate) / factor))
+			r.BufSize = int(math.Round(float64(r.BufSize) / factor))
+		}
+		r.Codec = config.CodecH264
+		r.Range = config.VideoRangeSDR
+		has[size] = true
+		out = append(out, r)
+	}
+	return out
+}
@@ -0,0 +1,135 @@
+package ladder
+
+import (
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/config"
+)
+
+func TestAddCodec(t *testing.T) {
+	base := []Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
+		{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
+		{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+	}
+
+	tests := []struct {
+		name      string
+		codec     config.Codec
+		expected  []Rendition
+		input     []Rendition
+		minHeight int
+	}{
+		{
+			name:      "HEVC top rungs",
+			input:     base,
+			codec:     config.CodecHEVC,
+			minHeight: 720,
+			expected: append(append([]Rendition(nil), base...),
+				Rendition{Codec: config.CodecHEVC, Width: 1920, Height: 1080, MaxRate: 3500, BufSize: 7000, Profile: "main", Level: "4.0"},
+				Rendition{Codec: config.CodecHEVC, Width: 1280, Height: 720, MaxRate: 2100, BufSize: 4200, Profile: "main", Level: "3.1"},
+			),
+		},
+		{
+			name:      "AV1 all rungs",
+			input:     base[2:],
+			codec:     config.CodecAV1,
+			minHeight: 0,
+			expected: []Rendition{
+				base[2],
+				{Codec: config.CodecAV1, Width: 640, Height: 360, MaxRate: 600, BufSize: 1200, Profile: "baseline", Level: "3.0"},
+			},
+		},
+		{
+			name:      "portrait uses shorter side",
+			input:     []Rendition{{Width: 1080, Height: 1920, MaxRate: 5000, BufSize: 10000}},
+			codec:     config.CodecHEVC,
+			minHeight: 1080,
+			expected: []Rendition{
+				{Width: 1080, Height: 1920, MaxRate: 5000, BufSize: 10000},
+				{Codec: config.CodecHEVC, Width: 1080, Height: 1920, MaxRate: 3500, BufSize: 7000},
+			},
+		},
+		{
+			name:      "no eligible rungs",
+			input:     base[2:],
+			codec:     config.CodecHEVC,
+			minHeight: 1080,
+			expected:  base[2:],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := AddCodec(tt.input, tt.codec, tt.minHeight)
+
+			if len(result) != len(tt.expected) {
+				t.Fatalf("expected %d renditions, got %d", len(tt.expected), len(result))
+			}
+			for i, r := range result {
+				if r != tt.expected[i] {
+					t.Errorf("rendition %d mismatch:\nexpected: %+v\ngot:      %+v", i, tt.expected[i], r)
+				}
+			}
+		})
+	}
+}
+
+func TestAddSDR(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []Rendition
+		expected []Rendition
+	}{
+		{
+			name: "HDR job codec rungs",
+			input: []Rendition{
+				{Range: config.VideoRangePQ, Width: 1920, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0"},
+			},
+			expected: []Rendition{
+				{Range: config.VideoRangePQ, Width: 1920, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0"},
+				{Codec: config.CodecH264, Range: config.VideoRangeSDR, Width: 1920, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0"},
+			},
+		},
+		{
+			name: "codec copies are scaled back and deduplicated",
+			input: []Rendition{
+				{Codec: config.CodecHEVC, Range: config.VideoRangeHLG, Width: 1280, Height: 720, MaxRate: 2100, BufSize: 4200},
+				{Codec: config.CodecAV1, Range: config.VideoRangeHLG, Width: 1280, Height: 720, MaxRate: 1800, BufSize: 3600},
+			},
+			expected: []Rendition{
+				{Codec: config.CodecHEVC, Range: config.VideoRangeHLG, Width: 1280, Height: 720, MaxRate: 2100, BufSize: 4200},
+				{Codec: config.CodecAV1, Range: config.VideoRangeHLG, Width: 1280, Height: 720, MaxRate: 1800, BufSize: 3600},
+				{Codec: config.CodecH264, Range: config.VideoRangeSDR, Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000},
+			},
+		},
+		{
+			name: "H.264 and SDR rungs are skipped",
+			input: []Rendition{
+				{Codec: config.CodecH264, Width: 1920, Height: 1080, MaxRate: 5200},
+				{Codec: config.CodecHEVC, Width: 1920, Height: 1080, MaxRate: 3640},
+				{Codec: config.CodecHEVC, Range: config.VideoRangeSDR, Width: 640, Height: 360, MaxRate: 700},
+			},
+			expected: []Rendition{
+				{Codec: config.CodecH264, Width: 1920, Height: 1080, MaxRate: 5200},
+				{Codec: config.CodecHEVC, Width: 1920, Height: 1080, MaxRate: 3640},
+				{Codec: config.CodecHEVC, Range: config.VideoRangeSDR, Width: 640, Height: 360, MaxRate: 700},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := AddSDR(tt.input)
+
+			if len(result) != len(tt.expected) {
+				t.Fatalf("expected %d renditions, got %d", len(tt.expected), len(result))
+			}
+			for i, r := range result {
+				if r != tt.expected[i] {
+					t.Errorf("rendition %d mismatch:\nexpected: %+v\ngot:      %+v", i, tt.expected[i], r)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package ladder
+
+import (
+	"slices"
+
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// SourcePolicy decides what happens to renditions larger than the source.
+type SourcePolicy int
+
+const (
+	// DropLarger drops renditions whose shorter side exceeds the source's.
+	// When every rendition is dropped, the smallest one is kept so the ladder
+	// is never empty. This is the default.
+	DropLarger SourcePolicy = iota
+	// KeepLarger keeps every rendition and upscales the source for the larger ones.
+	KeepLarger
+)
+
+// Fit adapts a custom ladder to the source. Renditions are rotated to match
+// a portrait or landscape source, renditions whose MinSourceHeight exceeds the
+// source are dropped, and renditions larger than the source are handled
+// according to policy. The input ladder is not modified.
+func Fit(l []Rendition, info probe.VideoInfo, policy SourcePolicy) []Rendition {
+	out := make([]Rendition, 0, len(l))
+	for _, r := range l {
+		if info.IsPortrait() != (r.Height > r.Width) && r.Width != r.Height {
+			r.Width, r.Height = r.Height, r.Width
+		}
+		out = append(out, r)
+	}
+	if len(out) == 0 {
+		return out
+	}
+
+	source := min(info.DisplayWidth(), info.DisplayHeight())
+	fits := slices.DeleteFunc(slices.Clone(out), func(r Rendition) bool {
+		return r.MinSourceHeight > source || policy != KeepLarger && min(r.Width, r.Height) > source
+	})
+	if len(fits) > 0 {
+		return fits
+	}
+	smallest := slices.MinFunc(out, func(a, b Rendition) int {
+		return a.Width*a.Height - b.Width*b.Height
+	})
+	return []Rendition{smallest}
+}
@@ -0,0 +1,95 @@
+package ladder
+
+import (
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+func TestFit(t *testing.T) {
+	custom := []Rendition{
+		{Width: 3840, Height: 2160, MaxRate: 16000},
+		{Width: 1920, Height: 1080, MaxRate: 6000},
+		{Width: 1280, Height: 720, MaxRate: 3000},
+	}
+	eligible := []Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 6000, MinSourceHeight: 1080},
+		{Width: 1280, Height: 720, MaxRate: 3000},
+	}
+
+	tests := []struct {
+		name     string
+		ladder   []Rendition
+		info     probe.VideoInfo
+		policy   SourcePolicy
+		expected []Rendition
+	}{
+		{
+			name:     "drops rungs above the source",
+			info:     probe.VideoInfo{Width: 1920, Height: 1080},
+			expected: custom[1:],
+		},
+		{
+			name:     "keeps rungs above the source",
+			info:     probe.VideoInfo{Width: 1920, Height: 1080},
+			policy:   KeepLarger,
+			expected: custom,
+		},
+		{
+			name:     "keeps the smallest rung of a tiny source",
+			info:     probe.VideoInfo{Width: 640, Height: 360},
+			expected: custom[2:],
+		},
+		{
+			name: "rotates rungs for a portrait source",
+			info: probe.VideoInfo{Width: 1920, Height: 1080, Rotation: 90},
+			expected: []Rendition{
+				{Width: 1080, Height: 1920, MaxRate: 6000},
+				{Width: 720, Height: 1280, MaxRate: 3000},
+			},
+		},
+		{
+			name:     "drops rungs the source is too small for",
+			ladder:   eligible,
+			info:     probe.VideoInfo{Width: 1280, Height: 720},
+			policy:   KeepLarger,
+			expected: eligible[1:],
+		},
+		{
+			name:     "source eligibility follows the shorter side",
+			ladder:   eligible,
+			info:     probe.VideoInfo{Width: 1080, Height: 1920},
+			expected: []Rendition{{Width: 1080, Height: 1920, MaxRate: 6000, MinSourceHeight: 1080}, {Width: 720, Height: 1280, MaxRate: 3000}},
+		},
+		{
+			name:     "keeps the smallest rung when none is eligible",
+			ladder:   []Rendition{{Width: 1920, Height: 1080, MaxRate: 6000, MinSourceHeight: 1080}, {Width: 1280, Height: 720, MaxRate: 3000, MinSourceHeight: 720}},
+			info:     probe.VideoInfo{Width: 640, Height: 360},
+			policy:   KeepLarger,
+			expected: []Rendition{{Width: 1280, Height: 720, MaxRate: 3000, MinSourceHeight: 720}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := custom
+			if tt.ladder != nil {
+				l = tt.ladder
+			}
+			result := Fit(l, tt.info, tt.policy)
+
+			if len(result) != len(tt.expected) {
+				t.Fatalf("expected %d renditions, got %d: %+v", len(tt.expected), len(result), result)
+			}
+			for i, r := range result {
+				if r != tt.expected[i] {
+					t.Errorf("rendition %d mismatch:\nexpected: %+v\ngot:      %+v", i, tt.expected[i], r)
+				}
+			}
+		})
+	}
+
+	if custom[0].Width != 3840 {
+		t.Error("Fit modified its input")
+	}
+}
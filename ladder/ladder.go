@@ -1,43 +1,255 @@
 package ladder
 
-import "github.com/farshidrezaei/mosaic/probe"
+import (
+	"math"
+	"slices"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// aspectTolerance is the largest relative aspect ratio error accepted to round
+// a fitted dimension to a multiple of 16.
+const aspectTolerance = 0.01
+
+const (
+	// highFrameRate is the frame rate above which a source is high frame rate.
+	highFrameRate = 30
+	// maxFrameRateFactor is the frame rate factor of a 60 fps rendition, the
+	// most a high frame rate rendition gets.
+	maxFrameRateFactor = math.Sqrt2
+	// fullRateHeight is the smallest rung that keeps the frame rate of a high
+	// frame rate source; smaller rungs halve it.
+	fullRateHeight = 720
+)
+
+// rung is a rendition of the built-in ladder, as a 16:9 box.
+type rung struct {
+	profile                         string
+	width, height, maxRate, bufSize int
+}
+
+// rungs are the standard rungs, largest first.
+var rungs = []rung{
+	{"high", 3840, 2160, 16000, 32000},
+	{"high", 2560, 1440, 9000, 18000},
+	{"main", 1920, 1080, 5200, 10400},
+	{"main", 1280, 720, 3000, 6000},
+	{"baseline", 640, 360, 1000, 2000},
+}
+
+// lowRungs are the rungs below 360p for low-bandwidth networks, largest first.
+var lowRungs = []rung{
+	{"baseline", 480, 270, 600, 1200},
+	{"baseline", 416, 234, 400, 800},
+	{"baseline", 256, 144, 200, 400},
+}
+
+// FrameRateFactor returns the bitrate of a rendition at fps relative to the
+// same rendition at 30 fps: frames closer in time differ less, so the bitrate
+// grows with the square root of the frame rate, up to √2 at 60 fps. Frame
+// rates of 30 fps or less, and unknown ones, give 1.
+func FrameRateFactor(fps float64) float64 {
+	if fps <= highFrameRate {
+		return 1
+	}
+	return math.Min(math.Sqrt(fps/highFrameRate), maxFrameRateFactor)
+}
+
+// halveFrameRate halves a high frame rate until it is at most 30 fps, so 60
+// fps becomes 30 and 50 fps 25. Other frame rates are returned unchanged.
+func halveFrameRate(fps float64) float64 {
+	for fps > highFrameRate {
+		fps /= 2
+	}
+	return fps
+}
 
 // Build generates an initial encoding ladder based on the source video's height.
-// It creates a set of standard renditions (1080p, 720p, 360p) that are suitable
-// for adaptive bitrate streaming.
-func Build(info probe.VideoInfo) []Rendition {
+// It creates the standard renditions (2160p, 1440p, 1080p, 720p, 360p) the
+// source is large enough for, so the source is never upscaled. Sources below
+// 360p get the low-bandwidth renditions (270p, 234p, 144p) up to their size
+// instead; 144p is always kept so the ladder is never empty. Each rendition is
+// fitted into its 16:9 (or 9:16) box at the source display aspect ratio, so
+// 4:3, square and scope sources are encoded without black bars; see
+// FitAspect. Renditions of an HDR source are labelled with its range.
+//
+// Every rendition carries the source frame rate as its FPS. For high frame
+// rate sources (above 30 fps), renditions of 720p and up keep it, with their
+// bitrate raised by FrameRateFactor, while smaller ones halve it (60 to 30
+// fps, 50 to 25 fps) to spend their few bits on detail instead of motion.
+// Each rendition gets the lowest H.264 level that allows it (see H264Level).
+// Build returns an error when no level allows one of them, such as 2160p at
+// more than 515 fps.
+func Build(info probe.VideoInfo) ([]Rendition, error) {
+	return build(info, false)
+}
+
+// BuildPadded is like Build but keeps every rendition at its full 16:9 (or
+// 9:16) size, for sources letterboxed or pillarboxed by the encoder.
+func BuildPadded(info probe.VideoInfo) ([]Rendition, error) {
+	return build(info, true)
+}
+
+func build(info probe.VideoInfo, pad bool) ([]Rendition, error) {
 	var out []Rendition
 	portrait := info.IsPortrait()
-	sourceHeight := info.DisplayHeight()
+	sourceWidth, sourceHeight := info.DisplayWidth(), info.DisplayHeight()
+	var videoRange config.VideoRange
+	if vr := info.VideoRange(); vr != config.VideoRangeSDR {
+		videoRange = vr
+	}
 
-	makeRendition := func(width, height, maxRate, bufSize int, profile, level string) Rendition {
+	makeRendition := func(r rung) Rendition {
+		width, height := r.width, r.height
 		if portrait {
 			width, height = height, width
 		}
-		return Rendition{
+		if !pad {
+			width, height = fitAspect(width, height, sourceWidth, sourceHeight)
+		}
+		rendition := Rendition{
+			Range:   videoRange,
 			Width:   width,
 			Height:  height,
-			MaxRate: maxRate,
-			BufSize: bufSize,
-			Profile: profile,
-			Level:   level,
+			MaxRate: r.maxRate,
+			BufSize: r.bufSize,
+			Profile: r.profile,
 			BFrames: 0,
+			FPS:     info.FPS,
 		}
+		switch {
+		case info.FPS <= highFrameRate:
+		case r.height < fullRateHeight:
+			rendition.FPS = halveFrameRate(info.FPS)
+		default:
+			factor := FrameRateFactor(info.FPS)
+			rendition.MaxRate = int(math.Round(float64(r.maxRate) * factor))
+			rendition.BufSize = int(math.Round(float64(r.bufSize) * factor))
+		}
+		return rendition
+	}
+
+	// eligible reports whether the source reaches a box of the given height:
+	// by its shorter side or, for sources wider than the box, by covering the
+	// fitted rendition without upscaling.
+	eligible := func(r Rendition, height int) bool {
+		return min(sourceWidth, sourceHeight) >= height || !pad && r.Width <= sourceWidth && r.Height <= sourceHeight
 	}
 
-	if sourceHeight >= 1080 {
-		out = append(out, makeRendition(1920, 1080, 5200, 10400, "main", "4.0"))
+	for _, r := range rungs {
+		if rendition := makeRendition(r); eligible(rendition, r.height) {
+			out = append(out, rendition)
+		}
+	}
+	if len(out) == 0 {
+		for _, r := range lowRungs {
+			if rendition := makeRendition(r); eligible(rendition, r.height) {
+				out = append(out, rendition)
+			}
+		}
 	}
-	if sourceHeight >= 720 {
-		out = append(out, makeRendition(1280, 720, 3000, 6000, "main", "3.1"))
+	if len(out) == 0 {
+		out = append(out, makeRendition(lowRungs[len(lowRungs)-1]))
+	}
+
+	for i, r := range out {
+		level, err := H264Level(r, r.FPS)
+		if err != nil {
+			return nil, err
+		}
+		out[i].Level = level
 	}
-	if sourceHeight >= 360 {
-		out = append(out, makeRendition(640, 360, 1000, 2000, "baseline", "3.0"))
+	return out, nil
+}
+
+// AddLowBandwidth appends the low-bandwidth renditions (270p, 234p, 144p)
+// below the smallest rendition of l, for viewers on 2G and congested mobile
+// networks. They take the aspect ratio, orientation, codec and range of the
+// smallest rendition, and its frame rate halved to at most 30 fps. It returns
+// an error when no H.264 level allows one of them, which takes an extreme
+// aspect ratio. The input ladder is not modified.
+func AddLowBandwidth(l []Rendition) ([]Rendition, error) {
+	out := slices.Clone(l)
+	if len(l) == 0 {
+		return out, nil
 	}
+	smallest := slices.MinFunc(l, func(a, b Rendition) int {
+		return min(a.Width, a.Height) - min(b.Width, b.Height)
+	})
+	short := min(smallest.Width, smallest.Height)
+	aspect := float64(max(smallest.Width, smallest.Height)) / float64(short)
 
-	if sourceHeight < 360 {
-		out = append(out, makeRendition(640, 360, 1000, 2000, "baseline", "3.0"))
+	for _, r := range lowRungs {
+		if r.height >= short {
+			continue
+		}
+		long := roundDimension(float64(r.height)*aspect, math.MaxInt)
+		rendition := smallest
+		rendition.Width, rendition.Height = long, r.height
+		if smallest.Height > smallest.Width {
+			rendition.Width, rendition.Height = r.height, long
+		}
+		rendition.MaxRate, rendition.BufSize = r.maxRate, r.bufSize
+		rendition.Profile = r.profile
+		rendition.FPS = halveFrameRate(smallest.FPS)
+		if factor, ok := codecEfficiency[smallest.Codec]; ok {
+			rendition.MaxRate = int(float64(r.maxRate) * factor)
+			rendition.BufSize = int(float64(r.bufSize) * factor)
+		}
+		level, err := H264Level(rendition, rendition.FPS)
+		if err != nil {
+			return nil, err
+		}
+		rendition.Level = level
+		out = append(out, rendition)
 	}
+	return out, nil
+}
 
+// FitAspect shrinks each rendition to fit its size at the source display
+// aspect ratio, so it can be scaled without distortion or padding. One side
+// keeps its size and the other is rounded to an even size, or to a multiple
+// of 16 when that changes the aspect ratio by at most 1%. Renditions of a source
+// with unknown dimensions are returned unchanged. The input ladder is not
+// modified.
+func FitAspect(l []Rendition, info probe.VideoInfo) []Rendition {
+	out := slices.Clone(l)
+	for i, r := range out {
+		out[i].Width, out[i].Height = fitAspect(r.Width, r.Height, info.DisplayWidth(), info.DisplayHeight())
+	}
 	return out
 }
+
+// fitAspect returns the largest size within width × height at the aspect
+// ratio of sourceWidth × sourceHeight.
+func fitAspect(width, height, sourceWidth, sourceHeight int) (int, int) {
+	if sourceWidth <= 0 || sourceHeight <= 0 {
+		return width, height
+	}
+	aspect := float64(sourceWidth) / float64(sourceHeight)
+	if float64(width)/float64(height) > aspect {
+		return roundDimension(float64(height)*aspect, width), height
+	}
+	return width, roundDimension(float64(width)/aspect, height)
+}
+
+// roundDimension rounds the exact size v, at most limit, to a multiple of 2. A
+// size that is not already even is rounded to a multiple of 16 instead when
+// that stays within aspectTolerance of v.
+func roundDimension(v float64, limit int) int {
+	round := func(mod int) int {
+		n := int(math.Round(v/float64(mod))) * mod
+		if n > limit {
+			n -= mod
+		}
+		return n
+	}
+	if n := round(2); math.Abs(float64(n)-v) < 0.01 {
+		return n
+	}
+	if n := round(16); n > 0 && math.Abs(float64(n)-v)/v <= aspectTolerance {
+		return n
+	}
+	return max(round(2), 2)
+}
@@ -1,8 +1,11 @@
 package ladder
 
 import (
+	"math"
+	"strings"
 	"testing"
 
+	"github.com/farshidrezaei/mosaic/config"
 	"github.com/farshidrezaei/mosaic/probe"
 )
 
@@ -20,9 +23,9 @@ func TestBuild(t *testing.T) {
 				FPS:    30.0,
 			},
 			expected: []Rendition{
-				{Width: 1920, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0"},
-				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
-				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+				{Width: 1920, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0", FPS: 30},
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", FPS: 30},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 30},
 			},
 		},
 		{
@@ -33,9 +36,38 @@ func TestBuild(t *testing.T) {
 				FPS:    60.0,
 			},
 			expected: []Rendition{
-				{Width: 1920, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0"},
-				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
-				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+				{Width: 3840, Height: 2160, MaxRate: 22627, BufSize: 45255, Profile: "high", Level: "5.2", FPS: 60},
+				{Width: 2560, Height: 1440, MaxRate: 12728, BufSize: 25456, Profile: "high", Level: "5.1", FPS: 60},
+				{Width: 1920, Height: 1080, MaxRate: 7354, BufSize: 14708, Profile: "main", Level: "4.2", FPS: 60},
+				{Width: 1280, Height: 720, MaxRate: 4243, BufSize: 8485, Profile: "main", Level: "3.2", FPS: 60},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 30},
+			},
+		},
+		{
+			name: "1440p source - no 2160p upscale",
+			info: probe.VideoInfo{Width: 2560, Height: 1440, FPS: 60},
+			expected: []Rendition{
+				{Width: 2560, Height: 1440, MaxRate: 12728, BufSize: 25456, Profile: "high", Level: "5.1", FPS: 60},
+				{Width: 1920, Height: 1080, MaxRate: 7354, BufSize: 14708, Profile: "main", Level: "4.2", FPS: 60},
+				{Width: 1280, Height: 720, MaxRate: 4243, BufSize: 8485, Profile: "main", Level: "3.2", FPS: 60},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 30},
+			},
+		},
+		{
+			name: "1080p50 source - 360p at 25 fps",
+			info: probe.VideoInfo{Width: 1920, Height: 1080, FPS: 50},
+			expected: []Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 6713, BufSize: 13426, Profile: "main", Level: "4.2", FPS: 50},
+				{Width: 1280, Height: 720, MaxRate: 3873, BufSize: 7746, Profile: "main", Level: "3.2", FPS: 50},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 25},
+			},
+		},
+		{
+			name: "720p59.94 source - 360p at 29.97 fps",
+			info: probe.VideoInfo{Width: 1280, Height: 720, FPS: 59.94},
+			expected: []Rendition{
+				{Width: 1280, Height: 720, MaxRate: 4241, BufSize: 8481, Profile: "main", Level: "3.2", FPS: 59.94},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 29.97},
 			},
 		},
 		{
@@ -46,8 +78,8 @@ func TestBuild(t *testing.T) {
 				FPS:    25.0,
 			},
 			expected: []Rendition{
-				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
-				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", FPS: 25},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 25},
 			},
 		},
 		{
@@ -58,18 +90,30 @@ func TestBuild(t *testing.T) {
 				FPS:    30.0,
 			},
 			expected: []Rendition{
-				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 30},
 			},
 		},
 		{
-			name: "240p source - no renditions",
+			name: "240p source - low-bandwidth renditions without upscaling",
 			info: probe.VideoInfo{
 				Width:  426,
 				Height: 240,
 				FPS:    30.0,
 			},
 			expected: []Rendition{
-				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+				{Width: 416, Height: 234, MaxRate: 400, BufSize: 800, Profile: "baseline", Level: "1.3", FPS: 30},
+				{Width: 256, Height: 144, MaxRate: 200, BufSize: 400, Profile: "baseline", Level: "1.2", FPS: 30},
+			},
+		},
+		{
+			name: "tiny source - 144p floor",
+			info: probe.VideoInfo{
+				Width:  160,
+				Height: 90,
+				FPS:    15.0,
+			},
+			expected: []Rendition{
+				{Width: 256, Height: 144, MaxRate: 200, BufSize: 400, Profile: "baseline", Level: "1.2", FPS: 15},
 			},
 		},
 		{
@@ -80,7 +124,7 @@ func TestBuild(t *testing.T) {
 				FPS:    30.0,
 			},
 			expected: []Rendition{
-				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 30},
 			},
 		},
 		{
@@ -91,9 +135,21 @@ func TestBuild(t *testing.T) {
 				FPS:    30.0,
 			},
 			expected: []Rendition{
-				{Width: 1080, Height: 1920, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0"},
-				{Width: 720, Height: 1280, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
-				{Width: 360, Height: 640, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+				{Width: 720, Height: 1280, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", FPS: 30},
+				{Width: 360, Height: 640, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 30},
+			},
+		},
+		{
+			name: "HDR source - renditions labelled with its range",
+			info: probe.VideoInfo{
+				Width:         1280,
+				Height:        720,
+				FPS:           30.0,
+				ColorTransfer: "arib-std-b67",
+			},
+			expected: []Rendition{
+				{Range: config.VideoRangeHLG, Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", FPS: 30},
+				{Range: config.VideoRangeHLG, Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 30},
 			},
 		},
 		{
@@ -105,16 +161,71 @@ func TestBuild(t *testing.T) {
 				Rotation: 90,
 			},
 			expected: []Rendition{
-				{Width: 1080, Height: 1920, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0"},
-				{Width: 720, Height: 1280, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
-				{Width: 360, Height: 640, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+				{Width: 1080, Height: 1920, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0", FPS: 30},
+				{Width: 720, Height: 1280, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", FPS: 30},
+				{Width: 360, Height: 640, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 30},
+			},
+		},
+		{
+			name: "4:3 source - pillarbox-free renditions",
+			info: probe.VideoInfo{Width: 1440, Height: 1080, FPS: 25},
+			expected: []Rendition{
+				{Width: 1440, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0", FPS: 25},
+				{Width: 960, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", FPS: 25},
+				{Width: 480, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "2.1", FPS: 25},
+			},
+		},
+		{
+			name: "square source",
+			info: probe.VideoInfo{Width: 1080, Height: 1080, FPS: 30},
+			expected: []Rendition{
+				{Width: 1080, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "3.2", FPS: 30},
+				{Width: 720, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", FPS: 30},
+				{Width: 360, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "2.1", FPS: 30},
+			},
+		},
+		{
+			name: "scope source - full width rendition kept",
+			info: probe.VideoInfo{Width: 1920, Height: 804, FPS: 24},
+			expected: []Rendition{
+				{Width: 1920, Height: 804, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0", FPS: 24},
+				{Width: 1280, Height: 536, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", FPS: 24},
+				{Width: 640, Height: 268, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "2.1", FPS: 24},
+			},
+		},
+		{
+			name: "anamorphic PAL 4:3 source - square pixel renditions",
+			info: probe.VideoInfo{Width: 720, Height: 576, FPS: 25, SampleAspectRatio: probe.AspectRatio{Num: 16, Den: 15}},
+			expected: []Rendition{
+				{Width: 480, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "2.1", FPS: 25},
+			},
+		},
+		{
+			name: "anamorphic 1440x1080 HDV source",
+			info: probe.VideoInfo{Width: 1440, Height: 1080, FPS: 25, SampleAspectRatio: probe.AspectRatio{Num: 4, Den: 3}},
+			expected: []Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0", FPS: 25},
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", FPS: 25},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 25},
+			},
+		},
+		{
+			name: "DCI scope source - mod-16 rounding within 1%",
+			info: probe.VideoInfo{Width: 2048, Height: 858, FPS: 24},
+			expected: []Rendition{
+				{Width: 1920, Height: 800, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0", FPS: 24},
+				{Width: 1280, Height: 536, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1", FPS: 24},
+				{Width: 640, Height: 268, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "2.1", FPS: 24},
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := Build(tt.info)
+			result, err := Build(tt.info)
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
 
 			if len(result) != len(tt.expected) {
 				t.Fatalf("expected %d renditions, got %d", len(tt.expected), len(result))
@@ -128,3 +239,168 @@ func TestBuild(t *testing.T) {
 		})
 	}
 }
+
+func TestBuildNoLevel(t *testing.T) {
+	_, err := Build(probe.VideoInfo{Width: 3840, Height: 2160, FPS: 600})
+	want := "no H.264 level allows 3840x2160 at 600 fps: 19440000 macroblocks/s at 600 fps exceeds level 6.2 (max 16711680)"
+	if err == nil || err.Error() != want {
+		t.Errorf("Build() error = %v, want %q", err, want)
+	}
+}
+
+func TestBuildPadded(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected [][2]int
+		info     probe.VideoInfo
+	}{
+		{name: "4:3 source", info: probe.VideoInfo{Width: 1440, Height: 1080}, expected: [][2]int{{1920, 1080}, {1280, 720}, {640, 360}}},
+		{name: "scope source", info: probe.VideoInfo{Width: 1920, Height: 804}, expected: [][2]int{{1280, 720}, {640, 360}}},
+		{name: "portrait source", info: probe.VideoInfo{Width: 1080, Height: 1350}, expected: [][2]int{{1080, 1920}, {720, 1280}, {360, 640}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := BuildPadded(tt.info)
+			if err != nil {
+				t.Fatalf("BuildPadded() error = %v", err)
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("expected %d renditions, got %+v", len(tt.expected), result)
+			}
+			for i, r := range result {
+				if [2]int{r.Width, r.Height} != tt.expected[i] {
+					t.Errorf("rendition %d: %dx%d, want %dx%d", i, r.Width, r.Height, tt.expected[i][0], tt.expected[i][1])
+				}
+			}
+		})
+	}
+}
+
+func TestFitAspect(t *testing.T) {
+	l := []Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000},
+		{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000},
+	}
+
+	tests := []struct {
+		name     string
+		expected [][2]int
+		info     probe.VideoInfo
+	}{
+		{name: "matching aspect ratio", info: probe.VideoInfo{Width: 3840, Height: 2160}, expected: [][2]int{{1920, 1080}, {1280, 720}}},
+		{name: "4:3", info: probe.VideoInfo{Width: 640, Height: 480}, expected: [][2]int{{1440, 1080}, {960, 720}}},
+		// 1920/2.35 = 817.0 is rounded to 816 and 1280/2.35 = 544.7 to 544.
+		{name: "2.35:1", info: probe.VideoInfo{Width: 1880, Height: 800}, expected: [][2]int{{1920, 816}, {1280, 544}}},
+		{name: "rotated 4:3", info: probe.VideoInfo{Width: 1440, Height: 1080, Rotation: 90}, expected: [][2]int{{810, 1080}, {540, 720}}},
+		{name: "unknown dimensions", expected: [][2]int{{1920, 1080}, {1280, 720}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := FitAspect(l, tt.info)
+			for i, r := range result {
+				if [2]int{r.Width, r.Height} != tt.expected[i] {
+					t.Errorf("rendition %d: %dx%d, want %dx%d", i, r.Width, r.Height, tt.expected[i][0], tt.expected[i][1])
+				}
+				if r.MaxRate != l[i].MaxRate {
+					t.Errorf("rendition %d: MaxRate changed to %d", i, r.MaxRate)
+				}
+			}
+		})
+	}
+}
+
+func TestFrameRateFactor(t *testing.T) {
+	tests := []struct {
+		fps  float64
+		want float64
+	}{
+		{fps: 0, want: 1},
+		{fps: 24, want: 1},
+		{fps: 30, want: 1},
+		{fps: 50, want: 1.291},
+		{fps: 60, want: 1.414},
+		{fps: 120, want: 1.414},
+	}
+
+	for _, tt := range tests {
+		if got := FrameRateFactor(tt.fps); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("FrameRateFactor(%v) = %.3f, want %.3f", tt.fps, got, tt.want)
+		}
+	}
+}
+
+func TestAddLowBandwidth(t *testing.T) {
+	tests := []struct {
+		name     string
+		ladder   []Rendition
+		expected []Rendition
+	}{
+		{
+			name: "16:9 ladder",
+			ladder: []Rendition{
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+			},
+			expected: []Rendition{
+				{Width: 480, Height: 270, MaxRate: 600, BufSize: 1200, Profile: "baseline", Level: "2.1"},
+				{Width: 416, Height: 234, MaxRate: 400, BufSize: 800, Profile: "baseline", Level: "1.3"},
+				{Width: 256, Height: 144, MaxRate: 200, BufSize: 400, Profile: "baseline", Level: "1.2"},
+			},
+		},
+		{
+			name: "portrait 4:3 HEVC ladder",
+			ladder: []Rendition{
+				{Codec: config.CodecHEVC, Range: config.VideoRangePQ, Width: 360, Height: 480, MaxRate: 700, BufSize: 1400, Profile: "main", Level: "3.0"},
+			},
+			expected: []Rendition{
+				{Codec: config.CodecHEVC, Range: config.VideoRangePQ, Width: 270, Height: 360, MaxRate: 420, BufSize: 840, Profile: "baseline", Level: "1.3"},
+				{Codec: config.CodecHEVC, Range: config.VideoRangePQ, Width: 234, Height: 312, MaxRate: 280, BufSize: 560, Profile: "baseline", Level: "1.3"},
+				{Codec: config.CodecHEVC, Range: config.VideoRangePQ, Width: 144, Height: 192, MaxRate: 140, BufSize: 280, Profile: "baseline", Level: "1.2"},
+			},
+		},
+		{
+			name:   "high frame rate ladder",
+			ladder: []Rendition{{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.1", FPS: 50}},
+			expected: []Rendition{
+				{Width: 480, Height: 270, MaxRate: 600, BufSize: 1200, Profile: "baseline", Level: "2.1", FPS: 25},
+				{Width: 416, Height: 234, MaxRate: 400, BufSize: 800, Profile: "baseline", Level: "1.3", FPS: 25},
+				{Width: 256, Height: 144, MaxRate: 200, BufSize: 400, Profile: "baseline", Level: "1.2", FPS: 25},
+			},
+		},
+		{
+			name:   "already low",
+			ladder: []Rendition{{Width: 416, Height: 234, MaxRate: 400, BufSize: 800, Profile: "baseline", Level: "1.3"}},
+			expected: []Rendition{
+				{Width: 256, Height: 144, MaxRate: 200, BufSize: 400, Profile: "baseline", Level: "1.2"},
+			},
+		},
+		{name: "empty"},
+	}
+
+	t.Run("no level", func(t *testing.T) {
+		// A 24000x360 rung makes an 18000x270 rung, wider than level 6.2 allows.
+		_, err := AddLowBandwidth([]Rendition{{Width: 24000, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "6.2"}})
+		if err == nil || !strings.HasPrefix(err.Error(), "no H.264 level allows 18000x270 at 30 fps") {
+			t.Errorf("AddLowBandwidth() error = %v", err)
+		}
+	})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := AddLowBandwidth(tt.ladder)
+			if err != nil {
+				t.Fatalf("AddLowBandwidth() error = %v", err)
+			}
+			if len(result) != len(tt.ladder)+len(tt.expected) {
+				t.Fatalf("expected %d added renditions, got %+v", len(tt.expected), result)
+			}
+			for i, r := range result[len(tt.ladder):] {
+				if r != tt.expected[i] {
+					t.Errorf("rendition %d mismatch:\nexpected: %+v\ngot:      %+v", i, tt.expected[i], r)
+				}
+			}
+		})
+	}
+}
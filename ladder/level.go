@@ -0,0 +1,255 @@
+package ladder
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/farshidrezaei/mosaic/config"
+)
+
+// levelFPS stands in for an unknown frame rate when a level is chosen.
+const levelFPS = 30
+
+// h264Level holds the limits of an H.264 level (ITU-T H.264 Table A-1).
+type h264Level struct {
+	name string
+	// maxMacroblockRate is MaxMBPS in 16x16 macroblocks per second.
+	maxMacroblockRate int
+	// maxFrameSize is MaxFS in 16x16 macroblocks.
+	maxFrameSize int
+	// maxBitrate is MaxBR in kbps for the Baseline and Main profiles.
+	maxBitrate int
+	// maxCPB is MaxCPB in kbits for the Baseline and Main profiles.
+	maxCPB int
+}
+
+// h264Levels are the H.264 levels, lowest first.
+var h264Levels = []h264Level{
+	{"1.0", 1485, 99, 64, 175},
+	{"1b", 1485, 99, 128, 350},
+	{"1.1", 3000, 396, 192, 500},
+	{"1.2", 6000, 396, 384, 1000},
+	{"1.3", 11880, 396, 768, 2000},
+	{"2.0", 11880, 396, 2000, 2000},
+	{"2.1", 19800, 792, 4000, 4000},
+	{"2.2", 20250, 1620, 4000, 4000},
+	{"3.0", 40500, 1620, 10000, 10000},
+	{"3.1", 108000, 3600, 14000, 14000},
+	{"3.2", 216000, 5120, 20000, 20000},
+	{"4.0", 245760, 8192, 20000, 25000},
+	{"4.1", 245760, 8192, 50000, 62500},
+	{"4.2", 522240, 8704, 50000, 62500},
+	{"5.0", 589824, 22080, 135000, 135000},
+	{"5.1", 983040, 36864, 240000, 240000},
+	{"5.2", 2073600, 36864, 240000, 240000},
+	{"6.0", 4177920, 139264, 240000, 240000},
+	{"6.1", 8355840, 139264, 480000, 480000},
+	{"6.2", 16711680, 139264, 800000, 800000},
+}
+
+// h264ProfileBitrateFactor is the cpbBrVclFactor of each H.264 profile
+// relative to Baseline and Main (ITU-T H.264 Table A-2).
+var h264ProfileBitrateFactor = map[string]float64{
+	"baseline": 1,
+	"main":     1,
+	"high":     1.25,
+	"high10":   3,
+}
+
+// hevcProfiles are the HEVC profiles a rendition may use.
+var hevcProfiles = map[string]bool{
+	"main":   true,
+	"main10": true,
+}
+
+// hevcLevel holds the Main tier limits of an HEVC level (ITU-T H.265 Table A.8).
+type hevcLevel struct {
+	name string
+	// maxPictureSize is MaxLumaPs in luma samples.
+	maxPictureSize int
+	// maxSampleRate is MaxLumaSr in luma samples per second.
+	maxSampleRate int
+	// maxBitrate is MaxBR in kbps for the Main and Main 10 profiles.
+	maxBitrate int
+	// maxCPB is MaxCPB in kbits for the Main and Main 10 profiles.
+	maxCPB int
+}
+
+// hevcLevels are the HEVC levels, lowest first.
+var hevcLevels = []hevcLevel{
+	{"1", 36864, 552960, 128, 350},
+	{"2", 122880, 3686400, 1500, 1500},
+	{"2.1", 245760, 7372800, 3000, 3000},
+	{"3", 552960, 16588800, 6000, 6000},
+	{"3.1", 983040, 33177600, 10000, 10000},
+	{"4", 2228224, 66846720, 12000, 12000},
+	{"4.1", 2228224, 133693440, 20000, 20000},
+	{"5", 8912896, 267386880, 25000, 25000},
+	{"5.1", 8912896, 534773760, 40000, 40000},
+	{"5.2", 8912896, 1069547520, 60000, 60000},
+	{"6", 35651584, 1069547520, 60000, 60000},
+	{"6.1", 35651584, 2139095040, 120000, 120000},
+	{"6.2", 35651584, 4278190080, 240000, 240000},
+}
+
+// H264Level returns the lowest H.264 level that allows rendition r at fps
+// frames per second: its frame size and dimensions, macroblock rate, MaxRate
+// and BufSize, with the bitrate limits of the High profiles raised by their
+// cpbBrVclFactor. Level 1b is never chosen. A non-positive fps is taken as
+// 30. When no level allows r, the error lists the limits of level 6.2 that it
+// exceeds.
+func H264Level(r Rendition, fps float64) (string, error) {
+	i, err := lowestH264Level(r, fps)
+	if err != nil {
+		return "", err
+	}
+	return h264Levels[i].name, nil
+}
+
+// lowestH264Level is H264Level returning the index of the level in h264Levels.
+func lowestH264Level(r Rendition, fps float64) (int, error) {
+	if fps <= 0 {
+		fps = levelFPS
+	}
+	var exceeded []string
+	for i, l := range h264Levels {
+		if l.name == "1b" {
+			continue
+		}
+		if exceeded = l.exceeded(r, fps); len(exceeded) == 0 {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no H.264 level allows %dx%d at %g fps: %s", r.Width, r.Height, fps, strings.Join(exceeded, "; "))
+}
+
+// HEVCLevel returns the lowest HEVC Main tier level that allows rendition r
+// at fps frames per second: its picture size and dimensions, luma sample
+// rate, MaxRate and BufSize. The limits are the same for the Main and Main 10
+// profiles. A non-positive fps is taken as 30. When no level allows r, the
+// error lists the limits of level 6.2 that it exceeds.
+func HEVCLevel(r Rendition, fps float64) (string, error) {
+	if fps <= 0 {
+		fps = levelFPS
+	}
+	var exceeded []string
+	for _, l := range hevcLevels {
+		if exceeded = l.exceeded(r, fps); len(exceeded) == 0 {
+			return l.name, nil
+		}
+	}
+	return "", fmt.Errorf("no HEVC level allows %dx%d at %g fps: %s", r.Width, r.Height, fps, strings.Join(exceeded, "; "))
+}
+
+// FitLevels checks every rendition of l at the frame rate it is encoded at
+// from a source at sourceFPS (see Rendition.FrameRate); renditions without a
+// Codec are taken as codec. H.264 renditions whose Level is missing or too
+// low for that frame rate are raised to the lowest level that allows them,
+// see H264Level, and HEVC renditions need an HEVC level that allows them, see
+// HEVCLevel. AV1 renditions are not checked. Renditions no level allows are
+// reported as *RenditionError values joined with errors.Join. The input
+// ladder is not modified.
+func FitLevels(l []Rendition, codec config.Codec, sourceFPS float64) ([]Rendition, error) {
+	out := slices.Clone(l)
+	var errs []error
+	for i, r := range out {
+		c := r.Codec
+		if c == "" {
+			c = codec
+		}
+		fps := r.FrameRate(sourceFPS)
+		switch c {
+		case config.CodecAV1:
+		case config.CodecHEVC:
+			if _, err := HEVCLevel(r, fps); err != nil {
+				errs = append(errs, &RenditionError{Index: i, Rendition: r, Msg: err.Error()})
+			}
+		default:
+			need, err := lowestH264Level(r, fps)
+			if err != nil {
+				errs = append(errs, &RenditionError{Index: i, Rendition: r, Msg: err.Error()})
+				continue
+			}
+			if have, ok := lookupH264Level(r.Level); !ok || have < need {
+				out[i].Level = h264Levels[need].name
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+// lookupH264Level returns the index in h264Levels of an H.264 level written
+// as "4", "4.0" or "4.1".
+func lookupH264Level(level string) (int, bool) {
+	if !strings.Contains(level, ".") && level != "1b" {
+		level += ".0"
+	}
+	for i, l := range h264Levels {
+		if l.name == level {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// exceeded describes every limit of level l that rendition r exceeds at fps.
+// The macroblock rate is not checked for a non-positive fps, and the bitrate
+// limits of an unknown profile are those of Main.
+func (l h264Level) exceeded(r Rendition, fps float64) []string {
+	factor, ok := h264ProfileBitrateFactor[r.Profile]
+	if !ok {
+		factor = 1
+	}
+
+	var out []string
+	mbs := macroblocks(r.Width, r.Height)
+	if mbs > l.maxFrameSize {
+		out = append(out, fmt.Sprintf("frame size of %d macroblocks exceeds level %s (max %d)", mbs, l.name, l.maxFrameSize))
+	} else if side := max((r.Width+15)/16, (r.Height+15)/16); side*side > 8*l.maxFrameSize {
+		// Neither side may exceed √(8 × MaxFS) macroblocks.
+		out = append(out, fmt.Sprintf("side of %d macroblocks exceeds level %s (max %d)", side, l.name, int(math.Sqrt(float64(8*l.maxFrameSize)))))
+	}
+	if rate := float64(mbs) * fps; fps > 0 && rate > float64(l.maxMacroblockRate) {
+		out = append(out, fmt.Sprintf("%.0f macroblocks/s at %g fps exceeds level %s (max %d)", rate, fps, l.name, l.maxMacroblockRate))
+	}
+	if limit := int(float64(l.maxBitrate) * factor); r.MaxRate > limit {
+		out = append(out, fmt.Sprintf("MaxRate %d kbps exceeds level %s %s (max %d kbps)", r.MaxRate, l.name, r.Profile, limit))
+	}
+	if limit := int(float64(l.maxCPB) * factor); r.BufSize > limit {
+		out = append(out, fmt.Sprintf("BufSize %d kbit exceeds level %s %s (max %d kbit)", r.BufSize, l.name, r.Profile, limit))
+	}
+	return out
+}
+
+// exceeded describes every limit of level l that rendition r exceeds at fps.
+func (l hevcLevel) exceeded(r Rendition, fps float64) []string {
+	var out []string
+	samples := r.Width * r.Height
+	if samples > l.maxPictureSize {
+		out = append(out, fmt.Sprintf("picture size of %d samples exceeds level %s (max %d)", samples, l.name, l.maxPictureSize))
+	} else if side := max(r.Width, r.Height); side*side > 8*l.maxPictureSize {
+		// Neither side may exceed √(8 × MaxLumaPs) samples.
+		out = append(out, fmt.Sprintf("side of %d samples exceeds level %s (max %d)", side, l.name, int(math.Sqrt(float64(8*l.maxPictureSize)))))
+	}
+	if rate := float64(samples) * fps; rate > float64(l.maxSampleRate) {
+		out = append(out, fmt.Sprintf("%.0f samples/s at %g fps exceeds level %s (max %d)", rate, fps, l.name, l.maxSampleRate))
+	}
+	if r.MaxRate > l.maxBitrate {
+		out = append(out, fmt.Sprintf("MaxRate %d kbps exceeds level %s (max %d kbps)", r.MaxRate, l.name, l.maxBitrate))
+	}
+	if r.BufSize > l.maxCPB {
+		out = append(out, fmt.Sprintf("BufSize %d kbit exceeds level %s (max %d kbit)", r.BufSize, l.name, l.maxCPB))
+	}
+	return out
+}
+
+// macroblocks returns the number of 16x16 macroblocks in a width x height frame.
+func macroblocks(width, height int) int {
+	return ((width + 15) / 16) * ((height + 15) / 16)
+}
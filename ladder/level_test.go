@@ -0,0 +1,182 @@
+package ladder
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/config"
+)
+
+func TestH264Level(t *testing.T) {
+	tests := []struct {
+		name      string
+		rendition Rendition
+		fps       float64
+		want      string
+		wantErr   string
+	}{
+		{name: "1080p30", rendition: Rendition{Width: 1920, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main"}, fps: 30, want: "4.0"},
+		{name: "1080p60", rendition: Rendition{Width: 1920, Height: 1080, MaxRate: 7354, BufSize: 14708, Profile: "main"}, fps: 60, want: "4.2"},
+		{name: "1080p30 above the level 4.0 bitrate", rendition: Rendition{Width: 1920, Height: 1080, MaxRate: 24000, BufSize: 48000, Profile: "main"}, fps: 30, want: "4.1"},
+		{name: "high profile bitrate allowance", rendition: Rendition{Width: 1920, Height: 1080, MaxRate: 24000, BufSize: 30000, Profile: "high"}, fps: 30, want: "4.0"},
+		{name: "720p30", rendition: Rendition{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main"}, fps: 30, want: "3.1"},
+		{name: "720p60", rendition: Rendition{Width: 1280, Height: 720, MaxRate: 4243, BufSize: 8485, Profile: "main"}, fps: 60, want: "3.2"},
+		{name: "360p30", rendition: Rendition{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline"}, fps: 30, want: "3.0"},
+		{name: "4:3 360p25", rendition: Rendition{Width: 480, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline"}, fps: 25, want: "2.1"},
+		{name: "unknown frame rate as 30 fps", rendition: Rendition{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline"}, want: "3.0"},
+		{name: "144p skips level 1b", rendition: Rendition{Width: 176, Height: 144, MaxRate: 100, BufSize: 200, Profile: "baseline"}, fps: 15, want: "1.1"},
+		{name: "2160p60", rendition: Rendition{Width: 3840, Height: 2160, MaxRate: 22627, BufSize: 45255, Profile: "high"}, fps: 60, want: "5.2"},
+		// 4112 macroblocks fit level 3.2, but a side of 257 needs level 4.2.
+		{name: "frame too wide", rendition: Rendition{Width: 4112, Height: 256, MaxRate: 5000, BufSize: 10000, Profile: "high"}, fps: 24, want: "4.2"},
+		{
+			name:      "impossible",
+			rendition: Rendition{Width: 7680, Height: 4320, MaxRate: 900000, BufSize: 900000, Profile: "main"},
+			fps:       240,
+			wantErr: "no H.264 level allows 7680x4320 at 240 fps: " +
+				"31104000 macroblocks/s at 240 fps exceeds level 6.2 (max 16711680); " +
+				"MaxRate 900000 kbps exceeds level 6.2 main (max 800000 kbps); " +
+				"BufSize 900000 kbit exceeds level 6.2 main (max 800000 kbit)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := H264Level(tt.rendition, tt.fps)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("H264Level() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("H264Level() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("H264Level() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHEVCLevel(t *testing.T) {
+	tests := []struct {
+		name      string
+		rendition Rendition
+		fps       float64
+		want      string
+		wantErr   string
+	}{
+		{name: "1080p30", rendition: Rendition{Width: 1920, Height: 1080, MaxRate: 3640, BufSize: 7280}, fps: 30, want: "4"},
+		{name: "1080p60", rendition: Rendition{Width: 1920, Height: 1080, MaxRate: 5148, BufSize: 10296}, fps: 60, want: "4.1"},
+		{name: "1080p30 above the level 4 bitrate", rendition: Rendition{Width: 1920, Height: 1080, MaxRate: 15000, BufSize: 15000}, fps: 30, want: "4.1"},
+		{name: "720p30", rendition: Rendition{Width: 1280, Height: 720, MaxRate: 2100, BufSize: 4200}, fps: 30, want: "3.1"},
+		{name: "360p30", rendition: Rendition{Width: 640, Height: 360, MaxRate: 700, BufSize: 1400}, fps: 30, want: "2.1"},
+		{name: "2160p60", rendition: Rendition{Width: 3840, Height: 2160, MaxRate: 15839, BufSize: 31678}, fps: 60, want: "5.1"},
+		{name: "unknown frame rate as 30 fps", rendition: Rendition{Width: 3840, Height: 2160, MaxRate: 11200, BufSize: 22400}, want: "5"},
+		{
+			name:      "impossible",
+			rendition: Rendition{Width: 8192, Height: 8192, MaxRate: 250000, BufSize: 250000},
+			fps:       30,
+			wantErr: "no HEVC level allows 8192x8192 at 30 fps: " +
+				"picture size of 67108864 samples exceeds level 6.2 (max 35651584); " +
+				"MaxRate 250000 kbps exceeds level 6.2 (max 240000 kbps); " +
+				"BufSize 250000 kbit exceeds level 6.2 (max 240000 kbit)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := HEVCLevel(tt.rendition, tt.fps)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("HEVCLevel() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HEVCLevel() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("HEVCLevel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFitLevels(t *testing.T) {
+	tests := []struct {
+		name      string
+		ladder    []Rendition
+		codec     config.Codec
+		sourceFPS float64
+		want      []string
+		wantErr   []string
+	}{
+		{
+			name: "raised to the source frame rate",
+			ladder: []Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 6000, BufSize: 12000, Profile: "high", Level: "4.0"},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "main", Level: "3.0", FPS: 30},
+			},
+			sourceFPS: 60,
+			want:      []string{"4.2", "3.0"},
+		},
+		{
+			name: "higher and missing levels",
+			ladder: []Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 6000, BufSize: 12000, Profile: "high", Level: "5.1"},
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main"},
+			},
+			sourceFPS: 30,
+			want:      []string{"5.1", "3.1"},
+		},
+		{
+			name: "job codec",
+			ladder: []Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 6000, BufSize: 12000, Profile: "main", Level: "4"},
+				{Codec: config.CodecH264, Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
+			},
+			codec:     config.CodecHEVC,
+			sourceFPS: 60,
+			want:      []string{"4", "3.2"},
+		},
+		{
+			name: "no level allows",
+			ladder: []Rendition{
+				{Width: 3840, Height: 2160, MaxRate: 16000, BufSize: 32000, Profile: "high", Level: "5.1"},
+				{Codec: config.CodecHEVC, Width: 3840, Height: 2160, MaxRate: 11200, BufSize: 22400, Profile: "main"},
+				{Codec: config.CodecAV1, Width: 3840, Height: 2160, MaxRate: 9600, BufSize: 19200},
+			},
+			sourceFPS: 600,
+			wantErr: []string{
+				"rendition 0 (3840x2160): no H.264 level allows 3840x2160 at 600 fps: 19440000 macroblocks/s at 600 fps exceeds level 6.2 (max 16711680)",
+				"rendition 1 (3840x2160): no HEVC level allows 3840x2160 at 600 fps: 4976640000 samples/s at 600 fps exceeds level 6.2 (max 4278190080)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := slices.Clone(tt.ladder)
+			got, err := FitLevels(tt.ladder, tt.codec, tt.sourceFPS)
+			if !slices.Equal(tt.ladder, input) {
+				t.Error("input ladder modified")
+			}
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != strings.Join(tt.wantErr, "\n") {
+					t.Fatalf("FitLevels() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FitLevels() error = %v", err)
+			}
+			for i, r := range got {
+				if r.Level != tt.want[i] {
+					t.Errorf("rendition %d level = %q, want %q", i, r.Level, tt.want[i])
+				}
+			}
+		})
+	}
+}
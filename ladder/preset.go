@@ -0,0 +1,387 @@
+package ladder
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/farshidrezaei/mosaic/config"
+)
+
+// PresetFormat is the encoding of a ladder preset document.
+type PresetFormat string
+
+const (
+	// PresetJSON is a JSON preset document.
+	PresetJSON PresetFormat = "json"
+	// PresetYAML is a YAML preset document.
+	PresetYAML PresetFormat = "yaml"
+)
+
+// Preset is a named ladder definition that can be tuned without recompiling.
+//
+// A preset document has a name, an optional description and a non-empty list
+// of rungs. Each rung takes these fields:
+//
+//	width, height        resolution in pixels (required)
+//	bitrate              maximum bitrate in kbps (required)
+//	bufsize              VBV buffer size in kbps (default: 2 × bitrate)
+//	codec                h264, hevc or av1 (default: the job-wide codec)
+//	range                sdr, pq or hlg (default: follow the source)
+//	profile, level       H.264 profile and level, e.g. main and "3.1"
+//	bframes              B-frames between I/P frames
+//	fps                  target frame rate, at most the source rate (default: the source rate)
+//	max_fps              frame rate cap (default: the source rate)
+//	min_source_height    shorter side the source needs for the rung to be encoded
+//
+// For example, in YAML:
+//
+//	name: small
+//	rungs:
+//	  - {width: 1280, height: 720, bitrate: 3000, profile: main, level: "3.1", min_source_height: 720}
+//	  - {width: 640, height: 360, bitrate: 800, profile: baseline, level: "3.0", max_fps: 30}
+type Preset struct {
+	// Name identifies the preset in the registry.
+	Name string
+	// Description is a human-readable summary of the preset.
+	Description string
+	// Renditions are the rungs of the ladder, in document order.
+	Renditions []Rendition
+}
+
+// PresetError is a problem at a line of a preset document.
+type PresetError struct {
+	// Msg describes the problem, prefixed with the path of the field.
+	Msg string
+	// Line is the 1-based line of the offending value.
+	Line int
+}
+
+func (e *PresetError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ParsePreset decodes a preset document and validates it: the document must
+// match the preset schema and its rungs must pass Validate. Every problem is
+// returned as a *PresetError, in line order, joined with errors.Join.
+func ParsePreset(data []byte, format PresetFormat) (Preset, error) {
+	switch format {
+	case PresetJSON:
+		// JSON is a subset of YAML, but the YAML parser accepts documents
+		// that are not valid JSON.
+		if err := checkJSON(data); err != nil {
+			return Preset{}, err
+		}
+	case PresetYAML:
+	default:
+		return Preset{}, fmt.Errorf("unknown preset format %q", format)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return Preset{}, fmt.Errorf("parse preset: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return Preset{}, errors.New("preset is empty")
+	}
+
+	var d presetDecoder
+	p, lines := d.preset(doc.Content[0])
+	if err := d.err(); err != nil {
+		return Preset{}, err
+	}
+
+	// Report ladder problems at the line of the offending rung.
+	if err := Validate(p.Renditions); err != nil {
+		errs := []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+		for k, e := range errs {
+			var re *RenditionError
+			if errors.As(e, &re) {
+				errs[k] = &PresetError{Line: lines[re.Index], Msg: fmt.Sprintf("rungs[%d]: %s", re.Index, re.Msg)}
+			}
+		}
+		return Preset{}, errors.Join(errs...)
+	}
+	return p, nil
+}
+
+// LoadPreset reads and parses the preset file at path. The format is taken
+// from the extension: .json, .yaml or .yml.
+func LoadPreset(path string) (Preset, error) {
+	var format PresetFormat
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		format = PresetJSON
+	case ".yaml", ".yml":
+		format = PresetYAML
+	default:
+		return Preset{}, fmt.Errorf("preset %s: unknown extension %q", path, filepath.Ext(path))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Preset{}, fmt.Errorf("read preset %s: %w", path, err)
+	}
+	p, err := ParsePreset(data, format)
+	if err != nil {
+		return Preset{}, fmt.Errorf("preset %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// checkJSON reports a JSON syntax error at its line.
+func checkJSON(data []byte) error {
+	var v any
+	err := json.Unmarshal(data, &v)
+	var syntax *json.SyntaxError
+	if errors.As(err, &syntax) {
+		offset := min(int(syntax.Offset), len(data))
+		return &PresetError{Line: bytes.Count(data[:offset], []byte("\n")) + 1, Msg: syntax.Error()}
+	}
+	return err
+}
+
+// presetDecoder walks a preset document and collects every schema error.
+type presetDecoder struct {
+	errs []*PresetError
+}
+
+func (d *presetDecoder) fail(n *yaml.Node, format string, args ...any) {
+	d.errs = append(d.errs, &PresetError{Line: n.Line, Msg: fmt.Sprintf(format, args...)})
+}
+
+// err returns the collected errors in line order, or nil.
+func (d *presetDecoder) err() error {
+	slices.SortStableFunc(d.errs, func(a, b *PresetError) int { return a.Line - b.Line })
+	errs := make([]error, len(d.errs))
+	for i, e := range d.errs {
+		errs[i] = e
+	}
+	return errors.Join(errs...)
+}
+
+// preset decodes the document root. It also returns the line of every rung.
+func (d *presetDecoder) preset(n *yaml.Node) (Preset, []int) {
+	var p Preset
+	if n.Kind != yaml.MappingNode {
+		d.fail(n, "preset must be a mapping")
+		return p, nil
+	}
+
+	var rungNodes *yaml.Node
+	seen := make(map[string]bool)
+	for k := 0; k+1 < len(n.Content); k += 2 {
+		key, val := n.Content[k], n.Content[k+1]
+		if seen[key.Value] {
+			// The YAML parser keeps both values of a repeated key.
+			d.fail(key, "%s: duplicate field", key.Value)
+			continue
+		}
+		seen[key.Value] = true
+		switch key.Value {
+		case "name":
+			p.Name = d.str(val, "name")
+		case "description":
+			p.Description = d.str(val, "description")
+		case "rungs":
+			rungNodes = val
+		default:
+			d.fail(key, "%s: unknown field", key.Value)
+		}
+	}
+
+	switch {
+	case rungNodes == nil:
+		d.fail(n, "rungs: required")
+		return p, nil
+	case rungNodes.Kind != yaml.SequenceNode || len(rungNodes.Content) == 0:
+		d.fail(rungNodes, "rungs: must be a non-empty list")
+		return p, nil
+	}
+
+	lines := make([]int, 0, len(rungNodes.Content))
+	for i, rn := range rungNodes.Content {
+		p.Renditions = append(p.Renditions, d.rung(rn, fmt.Sprintf("rungs[%d]", i)))
+		lines = append(lines, rn.Line)
+	}
+	return p, lines
+}
+
+// rung decodes one rung at path.
+func (d *presetDecoder) rung(n *yaml.Node, path string) Rendition {
+	var r Rendition
+	if n.Kind != yaml.MappingNode {
+		d.fail(n, "%s: must be a mapping", path)
+		return r
+	}
+
+	seen := make(map[string]bool)
+	for k := 0; k+1 < len(n.Content); k += 2 {
+		key, val := n.Content[k], n.Content[k+1]
+		field := path + "." + key.Value
+		if seen[key.Value] {
+			d.fail(key, "%s: duplicate field", field)
+			continue
+		}
+		seen[key.Value] = true
+		switch key.Value {
+		case "width":
+			r.Width = d.integer(val, field)
+		case "height":
+			r.Height = d.integer(val, field)
+		case "bitrate":
+			r.MaxRate = d.integer(val, field)
+		case "bufsize":
+			r.BufSize = d.integer(val, field)
+		case "codec":
+			r.Codec = config.Codec(d.enum(val, field, "h264", "hevc", "av1"))
+		case "range":
+			r.Range = config.VideoRange(strings.ToUpper(d.enum(val, field, "sdr", "pq", "hlg")))
+		case "profile":
+			r.Profile = d.str(val, field)
+		case "level":
+			// Levels are often written unquoted, e.g. 3.1 or 4, so any scalar
+			// is kept as written.
+			if val.Kind != yaml.ScalarNode || val.Tag == "!!null" {
+				d.fail(val, "%s: must be a string or number", field)
+			}
+			r.Level = val.Value
+		case "bframes":
+			r.BFrames = d.integer(val, field)
+		case "fps":
+			r.FPS = d.number(val, field)
+		case "max_fps":
+			r.MaxFPS = d.number(val, field)
+		case "min_source_height":
+			r.MinSourceHeight = d.integer(val, field)
+		default:
+			d.fail(key, "%s: unknown field", field)
+		}
+	}
+
+	for _, f := range []string{"width", "height", "bitrate"} {
+		if !seen[f] {
+			d.fail(n, "%s.%s: required", path, f)
+		}
+	}
+	if !seen["bufsize"] {
+		r.BufSize = 2 * r.MaxRate
+	}
+	return r
+}
+
+func (d *presetDecoder) str(n *yaml.Node, field string) string {
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!str" {
+		d.fail(n, "%s: must be a string", field)
+		return ""
+	}
+	return n.Value
+}
+
+func (d *presetDecoder) integer(n *yaml.Node, field string) int {
+	var v int
+	if n.Kind != yaml.ScalarNode || n.Tag != "!!int" || n.Decode(&v) != nil {
+		d.fail(n, "%s: must be an integer", field)
+	}
+	return v
+}
+
+func (d *presetDecoder) number(n *yaml.Node, field string) float64 {
+	var v float64
+	if n.Kind != yaml.ScalarNode || (n.Tag != "!!int" && n.Tag != "!!float") || n.Decode(&v) != nil {
+		d.fail(n, "%s: must be a number", field)
+	}
+	return v
+}
+
+// enum returns the lower-cased string value of n, which must be one of values.
+func (d *presetDecoder) enum(n *yaml.Node, field string, values ...string) string {
+	v := strings.ToLower(d.str(n, field))
+	if n.Tag == "!!str" && !slices.Contains(values, v) {
+		d.fail(n, "%s: must be one of %s, got %q", field, strings.Join(values, ", "), n.Value)
+	}
+	return v
+}
+
+//go:embed presets/*.yaml
+var builtinPresets embed.FS
+
+var (
+	presetsMu sync.RWMutex
+	presets   = make(map[string]Preset)
+)
+
+func init() {
+	entries, err := builtinPresets.ReadDir("presets")
+	if err != nil {
+		panic(err)
+	}
+	for _, e := range entries {
+		data, err := builtinPresets.ReadFile("presets/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		p, err := ParsePreset(data, PresetYAML)
+		if err == nil {
+			err = RegisterPreset(p)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("ladder: built-in preset %s: %v", e.Name(), err))
+		}
+	}
+}
+
+// RegisterPreset adds p to the registry of named presets. The name must be
+// unique and the renditions must pass Validate.
+func RegisterPreset(p Preset) error {
+	if p.Name == "" {
+		return errors.New("preset has no name")
+	}
+	if err := Validate(p.Renditions); err != nil {
+		return fmt.Errorf("preset %q: %w", p.Name, err)
+	}
+
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	if _, ok := presets[p.Name]; ok {
+		return fmt.Errorf("preset %q is already registered", p.Name)
+	}
+	p.Renditions = slices.Clone(p.Renditions)
+	presets[p.Name] = p
+	return nil
+}
+
+// LookupPreset returns the registered preset with the given name. The
+// built-in presets are "apple-hls-authoring", "mobile-first" and
+// "low-bandwidth".
+func LookupPreset(name string) (Preset, bool) {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	p, ok := presets[name]
+	p.Renditions = slices.Clone(p.Renditions)
+	return p, ok
+}
+
+// PresetNames returns the names of the registered presets in sorted order.
+func PresetNames() []string {
+	presetsMu.RLock()
+	defer presetsMu.RUnlock()
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
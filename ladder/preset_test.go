@@ -0,0 +1,226 @@
+package ladder
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/config"
+)
+
+func TestParsePreset(t *testing.T) {
+	want := Preset{
+		Name:        "small",
+		Description: "two rungs",
+		Renditions: []Rendition{
+			{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 4500, Profile: "main", Level: "3.1", MinSourceHeight: 720},
+			{Codec: config.CodecHEVC, Range: config.VideoRangeSDR, Width: 640, Height: 360, MaxRate: 800, BufSize: 1600, Profile: "main", Level: "3", BFrames: 2, MaxFPS: 29.97, FPS: 25},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		format  PresetFormat
+		wantErr []string
+	}{
+		{
+			name:   "yaml",
+			format: PresetYAML,
+			data: `name: small
+description: two rungs
+rungs:
+  - width: 1280
+    height: 720
+    bitrate: 3000
+    bufsize: 4500
+    profile: main
+    level: 3.1
+    min_source_height: 720
+  - {width: 640, height: 360, bitrate: 800, codec: HEVC, range: sdr, profile: main, level: 3, bframes: 2, max_fps: 29.97, fps: 25}
+`,
+		},
+		{
+			name:   "json",
+			format: PresetJSON,
+			data: `{
+	"name": "small",
+	"description": "two rungs",
+	"rungs": [
+		{"width": 1280, "height": 720, "bitrate": 3000, "bufsize": 4500, "profile": "main", "level": "3.1", "min_source_height": 720},
+		{"width": 640, "height": 360, "bitrate": 800, "codec": "hevc", "range": "SDR", "profile": "main", "level": 3, "bframes": 2, "max_fps": 29.97, "fps": 25}
+	]
+}`,
+		},
+		{
+			name:   "json syntax error",
+			format: PresetJSON,
+			data: `{
+	"name": "small",
+	"rungs": [
+		{"width": 1280,}
+	]
+}`,
+			wantErr: []string{"line 4: invalid character '}' looking for beginning of object key string"},
+		},
+		{
+			name:   "yaml syntax error",
+			format: PresetYAML,
+			data:   "name: small\nrungs:\n  - width: 1280\n\theight: 720\n",
+			wantErr: []string{
+				"parse preset: yaml: line 3: found a tab character that violates indentation",
+			},
+		},
+		{
+			name:   "schema errors",
+			format: PresetYAML,
+			data: `name: broken
+colour: blue
+rungs:
+  - width: 1280
+    height: 720.5
+    codec: vp9
+  - width: "640"
+    height: 360
+    bitrate: 800
+    max_fps: fast
+    bitrate: 900
+name: again
+`,
+			wantErr: []string{
+				"line 2: colour: unknown field",
+				"line 4: rungs[0].bitrate: required",
+				"line 5: rungs[0].height: must be an integer",
+				"line 6: rungs[0].codec: must be one of h264, hevc, av1, got \"vp9\"",
+				"line 7: rungs[1].width: must be an integer",
+				"line 10: rungs[1].max_fps: must be a number",
+				"line 11: rungs[1].bitrate: duplicate field",
+				"line 12: name: duplicate field",
+			},
+		},
+		{
+			name:    "missing rungs",
+			format:  PresetJSON,
+			data:    `{"name": "empty", "rungs": []}`,
+			wantErr: []string{"line 1: rungs: must be a non-empty list"},
+		},
+		{
+			name:   "ladder errors",
+			format: PresetYAML,
+			data: `rungs:
+  - width: 1920
+    height: 1080
+    bitrate: 5000
+    profile: main
+    level: "3.1"
+  - width: 1280
+    height: 720
+    bitrate: 6000
+    profile: main
+    level: "3.1"
+`,
+			wantErr: []string{
+				"line 2: rungs[0]: frame size of 8160 macroblocks exceeds level 3.1 (max 3600)",
+				"line 7: rungs[1]: MaxRate 6000 kbps must be below the 5000 kbps of larger rendition 0",
+			},
+		},
+		{
+			name:    "unknown format",
+			format:  "toml",
+			wantErr: []string{`unknown preset format "toml"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParsePreset([]byte(tt.data), tt.format)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if p.Name != want.Name || p.Description != want.Description || !slices.Equal(p.Renditions, want.Renditions) {
+					t.Errorf("preset mismatch:\nexpected: %+v\ngot:      %+v", want, p)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			got := strings.Split(err.Error(), "\n")
+			if len(got) != len(tt.wantErr) {
+				t.Fatalf("expected %d errors, got %d:\n%v", len(tt.wantErr), len(got), err)
+			}
+			for i, want := range tt.wantErr {
+				if got[i] != want {
+					t.Errorf("error %d = %q, want %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadPreset(t *testing.T) {
+	dir := t.TempDir()
+	yml := filepath.Join(dir, "ladder.yml")
+	if err := os.WriteFile(yml, []byte("name: file\nrungs:\n  - {width: 640, height: 360, bitrate: 800, profile: main, level: \"3.0\"}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadPreset(yml)
+	if err != nil {
+		t.Fatalf("LoadPreset() error = %v", err)
+	}
+	if p.Name != "file" || len(p.Renditions) != 1 || p.Renditions[0].BufSize != 1600 {
+		t.Errorf("unexpected preset %+v", p)
+	}
+
+	if _, err := LoadPreset(filepath.Join(dir, "ladder.txt")); err == nil {
+		t.Error("expected an error for an unknown extension")
+	}
+	if _, err := LoadPreset(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestPresetRegistry(t *testing.T) {
+	builtin := []string{"apple-hls-authoring", "low-bandwidth", "mobile-first"}
+	for _, name := range builtin {
+		p, ok := LookupPreset(name)
+		if !ok {
+			t.Fatalf("built-in preset %q is not registered", name)
+		}
+		if err := Validate(p.Renditions); err != nil {
+			t.Errorf("built-in preset %q is invalid: %v", name, err)
+		}
+		p.Renditions[0].Width = 2
+		if again, _ := LookupPreset(name); again.Renditions[0].Width == 2 {
+			t.Errorf("LookupPreset(%q) returned the registered renditions", name)
+		}
+	}
+
+	custom := Preset{Name: "test-registry", Renditions: []Rendition{{Width: 640, Height: 360, MaxRate: 800, BufSize: 1600, Profile: "main", Level: "3.0"}}}
+	if err := RegisterPreset(custom); err != nil {
+		t.Fatalf("RegisterPreset() error = %v", err)
+	}
+	if err := RegisterPreset(custom); err == nil {
+		t.Error("expected an error for a duplicate name")
+	}
+	if err := RegisterPreset(Preset{Renditions: custom.Renditions}); err == nil {
+		t.Error("expected an error for a missing name")
+	}
+	if err := RegisterPreset(Preset{Name: "test-invalid"}); err == nil {
+		t.Error("expected an error for an invalid ladder")
+	}
+
+	names := PresetNames()
+	if !slices.IsSorted(names) || !slices.Contains(names, "test-registry") {
+		t.Errorf("PresetNames() = %v", names)
+	}
+	for _, name := range builtin {
+		if !slices.Contains(names, name) {
+			t.Errorf("PresetNames() is missing %q", name)
+		}
+	}
+}
@@ -1,7 +1,15 @@
 package ladder
 
+import "github.com/farshidrezaei/mosaic/config"
+
 // Rendition represents a single video quality level in the encoding ladder.
 type Rendition struct {
+	// Codec is the video codec for this rendition. Empty uses the job-wide codec.
+	Codec config.Codec
+	// Range is the dynamic range of this rendition. Empty follows the source:
+	// HDR when the source is HDR and the encoder preserves it, SDR otherwise.
+	// config.VideoRangeSDR tone-maps an HDR source.
+	Range config.VideoRange
 	// Profile is the H.264 profile (e.g., "main", "baseline").
 	Profile string
 	// Level is the H.264 level (e.g., "4.0", "3.1").
@@ -16,4 +24,25 @@ type Rendition struct {
 	BufSize int
 	// BFrames number of B-frames (Bidirectional frames) between I/P frames.
 	BFrames int
+	// MinSourceHeight is the shorter side the source must have for this
+	// rendition to be encoded. Zero makes it always eligible.
+	MinSourceHeight int
+	// MaxFPS caps the frame rate of this rendition. Zero keeps the source rate.
+	MaxFPS float64
+	// FPS is the target frame rate of this rendition, e.g. half the source
+	// rate for small renditions of a 60 fps source. Zero keeps the source
+	// rate. It is never above the source rate, and MaxFPS still caps it.
+	FPS float64
+}
+
+// FrameRate returns the frame rate the rendition is encoded at from a source
+// at sourceFPS: the source rate, lowered to FPS and capped at MaxFPS.
+func (r Rendition) FrameRate(sourceFPS float64) float64 {
+	fps := sourceFPS
+	for _, limit := range []float64{r.FPS, r.MaxFPS} {
+		if limit > 0 && limit < fps {
+			fps = limit
+		}
+	}
+	return fps
 }
@@ -0,0 +1,127 @@
+package ladder
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/farshidrezaei/mosaic/config"
+)
+
+// RenditionError is a problem with one rendition of a ladder.
+type RenditionError struct {
+	// Msg describes the problem.
+	Msg string
+	// Rendition is the offending rendition.
+	Rendition Rendition
+	// Index is the position of the rendition in the ladder.
+	Index int
+}
+
+func (e *RenditionError) Error() string {
+	return fmt.Sprintf("rendition %d (%dx%d): %s", e.Index, e.Rendition.Width, e.Rendition.Height, e.Msg)
+}
+
+// Validate checks a ladder before encoding and returns every problem found as
+// *RenditionError values joined with errors.Join. Every rendition needs even,
+// positive dimensions, positive MaxRate and BufSize, and an FPS, MaxFPS and
+// MinSourceHeight that are not negative. H.264 and HEVC renditions need a
+// known H.264 profile and a level whose frame size, bitrate and buffer limits
+// fit the rendition, and whose macroblock rate fits its frame rate when FPS
+// or MaxFPS sets one; see H264Level. HEVC renditions need the "main" or
+// "main10" profile and an HEVC level that allows them at that frame rate, or
+// at 30 fps; they are encoded at the lowest such level, see HEVCLevel.
+// FitLevels repeats the level checks once the source frame rate is known.
+// Within each codec and range, a larger resolution must have a higher MaxRate
+// and no resolution may appear twice.
+func Validate(l []Rendition) error {
+	if len(l) == 0 {
+		return errors.New("ladder is empty")
+	}
+
+	var errs []error
+	fail := func(i int, r Rendition, format string, args ...any) {
+		errs = append(errs, &RenditionError{Index: i, Rendition: r, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	for i, r := range l {
+		if r.Width <= 0 || r.Height <= 0 {
+			fail(i, r, "dimensions must be positive")
+		} else if r.Width%2 != 0 || r.Height%2 != 0 {
+			fail(i, r, "dimensions must be even")
+		}
+		if r.MaxRate <= 0 {
+			fail(i, r, "MaxRate must be positive")
+		}
+		if r.BufSize <= 0 {
+			fail(i, r, "BufSize must be positive")
+		}
+		if r.FPS < 0 {
+			fail(i, r, "FPS must not be negative")
+		}
+		if r.MaxFPS < 0 {
+			fail(i, r, "MaxFPS must not be negative")
+		}
+		if r.MinSourceHeight < 0 {
+			fail(i, r, "MinSourceHeight must not be negative")
+		}
+		switch r.Codec {
+		case config.CodecAV1:
+		case config.CodecHEVC:
+			validateHEVCLevel(i, r, fail)
+		default:
+			validateLevel(i, r, fail)
+		}
+
+		for j, prev := range l[:i] {
+			if prev.Codec != r.Codec || prev.Range != r.Range {
+				continue
+			}
+			area, prevArea := r.Width*r.Height, prev.Width*prev.Height
+			switch {
+			case area == prevArea:
+				fail(i, r, "duplicates the resolution of rendition %d", j)
+			case area > prevArea && r.MaxRate <= prev.MaxRate:
+				fail(i, r, "MaxRate %d kbps must exceed the %d kbps of smaller rendition %d", r.MaxRate, prev.MaxRate, j)
+			case area < prevArea && r.MaxRate >= prev.MaxRate:
+				fail(i, r, "MaxRate %d kbps must be below the %d kbps of larger rendition %d", r.MaxRate, prev.MaxRate, j)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateLevel checks the H.264 profile and level of rendition i. The
+// macroblock rate is checked at the lower of FPS and MaxFPS, when either is set.
+func validateLevel(i int, r Rendition, fail func(int, Rendition, string, ...any)) {
+	if _, ok := h264ProfileBitrateFactor[r.Profile]; !ok {
+		fail(i, r, "unknown profile %q", r.Profile)
+	}
+	level, ok := lookupH264Level(r.Level)
+	if !ok {
+		fail(i, r, "unknown level %q", r.Level)
+		return
+	}
+	for _, msg := range h264Levels[level].exceeded(r, capFPS(r)) {
+		fail(i, r, "%s", msg)
+	}
+}
+
+// validateHEVCLevel checks the HEVC profile of rendition i and that an HEVC
+// level allows it at the lower of FPS and MaxFPS.
+func validateHEVCLevel(i int, r Rendition, fail func(int, Rendition, string, ...any)) {
+	if !hevcProfiles[r.Profile] {
+		fail(i, r, "unknown HEVC profile %q", r.Profile)
+	}
+	if _, err := HEVCLevel(r, capFPS(r)); err != nil {
+		fail(i, r, "%v", err)
+	}
+}
+
+// capFPS returns the lower of the FPS and MaxFPS of r that are set, or zero.
+func capFPS(r Rendition) float64 {
+	fps := r.MaxFPS
+	if r.FPS > 0 && (fps <= 0 || r.FPS < fps) {
+		fps = r.FPS
+	}
+	return fps
+}
@@ -0,0 +1,142 @@
+package ladder
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/config"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ladder  []Rendition
+		wantErr []string
+	}{
+		{
+			name: "valid ladder",
+			ladder: []Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 6000, BufSize: 12000, Profile: "high", Level: "4.0"},
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
+				{Width: 640, Height: 360, MaxRate: 800, BufSize: 1600, Profile: "baseline", Level: "3.0"},
+				{Codec: config.CodecAV1, Width: 1920, Height: 1080, MaxRate: 3500, BufSize: 7000},
+				{Codec: config.CodecHEVC, Width: 1920, Height: 1080, MaxRate: 4000, BufSize: 8000, Profile: "main10"},
+			},
+		},
+		{
+			name:    "empty",
+			wantErr: []string{"ladder is empty"},
+		},
+		{
+			name: "odd and non-positive values",
+			ladder: []Rendition{
+				{Width: 1279, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
+				{Width: 0, Height: 360, BufSize: 0, Profile: "main", Level: "3.0"},
+			},
+			wantErr: []string{
+				"rendition 0 (1279x720): dimensions must be even",
+				"rendition 1 (0x360): dimensions must be positive",
+				"rendition 1 (0x360): MaxRate must be positive",
+				"rendition 1 (0x360): BufSize must be positive",
+			},
+		},
+		{
+			name: "negative caps",
+			ladder: []Rendition{
+				{Width: 640, Height: 360, MaxRate: 800, BufSize: 1600, Profile: "main", Level: "3.0", FPS: -30, MaxFPS: -1, MinSourceHeight: -360},
+			},
+			wantErr: []string{
+				"rendition 0 (640x360): FPS must not be negative",
+				"rendition 0 (640x360): MaxFPS must not be negative",
+				"rendition 0 (640x360): MinSourceHeight must not be negative",
+			},
+		},
+		{
+			name: "bitrates not monotonic",
+			ladder: []Rendition{
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
+				{Width: 1920, Height: 1080, MaxRate: 2500, BufSize: 5000, Profile: "main", Level: "4.0"},
+				{Width: 640, Height: 360, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.0"},
+				{Width: 1280, Height: 720, MaxRate: 2000, BufSize: 4000, Profile: "main", Level: "3.1"},
+			},
+			wantErr: []string{
+				"rendition 1 (1920x1080): MaxRate 2500 kbps must exceed the 3000 kbps of smaller rendition 0",
+				"rendition 2 (640x360): MaxRate 3000 kbps must be below the 3000 kbps of larger rendition 0",
+				"rendition 2 (640x360): MaxRate 3000 kbps must be below the 2500 kbps of larger rendition 1",
+				"rendition 3 (1280x720): duplicates the resolution of rendition 0",
+				"rendition 3 (1280x720): MaxRate 2000 kbps must exceed the 3000 kbps of smaller rendition 2",
+			},
+		},
+		{
+			name: "level limits",
+			ladder: []Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 12000, BufSize: 24000, Profile: "main", Level: "3.1"},
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "ultra", Level: "9"},
+			},
+			wantErr: []string{
+				"rendition 0 (1920x1080): frame size of 8160 macroblocks exceeds level 3.1 (max 3600)",
+				"rendition 0 (1920x1080): BufSize 24000 kbit exceeds level 3.1 main (max 14000 kbit)",
+				"rendition 1 (1280x720): unknown profile \"ultra\"",
+				"rendition 1 (1280x720): unknown level \"9\"",
+			},
+		},
+		{
+			name: "HEVC limits",
+			ladder: []Rendition{
+				{Codec: config.CodecHEVC, Width: 3840, Height: 2160, MaxRate: 300000, BufSize: 200000, Profile: "main10", MaxFPS: 60},
+				{Codec: config.CodecHEVC, Width: 1920, Height: 1080, MaxRate: 6000, BufSize: 12000, Profile: "high", Level: "4.0"},
+			},
+			wantErr: []string{
+				"rendition 0 (3840x2160): no HEVC level allows 3840x2160 at 60 fps: MaxRate 300000 kbps exceeds level 6.2 (max 240000 kbps)",
+				"rendition 1 (1920x1080): unknown HEVC profile \"high\"",
+			},
+		},
+		{
+			name: "high profile bitrate allowance",
+			ladder: []Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 24000, BufSize: 31000, Profile: "high", Level: "4.0"},
+				{Width: 1280, Height: 720, MaxRate: 21000, BufSize: 42000, Profile: "main", Level: "4.0"},
+			},
+			wantErr: []string{
+				"rendition 1 (1280x720): MaxRate 21000 kbps exceeds level 4.0 main (max 20000 kbps)",
+				"rendition 1 (1280x720): BufSize 42000 kbit exceeds level 4.0 main (max 25000 kbit)",
+			},
+		},
+		{
+			name: "frame rate limits",
+			ladder: []Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 7800, BufSize: 15600, Profile: "high", Level: "4.0", MaxFPS: 60},
+				{Width: 1280, Height: 720, MaxRate: 4500, BufSize: 9000, Profile: "high", Level: "3.1", FPS: 50, MaxFPS: 60},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "main", Level: "3.0", FPS: 60, MaxFPS: 30},
+			},
+			wantErr: []string{
+				"rendition 0 (1920x1080): 489600 macroblocks/s at 60 fps exceeds level 4.0 (max 245760)",
+				"rendition 1 (1280x720): 180000 macroblocks/s at 50 fps exceeds level 3.1 (max 108000)",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.ladder)
+			if len(tt.wantErr) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			got := strings.Split(err.Error(), "\n")
+			if len(got) != len(tt.wantErr) {
+				t.Fatalf("expected %d errors, got %d:\n%v", len(tt.wantErr), len(got), err)
+			}
+			for i, want := range tt.wantErr {
+				if got[i] != want {
+					t.Errorf("error %d = %q, want %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
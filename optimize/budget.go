@@ -0,0 +1,314 @@
+package optimize
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// DefaultBandwidths is the default throughput mix of the audience of Budget,
+// from mobile networks to fast broadband.
+var DefaultBandwidths = []Bandwidth{
+	{Kbps: 500, Share: 0.05},
+	{Kbps: 1000, Share: 0.1},
+	{Kbps: 1500, Share: 0.1},
+	{Kbps: 2500, Share: 0.15},
+	{Kbps: 4000, Share: 0.15},
+	{Kbps: 6000, Share: 0.15},
+	{Kbps: 10000, Share: 0.15},
+	{Kbps: 20000, Share: 0.15},
+}
+
+// budgetSteps are the shares of each candidate's MaxRate that Budget tries.
+var budgetSteps = []float64{1, 0.75, 0.5}
+
+const (
+	// qualityAreaExponent relates the quality ceiling of a rendition to its
+	// share of the source area: 720p of a 1080p source tops out at about 89.
+	qualityAreaExponent = 0.15
+	// qualityBPP is the bits per pixel per frame at which a rendition reaches
+	// 1 - 1/e of its quality ceiling.
+	qualityBPP = 0.015
+)
+
+// Bandwidth is a share of the audience with one network throughput.
+type Bandwidth struct {
+	// Kbps is the throughput of these viewers.
+	Kbps int
+	// Share is their share of the audience. Shares need not add up to 1.
+	Share float64
+}
+
+// QualityModel scores the expected quality of rendition r of a source, on a
+// VMAF-like scale of 0 to 100.
+type QualityModel func(r ladder.Rendition, info probe.VideoInfo) float64
+
+// EstimatedQuality is the default QualityModel of Budget. A rendition's
+// quality ceiling falls slowly with its share of the source area, and it
+// approaches the ceiling as its bits per pixel per frame grow. It is a
+// content-agnostic heuristic; a model fitted to VMAF measurements of the
+// content, such as a HullReport, ranks rungs better.
+func EstimatedQuality(r ladder.Rendition, info probe.VideoInfo) float64 {
+	area := float64(r.Width * r.Height)
+	if area <= 0 {
+		return 0
+	}
+	source := float64(info.DisplayWidth() * info.DisplayHeight())
+	if source <= 0 {
+		source = 1920 * 1080
+	}
+	fps := r.FrameRate(info.FPS)
+	if fps <= 0 {
+		fps = defaultFPS
+	}
+	bpp := float64(r.MaxRate) * 1000 / (area * fps)
+	return 100 * math.Pow(min(area/source, 1), qualityAreaExponent) * (1 - math.Exp(-bpp/qualityBPP))
+}
+
+// Budget is a Strategy that fits the ladder of another Strategy to cost
+// constraints. When the candidate rungs break a constraint, it chooses the
+// subset of them, each at 100%, 75% or 50% of its MaxRate (never below
+// DefaultMinRate), that satisfies every constraint and maximizes the expected
+// quality: each viewer in Bandwidths plays the highest rung whose MaxRate fits
+// their throughput, scored by Quality, and viewers below the lowest rung score
+// zero. Candidates that already satisfy every constraint are returned as is.
+//
+// Bitrates keep falling down the chosen ladder, and BufSize keeps its ratio to
+// MaxRate. Storage is estimated as in Estimate, for the audio tracks of the
+// source. Budget returns an error when not even the cheapest candidate fits.
+// Check tells whether a ladder with rungs added afterwards still fits.
+type Budget struct {
+	// Strategy builds the candidate rungs. Nil uses Static.
+	Strategy Strategy
+	// MaxRungs is the most rungs of the ladder. Zero is unlimited.
+	MaxRungs int
+	// MaxTotalBitrate is the most the MaxRates of the rungs add up to, in
+	// kbps. Zero is unlimited.
+	MaxTotalBitrate int
+	// MaxStoragePerHour is the most bytes an hour of the title takes to store,
+	// audio included. Zero is unlimited.
+	MaxStoragePerHour int64
+	// BitrateUtilization is the average bitrate of a rendition as a share of
+	// its MaxRate, for storage. Zero uses DefaultBitrateUtilization.
+	BitrateUtilization float64
+	// Bandwidths is the throughput mix of the audience. Empty uses
+	// DefaultBandwidths.
+	Bandwidths []Bandwidth
+	// Quality scores each candidate rung. Nil uses EstimatedQuality.
+	Quality QualityModel
+}
+
+// Optimize implements Strategy.
+func (b Budget) Optimize(
+	ctx context.Context,
+	exec CommandExecutor,
+	input string,
+	info probe.VideoInfo,
+	l []ladder.Rendition,
+) ([]ladder.Rendition, error) {
+	inner := b.Strategy
+	if inner == nil {
+		inner = Static{}
+	}
+	candidates, err := inner.Optimize(ctx, exec, input, info, l)
+	if err != nil || len(candidates) == 0 {
+		return candidates, err
+	}
+	if b.Check(candidates, info) == nil {
+		return candidates, nil
+	}
+
+	b = b.withDefaults()
+	out := b.choose(candidates, info, b.rateLimit(info))
+	if out == nil {
+		return nil, fmt.Errorf("budget: no rendition fits %s", b.describe())
+	}
+	return out, nil
+}
+
+// Check returns an error when ladder l of a source breaks a constraint of b.
+func (b Budget) Check(l []ladder.Rendition, info probe.VideoInfo) error {
+	b = b.withDefaults()
+	if b.MaxRungs > 0 && len(l) > b.MaxRungs {
+		return fmt.Errorf("budget: %d rungs exceed the maximum of %d", len(l), b.MaxRungs)
+	}
+	total := 0
+	for _, r := range l {
+		total += r.MaxRate
+	}
+	if float64(total) > b.rateLimit(info) {
+		return fmt.Errorf("budget: a total MaxRate of %d kbps exceeds %s", total, b.describe())
+	}
+	return nil
+}
+
+func (b Budget) withDefaults() Budget {
+	if b.BitrateUtilization <= 0 {
+		b.BitrateUtilization = DefaultBitrateUtilization
+	}
+	if len(b.Bandwidths) == 0 {
+		b.Bandwidths = DefaultBandwidths
+	}
+	if b.Quality == nil {
+		b.Quality = EstimatedQuality
+	}
+	b.MaxRungs = max(b.MaxRungs, 0)
+	return b
+}
+
+// rateLimit returns the most the MaxRates of the ladder may add up to in
+// kbps under the bitrate and storage constraints.
+func (b Budget) rateLimit(info probe.VideoInfo) float64 {
+	limit := math.Inf(1)
+	if b.MaxTotalBitrate > 0 {
+		limit = float64(b.MaxTotalBitrate)
+	}
+	if b.MaxStoragePerHour > 0 {
+		audio := byteRate(audioRate, 1) * 3600 * float64(audioTracks(info))
+		limit = min(limit, (float64(b.MaxStoragePerHour)-audio)/(byteRate(1, b.BitrateUtilization)*3600))
+	}
+	return limit
+}
+
+// describe lists the constraints of b, for errors.
+func (b Budget) describe() string {
+	var parts []string
+	if b.MaxTotalBitrate > 0 {
+		parts = append(parts, fmt.Sprintf("a total bitrate of %d kbps", b.MaxTotalBitrate))
+	}
+	if b.MaxStoragePerHour > 0 {
+		parts = append(parts, fmt.Sprintf("%d bytes of storage per hour", b.MaxStoragePerHour))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// budgetOption is one candidate rung at one of the budgetSteps.
+type budgetOption struct {
+	candidate int
+	rendition ladder.Rendition
+	quality   float64
+}
+
+// budgetLadder is a ladder chosen from the top down, with the options of its
+// rungs, the sum of their MaxRates and its expected quality so far.
+type budgetLadder struct {
+	options []int
+	rate    int
+	value   float64
+}
+
+// choose returns the ladder of candidates with the highest expected quality
+// whose MaxRates add up to at most limit, or nil when none does. It extends
+// ladders from the top down, keeping for every lowest option and rung count
+// only the ladders that no cheaper one beats.
+func (b Budget) choose(candidates []ladder.Rendition, info probe.VideoInfo, limit float64) []ladder.Rendition {
+	candidates = slices.Clone(candidates)
+	slices.SortStableFunc(candidates, func(x, y ladder.Rendition) int { return cmp.Compare(y.MaxRate, x.MaxRate) })
+
+	var options []budgetOption
+	for i, r := range candidates {
+		seen := make(map[int]bool)
+		for _, step := range budgetSteps {
+			rate := min(max(int(math.Round(float64(r.MaxRate)*step)), DefaultMinRate), r.MaxRate)
+			if seen[rate] {
+				continue
+			}
+			seen[rate] = true
+			o := r
+			o.MaxRate = rate
+			if r.MaxRate > 0 {
+				o.BufSize = int(math.Round(float64(r.BufSize) * float64(rate) / float64(r.MaxRate)))
+			}
+			options = append(options, budgetOption{candidate: i, rendition: o, quality: b.Quality(o, info)})
+		}
+	}
+
+	maxRungs := b.MaxRungs
+	if maxRungs == 0 {
+		maxRungs = len(candidates)
+	}
+	// fronts[o][n-1] holds the ladders of n rungs whose lowest option is o.
+	fronts := make([][][]budgetLadder, len(options))
+	var best *budgetLadder
+	for o, opt := range options {
+		fronts[o] = make([][]budgetLadder, maxRungs)
+		rate := opt.rendition.MaxRate
+		if float64(rate) > limit {
+			continue
+		}
+		fronts[o][0] = []budgetLadder{{options: []int{o}, rate: rate, value: opt.quality * b.audience(rate, math.MaxInt)}}
+		for p := range o {
+			prev := options[p]
+			if prev.candidate == opt.candidate || prev.rendition.MaxRate <= rate {
+				continue
+			}
+			gain := opt.quality * b.audience(rate, prev.rendition.MaxRate)
+			for n := 1; n < maxRungs; n++ {
+				for _, l := range fronts[p][n-1] {
+					if float64(l.rate+rate) > limit {
+						continue
+					}
+					fronts[o][n] = append(fronts[o][n], budgetLadder{
+						options: append(slices.Clip(l.options), o),
+						rate:    l.rate + rate,
+						value:   l.value + gain,
+					})
+				}
+			}
+		}
+		for n := range fronts[o] {
+			fronts[o][n] = pareto(fronts[o][n])
+			for i, l := range fronts[o][n] {
+				if best == nil || l.value > best.value || (l.value == best.value && l.rate < best.rate) {
+					best = &fronts[o][n][i]
+				}
+			}
+		}
+	}
+	if best == nil {
+		return nil
+	}
+
+	out := make([]ladder.Rendition, 0, len(best.options))
+	for _, o := range best.options {
+		out = append(out, options[o].rendition)
+	}
+	return out
+}
+
+// audience returns the share of viewers whose throughput is at least low
+// and below high kbps.
+func (b Budget) audience(low, high int) float64 {
+	var in, total float64
+	for _, bw := range b.Bandwidths {
+		total += bw.Share
+		if bw.Kbps >= low && bw.Kbps < high {
+			in += bw.Share
+		}
+	}
+	if total <= 0 {
+		return 0
+	}
+	return in / total
+}
+
+// pareto returns the ladders that no ladder at most as costly beats, by
+// rising cost.
+func pareto(ladders []budgetLadder) []budgetLadder {
+	slices.SortStableFunc(ladders, func(x, y budgetLadder) int {
+		return cmp.Or(cmp.Compare(x.rate, y.rate), cmp.Compare(y.value, x.value))
+	})
+	var out []budgetLadder
+	for _, l := range ladders {
+		if len(out) == 0 || l.value > out[len(out)-1].value {
+			out = append(out, l)
+		}
+	}
+	return out
+}
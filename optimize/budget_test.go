@@ -0,0 +1,205 @@
+package optimize
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// fixedStrategy returns its ladder, or fails with err.
+type fixedStrategy struct {
+	l   []ladder.Rendition
+	err error
+}
+
+func (s fixedStrategy) Optimize(_ context.Context, _ executor.CommandExecutor, _ string, _ probe.VideoInfo, _ []ladder.Rendition) ([]ladder.Rendition, error) {
+	return s.l, s.err
+}
+
+func TestBudget(t *testing.T) {
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000},
+		{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000},
+		{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000},
+	}
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30}
+	// Quality by height alone, so lower bitrates only reach more viewers.
+	byHeight := func(r ladder.Rendition, _ probe.VideoInfo) float64 { return float64(r.Height) / 10 }
+	quarters := []Bandwidth{{Kbps: 800, Share: 1}, {Kbps: 2000, Share: 1}, {Kbps: 4000, Share: 1}, {Kbps: 8000, Share: 1}}
+
+	tests := []struct {
+		name     string
+		budget   Budget
+		info     probe.VideoInfo
+		expected []ladder.Rendition
+		wantErr  string
+	}{
+		{name: "within budget", budget: Budget{MaxRungs: 3, MaxTotalBitrate: 9000}, info: info, expected: l},
+		{
+			// 1080p at 2500 reaches 4000 and 8000 kbps viewers (54), 360p at 500
+			// the rest (18); 360p at 750 or 720p at 1500 score the same but cost more.
+			name:   "max rungs",
+			budget: Budget{MaxRungs: 2, Bandwidths: quarters, Quality: byHeight},
+			info:   info,
+			expected: []ladder.Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 2500, BufSize: 5000},
+				{Width: 640, Height: 360, MaxRate: 500, BufSize: 1000},
+			},
+		},
+		{
+			// No 1080p option fits; 720p at 1500 (54) over 360p at 500 (9).
+			name:   "max total bitrate",
+			budget: Budget{MaxTotalBitrate: 2000, Bandwidths: quarters, Quality: byHeight},
+			info:   info,
+			expected: []ladder.Rendition{
+				{Width: 1280, Height: 720, MaxRate: 1500, BufSize: 3000},
+				{Width: 640, Height: 360, MaxRate: 500, BufSize: 1000},
+			},
+		},
+		{
+			// 2000 kbps of video at 75% for an hour plus 96 kbps of audio.
+			name:   "max storage per hour",
+			budget: Budget{MaxStoragePerHour: 718200000, Bandwidths: quarters, Quality: byHeight},
+			info:   probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, HasAudio: true},
+			expected: []ladder.Rendition{
+				{Width: 1280, Height: 720, MaxRate: 1500, BufSize: 3000},
+				{Width: 640, Height: 360, MaxRate: 500, BufSize: 1000},
+			},
+		},
+		{
+			name:   "estimated quality",
+			budget: Budget{MaxTotalBitrate: 5000},
+			info:   info,
+			expected: []ladder.Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 2500, BufSize: 5000},
+				{Width: 1280, Height: 720, MaxRate: 1500, BufSize: 3000},
+				{Width: 640, Height: 360, MaxRate: 500, BufSize: 1000},
+			},
+		},
+		{
+			name:     "estimated quality with one rung",
+			budget:   Budget{MaxRungs: 1},
+			info:     info,
+			expected: []ladder.Rendition{{Width: 1280, Height: 720, MaxRate: 1500, BufSize: 3000}},
+		},
+		{
+			name:    "nothing fits",
+			budget:  Budget{MaxTotalBitrate: 400},
+			info:    info,
+			wantErr: "budget: no rendition fits a total bitrate of 400 kbps",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.budget.Strategy = fixedStrategy{l: l}
+			result, err := tt.budget.Optimize(context.Background(), nil, "in.mp4", tt.info, l)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("Optimize() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Optimize() error = %v", err)
+			}
+			if !slices.Equal(result, tt.expected) {
+				t.Errorf("Optimize() = %+v, want %+v", result, tt.expected)
+			}
+		})
+	}
+
+	t.Run("static candidates by default", func(t *testing.T) {
+		result, err := Budget{}.Optimize(context.Background(), nil, "in.mp4", info, l)
+		if err != nil {
+			t.Fatalf("Optimize() error = %v", err)
+		}
+		if !slices.Equal(result, Apply(l)) {
+			t.Errorf("Optimize() = %+v, want Apply's %+v", result, Apply(l))
+		}
+	})
+
+	t.Run("strategy error", func(t *testing.T) {
+		b := Budget{MaxRungs: 1, Strategy: fixedStrategy{err: errors.New("boom")}}
+		if _, err := b.Optimize(context.Background(), nil, "in.mp4", info, l); err == nil {
+			t.Error("expected the candidate strategy's error")
+		}
+	})
+
+	t.Run("input unchanged", func(t *testing.T) {
+		before := slices.Clone(l)
+		if _, err := (Budget{MaxRungs: 1}).Optimize(context.Background(), nil, "in.mp4", info, l); err != nil {
+			t.Fatalf("Optimize() error = %v", err)
+		}
+		if !slices.Equal(l, before) {
+			t.Errorf("Optimize modified its input: %+v", l)
+		}
+	})
+}
+
+func TestBudgetCheck(t *testing.T) {
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000},
+		{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000},
+	}
+	twoTracks := probe.VideoInfo{AudioStreams: []probe.AudioStream{{Index: 1}, {Index: 2}}}
+	tests := []struct {
+		name    string
+		budget  Budget
+		info    probe.VideoInfo
+		wantErr string
+	}{
+		{name: "fits", budget: Budget{MaxRungs: 2, MaxTotalBitrate: 6000}},
+		{name: "unconstrained", budget: Budget{}},
+		{name: "too many rungs", budget: Budget{MaxRungs: 1}, wantErr: "budget: 2 rungs exceed the maximum of 1"},
+		{name: "total bitrate", budget: Budget{MaxTotalBitrate: 5999}, wantErr: "budget: a total MaxRate of 6000 kbps exceeds a total bitrate of 5999 kbps"},
+		// 6000 kbps of video at 75% for an hour is 2025000000 bytes, plus
+		// 43200000 bytes for each 96 kbps audio track.
+		{name: "storage", budget: Budget{MaxStoragePerHour: 2068200000}, info: probe.VideoInfo{HasAudio: true}},
+		{
+			name:    "storage with every audio track",
+			budget:  Budget{MaxStoragePerHour: 2068200000},
+			info:    twoTracks,
+			wantErr: "budget: a total MaxRate of 6000 kbps exceeds 2068200000 bytes of storage per hour",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.budget.Check(l, tt.info)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Check() error = %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEstimatedQuality(t *testing.T) {
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30}
+	q := func(w, h, rate int) float64 {
+		return EstimatedQuality(ladder.Rendition{Width: w, Height: h, MaxRate: rate}, info)
+	}
+
+	if q(1920, 1080, 5000) <= q(1920, 1080, 2500) {
+		t.Error("expected quality to rise with bitrate")
+	}
+	if q(1920, 1080, 5000) <= q(1280, 720, 5000) {
+		t.Error("expected the full-resolution rung to score higher at a high bitrate")
+	}
+	if q(640, 360, 300) <= q(1920, 1080, 300) {
+		t.Error("expected a smaller rung to score higher at a low bitrate")
+	}
+	if got := q(1920, 1080, 100000); got > 100 {
+		t.Errorf("EstimatedQuality() = %g, want at most 100", got)
+	}
+}
@@ -1,14 +1,317 @@
 package optimize
 
-func capBitrate(height int, bitrate int) int {
-	switch {
-	case height >= 1080 && bitrate > 5000:
-		return 5000
-	case height >= 720 && bitrate > 3000:
-		return 3000
-	case bitrate > 1000:
-		return 1000
-	default:
-		return bitrate
+import (
+	"errors"
+	"math"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// bitrateCaps are the highest MaxRate in kbps of a rendition by its shorter
+// side, largest first.
+var bitrateCaps = []struct{ height, maxRate int }{
+	{2160, 16000},
+	{1440, 9000},
+	{1080, 5000},
+	{720, 3000},
+	{360, 1000},
+	{270, 600},
+	{234, 400},
+	{0, 200},
+}
+
+// capBitrate caps bitrate to the limit of the largest resolution class that
+// height reaches, raised by ladder.FrameRateFactor for high frame rates.
+func capBitrate(height int, fps float64, bitrate int) int {
+	for _, c := range bitrateCaps {
+		if height >= c.height {
+			return min(bitrate, int(math.Round(float64(c.maxRate)*ladder.FrameRateFactor(fps))))
+		}
+	}
+	return bitrate
+}
+
+const (
+	// DefaultBitrateUtilization is the default average bitrate of a rendition
+	// as a share of its MaxRate.
+	DefaultBitrateUtilization = 0.75
+	// DefaultCPUSecondsPerPixel is the default CPU time of encoding one pixel
+	// with x264 at the medium preset, about 25 fps per core at 1080p.
+	DefaultCPUSecondsPerPixel = 2e-8
+)
+
+// audioRate is the bitrate in kbps of each encoded AAC audio track.
+const audioRate = 96
+
+// codecComplexity is the CPU time of each codec's software encoder relative
+// to x264 at the medium preset.
+var codecComplexity = map[config.Codec]float64{
+	config.CodecH264: 1,
+	config.CodecHEVC: 4,
+	config.CodecAV1:  3,
+}
+
+// ViewingDistribution returns the share of watch time each rendition of l is
+// played at. The shares are normalised by Estimate, so they need not add up
+// to 1.
+type ViewingDistribution func(l []ladder.Rendition) []float64
+
+// UniformViewing spreads watch time evenly over the renditions.
+func UniformViewing(l []ladder.Rendition) []float64 {
+	shares := make([]float64, len(l))
+	for i := range shares {
+		shares[i] = 1
+	}
+	return shares
+}
+
+// TopViewing spends all watch time on the rendition with the highest MaxRate,
+// an upper bound on delivery.
+func TopViewing(l []ladder.Rendition) []float64 {
+	shares := make([]float64, len(l))
+	if len(l) > 0 {
+		top := 0
+		for i, r := range l {
+			if r.MaxRate > l[top].MaxRate {
+				top = i
+			}
+		}
+		shares[top] = 1
+	}
+	return shares
+}
+
+// ViewingByHeight spends watch time by the shorter side of the renditions,
+// e.g. {1080: 0.6, 720: 0.3, 360: 0.1}. A share is split evenly between the
+// renditions of that height, such as the H.264 and HEVC copies of a rung, and
+// renditions of other heights are not watched.
+func ViewingByHeight(shares map[int]float64) ViewingDistribution {
+	return func(l []ladder.Rendition) []float64 {
+		count := make(map[int]int)
+		for _, r := range l {
+			count[min(r.Width, r.Height)]++
+		}
+		out := make([]float64, len(l))
+		for i, r := range l {
+			h := min(r.Width, r.Height)
+			out[i] = shares[h] / float64(count[h])
+		}
+		return out
+	}
+}
+
+// CostProfile describes how a ladder is encoded, stored and watched, for
+// Estimate.
+type CostProfile struct {
+	// Views is the expected number of views of the title.
+	Views float64
+	// WatchedFraction is the average share of the title watched per view.
+	// Zero watches the whole title.
+	WatchedFraction float64
+	// Viewing is the share of watch time spent on each rendition. Nil uses
+	// TopViewing.
+	Viewing ViewingDistribution
+	// BitrateUtilization is the average bitrate of a rendition as a share of
+	// its MaxRate. Zero uses DefaultBitrateUtilization.
+	BitrateUtilization float64
+	// CPUSecondsPerPixel is the CPU time of encoding one pixel with x264,
+	// measured with Calibrate. Zero uses DefaultCPUSecondsPerPixel.
+	CPUSecondsPerPixel float64
+	// Codec is the job-wide codec of renditions without one, as set with
+	// mosaic.WithCodec. Empty is H.264.
+	Codec config.Codec
+}
+
+func (p CostProfile) withDefaults() CostProfile {
+	if p.WatchedFraction <= 0 {
+		p.WatchedFraction = 1
+	}
+	if p.Viewing == nil {
+		p.Viewing = TopViewing
+	}
+	if p.BitrateUtilization <= 0 {
+		p.BitrateUtilization = DefaultBitrateUtilization
+	}
+	if p.CPUSecondsPerPixel <= 0 {
+		p.CPUSecondsPerPixel = DefaultCPUSecondsPerPixel
+	}
+	return p
+}
+
+// RenditionCost is the predicted cost of one rendition.
+type RenditionCost struct {
+	Rendition ladder.Rendition
+	// Bytes is the size of the encoded rendition.
+	Bytes int64
+	// CPUSeconds is the CPU time of encoding it.
+	CPUSeconds float64
+	// ViewShare is the share of watch time it is played at.
+	ViewShare float64
+	// EgressBytes is the video delivered at it over all views.
+	EgressBytes int64
+}
+
+// CostEstimate is the predicted cost of encoding, storing and delivering a
+// title.
+type CostEstimate struct {
+	// Renditions is the cost of each rendition, in ladder order.
+	Renditions []RenditionCost
+	// AudioBytes is the size of the encoded audio tracks.
+	AudioBytes int64
+	// StorageBytes is the size of every rendition and audio track.
+	StorageBytes int64
+	// CPUSeconds is the CPU time of encoding every rendition.
+	CPUSeconds float64
+	// EgressBytes is the video and audio delivered over all views.
+	EgressBytes int64
+}
+
+// Prices converts a CostEstimate to money, in any one currency.
+type Prices struct {
+	// StoragePerGBMonth is the price of storing 1 GB (10^9 bytes) for a month.
+	StoragePerGBMonth float64
+	// StorageMonths is how long the title is stored. Zero stores it for a month.
+	StorageMonths float64
+	// EgressPerGB is the price of delivering 1 GB.
+	EgressPerGB float64
+	// CPUPerHour is the price of one CPU-hour of encoding.
+	CPUPerHour float64
+}
+
+// Cost returns the price of storing, delivering and encoding the title.
+func (e CostEstimate) Cost(p Prices) float64 {
+	months := p.StorageMonths
+	if months <= 0 {
+		months = 1
+	}
+	return float64(e.StorageBytes)/1e9*p.StoragePerGBMonth*months +
+		float64(e.EgressBytes)/1e9*p.EgressPerGB +
+		e.CPUSeconds/3600*p.CPUPerHour
+}
+
+// Estimate predicts the output size, encoding CPU time and delivery egress
+// of encoding the source described by info with ladder l, before encoding.
+//
+// Each rendition averages profile.BitrateUtilization of its MaxRate over the
+// source duration, and every audio track is encoded at 96 kbps. CPU time
+// scales with the pixels encoded at each rendition's frame rate and the
+// relative cost of the software encoder of its codec, or of profile.Codec.
+// Each view plays the first audio track and profile.WatchedFraction of the
+// title, split over the renditions by profile.Viewing.
+//
+// Estimate returns an error when the source duration is unknown.
+func Estimate(info probe.VideoInfo, l []ladder.Rendition, profile CostProfile) (CostEstimate, error) {
+	if info.Duration <= 0 {
+		return CostEstimate{}, errors.New("estimate: source duration is unknown")
+	}
+	profile = profile.withDefaults()
+
+	shares := profile.Viewing(l)
+	total := 0.0
+	for _, s := range shares {
+		total += s
+	}
+	watched := info.Duration * profile.WatchedFraction * profile.Views
+
+	var e CostEstimate
+	for i, r := range l {
+		rate := byteRate(r.MaxRate, profile.BitrateUtilization)
+		c := RenditionCost{
+			Rendition:  r,
+			Bytes:      int64(math.Round(rate * info.Duration)),
+			CPUSeconds: pixels(r, info) * complexity(r.Codec, profile.Codec) * profile.CPUSecondsPerPixel,
+		}
+		if total > 0 {
+			c.ViewShare = shares[i] / total
+			c.EgressBytes = int64(math.Round(rate * watched * c.ViewShare))
+		}
+		e.Renditions = append(e.Renditions, c)
+		e.StorageBytes += c.Bytes
+		e.CPUSeconds += c.CPUSeconds
+		e.EgressBytes += c.EgressBytes
+	}
+
+	if tracks := audioTracks(info); tracks > 0 {
+		audio := byteRate(audioRate, 1)
+		e.AudioBytes = int64(math.Round(audio * info.Duration * float64(tracks)))
+		e.StorageBytes += e.AudioBytes
+		e.EgressBytes += int64(math.Round(audio * watched))
+	}
+	return e, nil
+}
+
+// byteRate returns the average bytes per second of a stream that averages
+// utilization of kbps.
+func byteRate(kbps int, utilization float64) float64 {
+	return float64(kbps) * 1000 / 8 * utilization
+}
+
+// audioTracks returns the number of audio tracks encoded from a source.
+func audioTracks(info probe.VideoInfo) int {
+	if len(info.AudioStreams) == 0 && info.HasAudio {
+		return 1
+	}
+	return len(info.AudioStreams)
+}
+
+// EncodeSample is a finished encode, for Calibrate: the source, the ladder
+// it was encoded with, the job-wide codec of renditions without one (empty
+// is H.264) and the resources FFmpeg used.
+type EncodeSample struct {
+	Info   probe.VideoInfo
+	Ladder []ladder.Rendition
+	Codec  config.Codec
+	Usage  Usage
+}
+
+// Calibrate returns the CPU seconds per pixel of x264 measured over past
+// encodes, for CostProfile.CPUSecondsPerPixel: their total user and system
+// time over the pixels they encoded, with pixels of other codecs weighted by
+// their relative cost. Samples with an unknown duration or no CPU time are
+// skipped. The samples should be software encodes on the hardware to be
+// estimated.
+func Calibrate(samples []EncodeSample) (float64, error) {
+	var seconds, work float64
+	for _, s := range samples {
+		cpu := s.Usage.UserTime + s.Usage.SystemTime
+		if s.Info.Duration <= 0 || cpu <= 0 {
+			continue
+		}
+		w := 0.0
+		for _, r := range s.Ladder {
+			w += pixels(r, s.Info) * complexity(r.Codec, s.Codec)
+		}
+		if w > 0 {
+			seconds += cpu
+			work += w
+		}
+	}
+	if work == 0 {
+		return 0, errors.New("calibrate: no sample with a known duration and CPU time")
+	}
+	return seconds / work, nil
+}
+
+// pixels returns the number of pixels encoded for rendition r of a source,
+// at the rendition's frame rate. An unknown frame rate is taken as 30 fps.
+func pixels(r ladder.Rendition, info probe.VideoInfo) float64 {
+	fps := r.FrameRate(info.FPS)
+	if fps <= 0 {
+		fps = defaultFPS
+	}
+	return float64(r.Width*r.Height) * fps * info.Duration
+}
+
+// complexity returns the relative CPU cost of codec, or of the job-wide codec
+// for a rendition without one.
+func complexity(codec, job config.Codec) float64 {
+	if codec == "" {
+		codec = job
+	}
+	if c, ok := codecComplexity[codec]; ok {
+		return c
 	}
+	return 1
 }
@@ -0,0 +1,234 @@
+package optimize
+
+import (
+	"math"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/config"
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+func TestEstimate(t *testing.T) {
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 4000, BufSize: 8000},
+		{Width: 1280, Height: 720, MaxRate: 2000, BufSize: 4000, Codec: config.CodecHEVC},
+	}
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, Duration: 100, HasAudio: true}
+
+	tests := []struct {
+		name        string
+		info        probe.VideoInfo
+		profile     CostProfile
+		bytes       []int64
+		shares      []float64
+		egress      []int64
+		audio       int64
+		storage     int64
+		cpu         float64
+		totalEgress int64
+	}{
+		{
+			// 75% of MaxRate for 100 s; 500 s watched, all of it at 1080p.
+			name:        "defaults",
+			info:        info,
+			profile:     CostProfile{Views: 10, WatchedFraction: 0.5},
+			bytes:       []int64{37500000, 18750000},
+			shares:      []float64{1, 0},
+			egress:      []int64{187500000, 0},
+			audio:       1200000,
+			storage:     57450000,
+			cpu:         345.6,
+			totalEgress: 193500000,
+		},
+		{
+			name:        "uniform viewing",
+			info:        info,
+			profile:     CostProfile{Views: 10, WatchedFraction: 0.5, Viewing: UniformViewing},
+			bytes:       []int64{37500000, 18750000},
+			shares:      []float64{0.5, 0.5},
+			egress:      []int64{93750000, 46875000},
+			audio:       1200000,
+			storage:     57450000,
+			cpu:         345.6,
+			totalEgress: 146625000,
+		},
+		{
+			name:        "viewing by height",
+			info:        info,
+			profile:     CostProfile{Views: 10, WatchedFraction: 0.5, Viewing: ViewingByHeight(map[int]float64{1080: 0.6, 720: 0.2})},
+			bytes:       []int64{37500000, 18750000},
+			shares:      []float64{0.75, 0.25},
+			egress:      []int64{140625000, 23437500},
+			audio:       1200000,
+			storage:     57450000,
+			cpu:         345.6,
+			totalEgress: 170062500,
+		},
+		{
+			name:        "bitrate utilization and calibrated CPU",
+			info:        info,
+			profile:     CostProfile{BitrateUtilization: 0.5, CPUSecondsPerPixel: 1e-8},
+			bytes:       []int64{25000000, 12500000},
+			shares:      []float64{1, 0},
+			egress:      []int64{0, 0},
+			audio:       1200000,
+			storage:     38700000,
+			cpu:         172.8,
+			totalEgress: 0,
+		},
+		{
+			// Two 96 kbps tracks are stored; each view plays one.
+			name:        "two audio tracks at 60 fps",
+			info:        probe.VideoInfo{Width: 1920, Height: 1080, FPS: 60, Duration: 100, HasAudio: true, AudioStreams: []probe.AudioStream{{}, {}}},
+			profile:     CostProfile{Views: 1},
+			bytes:       []int64{37500000, 18750000},
+			shares:      []float64{1, 0},
+			egress:      []int64{37500000, 0},
+			audio:       2400000,
+			storage:     58650000,
+			cpu:         691.2,
+			totalEgress: 38700000,
+		},
+		{
+			// The 1080p rendition is encoded with the job-wide AV1 encoder.
+			name:        "job codec",
+			info:        info,
+			profile:     CostProfile{Views: 1, Codec: config.CodecAV1},
+			bytes:       []int64{37500000, 18750000},
+			shares:      []float64{1, 0},
+			egress:      []int64{37500000, 0},
+			audio:       1200000,
+			storage:     57450000,
+			cpu:         594.432,
+			totalEgress: 38700000,
+		},
+		{
+			name:        "silent source",
+			info:        probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, Duration: 100},
+			profile:     CostProfile{Views: 1},
+			bytes:       []int64{37500000, 18750000},
+			shares:      []float64{1, 0},
+			egress:      []int64{37500000, 0},
+			storage:     56250000,
+			cpu:         345.6,
+			totalEgress: 37500000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Estimate(tt.info, l, tt.profile)
+			if err != nil {
+				t.Fatalf("Estimate() error = %v", err)
+			}
+			if len(e.Renditions) != len(l) {
+				t.Fatalf("expected %d renditions, got %+v", len(l), e.Renditions)
+			}
+			for i, c := range e.Renditions {
+				if c.Rendition != l[i] {
+					t.Errorf("rendition %d: %+v, want %+v", i, c.Rendition, l[i])
+				}
+				if c.Bytes != tt.bytes[i] || c.EgressBytes != tt.egress[i] || !approxEqual(c.ViewShare, tt.shares[i]) {
+					t.Errorf("rendition %d: Bytes %d EgressBytes %d ViewShare %g, want %d %d %g",
+						i, c.Bytes, c.EgressBytes, c.ViewShare, tt.bytes[i], tt.egress[i], tt.shares[i])
+				}
+			}
+			if e.AudioBytes != tt.audio || e.StorageBytes != tt.storage || e.EgressBytes != tt.totalEgress {
+				t.Errorf("AudioBytes %d StorageBytes %d EgressBytes %d, want %d %d %d",
+					e.AudioBytes, e.StorageBytes, e.EgressBytes, tt.audio, tt.storage, tt.totalEgress)
+			}
+			if !approxEqual(e.CPUSeconds, tt.cpu) {
+				t.Errorf("CPUSeconds %g, want %g", e.CPUSeconds, tt.cpu)
+			}
+		})
+	}
+
+	t.Run("unknown duration", func(t *testing.T) {
+		if _, err := Estimate(probe.VideoInfo{Width: 1920, Height: 1080}, l, CostProfile{}); err == nil {
+			t.Error("expected an error for an unknown duration")
+		}
+	})
+}
+
+func TestCalibrate(t *testing.T) {
+	l := []ladder.Rendition{{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000}}
+	hevc := []ladder.Rendition{{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Codec: config.CodecHEVC}}
+	info := probe.VideoInfo{Width: 1920, Height: 1080, FPS: 30, Duration: 100}
+
+	tests := []struct {
+		name    string
+		samples []EncodeSample
+		want    float64
+		wantErr bool
+	}{
+		{
+			// 1920 × 1080 × 30 fps × 100 s is 6.2208e9 pixels.
+			name:    "single encode",
+			samples: []EncodeSample{{Info: info, Ladder: l, Usage: executor.Usage{UserTime: 100, SystemTime: 24.416}}},
+			want:    2e-8,
+		},
+		{
+			name: "codecs weighted and unusable samples skipped",
+			samples: []EncodeSample{
+				{Info: info, Ladder: l, Usage: executor.Usage{UserTime: 62.208}},
+				{Info: info, Ladder: hevc, Usage: executor.Usage{UserTime: 248.832}},
+				{Info: probe.VideoInfo{Width: 1920, Height: 1080}, Ladder: l, Usage: executor.Usage{UserTime: 1000}},
+				{Info: info, Ladder: l},
+			},
+			want: 1e-8,
+		},
+		{
+			name:    "job codec",
+			samples: []EncodeSample{{Info: info, Ladder: l, Codec: config.CodecHEVC, Usage: executor.Usage{UserTime: 497.664}}},
+			want:    2e-8,
+		},
+		{name: "no samples", wantErr: true},
+		{name: "no usable samples", samples: []EncodeSample{{Info: info, Ladder: l}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Calibrate(tt.samples)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Calibrate() = %g, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Calibrate() error = %v", err)
+			}
+			if !approxEqual(got, tt.want) {
+				t.Errorf("Calibrate() = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCost(t *testing.T) {
+	e := CostEstimate{StorageBytes: 1e9, EgressBytes: 2e9, CPUSeconds: 3600}
+
+	tests := []struct {
+		name   string
+		prices Prices
+		want   float64
+	}{
+		{name: "a year of storage", prices: Prices{StoragePerGBMonth: 0.02, StorageMonths: 12, EgressPerGB: 0.05, CPUPerHour: 0.04}, want: 0.38},
+		{name: "one month by default", prices: Prices{StoragePerGBMonth: 0.02, EgressPerGB: 0.05, CPUPerHour: 0.04}, want: 0.16},
+		{name: "free", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.Cost(tt.prices); !approxEqual(got, tt.want) {
+				t.Errorf("Cost() = %g, want %g", got, tt.want)
+			}
+		})
+	}
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(math.Abs(a), math.Abs(b))
+}
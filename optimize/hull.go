@@ -0,0 +1,377 @@
+package optimize
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// DefaultHullSamples is the number of scenes ConvexHull encodes by default.
+const DefaultHullSamples = 3
+
+var (
+	// DefaultHullBitrates is the default bitrate grid of ConvexHull in kbps.
+	DefaultHullBitrates = []int{200, 400, 700, 1100, 1700, 2500, 3600, 5000, 7000}
+	// DefaultVMAFTargets are the default quality steps of ConvexHull.
+	DefaultVMAFTargets = []float64{95, 90, 80, 70}
+)
+
+// ConvexHull is a Strategy that picks rungs from VMAF measurements. Sampled
+// scenes are encoded at every resolution of the input ladder and every grid
+// bitrate up to that rung's MaxRate, and each encode is scored with FFmpeg's
+// libvmaf against the source at the largest rung's resolution.
+//
+// The Pareto-efficient points (no cheaper point scores higher) are reduced to
+// their upper convex hull over log bitrate. For each VMAF target, from the
+// highest, the cheapest hull point that reaches it becomes a rung; when no
+// point reaches a target the best point is used, and targets that land on an
+// already chosen point add nothing. When targets choose several points of one
+// resolution, only the one with the most VMAF per kbps is kept, so no two
+// rungs share a resolution. The rungs keep the profile and level of the input
+// rung at their resolution.
+//
+// Measuring costs two FFmpeg runs per point and scene, so ConvexHull suits VOD
+// jobs where encoding time matters less than bitrate.
+type ConvexHull struct {
+	// Report, when set, receives the measurements behind the chosen rungs.
+	Report func(HullReport)
+	// Bitrates is the bitrate grid in kbps. Empty uses DefaultHullBitrates.
+	Bitrates []int
+	// Targets are the VMAF quality steps. Empty uses DefaultVMAFTargets.
+	Targets []float64
+	// SampleDuration is the length of each sampled scene. Zero uses DefaultSampleDuration.
+	SampleDuration time.Duration
+	// Samples is the number of scenes encoded per point. Zero uses DefaultHullSamples.
+	Samples int
+}
+
+// HullReport holds every point ConvexHull measured, for auditing its choice.
+type HullReport struct {
+	// Points are the measured points, by resolution and then grid bitrate.
+	Points []HullPoint `json:"points"`
+	// Targets are the VMAF quality steps, from the highest.
+	Targets []float64 `json:"targets"`
+}
+
+// HullPoint is one resolution and bitrate of the ConvexHull grid.
+type HullPoint struct {
+	// Width and Height are the resolution of the encodes.
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Bitrate is the grid bitrate in kbps.
+	Bitrate int `json:"bitrate"`
+	// MeasuredBitrate is the mean bitrate the encodes reached, in kbps.
+	MeasuredBitrate float64 `json:"measured_bitrate"`
+	// VMAF is the mean VMAF score of the encodes.
+	VMAF float64 `json:"vmaf"`
+	// OnHull reports whether the point is on the convex hull.
+	OnHull bool `json:"on_hull"`
+	// Selected reports whether the point became a rung.
+	Selected bool `json:"selected"`
+}
+
+// Optimize implements Strategy. It passes the report to Report when set.
+func (c ConvexHull) Optimize(
+	ctx context.Context,
+	exec CommandExecutor,
+	input string,
+	info probe.VideoInfo,
+	l []ladder.Rendition,
+) ([]ladder.Rendition, error) {
+	out, report, err := c.Analyze(ctx, exec, input, info, l)
+	if err != nil {
+		return nil, err
+	}
+	if c.Report != nil {
+		c.Report(report)
+	}
+	return out, nil
+}
+
+// Analyze measures the grid and returns the chosen ladder with its report.
+// The rungs of l supply the candidate resolutions and the bitrate ceilings.
+func (c ConvexHull) Analyze(
+	ctx context.Context,
+	exec CommandExecutor,
+	input string,
+	info probe.VideoInfo,
+	l []ladder.Rendition,
+) ([]ladder.Rendition, HullReport, error) {
+	if len(l) == 0 {
+		return l, HullReport{}, nil
+	}
+	c = c.withDefaults()
+
+	ref := l[0]
+	for _, r := range l[1:] {
+		if r.Width*r.Height > ref.Width*ref.Height {
+			ref = r
+		}
+	}
+	starts := sampleOffsets(info.Duration, c.Samples, c.SampleDuration)
+
+	report := HullReport{Targets: c.Targets}
+	for _, r := range l {
+		for _, rate := range gridBitrates(c.Bitrates, r.MaxRate) {
+			point := HullPoint{Width: r.Width, Height: r.Height, Bitrate: rate}
+			for _, start := range starts {
+				measured, vmaf, err := c.measure(ctx, exec, input, r, ref, rate, start)
+				if err != nil {
+					return nil, HullReport{}, fmt.Errorf("convex hull %dx%d at %d kbps: %w", r.Width, r.Height, rate, err)
+				}
+				point.MeasuredBitrate += measured / float64(len(starts))
+				point.VMAF += vmaf / float64(len(starts))
+			}
+			report.Points = append(report.Points, point)
+		}
+	}
+
+	markHull(report.Points)
+	picks := selectHull(report.Points, c.Targets)
+
+	out := make([]ladder.Rendition, 0, len(picks))
+	for _, i := range picks {
+		report.Points[i].Selected = true
+		p := report.Points[i]
+		k := slices.IndexFunc(l, func(r ladder.Rendition) bool { return r.Width == p.Width && r.Height == p.Height })
+		r := l[k]
+		r.MaxRate = p.Bitrate
+		r.BufSize = p.Bitrate * 2
+		out = append(out, r)
+	}
+	slices.SortStableFunc(out, func(a, b ladder.Rendition) int { return b.MaxRate - a.MaxRate })
+	return out, report, nil
+}
+
+func (c ConvexHull) withDefaults() ConvexHull {
+	if len(c.Bitrates) == 0 {
+		c.Bitrates = DefaultHullBitrates
+	}
+	if len(c.Targets) == 0 {
+		c.Targets = DefaultVMAFTargets
+	}
+	c.Targets = slices.Clone(c.Targets)
+	slices.SortFunc(c.Targets, func(a, b float64) int { return cmp.Compare(b, a) })
+	if c.SampleDuration <= 0 {
+		c.SampleDuration = DefaultSampleDuration
+	}
+	if c.Samples <= 0 {
+		c.Samples = DefaultHullSamples
+	}
+	return c
+}
+
+// gridBitrates returns the sorted grid bitrates up to ceiling, or the ceiling
+// alone when the whole grid is above it.
+func gridBitrates(grid []int, ceiling int) []int {
+	var rates []int
+	for _, rate := range grid {
+		if rate > 0 && rate <= ceiling && !slices.Contains(rates, rate) {
+			rates = append(rates, rate)
+		}
+	}
+	if len(rates) == 0 {
+		return []int{ceiling}
+	}
+	slices.Sort(rates)
+	return rates
+}
+
+// markHull sets OnHull for the points on the upper convex hull, over log
+// bitrate, of the Pareto-efficient points.
+func markHull(points []HullPoint) {
+	order := make([]int, len(points))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Or(
+			cmp.Compare(points[a].MeasuredBitrate, points[b].MeasuredBitrate),
+			cmp.Compare(points[b].VMAF, points[a].VMAF),
+		)
+	})
+
+	var hull []int
+	best := math.Inf(-1)
+	for _, i := range order {
+		if points[i].VMAF <= best || points[i].MeasuredBitrate <= 0 {
+			continue
+		}
+		best = points[i].VMAF
+		// Drop points on or below the chord from their neighbour to point i.
+		for len(hull) >= 2 && !above(points[hull[len(hull)-2]], points[hull[len(hull)-1]], points[i]) {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, i)
+	}
+	for _, i := range hull {
+		points[i].OnHull = true
+	}
+}
+
+// above reports whether b lies above the chord from a to c.
+func above(a, b, c HullPoint) bool {
+	ax, bx, cx := math.Log2(a.MeasuredBitrate), math.Log2(b.MeasuredBitrate), math.Log2(c.MeasuredBitrate)
+	return (bx-ax)*(c.VMAF-a.VMAF)-(b.VMAF-a.VMAF)*(cx-ax) < 0
+}
+
+// selectHull returns the indexes of the hull points that become rungs, at most
+// one per target, from the highest target, and one per resolution: the most
+// efficient in VMAF per kbps.
+func selectHull(points []HullPoint, targets []float64) []int {
+	var hull []int
+	for i, p := range points {
+		if p.OnHull {
+			hull = append(hull, i)
+		}
+	}
+	slices.SortFunc(hull, func(a, b int) int { return cmp.Compare(points[a].MeasuredBitrate, points[b].MeasuredBitrate) })
+	if len(hull) == 0 {
+		return nil
+	}
+
+	var picks []int
+	for _, target := range targets {
+		pick := hull[len(hull)-1]
+		for _, i := range hull {
+			if points[i].VMAF >= target {
+				pick = i
+				break
+			}
+		}
+		if !slices.Contains(picks, pick) {
+			picks = append(picks, pick)
+		}
+	}
+
+	best := make(map[[2]int]int)
+	for _, i := range picks {
+		size := [2]int{points[i].Width, points[i].Height}
+		if j, ok := best[size]; !ok || efficiency(points[i]) > efficiency(points[j]) {
+			best[size] = i
+		}
+	}
+	return slices.DeleteFunc(picks, func(i int) bool {
+		return best[[2]int{points[i].Width, points[i].Height}] != i
+	})
+}
+
+// efficiency returns the VMAF score of a point per kbps it measured.
+func efficiency(p HullPoint) float64 {
+	return p.VMAF / p.MeasuredBitrate
+}
+
+// measure encodes one scene of input at rendition r's resolution and rate and
+// returns the bitrate it reached in kbps and its VMAF score against the source
+// at ref's resolution.
+func (c ConvexHull) measure(
+	ctx context.Context,
+	exec CommandExecutor,
+	input string,
+	r, ref ladder.Rendition,
+	rate int,
+	start time.Duration,
+) (float64, float64, error) {
+	encoded, err := os.CreateTemp("", "mosaic-hull-*.mkv")
+	if err != nil {
+		return 0, 0, err
+	}
+	encoded.Close()
+	defer os.Remove(encoded.Name())
+
+	kbps := strconv.Itoa(rate) + "k"
+	args := []string{
+		"-y",
+		"-loglevel", "error",
+		"-nostats",
+		"-ss", formatSeconds(start),
+		"-t", formatSeconds(c.SampleDuration),
+		"-i", input,
+		"-map", "0:v:0",
+		"-vf", fmt.Sprintf("scale=%d:%d", r.Width, r.Height),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-b:v", kbps,
+		"-maxrate", kbps,
+		"-bufsize", strconv.Itoa(rate*2) + "k",
+		"-f", "matroska",
+		"-progress", "pipe:1",
+		encoded.Name(),
+	}
+	report, _, err := exec.Execute(ctx, "ffmpeg", args...)
+	if err != nil {
+		return 0, 0, fmt.Errorf("ffmpeg trial encode failed: %w", err)
+	}
+	measured, err := progressBitrate(string(report))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	vmaf, err := c.score(ctx, exec, input, encoded.Name(), ref, start)
+	if err != nil {
+		return 0, 0, err
+	}
+	return measured, vmaf, nil
+}
+
+// score returns the mean VMAF of the encoded scene against the same scene of
+// input, with both scaled to ref's resolution.
+func (c ConvexHull) score(
+	ctx context.Context,
+	exec CommandExecutor,
+	input, encoded string,
+	ref ladder.Rendition,
+	start time.Duration,
+) (float64, error) {
+	vmafLog, err := os.CreateTemp("", "mosaic-vmaf-*.json")
+	if err != nil {
+		return 0, err
+	}
+	vmafLog.Close()
+	defer os.Remove(vmafLog.Name())
+
+	scale := fmt.Sprintf("scale=%d:%d:flags=bicubic,setpts=PTS-STARTPTS", ref.Width, ref.Height)
+	args := []string{
+		"-loglevel", "error",
+		"-nostats",
+		"-ss", formatSeconds(start),
+		"-t", formatSeconds(c.SampleDuration),
+		"-i", input,
+		"-i", encoded,
+		"-lavfi", fmt.Sprintf("[1:v]%s[dist];[0:v]%s[ref];[dist][ref]libvmaf=log_fmt=json:log_path=%s", scale, scale, vmafLog.Name()),
+		"-f", "null",
+		"-",
+	}
+	if _, _, err := exec.Execute(ctx, "ffmpeg", args...); err != nil {
+		return 0, fmt.Errorf("ffmpeg VMAF failed: %w", err)
+	}
+
+	data, err := os.ReadFile(vmafLog.Name())
+	if err != nil {
+		return 0, fmt.Errorf("read VMAF log: %w", err)
+	}
+	var result struct {
+		PooledMetrics struct {
+			VMAF struct {
+				Mean *float64 `json:"mean"`
+			} `json:"vmaf"`
+		} `json:"pooled_metrics"`
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return 0, fmt.Errorf("parse VMAF log: %w", err)
+	}
+	if result.PooledMetrics.VMAF.Mean == nil {
+		return 0, errors.New("VMAF log has no pooled mean")
+	}
+	return *result.PooledMetrics.VMAF.Mean, nil
+}
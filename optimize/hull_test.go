@@ -0,0 +1,214 @@
+package optimize
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// vmafExecutor fakes the FFmpeg runs of ConvexHull. Trial encodes reach their
+// grid bitrate over four seconds, and VMAF runs write the score of the last
+// encode, keyed by "W:H@kbps", to the libvmaf log.
+type vmafExecutor struct {
+	scores map[string]float64
+	last   string
+	calls  int
+}
+
+func (e *vmafExecutor) Execute(ctx context.Context, name string, args ...string) ([]byte, *executor.Usage, error) {
+	return e.ExecuteWithProgress(ctx, nil, name, args...)
+}
+
+func (e *vmafExecutor) ExecuteWithProgress(_ context.Context, _ chan<- string, _ string, args ...string) ([]byte, *executor.Usage, error) {
+	e.calls++
+	if i := slices.Index(args, "-b:v"); i >= 0 {
+		rate := strings.TrimSuffix(args[i+1], "k")
+		e.last = strings.TrimPrefix(args[slices.Index(args, "-vf")+1], "scale=") + "@" + rate
+		kbps, _ := strconv.Atoi(rate)
+		return progressReport(strconv.Itoa(kbps * 500)), nil, nil
+	}
+
+	score, ok := e.scores[e.last]
+	if !ok {
+		return nil, nil, fmt.Errorf("no score for %s", e.last)
+	}
+	lavfi := args[slices.Index(args, "-lavfi")+1]
+	_, path, _ := strings.Cut(lavfi, "log_path=")
+	return nil, nil, os.WriteFile(path, fmt.Appendf(nil, `{"pooled_metrics":{"vmaf":{"mean":%g}}}`, score), 0o644)
+}
+
+var hullScores = map[string]float64{
+	"640:360@400": 60, "640:360@700": 70,
+	"1280:720@400": 50, "1280:720@700": 65, "1280:720@1100": 82, "1280:720@1700": 88, "1280:720@2500": 90,
+	"1920:1080@400": 40, "1920:1080@700": 55, "1920:1080@1100": 74, "1920:1080@1700": 85, "1920:1080@2500": 92, "1920:1080@3600": 95.5,
+}
+
+func TestConvexHull(t *testing.T) {
+	built := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0"},
+		{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
+		{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+	}
+	grid := []int{400, 700, 1100, 1700, 2500, 3600}
+
+	tests := []struct {
+		name     string
+		targets  []float64
+		expected []ladder.Rendition
+	}{
+		{
+			// 1080p@3600 and 1080p@2500 reach 95 and 90; the second scores
+			// more per kbps and is the only 1080p rung.
+			name: "default targets",
+			expected: []ladder.Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 2500, BufSize: 5000, Profile: "main", Level: "4.0"},
+				{Width: 1280, Height: 720, MaxRate: 1100, BufSize: 2200, Profile: "main", Level: "3.1"},
+			},
+		},
+		{
+			name:    "lower quality step",
+			targets: []float64{60, 80, 90, 95},
+			expected: []ladder.Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 2500, BufSize: 5000, Profile: "main", Level: "4.0"},
+				{Width: 1280, Height: 720, MaxRate: 1100, BufSize: 2200, Profile: "main", Level: "3.1"},
+				{Width: 640, Height: 360, MaxRate: 400, BufSize: 800, Profile: "baseline", Level: "3.0"},
+			},
+		},
+		{
+			name:    "unreachable target uses the best point",
+			targets: []float64{99},
+			expected: []ladder.Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 3600, BufSize: 7200, Profile: "main", Level: "4.0"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exec := &vmafExecutor{scores: hullScores}
+			var report HullReport
+			c := ConvexHull{Bitrates: grid, Targets: tt.targets, Samples: 2, Report: func(r HullReport) { report = r }}
+
+			result, err := c.Optimize(context.Background(), exec, "in.mp4", probe.VideoInfo{}, built)
+			if err != nil {
+				t.Fatalf("Optimize() error = %v", err)
+			}
+			if !slices.Equal(result, tt.expected) {
+				t.Errorf("ladder mismatch:\nexpected: %+v\ngot:      %+v", tt.expected, result)
+			}
+
+			// 13 grid points, one scene each for a source of unknown length,
+			// with an encode and a VMAF run per point.
+			if exec.calls != 26 {
+				t.Errorf("expected 26 FFmpeg runs, got %d", exec.calls)
+			}
+			if len(report.Points) != 13 {
+				t.Fatalf("expected 13 points in the report, got %d", len(report.Points))
+			}
+			var hull, selected []string
+			for _, p := range report.Points {
+				key := fmt.Sprintf("%dp@%d", p.Height, p.Bitrate)
+				if p.OnHull {
+					hull = append(hull, key)
+				}
+				if p.Selected {
+					selected = append(selected, key)
+				}
+				if p.MeasuredBitrate != float64(p.Bitrate) {
+					t.Errorf("%s: measured %v kbps", key, p.MeasuredBitrate)
+				}
+			}
+			wantHull := []string{"1080p@2500", "1080p@3600", "720p@1100", "720p@1700", "360p@400"}
+			if !slices.Equal(hull, wantHull) {
+				t.Errorf("hull = %v, want %v", hull, wantHull)
+			}
+			if len(selected) != len(tt.expected) {
+				t.Errorf("selected = %v, want %d points", selected, len(tt.expected))
+			}
+			if !slices.IsSortedFunc(report.Targets, func(a, b float64) int { return cmp.Compare(b, a) }) {
+				t.Errorf("targets not sorted from the highest: %v", report.Targets)
+			}
+		})
+	}
+}
+
+func TestConvexHullCommands(t *testing.T) {
+	mock := executor.NewMockExecutor()
+	mock.Responses["ffmpeg"] = executor.MockResponse{Output: progressReport("100000")}
+
+	l := []ladder.Rendition{{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000}}
+	_, err := ConvexHull{Bitrates: []int{5000}}.Optimize(context.Background(), mock, "in.mp4", probe.VideoInfo{}, l)
+	if err == nil || !strings.Contains(err.Error(), "VMAF log") {
+		t.Fatalf("expected a VMAF log error, got %v", err)
+	}
+
+	// The grid is above the rung, so its MaxRate is the only point.
+	encode, vmaf := mock.CallLog[0].Args, mock.CallLog[1].Args
+	for _, pair := range [][2]string{{"-b:v", "3000k"}, {"-maxrate", "3000k"}, {"-bufsize", "6000k"}, {"-vf", "scale=1280:720"}} {
+		if i := slices.Index(encode, pair[0]); i < 0 || encode[i+1] != pair[1] {
+			t.Errorf("encode: expected %s %s in %v", pair[0], pair[1], encode)
+		}
+	}
+	lavfi := vmaf[slices.Index(vmaf, "-lavfi")+1]
+	if !strings.Contains(lavfi, "[dist][ref]libvmaf=log_fmt=json") || !strings.Contains(lavfi, "scale=1280:720:flags=bicubic") {
+		t.Errorf("unexpected VMAF filter %q", lavfi)
+	}
+	if slices.Index(vmaf, encode[len(encode)-1]) < 0 {
+		t.Errorf("VMAF run does not read the trial encode: %v", vmaf)
+	}
+}
+
+func TestGridBitrates(t *testing.T) {
+	tests := []struct {
+		grid     []int
+		ceiling  int
+		expected []int
+	}{
+		{grid: []int{1100, 400, 700, 400}, ceiling: 1000, expected: []int{400, 700}},
+		{grid: []int{2000, 3000}, ceiling: 1000, expected: []int{1000}},
+		{grid: []int{0, -5, 800}, ceiling: 1000, expected: []int{800}},
+	}
+
+	for _, tt := range tests {
+		if got := gridBitrates(tt.grid, tt.ceiling); !slices.Equal(got, tt.expected) {
+			t.Errorf("gridBitrates(%v, %d) = %v, want %v", tt.grid, tt.ceiling, got, tt.expected)
+		}
+	}
+}
+
+func TestSelectHull(t *testing.T) {
+	points := []HullPoint{
+		{Width: 640, Height: 360, MeasuredBitrate: 400, VMAF: 60, OnHull: true},
+		{Width: 1280, Height: 720, MeasuredBitrate: 1100, VMAF: 82, OnHull: true},
+		{Width: 1280, Height: 720, MeasuredBitrate: 1700, VMAF: 88, OnHull: true},
+		{Width: 1920, Height: 1080, MeasuredBitrate: 2500, VMAF: 92, OnHull: true},
+		{Width: 1920, Height: 1080, MeasuredBitrate: 3600, VMAF: 95.5, OnHull: true},
+		{Width: 1920, Height: 1080, MeasuredBitrate: 5000, VMAF: 96},
+	}
+
+	tests := []struct {
+		name    string
+		targets []float64
+		want    []int
+	}{
+		{name: "one point per target", targets: []float64{95, 85, 60}, want: []int{4, 2, 0}},
+		{name: "one point per resolution", targets: []float64{95, 90, 88, 80}, want: []int{3, 1}},
+		{name: "repeated point", targets: []float64{99, 96}, want: []int{4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := selectHull(points, tt.targets); !slices.Equal(got, tt.want) {
+				t.Errorf("selectHull() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
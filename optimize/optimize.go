@@ -3,13 +3,13 @@ package optimize
 import "github.com/farshidrezaei/mosaic/ladder"
 
 // Apply performs bitrate optimization and rendition trimming on the encoding ladder.
-// It caps bitrates based on resolution and removes redundant renditions that are
+// It caps bitrates based on resolution and frame rate and removes redundant renditions that are
 // too close in resolution to each other.
 func Apply(in []ladder.Rendition) []ladder.Rendition {
 	var out []ladder.Rendition
 
 	for _, r := range in {
-		r.MaxRate = capBitrate(r.Height, r.MaxRate)
+		r.MaxRate = capBitrate(min(r.Width, r.Height), r.FPS, r.MaxRate)
 		r.BufSize = r.MaxRate * 2
 		out = append(out, r)
 	}
@@ -17,8 +17,14 @@ func Apply(in []ladder.Rendition) []ladder.Rendition {
 	return trim(out)
 }
 
+// lowBandwidthHeight is the shorter side below which a rendition is one of
+// the low-bandwidth rungs of ladder.Build.
+const lowBandwidthHeight = 360
+
 // trim removes renditions that are too close in resolution to the previous one.
-// It uses a 0.7 height ratio threshold to determine if a rendition is redundant.
+// It uses a 0.8 height ratio threshold to determine if a rendition is redundant,
+// so 1080p is kept below 1440p. Low-bandwidth rungs (270p, 234p, 144p) are
+// spaced by throughput rather than resolution and are always kept.
 func trim(in []ladder.Rendition) []ladder.Rendition {
 	if len(in) <= 1 {
 		return in
@@ -31,7 +37,7 @@ func trim(in []ladder.Rendition) []ladder.Rendition {
 		prev := res[len(res)-1]
 		curr := in[i]
 
-		if float64(curr.Height)/float64(prev.Height) < 0.7 {
+		if min(curr.Width, curr.Height) < lowBandwidthHeight || float64(curr.Height)/float64(prev.Height) < 0.8 {
 			res = append(res, curr)
 		}
 	}
@@ -10,26 +10,35 @@ func TestCapBitrate(t *testing.T) {
 	tests := []struct {
 		name     string
 		height   int
+		fps      float64
 		bitrate  int
 		expected int
 	}{
-		{"1080p - cap at 5000", 1080, 6000, 5000},
-		{"1080p - under cap triggers 720 rule", 1080, 4500, 3000},     // cascades to case height >= 720
-		{"1080p - at 5000 not > triggers 720 rule", 1080, 5000, 3000}, // 5000 is not > 5000, cascades
-		{"720p - cap at 3000", 720, 4000, 3000},
-		{"720p - under cap triggers 1000 rule", 720, 2500, 1000},     // cascades to case bitrate > 1000
-		{"720p - at 3000 not > triggers 1000 rule", 720, 3000, 1000}, // 3000 is not > 3000, cascades
-		{"720p - under 1000", 720, 800, 800},
-		{"360p - cap at 1000", 360, 1500, 1000},
-		{"360p - under cap", 360, 800, 800},
-		{"360p - at 1000 passes through", 360, 1000, 1000}, // 1000 is not > 1000, returns as-is
-		{"240p - cap at 1000", 240, 1200, 1000},
-		{"240p - under cap", 240, 500, 500},
+		{"2160p - cap at 16000", 2160, 30, 20000, 16000},
+		{"2160p - under cap", 2160, 30, 12000, 12000},
+		{"1440p - cap at 9000", 1440, 30, 16000, 9000},
+		{"1080p - cap at 5000", 1080, 30, 6000, 5000},
+		{"1080p - under cap", 1080, 30, 4500, 4500},
+		{"1080p - at cap", 1080, 30, 5000, 5000},
+		{"720p - cap at 3000", 720, 30, 4000, 3000},
+		{"720p - under cap", 720, 30, 2500, 2500},
+		{"720p - under 1000", 720, 30, 800, 800},
+		{"scope 1080p - 720p class", 804, 30, 5200, 3000},
+		{"360p - cap at 1000", 360, 30, 1500, 1000},
+		{"360p - under cap", 360, 30, 800, 800},
+		{"360p - at 1000 passes through", 360, 30, 1000, 1000},
+		{"270p - cap at 600", 270, 30, 1000, 600},
+		{"240p - 234p class", 240, 30, 500, 400},
+		{"144p - cap at 200", 144, 30, 400, 200},
+		{"unknown frame rate", 1080, 0, 6000, 5000},
+		{"1080p60 - cap raised to 7071", 1080, 60, 8000, 7071},
+		{"720p50 - cap raised to 3873", 720, 50, 4000, 3873},
+		{"2160p120 - raise capped at 60 fps", 2160, 120, 30000, 22627},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := capBitrate(tt.height, tt.bitrate)
+			result := capBitrate(tt.height, tt.fps, tt.bitrate)
 			if result != tt.expected {
 				t.Errorf("expected %d, got %d", tt.expected, result)
 			}
@@ -44,16 +53,16 @@ func TestApply(t *testing.T) {
 		expected []ladder.Rendition
 	}{
 		{
-			name: "standard 1080p ladder - caps based on cascading rules",
+			name: "standard 1080p ladder - caps by resolution class",
 			input: []ladder.Rendition{
 				{Width: 1920, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0"},
 				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
 				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
 			},
 			expected: []ladder.Rendition{
-				{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},  // 5200 > 5000 at 1080p
-				{Width: 1280, Height: 720, MaxRate: 1000, BufSize: 2000, Profile: "main", Level: "3.1"},    // 3000 at 720p triggers > 1000 case
-				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"}, // 1000 at 360p triggers > 1000 case
+				{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"}, // 5200 > 5000 at 1080p
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
 			},
 		},
 		{
@@ -69,6 +78,19 @@ func TestApply(t *testing.T) {
 				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
 			},
 		},
+		{
+			name: "high frame rate ladder - caps raised for 60 fps rungs",
+			input: []ladder.Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 7354, BufSize: 14708, Profile: "main", Level: "4.2", FPS: 60},
+				{Width: 1280, Height: 720, MaxRate: 4243, BufSize: 8485, Profile: "main", Level: "3.2", FPS: 60},
+				{Width: 640, Height: 360, MaxRate: 1500, BufSize: 3000, Profile: "baseline", Level: "3.0", FPS: 30},
+			},
+			expected: []ladder.Rendition{
+				{Width: 1920, Height: 1080, MaxRate: 7071, BufSize: 14142, Profile: "main", Level: "4.2", FPS: 60},
+				{Width: 1280, Height: 720, MaxRate: 4243, BufSize: 8486, Profile: "main", Level: "3.2", FPS: 60},
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0", FPS: 30},
+			},
+		},
 		{
 			name: "close resolutions - trim middle",
 			input: []ladder.Rendition{
@@ -77,7 +99,7 @@ func TestApply(t *testing.T) {
 				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
 			},
 			expected: []ladder.Rendition{
-				{Width: 1920, Height: 1080, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "4.0"}, // 5000 at 1080p but < 5000 threshold triggers 720 rule
+				{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
 				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
 			},
 		},
@@ -96,12 +118,12 @@ func TestApply(t *testing.T) {
 			expected: []ladder.Rendition{},
 		},
 		{
-			name: "very small resolution - cap at 1000",
+			name: "very small resolution - cap at 200",
 			input: []ladder.Rendition{
 				{Width: 160, Height: 90, MaxRate: 2000, BufSize: 4000, Profile: "baseline", Level: "1.0"},
 			},
 			expected: []ladder.Rendition{
-				{Width: 160, Height: 90, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "1.0"},
+				{Width: 160, Height: 90, MaxRate: 200, BufSize: 400, Profile: "baseline", Level: "1.0"},
 			},
 		},
 		{
@@ -139,11 +161,11 @@ func TestTrim(t *testing.T) {
 		expected []ladder.Rendition
 	}{
 		{
-			name: "keeps renditions with ratio >= 0.7",
+			name: "keeps renditions with ratio < 0.8",
 			input: []ladder.Rendition{
 				{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
-				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},    // 720/1080 = 0.666 < 0.7, keep
-				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"}, // 360/720 = 0.5 < 0.7, keep
+				{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},    // 720/1080 = 0.666 < 0.8, keep
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"}, // 360/720 = 0.5 < 0.8, keep
 			},
 			expected: []ladder.Rendition{
 				{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
@@ -155,14 +177,38 @@ func TestTrim(t *testing.T) {
 			name: "trims very close resolutions",
 			input: []ladder.Rendition{
 				{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
-				{Width: 1600, Height: 900, MaxRate: 4000, BufSize: 8000, Profile: "main", Level: "3.2"},    // 900/1080 = 0.833 >= 0.7, skip
-				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"}, // 360/1080 = 0.333 < 0.7, keep
+				{Width: 1600, Height: 900, MaxRate: 4000, BufSize: 8000, Profile: "main", Level: "3.2"},    // 900/1080 = 0.833 >= 0.8, skip
+				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"}, // 360/1080 = 0.333 < 0.8, keep
 			},
 			expected: []ladder.Rendition{
 				{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
 				{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
 			},
 		},
+		{
+			name: "keeps 1080p below 1440p",
+			input: []ladder.Rendition{
+				{Width: 2560, Height: 1440, MaxRate: 9000, BufSize: 18000, Profile: "high", Level: "5.0"},
+				{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"}, // 1080/1440 = 0.75 < 0.8, keep
+			},
+			expected: []ladder.Rendition{
+				{Width: 2560, Height: 1440, MaxRate: 9000, BufSize: 18000, Profile: "high", Level: "5.0"},
+				{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, Profile: "main", Level: "4.0"},
+			},
+		},
+		{
+			name: "keeps low-bandwidth rungs",
+			input: []ladder.Rendition{
+				{Width: 480, Height: 270, MaxRate: 600, BufSize: 1200, Profile: "baseline", Level: "2.1"},
+				{Width: 416, Height: 234, MaxRate: 400, BufSize: 800, Profile: "baseline", Level: "2.1"}, // 234/270 = 0.867, low-bandwidth, keep
+				{Width: 256, Height: 144, MaxRate: 200, BufSize: 400, Profile: "baseline", Level: "1.2"},
+			},
+			expected: []ladder.Rendition{
+				{Width: 480, Height: 270, MaxRate: 600, BufSize: 1200, Profile: "baseline", Level: "2.1"},
+				{Width: 416, Height: 234, MaxRate: 400, BufSize: 800, Profile: "baseline", Level: "2.1"},
+				{Width: 256, Height: 144, MaxRate: 200, BufSize: 400, Profile: "baseline", Level: "1.2"},
+			},
+		},
 		{
 			name: "single rendition - no changes",
 			input: []ladder.Rendition{
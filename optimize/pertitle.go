@@ -0,0 +1,196 @@
+package optimize
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+const (
+	// DefaultSamples is the number of scenes PerTitle encodes by default.
+	DefaultSamples = 5
+	// DefaultSampleDuration is the default length of each sampled scene.
+	DefaultSampleDuration = 4 * time.Second
+	// DefaultCRF is the default x264 CRF of the trial encodes.
+	DefaultCRF = 23
+	// DefaultMinRate is the default lowest MaxRate of a per-title rung in kbps.
+	DefaultMinRate = 150
+)
+
+// areaExponent relates bitrate to picture area: at equal quality, a rung
+// with half the pixels needs about 2^-0.75 of the bitrate.
+const areaExponent = 0.75
+
+// PerTitle is a content-adaptive Strategy. It encodes short scenes sampled
+// across the source with x264 at a constant quality (CRF), at the resolution
+// of the largest rung, and takes the bitrate of the most complex scene as that
+// rung's MaxRate. Smaller rungs are scaled from it by picture area.
+//
+// Every rung stays between MinRate and the MaxRate it was built with, so a
+// static slideshow gets far less than a sports match but never more than the
+// built-in ladder. Rungs too close in resolution are trimmed as in Apply.
+// PerTitle is meant for VOD: it reads the source once per sample before
+// encoding starts.
+type PerTitle struct {
+	// SampleDuration is the length of each sampled scene. Zero uses DefaultSampleDuration.
+	SampleDuration time.Duration
+	// Samples is the number of scenes encoded. Zero uses DefaultSamples.
+	// Sources shorter than Samples × SampleDuration get fewer samples.
+	Samples int
+	// CRF is the x264 constant rate factor of the trial encodes; lower values
+	// target higher quality and yield higher bitrates. Zero uses DefaultCRF.
+	CRF int
+	// MinRate is the lowest MaxRate of a rung in kbps. Zero uses DefaultMinRate.
+	MinRate int
+}
+
+// Optimize implements Strategy.
+func (p PerTitle) Optimize(
+	ctx context.Context,
+	exec CommandExecutor,
+	input string,
+	info probe.VideoInfo,
+	l []ladder.Rendition,
+) ([]ladder.Rendition, error) {
+	if len(l) == 0 {
+		return l, nil
+	}
+	p = p.withDefaults()
+
+	ref := l[0]
+	for _, r := range l[1:] {
+		if r.Width*r.Height > ref.Width*ref.Height {
+			ref = r
+		}
+	}
+
+	peak := 0.0
+	for k, start := range sampleOffsets(info.Duration, p.Samples, p.SampleDuration) {
+		rate, err := p.trialEncode(ctx, exec, input, ref, start)
+		if err != nil {
+			return nil, fmt.Errorf("per-title sample %d: %w", k, err)
+		}
+		peak = math.Max(peak, rate)
+	}
+
+	out := make([]ladder.Rendition, 0, len(l))
+	for _, r := range l {
+		scale := math.Pow(float64(r.Width*r.Height)/float64(ref.Width*ref.Height), areaExponent)
+		r.MaxRate = min(max(int(math.Round(peak*scale)), p.MinRate), r.MaxRate)
+		r.BufSize = r.MaxRate * 2
+		out = append(out, r)
+	}
+	return trim(out), nil
+}
+
+func (p PerTitle) withDefaults() PerTitle {
+	if p.SampleDuration <= 0 {
+		p.SampleDuration = DefaultSampleDuration
+	}
+	if p.Samples <= 0 {
+		p.Samples = DefaultSamples
+	}
+	if p.CRF <= 0 {
+		p.CRF = DefaultCRF
+	}
+	if p.MinRate <= 0 {
+		p.MinRate = DefaultMinRate
+	}
+	return p
+}
+
+// sampleOffsets returns the start of each sampled scene: the centres of n
+// equal parts of the source, shifted back by half a sample. A source with an
+// unknown duration is sampled once from the start.
+func sampleOffsets(duration float64, n int, sample time.Duration) []time.Duration {
+	total := time.Duration(duration * float64(time.Second))
+	if total <= 0 {
+		return []time.Duration{0}
+	}
+	n = max(min(n, int(total/sample)), 1)
+
+	offsets := make([]time.Duration, n)
+	for k := range offsets {
+		centre := total * time.Duration(2*k+1) / time.Duration(2*n)
+		offsets[k] = max(centre-sample/2, 0)
+	}
+	return offsets
+}
+
+// trialEncode encodes one sampled scene at rendition r's resolution and
+// returns its bitrate in kbps, read from FFmpeg's progress report.
+func (p PerTitle) trialEncode(
+	ctx context.Context,
+	exec CommandExecutor,
+	input string,
+	r ladder.Rendition,
+	start time.Duration,
+) (float64, error) {
+	out, err := os.CreateTemp("", "mosaic-pertitle-*.mkv")
+	if err != nil {
+		return 0, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	args := []string{
+		"-y",
+		"-loglevel", "error",
+		"-nostats",
+		"-ss", formatSeconds(start),
+		"-t", formatSeconds(p.SampleDuration),
+		"-i", input,
+		"-map", "0:v:0",
+		"-vf", fmt.Sprintf("scale=%d:%d", r.Width, r.Height),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", strconv.Itoa(p.CRF),
+		"-f", "matroska",
+		"-progress", "pipe:1",
+		out.Name(),
+	}
+	report, _, err := exec.Execute(ctx, "ffmpeg", args...)
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg trial encode failed: %w", err)
+	}
+	return progressBitrate(string(report))
+}
+
+// progressBitrate returns the average bitrate in kbps of an encode from the
+// last total_size and out_time_us of its progress report.
+func progressBitrate(report string) (float64, error) {
+	var size, micros float64
+	for _, line := range strings.Split(report, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "total_size":
+			size = v
+		case "out_time_us":
+			micros = v
+		}
+	}
+	if size <= 0 || micros <= 0 {
+		return 0, errors.New("trial encode reported no output")
+	}
+	return size * 8 / (micros / 1e6) / 1000, nil
+}
+
+// formatSeconds formats d as seconds with millisecond precision.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,137 @@
+package optimize
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// progressReport is an FFmpeg progress report for an encode of the given size
+// in bytes over four seconds.
+func progressReport(size string) []byte {
+	return []byte("frame=120\nout_time_us=2000000\ntotal_size=1\nprogress=continue\n" +
+		"frame=240\nout_time_us=4000000\ntotal_size=" + size + "\nprogress=end\n")
+}
+
+func TestPerTitle(t *testing.T) {
+	built := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5200, BufSize: 10400, Profile: "main", Level: "4.0"},
+		{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000, Profile: "main", Level: "3.1"},
+		{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000, Profile: "baseline", Level: "3.0"},
+	}
+
+	tests := []struct {
+		name     string
+		size     string
+		expected []int
+	}{
+		// 1 MB over 4 s is 2000 kbps at 1080p; smaller rungs scale by area^0.75.
+		{name: "moderate content", size: "1000000", expected: []int{2000, 1089, 385}},
+		// 250 kB over 4 s is 500 kbps; the 360p rung is raised to the floor.
+		{name: "static content", size: "250000", expected: []int{500, 272, 150}},
+		// 5 MB over 4 s is 10000 kbps; every rung is held at its built rate.
+		{name: "complex content", size: "5000000", expected: []int{5200, 3000, 1000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffmpeg"] = executor.MockResponse{Output: progressReport(tt.size)}
+
+			result, err := PerTitle{Samples: 3}.Optimize(context.Background(), mock, "in.mp4", probe.VideoInfo{Duration: 60}, built)
+			if err != nil {
+				t.Fatalf("Optimize() error = %v", err)
+			}
+			if mock.GetCallCount("ffmpeg") != 3 {
+				t.Errorf("expected 3 trial encodes, got %d", mock.GetCallCount("ffmpeg"))
+			}
+			if len(result) != len(tt.expected) {
+				t.Fatalf("expected %d renditions, got %+v", len(tt.expected), result)
+			}
+			for i, r := range result {
+				if r.MaxRate != tt.expected[i] || r.BufSize != 2*tt.expected[i] {
+					t.Errorf("rendition %d: MaxRate %d BufSize %d, want %d", i, r.MaxRate, r.BufSize, tt.expected[i])
+				}
+			}
+		})
+	}
+
+	if built[0].MaxRate != 5200 {
+		t.Error("Optimize modified its input")
+	}
+}
+
+func TestPerTitleTrialEncode(t *testing.T) {
+	mock := executor.NewMockExecutor()
+	mock.Responses["ffmpeg"] = executor.MockResponse{Output: progressReport("1000000")}
+
+	l := []ladder.Rendition{
+		{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000},
+		{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000},
+	}
+	p := PerTitle{Samples: 2, SampleDuration: 2 * time.Second, CRF: 20}
+	if _, err := p.Optimize(context.Background(), mock, "in.mp4", probe.VideoInfo{Duration: 20}, l); err != nil {
+		t.Fatalf("Optimize() error = %v", err)
+	}
+
+	// Scenes are centred in the two halves of the source and encoded at the largest rung.
+	for k, start := range []string{"4.000", "14.000"} {
+		args := mock.CallLog[k].Args
+		for _, pair := range [][2]string{{"-ss", start}, {"-t", "2.000"}, {"-crf", "20"}, {"-vf", "scale=1280:720"}, {"-i", "in.mp4"}} {
+			if i := slices.Index(args, pair[0]); i < 0 || args[i+1] != pair[1] {
+				t.Errorf("call %d: expected %s %s in %v", k, pair[0], pair[1], args)
+			}
+		}
+	}
+}
+
+func TestPerTitleErrors(t *testing.T) {
+	l := []ladder.Rendition{{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000}}
+
+	tests := []struct {
+		name     string
+		response executor.MockResponse
+	}{
+		{name: "ffmpeg fails", response: executor.MockResponse{Err: errors.New("exit status 1")}},
+		{name: "no progress", response: executor.MockResponse{Output: []byte("progress=end\n")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffmpeg"] = tt.response
+			if _, err := (PerTitle{}).Optimize(context.Background(), mock, "in.mp4", probe.VideoInfo{}, l); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestSampleOffsets(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration float64
+		n        int
+		expected []time.Duration
+	}{
+		{name: "unknown duration", duration: 0, n: 5, expected: []time.Duration{0}},
+		{name: "spread", duration: 100, n: 4, expected: []time.Duration{10500 * time.Millisecond, 35500 * time.Millisecond, 60500 * time.Millisecond, 85500 * time.Millisecond}},
+		{name: "short source", duration: 9, n: 5, expected: []time.Duration{250 * time.Millisecond, 4750 * time.Millisecond}},
+		{name: "shorter than a sample", duration: 3, n: 5, expected: []time.Duration{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := sampleOffsets(tt.duration, tt.n, 4*time.Second)
+			if !slices.Equal(result, tt.expected) {
+				t.Errorf("sampleOffsets(%v, %d) = %v, want %v", tt.duration, tt.n, result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package optimize
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// DefaultSourceHeadroom is the default factor by which a rung may exceed the
+// bitrate the source spends on the same number of pixels, to make up for the
+// generation loss of re-encoding.
+const DefaultSourceHeadroom = 1.2
+
+const (
+	// starvedRatio is the least bitrate step over the next smaller rung that
+	// keeps a capped rung: below it the rung costs bandwidth without looking
+	// better.
+	starvedRatio = 1.2
+	// starvedBitsPerPixel is the least bits per pixel per frame that keeps a
+	// capped rung: below it the rung shows no more detail than a smaller one.
+	starvedBitsPerPixel = 0.02
+	// defaultFPS stands in for an unknown source frame rate.
+	defaultFPS = 30
+)
+
+// CapToSource caps every rung relative to the source video bitrate: a rung
+// the display size of the source, or larger, gets at most info.Bitrate ×
+// headroom, and smaller rungs a share scaled by picture area as in PerTitle.
+// BufSize keeps its ratio to MaxRate. A non-positive headroom uses
+// DefaultSourceHeadroom.
+//
+// Capped rungs left with less than 0.02 bits per pixel per frame at their own
+// frame rate, or less than 1.2× the bitrate of the next smaller rung, are
+// bit-starved duplicates and are dropped; the smallest rung is always kept.
+// The ladder is returned unchanged when the source bitrate is unknown. The
+// input ladder is not modified.
+func CapToSource(l []ladder.Rendition, info probe.VideoInfo, headroom float64) []ladder.Rendition {
+	source := info.DisplayWidth() * info.DisplayHeight()
+	if info.Bitrate <= 0 || source <= 0 || len(l) == 0 {
+		return l
+	}
+	if headroom <= 0 {
+		headroom = DefaultSourceHeadroom
+	}
+
+	out := slices.Clone(l)
+	capped := make([]bool, len(out))
+	for i, r := range out {
+		scale := math.Min(math.Pow(float64(r.Width*r.Height)/float64(source), areaExponent), 1)
+		limit := max(int(math.Round(float64(info.Bitrate)*headroom*scale)), 1)
+		if r.MaxRate > limit {
+			out[i].BufSize = int(math.Round(float64(r.BufSize) * float64(limit) / float64(r.MaxRate)))
+			out[i].MaxRate = limit
+			capped[i] = true
+		}
+	}
+
+	// Walk from the smallest rung up and drop the starved ones.
+	order := make([]int, len(out))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Or(
+			cmp.Compare(out[a].Width*out[a].Height, out[b].Width*out[b].Height),
+			cmp.Compare(out[a].MaxRate, out[b].MaxRate),
+		)
+	})
+	fps := info.FPS
+	if fps <= 0 {
+		fps = defaultFPS
+	}
+	drop := make([]bool, len(out))
+	prev := order[0]
+	for _, i := range order[1:] {
+		r := out[i]
+		bpp := float64(r.MaxRate) * 1000 / (float64(r.Width*r.Height) * r.FrameRate(fps))
+		if capped[i] && (bpp < starvedBitsPerPixel || float64(r.MaxRate) < float64(out[prev].MaxRate)*starvedRatio) {
+			drop[i] = true
+			continue
+		}
+		prev = i
+	}
+
+	kept := out[:0]
+	for i, r := range out {
+		if !drop[i] {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,103 @@
+package optimize
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+func TestCapToSource(t *testing.T) {
+	built := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000},
+		{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000},
+		{Width: 960, Height: 540, MaxRate: 1800, BufSize: 3600},
+		{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000},
+	}
+	source := probe.VideoInfo{Width: 1920, Height: 1080}
+
+	tests := []struct {
+		name     string
+		bitrate  int
+		headroom float64
+		expected []int
+	}{
+		// 1500 kbps × 1.2 at 1080p; smaller rungs scale by area^0.75.
+		{name: "low bitrate source", bitrate: 1500, expected: []int{1800, 980, 636, 346}},
+		{name: "headroom", bitrate: 1500, headroom: 1.5, expected: []int{2250, 1225, 795, 433}},
+		// 1080p at 1200 kbps is below 0.02 bits per pixel at 30 fps.
+		{name: "starved rung", bitrate: 1000, expected: []int{653, 424, 231}},
+		{name: "high bitrate source", bitrate: 20000, expected: []int{5000, 3000, 1800, 1000}},
+		{name: "unknown bitrate", expected: []int{5000, 3000, 1800, 1000}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := source
+			info.Bitrate = tt.bitrate
+			result := CapToSource(built, info, tt.headroom)
+			if len(result) != len(tt.expected) {
+				t.Fatalf("expected %d renditions, got %+v", len(tt.expected), result)
+			}
+			for i, r := range result {
+				if r.MaxRate != tt.expected[i] || r.BufSize != 2*tt.expected[i] {
+					t.Errorf("rendition %d: MaxRate %d BufSize %d, want %d", i, r.MaxRate, r.BufSize, tt.expected[i])
+				}
+			}
+		})
+	}
+
+	t.Run("input unchanged", func(t *testing.T) {
+		before := slices.Clone(built)
+		CapToSource(built, probe.VideoInfo{Width: 1920, Height: 1080, Bitrate: 500}, 0)
+		if !slices.Equal(built, before) {
+			t.Errorf("CapToSource modified its input: %+v", built)
+		}
+	})
+
+	t.Run("duplicate rung dropped", func(t *testing.T) {
+		// 720p is capped to 1200 kbps, within 1.2× of the 1025 kbps of 648p.
+		l := []ladder.Rendition{
+			{Width: 1280, Height: 720, MaxRate: 3000, BufSize: 6000},
+			{Width: 1152, Height: 648, MaxRate: 1100, BufSize: 2200},
+		}
+		result := CapToSource(l, probe.VideoInfo{Width: 1280, Height: 720, FPS: 30, Bitrate: 1000}, 0)
+		if len(result) != 1 || result[0].Height != 648 || result[0].MaxRate != 1025 {
+			t.Errorf("expected only the 648p rung at 1025 kbps, got %+v", result)
+		}
+	})
+
+	t.Run("anamorphic source", func(t *testing.T) {
+		// 1440x1080 at 4:3 pixels displays as 1920x1080.
+		result := CapToSource(built, probe.VideoInfo{Width: 1440, Height: 1080, SampleAspectRatio: probe.AspectRatio{Num: 4, Den: 3}, Bitrate: 1500}, 0)
+		want := []int{1800, 980, 636, 346}
+		if len(result) != len(want) {
+			t.Fatalf("expected %d renditions, got %+v", len(want), result)
+		}
+		for i, r := range result {
+			if r.MaxRate != want[i] {
+				t.Errorf("rendition %d: MaxRate %d, want %d", i, r.MaxRate, want[i])
+			}
+		}
+	})
+
+	t.Run("frame rate cap", func(t *testing.T) {
+		// 1080p at 2400 kbps is starved at 60 fps but not at its MaxFPS of 30.
+		l := []ladder.Rendition{
+			{Width: 1920, Height: 1080, MaxRate: 5000, BufSize: 10000, MaxFPS: 30},
+			{Width: 640, Height: 360, MaxRate: 1000, BufSize: 2000},
+		}
+		result := CapToSource(l, probe.VideoInfo{Width: 1920, Height: 1080, FPS: 60, Bitrate: 2000}, 0)
+		if len(result) != 2 || result[0].MaxRate != 2400 {
+			t.Errorf("expected the 1080p rung kept at 2400 kbps, got %+v", result)
+		}
+	})
+
+	t.Run("smallest rung kept", func(t *testing.T) {
+		result := CapToSource(built, probe.VideoInfo{Width: 1920, Height: 1080, Bitrate: 100}, 0)
+		if len(result) != 1 || result[0].Height != 360 {
+			t.Errorf("expected only the 360p rung, got %+v", result)
+		}
+	})
+}
@@ -0,0 +1,43 @@
+package optimize
+
+import (
+	"context"
+
+	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+// CommandExecutor runs the FFmpeg and FFprobe commands of a Strategy. It is
+// exported here so strategies and executors can be written outside this module.
+type CommandExecutor = executor.CommandExecutor
+
+// Usage holds the resource usage of a command run by a CommandExecutor.
+type Usage = executor.Usage
+
+// Strategy sets the bitrates of a ladder built for a source and may drop rungs.
+// The input ladder must not be modified.
+type Strategy interface {
+	Optimize(
+		ctx context.Context,
+		exec CommandExecutor,
+		input string,
+		info probe.VideoInfo,
+		l []ladder.Rendition,
+	) ([]ladder.Rendition, error)
+}
+
+// Static is the default Strategy. It applies Apply's fixed, height-based
+// bitrate caps without looking at the content.
+type Static struct{}
+
+// Optimize implements Strategy.
+func (Static) Optimize(
+	_ context.Context,
+	_ CommandExecutor,
+	_ string,
+	_ probe.VideoInfo,
+	l []ladder.Rendition,
+) ([]ladder.Rendition, error) {
+	return Apply(l), nil
+}
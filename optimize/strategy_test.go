@@ -0,0 +1,24 @@
+package optimize
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/ladder"
+	"github.com/farshidrezaei/mosaic/probe"
+)
+
+func TestStatic(t *testing.T) {
+	l := []ladder.Rendition{
+		{Width: 1920, Height: 1080, MaxRate: 8000, BufSize: 16000},
+		{Width: 1280, Height: 720, MaxRate: 5000, BufSize: 10000},
+	}
+	result, err := Static{}.Optimize(context.Background(), nil, "in.mp4", probe.VideoInfo{}, l)
+	if err != nil {
+		t.Fatalf("Optimize() error = %v", err)
+	}
+	if !slices.Equal(result, Apply(l)) {
+		t.Errorf("Static = %+v, want Apply's %+v", result, Apply(l))
+	}
+}
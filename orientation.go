@@ -2,88 +2,69 @@ package mosaic
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"math"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	"github.com/farshidrezaei/mosaic/internal/executor"
+	"github.com/farshidrezaei/mosaic/probe"
 )
 
-type orientationProbeResponse struct {
-	Streams []orientationProbeStream `json:"streams"`
-}
-
-type orientationProbeStream struct {
-	CodecName    string                     `json:"codec_name"`
-	Tags         map[string]string          `json:"tags"`
-	SideDataList []orientationProbeSideData `json:"side_data_list"`
-	Width        int                        `json:"width"`
-	Height       int                        `json:"height"`
-}
-
-type orientationProbeSideData struct {
-	Rotation interface{} `json:"rotation"`
-}
-
-type orientationMetadata struct {
-	CodecName string
-	Width     int
-	Height    int
-	Rotation  int
-}
-
 // NormalizeVideoOrientation normalizes source orientation by physically rotating
 // frames for 90/180/270 metadata-based rotations and clearing rotate metadata.
 func NormalizeVideoOrientation(ctx context.Context, inputPath, outputPath string) error {
-	return normalizeRotationWithExecutor(ctx, inputPath, outputPath, executor.DefaultExecutor)
+	_, err := normalizeRotationWithExecutor(ctx, inputPath, outputPath, executor.DefaultExecutor)
+	return err
 }
 
+// normalizeRotationWithExecutor writes inputPath to outputPath without rotate
+// metadata and returns the probe of the written file: the input's own probe
+// after a remux, which copies every stream as it was, or the probe that
+// verifies the rotated output, which keeps the first video stream and every
+// audio and subtitle stream.
 func normalizeRotationWithExecutor(
 	ctx context.Context,
 	inputPath, outputPath string,
 	exec executor.CommandExecutor,
-) error {
+) (probe.Result, error) {
 	if strings.TrimSpace(inputPath) == "" {
-		return fmt.Errorf("input path is required")
+		return probe.Result{}, fmt.Errorf("input path is required")
 	}
 	if strings.TrimSpace(outputPath) == "" {
-		return fmt.Errorf("output path is required")
+		return probe.Result{}, fmt.Errorf("output path is required")
 	}
 
-	meta, err := probeOrientationMetadata(ctx, inputPath, exec)
+	res, stream, err := probeVideoStream(ctx, inputPath, exec)
 	if err != nil {
-		return err
+		return probe.Result{}, err
 	}
 
-	filter, shouldRotate := rotationFilter(meta.Rotation)
+	filter, shouldRotate := rotationFilter(stream.Rotation())
 	if !shouldRotate {
 		tmpOutput, cleanup, prepErr := prepareTempOutput(outputPath)
 		if prepErr != nil {
-			return prepErr
+			return probe.Result{}, prepErr
 		}
 		defer cleanup()
 
 		args := buildRemuxFFmpegArgs(inputPath, tmpOutput)
 		if _, _, execErr := exec.Execute(ctx, "ffmpeg", args...); execErr != nil {
-			return fmt.Errorf("normalize orientation: ffmpeg remux failed: %w", execErr)
+			return probe.Result{}, fmt.Errorf("normalize orientation: ffmpeg remux failed: %w", execErr)
 		}
 		if renameErr := os.Rename(tmpOutput, outputPath); renameErr != nil {
-			return fmt.Errorf("finalize remux output: %w", renameErr)
+			return probe.Result{}, fmt.Errorf("finalize remux output: %w", renameErr)
 		}
-		return nil
+		return res, nil
 	}
 
 	tmpOutput, cleanup, err := prepareTempOutput(outputPath)
 	if err != nil {
-		return err
+		return probe.Result{}, err
 	}
 	defer cleanup()
 
-	enc := preferredVideoEncoder(meta.CodecName)
+	enc := preferredVideoEncoder(stream.CodecName)
 	args := buildRotateFFmpegArgs(inputPath, tmpOutput, enc, filter)
 	_, _, err = exec.Execute(ctx, "ffmpeg", args...)
 	if err != nil && enc != "libx264" {
@@ -91,95 +72,39 @@ func normalizeRotationWithExecutor(
 		_, _, err = exec.Execute(ctx, "ffmpeg", args...)
 	}
 	if err != nil {
-		return fmt.Errorf("normalize orientation: ffmpeg failed: %w", err)
+		return probe.Result{}, fmt.Errorf("normalize orientation: ffmpeg failed: %w", err)
 	}
 
-	outMeta, err := probeOrientationMetadata(ctx, tmpOutput, exec)
+	outRes, outStream, err := probeVideoStream(ctx, tmpOutput, exec)
 	if err != nil {
-		return fmt.Errorf("verify normalized output: %w", err)
+		return probe.Result{}, fmt.Errorf("verify normalized output: %w", err)
 	}
-	if outMeta.Rotation != 0 {
-		return fmt.Errorf("verify normalized output: rotate metadata still present (%d)", outMeta.Rotation)
+	if rotation := outStream.Rotation(); rotation != 0 {
+		return probe.Result{}, fmt.Errorf("verify normalized output: rotate metadata still present (%d)", rotation)
 	}
 
 	if err := os.Rename(tmpOutput, outputPath); err != nil {
-		return fmt.Errorf("finalize normalized output: %w", err)
+		return probe.Result{}, fmt.Errorf("finalize normalized output: %w", err)
 	}
-	return nil
+	return outRes, nil
 }
 
-func probeOrientationMetadata(
+// probeVideoStream probes inputPath and returns the result with its first
+// video stream.
+func probeVideoStream(
 	ctx context.Context,
 	inputPath string,
 	exec executor.CommandExecutor,
-) (orientationMetadata, error) {
-	args := []string{
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-show_entries", "stream=width,height,codec_name:stream_tags=rotate:stream_side_data=rotation",
-		"-of", "json",
-		inputPath,
-	}
-	out, _, err := exec.Execute(ctx, "ffprobe", args...)
+) (probe.Result, probe.Stream, error) {
+	res, err := probe.RunWithExecutor(ctx, inputPath, exec)
 	if err != nil {
-		return orientationMetadata{}, fmt.Errorf("ffprobe orientation probe failed: %w", err)
-	}
-	return parseOrientationProbeOutput(out)
-}
-
-func parseOrientationProbeOutput(data []byte) (orientationMetadata, error) {
-	var resp orientationProbeResponse
-	if err := json.Unmarshal(data, &resp); err != nil {
-		return orientationMetadata{}, fmt.Errorf("parse ffprobe json: %w", err)
-	}
-	if len(resp.Streams) == 0 {
-		return orientationMetadata{}, fmt.Errorf("no video stream found")
+		return probe.Result{}, probe.Stream{}, fmt.Errorf("ffprobe orientation probe failed: %w", err)
 	}
-
-	s := resp.Streams[0]
-	return orientationMetadata{
-		Width:     s.Width,
-		Height:    s.Height,
-		CodecName: s.CodecName,
-		Rotation:  detectOrientationRotation(s),
-	}, nil
-}
-
-func detectOrientationRotation(stream orientationProbeStream) int {
-	for _, sideData := range stream.SideDataList {
-		if r, ok := parseRotationValue(sideData.Rotation); ok {
-			return normalizeRotationDegrees(r)
-		}
-	}
-	if stream.Tags != nil {
-		if raw, ok := stream.Tags["rotate"]; ok {
-			if r, ok := parseRotationValue(raw); ok {
-				return normalizeRotationDegrees(r)
-			}
-		}
-	}
-	return 0
-}
-
-func parseRotationValue(v interface{}) (int, bool) {
-	switch x := v.(type) {
-	case float64:
-		return int(math.Round(x)), true
-	case string:
-		trimmed := strings.TrimSpace(x)
-		if trimmed == "" {
-			return 0, false
-		}
-		if i, err := strconv.Atoi(trimmed); err == nil {
-			return i, true
-		}
-		if f, err := strconv.ParseFloat(trimmed, 64); err == nil {
-			return int(math.Round(f)), true
-		}
-		return 0, false
-	default:
-		return 0, false
+	stream, ok := res.VideoStream()
+	if !ok {
+		return probe.Result{}, probe.Stream{}, fmt.Errorf("no video stream found")
 	}
+	return res, stream, nil
 }
 
 func normalizeRotationDegrees(rotation int) int {
@@ -230,9 +155,11 @@ func buildRotateFFmpegArgs(inputPath, outputPath, encoderName, filter string) []
 		"-i", inputPath,
 		"-map", "0:v:0",
 		"-map", "0:a?",
+		"-map", "0:s?",
 		"-vf", filter,
 		"-c:v", encoderName,
 		"-c:a", "copy",
+		"-c:s", "copy",
 		"-metadata:s:v:0", "rotate=0",
 		outputPath,
 	}
@@ -243,6 +170,7 @@ func buildRemuxFFmpegArgs(inputPath, outputPath string) []string {
 		"-y",
 		"-v", "error",
 		"-i", inputPath,
+		"-map", "0",
 		"-c", "copy",
 		"-metadata:s:v:0", "rotate=0",
 		outputPath,
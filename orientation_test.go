@@ -11,7 +11,7 @@ import (
 	"github.com/farshidrezaei/mosaic/internal/executor"
 )
 
-func TestParseOrientationProbeOutput(t *testing.T) {
+func TestProbeVideoStream(t *testing.T) {
 	tests := []struct {
 		name         string
 		json         string
@@ -21,19 +21,19 @@ func TestParseOrientationProbeOutput(t *testing.T) {
 	}{
 		{
 			name:         "side data rotation has priority",
-			json:         `{"streams":[{"width":1920,"height":1080,"codec_name":"h264","tags":{"rotate":"270"},"side_data_list":[{"rotation":90}]}]}`,
+			json:         `{"streams":[{"codec_type":"video","width":1920,"height":1080,"codec_name":"h264","tags":{"rotate":"270"},"side_data_list":[{"rotation":90}]}]}`,
 			wantRotation: 90,
 			wantCodec:    "h264",
 		},
 		{
 			name:         "tag rotation fallback",
-			json:         `{"streams":[{"width":1920,"height":1080,"codec_name":"hevc","tags":{"rotate":"-90"}}]}`,
+			json:         `{"streams":[{"codec_type":"video","width":1920,"height":1080,"codec_name":"hevc","tags":{"rotate":"-90"}}]}`,
 			wantRotation: 270,
 			wantCodec:    "hevc",
 		},
 		{
 			name:         "broken metadata",
-			json:         `{"streams":[{"width":1920,"height":1080,"codec_name":"h264","tags":{"rotate":"bad"},"side_data_list":[{"rotation":"bad"}]}]}`,
+			json:         `{"streams":[{"codec_type":"video","width":1920,"height":1080,"codec_name":"h264","tags":{"rotate":"bad"},"side_data_list":[{"rotation":"bad"}]}]}`,
 			wantRotation: 0,
 			wantCodec:    "h264",
 		},
@@ -46,15 +46,16 @@ func TestParseOrientationProbeOutput(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := parseOrientationProbeOutput([]byte(tt.json))
+			mock := &orientationMockExecutor{ffprobeOutputs: [][]byte{[]byte(tt.json)}}
+			_, got, err := probeVideoStream(context.Background(), "in.mp4", mock)
 			if (err != nil) != tt.wantErr {
-				t.Fatalf("parseOrientationProbeOutput() err=%v wantErr=%v", err, tt.wantErr)
+				t.Fatalf("probeVideoStream() err=%v wantErr=%v", err, tt.wantErr)
 			}
 			if tt.wantErr {
 				return
 			}
-			if got.Rotation != tt.wantRotation {
-				t.Fatalf("Rotation=%d want %d", got.Rotation, tt.wantRotation)
+			if got.Rotation() != tt.wantRotation {
+				t.Fatalf("Rotation()=%d want %d", got.Rotation(), tt.wantRotation)
 			}
 			if got.CodecName != tt.wantCodec {
 				t.Fatalf("CodecName=%q want %q", got.CodecName, tt.wantCodec)
@@ -99,12 +100,12 @@ func TestNormalizeRotationWithExecutor_NoRotationRemuxes(t *testing.T) {
 
 	mock := &orientationMockExecutor{
 		ffprobeOutputs: [][]byte{
-			[]byte(`{"streams":[{"width":1280,"height":720,"codec_name":"h264"}]}`),
+			[]byte(`{"streams":[{"codec_type":"video","width":1280,"height":720,"codec_name":"h264"}]}`),
 		},
 		createFFmpegOutput: true,
 	}
 
-	if err := normalizeRotationWithExecutor(context.Background(), inputPath, outputPath, mock); err != nil {
+	if _, err := normalizeRotationWithExecutor(context.Background(), inputPath, outputPath, mock); err != nil {
 		t.Fatalf("normalizeRotationWithExecutor() err=%v", err)
 	}
 	if mock.ffmpegCalls != 1 {
@@ -113,6 +114,8 @@ func TestNormalizeRotationWithExecutor_NoRotationRemuxes(t *testing.T) {
 	assertContainsArg(t, mock.lastFFmpegArgs, "-c")
 	assertContainsArg(t, mock.lastFFmpegArgs, "copy")
 	assertContainsArg(t, mock.lastFFmpegArgs, "rotate=0")
+	// Every stream is kept, so the input's probe describes the output.
+	assertArgPair(t, mock.lastFFmpegArgs, "-map", "0")
 }
 
 func TestNormalizeRotationWithExecutor_NoRotationURLInput(t *testing.T) {
@@ -121,12 +124,12 @@ func TestNormalizeRotationWithExecutor_NoRotationURLInput(t *testing.T) {
 
 	mock := &orientationMockExecutor{
 		ffprobeOutputs: [][]byte{
-			[]byte(`{"streams":[{"width":1280,"height":720,"codec_name":"h264"}]}`),
+			[]byte(`{"streams":[{"codec_type":"video","width":1280,"height":720,"codec_name":"h264"}]}`),
 		},
 		createFFmpegOutput: true,
 	}
 
-	if err := normalizeRotationWithExecutor(context.Background(), "https://example.com/video.mp4", outputPath, mock); err != nil {
+	if _, err := normalizeRotationWithExecutor(context.Background(), "https://example.com/video.mp4", outputPath, mock); err != nil {
 		t.Fatalf("normalizeRotationWithExecutor() err=%v", err)
 	}
 	if mock.ffmpegCalls != 1 {
@@ -144,21 +147,28 @@ func TestNormalizeRotationWithExecutor_Rotation90RunsFFmpeg(t *testing.T) {
 
 	mock := &orientationMockExecutor{
 		ffprobeOutputs: [][]byte{
-			[]byte(`{"streams":[{"width":1920,"height":1080,"codec_name":"h264","side_data_list":[{"rotation":90}]}]}`),
-			[]byte(`{"streams":[{"width":1080,"height":1920,"codec_name":"h264"}]}`),
+			[]byte(`{"streams":[{"codec_type":"video","width":1920,"height":1080,"codec_name":"h264","side_data_list":[{"rotation":90}]}]}`),
+			[]byte(`{"streams":[{"codec_type":"video","width":1080,"height":1920,"codec_name":"h264"}]}`),
 		},
 		createFFmpegOutput: true,
 	}
 
-	if err := normalizeRotationWithExecutor(context.Background(), inputPath, outputPath, mock); err != nil {
+	res, err := normalizeRotationWithExecutor(context.Background(), inputPath, outputPath, mock)
+	if err != nil {
 		t.Fatalf("normalizeRotationWithExecutor() err=%v", err)
 	}
 	if mock.ffmpegCalls != 1 {
 		t.Fatalf("expected 1 ffmpeg call, got %d", mock.ffmpegCalls)
 	}
+	if s, ok := res.VideoStream(); !ok || s.Width != 1080 || s.Height != 1920 {
+		t.Fatalf("expected the probe of the rotated output, got %+v", res)
+	}
 	assertContainsArg(t, mock.lastFFmpegArgs, "-noautorotate")
 	assertContainsArg(t, mock.lastFFmpegArgs, "transpose=1")
 	assertContainsArg(t, mock.lastFFmpegArgs, "rotate=0")
+	assertArgPair(t, mock.lastFFmpegArgs, "-map", "0:a?")
+	assertArgPair(t, mock.lastFFmpegArgs, "-map", "0:s?")
+	assertArgPair(t, mock.lastFFmpegArgs, "-c:s", "copy")
 }
 
 func assertContainsArg(t *testing.T, args []string, want string) {
@@ -171,6 +181,16 @@ func assertContainsArg(t *testing.T, args []string, want string) {
 	t.Fatalf("args %v do not contain %q", args, want)
 }
 
+func assertArgPair(t *testing.T, args []string, flag, value string) {
+	t.Helper()
+	for i := 0; i+1 < len(args); i++ {
+		if args[i] == flag && args[i+1] == value {
+			return
+		}
+	}
+	t.Fatalf("args %v do not contain %s %s", args, flag, value)
+}
+
 type orientationMockExecutor struct {
 	ffprobeErr     error
 	ffprobeOutputs [][]byte
@@ -199,7 +219,7 @@ func (m *orientationMockExecutor) ExecuteWithProgress(ctx context.Context, progr
 		if m.ffprobeCalls <= len(m.ffprobeOutputs) {
 			return m.ffprobeOutputs[m.ffprobeCalls-1], nil, nil
 		}
-		return []byte(`{"streams":[{"width":1920,"height":1080,"codec_name":"h264"}]}`), nil, nil
+		return []byte(`{"streams":[{"codec_type":"video","width":1920,"height":1080,"codec_name":"h264"}]}`), nil, nil
 	case "ffmpeg":
 		m.ffmpegCalls++
 		m.lastFFmpegArgs = append([]string(nil), args...)
@@ -0,0 +1,188 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/farshidrezaei/mosaic/internal/executor"
+)
+
+// Result is the output of ffprobe -show_format -show_streams -show_chapters,
+// decoded once and shared by every reader of a source. Numbers that ffprobe
+// prints as strings, such as bitrates and durations, are kept as strings and
+// may be "N/A".
+type Result struct {
+	// Streams are every stream of the file, in stream order.
+	Streams []Stream `json:"streams"`
+	// Chapters are the chapter markers of the file, if any.
+	Chapters []Chapter `json:"chapters"`
+	// Format describes the container.
+	Format Format `json:"format"`
+}
+
+// Stream is one stream of an ffprobe Result. Video fields are empty for
+// audio and subtitle streams, and audio fields for the others.
+type Stream struct {
+	// Tags are the stream metadata tags, such as "language", "title",
+	// "rotate" and Matroska's "BPS".
+	Tags map[string]string `json:"tags"`
+	// Disposition holds the stream disposition flags, such as "default",
+	// "forced" and "hearing_impaired", as 0 or 1.
+	Disposition map[string]int `json:"disposition"`
+
+	CodecType string `json:"codec_type"`
+	CodecName string `json:"codec_name"`
+	Profile   string `json:"profile"`
+	BitRate   string `json:"bit_rate"`
+	Duration  string `json:"duration"`
+
+	SampleAspectRatio  string     `json:"sample_aspect_ratio"`
+	DisplayAspectRatio string     `json:"display_aspect_ratio"`
+	AvgFrameRate       string     `json:"avg_frame_rate"`
+	RFrameRate         string     `json:"r_frame_rate"`
+	FieldOrder         string     `json:"field_order"`
+	PixFmt             string     `json:"pix_fmt"`
+	BitsPerRawSample   string     `json:"bits_per_raw_sample"`
+	ColorRange         string     `json:"color_range"`
+	ColorSpace         string     `json:"color_space"`
+	ColorTransfer      string     `json:"color_transfer"`
+	ColorPrimaries     string     `json:"color_primaries"`
+	SideDataList       []SideData `json:"side_data_list"`
+
+	SampleRate    string `json:"sample_rate"`
+	ChannelLayout string `json:"channel_layout"`
+
+	Index          int `json:"index"`
+	Level          int `json:"level"`
+	Width          int `json:"width"`
+	Height         int `json:"height"`
+	ClosedCaptions int `json:"closed_captions"`
+	Channels       int `json:"channels"`
+}
+
+// SideData is one entry of a stream's side_data_list: a display matrix,
+// mastering display metadata or content light level metadata.
+type SideData struct {
+	// Rotation is the display matrix rotation in degrees. ffprobe prints a
+	// number, but some builds quote it, so it holds either.
+	Rotation     any    `json:"rotation"`
+	SideDataType string `json:"side_data_type"`
+	RedX         string `json:"red_x"`
+	RedY         string `json:"red_y"`
+	GreenX       string `json:"green_x"`
+	GreenY       string `json:"green_y"`
+	BlueX        string `json:"blue_x"`
+	BlueY        string `json:"blue_y"`
+	WhitePointX  string `json:"white_point_x"`
+	WhitePointY  string `json:"white_point_y"`
+	MinLuminance string `json:"min_luminance"`
+	MaxLuminance string `json:"max_luminance"`
+	MaxContent   int    `json:"max_content"`
+	MaxAverage   int    `json:"max_average"`
+}
+
+// Format describes the container of an ffprobe Result.
+type Format struct {
+	// Tags are the container metadata tags.
+	Tags           map[string]string `json:"tags"`
+	Filename       string            `json:"filename"`
+	FormatName     string            `json:"format_name"`
+	FormatLongName string            `json:"format_long_name"`
+	StartTime      string            `json:"start_time"`
+	Duration       string            `json:"duration"`
+	Size           string            `json:"size"`
+	BitRate        string            `json:"bit_rate"`
+	NbStreams      int               `json:"nb_streams"`
+}
+
+// Chapter is one chapter marker of an ffprobe Result.
+type Chapter struct {
+	// Tags are the chapter metadata tags, usually "title".
+	Tags      map[string]string `json:"tags"`
+	TimeBase  string            `json:"time_base"`
+	StartTime string            `json:"start_time"`
+	EndTime   string            `json:"end_time"`
+	ID        int64             `json:"id"`
+}
+
+// Run probes the given file or URL with a single ffprobe call.
+// It uses the default command executor to run ffprobe.
+func Run(ctx context.Context, input string) (Result, error) {
+	return RunWithExecutor(ctx, input, executor.DefaultExecutor)
+}
+
+// RunWithExecutor is like Run but allows providing a custom CommandExecutor.
+func RunWithExecutor(ctx context.Context, input string, exec executor.CommandExecutor) (Result, error) {
+	out, _, err := exec.Execute(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-show_format",
+		"-show_streams",
+		"-show_chapters",
+		"-of", "json",
+		input,
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var r Result
+	if err := json.Unmarshal(out, &r); err != nil {
+		return Result{}, fmt.Errorf("parse ffprobe json: %w", err)
+	}
+	return r, nil
+}
+
+// VideoStream returns the first video stream, the one FFmpeg maps as 0:v:0.
+func (r Result) VideoStream() (Stream, bool) {
+	for _, s := range r.Streams {
+		if s.CodecType == "video" {
+			return s, true
+		}
+	}
+	return Stream{}, false
+}
+
+// Rotation returns the normalized clockwise rotation of a video stream in
+// degrees (0, 90, 180, 270), from its display matrix or else its "rotate"
+// tag. Malformed values are ignored.
+func (s Stream) Rotation() int {
+	for _, sd := range s.SideDataList {
+		if r, ok := parseRotation(sd.Rotation); ok {
+			return normalizeRotation(r)
+		}
+	}
+	if r, ok := parseRotation(s.Tags["rotate"]); ok {
+		return normalizeRotation(r)
+	}
+	return 0
+}
+
+// parseRotation parses a rotation printed as a number or a string.
+func parseRotation(v any) (int, bool) {
+	switch x := v.(type) {
+	case float64:
+		return int(math.Round(x)), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return 0, false
+		}
+		return int(math.Round(f)), true
+	default:
+		return 0, false
+	}
+}
+
+func normalizeRotation(deg int) int {
+	deg = deg % 360
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
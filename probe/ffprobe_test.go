@@ -0,0 +1,98 @@
+package probe
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/internal/executor"
+)
+
+func TestRunWithExecutor(t *testing.T) {
+	mock := executor.NewMockExecutor()
+	mock.Responses["ffprobe"] = executor.MockResponse{Output: []byte(`{
+		"streams":[
+			{"index":0,"codec_type":"audio","codec_name":"aac","sample_rate":"48000","channels":2},
+			{"index":1,"codec_type":"video","codec_name":"h264","width":1920,"height":1080,"level":40,"tags":{"rotate":"90"}}
+		],
+		"chapters":[
+			{"id":0,"time_base":"1/1000","start_time":"0.000000","end_time":"60.000000","tags":{"title":"Intro"}},
+			{"id":1,"time_base":"1/1000","start_time":"60.000000","end_time":"120.000000","tags":{"title":"Main"}}
+		],
+		"format":{"filename":"in.mkv","nb_streams":2,"format_name":"matroska,webm","duration":"120.000000","bit_rate":"5000000","tags":{"title":"Film"}}
+	}`)}
+
+	r, err := RunWithExecutor(context.Background(), "in.mkv", mock)
+	if err != nil {
+		t.Fatalf("RunWithExecutor() error = %v", err)
+	}
+	if calls := mock.GetCallCount("ffprobe"); calls != 1 {
+		t.Fatalf("ffprobe calls: got %d, want 1", calls)
+	}
+	args := mock.CallLog[0].Args
+	for _, want := range []string{"-show_format", "-show_streams", "-show_chapters", "in.mkv"} {
+		if !slices.Contains(args, want) {
+			t.Errorf("args %v do not contain %q", args, want)
+		}
+	}
+
+	if len(r.Streams) != 2 || r.Streams[0].SampleRate != "48000" {
+		t.Errorf("Streams: got %+v", r.Streams)
+	}
+	v, ok := r.VideoStream()
+	if !ok || v.Index != 1 || v.Level != 40 || v.Rotation() != 90 {
+		t.Errorf("VideoStream: got %+v, %v", v, ok)
+	}
+	if len(r.Chapters) != 2 || r.Chapters[1].ID != 1 || r.Chapters[1].Tags["title"] != "Main" || r.Chapters[1].EndTime != "120.000000" {
+		t.Errorf("Chapters: got %+v", r.Chapters)
+	}
+	if r.Format.FormatName != "matroska,webm" || r.Format.NbStreams != 2 || r.Format.Tags["title"] != "Film" {
+		t.Errorf("Format: got %+v", r.Format)
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		for _, resp := range []executor.MockResponse{
+			{Err: errors.New("ffprobe failed")},
+			{Output: []byte("not json")},
+		} {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffprobe"] = resp
+			if _, err := RunWithExecutor(context.Background(), "in.mkv", mock); err == nil {
+				t.Errorf("expected an error for %+v", resp)
+			}
+		}
+	})
+}
+
+func TestStreamRotation(t *testing.T) {
+	tests := []struct {
+		name   string
+		stream Stream
+		want   int
+	}{
+		{name: "none", want: 0},
+		{name: "side data", stream: Stream{SideDataList: []SideData{{Rotation: float64(-90)}}}, want: 270},
+		{name: "quoted side data", stream: Stream{SideDataList: []SideData{{Rotation: "180"}}}, want: 180},
+		{
+			name:   "side data before tag",
+			stream: Stream{Tags: map[string]string{"rotate": "270"}, SideDataList: []SideData{{SideDataType: "Content light level metadata"}, {Rotation: float64(90)}}},
+			want:   90,
+		},
+		{name: "tag", stream: Stream{Tags: map[string]string{"rotate": "-90"}}, want: 270},
+		{name: "full turn", stream: Stream{Tags: map[string]string{"rotate": "360"}}, want: 0},
+		{
+			name:   "broken metadata",
+			stream: Stream{Tags: map[string]string{"rotate": "bad"}, SideDataList: []SideData{{Rotation: "bad"}}},
+			want:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.stream.Rotation(); got != tt.want {
+				t.Errorf("Rotation() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
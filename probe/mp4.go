@@ -0,0 +1,948 @@
+package probe
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// MP4 is a Prober that reads local MP4 and MOV files in Go with ParseMP4, so
+// files can be validated on hosts without FFmpeg. URLs and files in other
+// containers are passed to Fallback.
+type MP4 struct {
+	// Fallback describes the inputs MP4 does not understand. Nil uses FFprobe.
+	Fallback Prober
+}
+
+// Probe implements Prober.
+func (p MP4) Probe(ctx context.Context, input string) (VideoInfo, error) {
+	if strings.Contains(input, "://") {
+		return p.fallback().Probe(ctx, input)
+	}
+	if err := ctx.Err(); err != nil {
+		return VideoInfo{}, err
+	}
+
+	f, err := os.Open(input)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return VideoInfo{}, err
+	}
+
+	info, err := ParseMP4(f, st.Size())
+	if errors.Is(err, ErrNotMP4) {
+		return p.fallback().Probe(ctx, input)
+	}
+	return info, err
+}
+
+func (p MP4) fallback() Prober {
+	if p.Fallback == nil {
+		return FFprobe{}
+	}
+	return p.Fallback
+}
+
+// ErrNotMP4 is returned by ParseMP4 for files that are not ISO base media
+// files such as MP4, MOV, M4V and 3GP.
+var ErrNotMP4 = errors.New("not an MP4 or MOV file")
+
+// mp4Format is the format name ffprobe reports for ISO base media files.
+const mp4Format = "mov,mp4,m4a,3gp,3g2,mj2"
+
+// maxMoovSize is the largest moov box ParseMP4 reads into memory.
+const maxMoovSize = 64 << 20
+
+// mp4FirstBoxes are the box types an ISO base media file may start with.
+var mp4FirstBoxes = map[string]bool{
+	"ftyp": true,
+	"moov": true,
+	"mdat": true,
+	"free": true,
+	"skip": true,
+	"wide": true,
+	"pnot": true,
+}
+
+// mp4Codecs maps sample entry types to FFmpeg codec names.
+var mp4Codecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"dvh1": "hevc",
+	"dvhe": "hevc",
+	"av01": "av1",
+	"vp09": "vp9",
+	"vp08": "vp8",
+	"mp4v": "mpeg4",
+	"apch": "prores",
+	"apcn": "prores",
+	"apcs": "prores",
+	"apco": "prores",
+	"ap4h": "prores",
+	"ap4x": "prores",
+	"jpeg": "mjpeg",
+	"mp4a": "aac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"Opus": "opus",
+	"fLaC": "flac",
+	".mp3": "mp3",
+	"alac": "alac",
+	"tx3g": "mov_text",
+	"text": "mov_text",
+	"wvtt": "webvtt",
+	"stpp": "ttml",
+	"c608": "eia_608",
+}
+
+// The FFmpeg names of the ISO/IEC 23091-2 color codes of a colr box.
+var (
+	mp4Primaries = map[uint16]string{1: "bt709", 5: "bt470bg", 6: "smpte170m", 9: "bt2020", 11: "smpte431", 12: "smpte432"}
+	mp4Transfers = map[uint16]string{1: "bt709", 6: "smpte170m", 13: "iec61966-2-1", 14: "bt2020-10", 15: "bt2020-12", 16: "smpte2084", 18: "arib-std-b67"}
+	mp4Matrices  = map[uint16]string{0: "gbr", 1: "bt709", 5: "bt470bg", 6: "smpte170m", 9: "bt2020nc", 10: "bt2020c"}
+)
+
+// mp4FieldOrders maps the detail byte of an interlaced fiel box to a field
+// order.
+var mp4FieldOrders = map[uint8]string{1: "tt", 6: "bb", 9: "tb", 14: "bt"}
+
+// ParseMP4 describes an MP4 or MOV file of size bytes from its box structure,
+// without ffprobe. It reads the movie header and, for every track, the track
+// header, media header, handler and sample table, including the codec
+// configuration (avcC, hvcC, av1C), pixel aspect ratio, color and HDR
+// metadata of the video sample entry.
+//
+// Fields FFmpeg only learns by decoding, such as PixelFormat and
+// ClosedCaptions, are left empty, and audio channels are those the sample
+// entry declares. ParseMP4 returns ErrNotMP4 when r is not an ISO base media
+// file, and an error when it is one but is truncated or malformed or has no
+// video track.
+//
+// The frame rate of a fragmented file is read from its first movie fragment,
+// or from the default sample duration of its movie extends box. FPS is left
+// at 0 when the file times no video samples at all.
+func ParseMP4(r io.ReaderAt, size int64) (VideoInfo, error) {
+	moov, moof, err := readMoov(r, size)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+
+	boxes, err := mp4Children(moov)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	timescale, duration, err := parseMvhd(mp4First(boxes, "mvhd"))
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	// Fragmented files declare their duration and default sample durations
+	// in the movie extends box.
+	mvex, err := mp4Children(mp4First(boxes, "mvex"))
+	if err != nil {
+		return VideoInfo{}, err
+	}
+	if duration == 0 {
+		if duration, err = parseMehd(mp4First(mvex, "mehd")); err != nil {
+			return VideoInfo{}, err
+		}
+	}
+	trex, err := parseTrex(mvex)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+
+	info := VideoInfo{Format: mp4Format}
+	if timescale > 0 {
+		info.Duration = float64(duration) / float64(timescale)
+	}
+	if info.Duration > 0 {
+		info.FormatBitrate = int(math.Round(float64(size) * 8 / info.Duration / 1000))
+	}
+
+	var video *mp4Track
+	index := 0
+	for _, b := range boxes {
+		if b.typ != "trak" {
+			continue
+		}
+		t, err := parseTrak(b.data)
+		if err != nil {
+			return VideoInfo{}, err
+		}
+		switch t.handler {
+		case "vide":
+			if video == nil {
+				video = &t
+			}
+		case "soun":
+			info.AudioStreams = append(info.AudioStreams, AudioStream{
+				Index:         index,
+				Codec:         t.codec(),
+				Language:      t.language,
+				Channels:      t.channels,
+				ChannelLayout: channelLayout(t.channels),
+				Bitrate:       t.bitrate(),
+				SampleRate:    t.sampleRate,
+				Default:       t.enabled,
+			})
+		case "sbtl", "subt", "text", "clcp":
+			info.SubtitleStreams = append(info.SubtitleStreams, SubtitleStream{
+				Index:    index,
+				Codec:    t.codec(),
+				Language: t.language,
+				Default:  t.enabled,
+			})
+		}
+		index++
+	}
+	if video == nil {
+		return VideoInfo{}, fmt.Errorf("no video stream found")
+	}
+	info.HasAudio = len(info.AudioStreams) > 0
+
+	v := video.visual
+	info.Width, info.Height = v.width, v.height
+	if info.Width == 0 || info.Height == 0 {
+		info.Width, info.Height = video.width, video.height
+	}
+	info.Codec = video.codec()
+	info.Profile, info.Level, info.BitDepth = v.profile, v.level, v.bitDepth
+	if info.BitDepth == 0 {
+		info.BitDepth = 8
+	}
+	info.FieldOrder = v.fieldOrder
+	info.ColorPrimaries, info.ColorTransfer, info.ColorSpace, info.ColorRange = v.primaries, v.transfer, v.matrix, v.colorRange
+	info.MasteringDisplay, info.ContentLightLevel = v.mastering, v.light
+	info.Rotation = video.rotation
+	if v.sar.Num > 0 && v.sar.Den > 0 {
+		info.SampleAspectRatio = reduceRatio(v.sar.Num, v.sar.Den)
+		info.DisplayAspectRatio = reduceRatio(info.Width*v.sar.Num, info.Height*v.sar.Den)
+	}
+
+	if video.sampleTime == 0 {
+		if err := video.parseMoof(moof, trex[video.id]); err != nil {
+			return VideoInfo{}, err
+		}
+		if video.sampleTime == 0 && trex[video.id] > 0 {
+			video.addSamples(1, trex[video.id])
+		}
+	}
+	if video.sampleTime > 0 {
+		info.FPS = float64(video.samples) * float64(video.timescale) / float64(video.sampleTime)
+	}
+	if video.commonDelta > 0 {
+		info.BaseFPS = float64(video.timescale) / float64(video.commonDelta)
+	}
+	info.Bitrate = video.bitrate()
+	if info.Bitrate == 0 {
+		info.Bitrate = residualBitrate(info.FormatBitrate, info.AudioStreams)
+	}
+	return info, nil
+}
+
+// readMoov walks the top-level boxes of a file and returns the payloads of
+// its moov box and of its first moof box, if any.
+func readMoov(r io.ReaderAt, size int64) (moov, moof []byte, err error) {
+	if size < 8 {
+		return nil, nil, ErrNotMP4
+	}
+	hdr := make([]byte, 16)
+	for off := int64(0); off < size; {
+		n := min(size-off, int64(len(hdr)))
+		if _, err := r.ReadAt(hdr[:n], off); err != nil && !errors.Is(err, io.EOF) {
+			return nil, nil, err
+		}
+		if n < 8 {
+			return nil, nil, fmt.Errorf("mp4: truncated box header at offset %d", off)
+		}
+		typ := string(hdr[4:8])
+		if off == 0 && !mp4FirstBoxes[typ] {
+			return nil, nil, ErrNotMP4
+		}
+
+		boxSize, hdrSize := int64(binary.BigEndian.Uint32(hdr)), int64(8)
+		switch boxSize {
+		case 0:
+			boxSize = size - off
+		case 1:
+			if n < 16 {
+				return nil, nil, fmt.Errorf("mp4: truncated %q box header", typ)
+			}
+			boxSize, hdrSize = int64(binary.BigEndian.Uint64(hdr[8:])), 16
+		}
+		if boxSize < hdrSize {
+			return nil, nil, fmt.Errorf("mp4: invalid %q box size %d", typ, boxSize)
+		}
+		if boxSize > size-off {
+			return nil, nil, fmt.Errorf("mp4: %q box runs past the end of the file", typ)
+		}
+
+		if (typ == "moov" && moov == nil) || (typ == "moof" && moof == nil) {
+			if boxSize-hdrSize > maxMoovSize {
+				return nil, nil, fmt.Errorf("mp4: %s box of %d bytes is too large", typ, boxSize-hdrSize)
+			}
+			b := make([]byte, boxSize-hdrSize)
+			if _, err := r.ReadAt(b, off+hdrSize); err != nil && !errors.Is(err, io.EOF) {
+				return nil, nil, err
+			}
+			if typ == "moov" {
+				moov = b
+			} else {
+				moof = b
+			}
+		}
+		off += boxSize
+	}
+	if moov == nil {
+		return nil, nil, errors.New("mp4: no moov box")
+	}
+	return moov, moof, nil
+}
+
+// mp4Box is a box read into memory.
+type mp4Box struct {
+	typ  string
+	data []byte
+}
+
+// mp4Children splits the payload of a container box into its boxes. Trailing
+// bytes too short for a box header, such as the zero terminator of QuickTime
+// user data, are ignored.
+func mp4Children(b []byte) ([]mp4Box, error) {
+	var out []mp4Box
+	for len(b) >= 8 {
+		size, hdr := uint64(binary.BigEndian.Uint32(b)), uint64(8)
+		typ := string(b[4:8])
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return nil, fmt.Errorf("mp4: truncated %q box header", typ)
+			}
+			size, hdr = binary.BigEndian.Uint64(b[8:]), 16
+		}
+		if size < hdr || size > uint64(len(b)) {
+			return nil, fmt.Errorf("mp4: invalid %q box size %d", typ, size)
+		}
+		out = append(out, mp4Box{typ: typ, data: b[hdr:size]})
+		b = b[size:]
+	}
+	return out, nil
+}
+
+// mp4First returns the payload of the first box of type typ, or nil.
+func mp4First(boxes []mp4Box, typ string) []byte {
+	for _, b := range boxes {
+		if b.typ == typ {
+			return b.data
+		}
+	}
+	return nil
+}
+
+// mp4Path returns the boxes inside the box at path below the boxes of b, or
+// none if it is missing.
+func mp4Path(b []byte, path ...string) ([]mp4Box, error) {
+	boxes, err := mp4Children(b)
+	for _, typ := range path {
+		if err != nil {
+			return nil, err
+		}
+		boxes, err = mp4Children(mp4First(boxes, typ))
+	}
+	return boxes, err
+}
+
+// boxReader reads big-endian fields from a box payload. Reads past the end
+// yield zeros and mark the reader short.
+type boxReader struct {
+	b     []byte
+	off   int
+	short bool
+}
+
+func (r *boxReader) next(n int) []byte {
+	if r.off+n > len(r.b) {
+		r.short = true
+		r.off = len(r.b)
+		return make([]byte, n)
+	}
+	b := r.b[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+func (r *boxReader) skip(n int)   { r.next(n) }
+func (r *boxReader) u8() uint8    { return r.next(1)[0] }
+func (r *boxReader) u16() uint16  { return binary.BigEndian.Uint16(r.next(2)) }
+func (r *boxReader) u32() uint32  { return binary.BigEndian.Uint32(r.next(4)) }
+func (r *boxReader) u64() uint64  { return binary.BigEndian.Uint64(r.next(8)) }
+func (r *boxReader) rest() []byte { return r.next(len(r.b) - r.off) }
+
+// err returns an error naming box typ if the reader ran short.
+func (r *boxReader) err(typ string) error {
+	if r.short {
+		return fmt.Errorf("mp4: truncated %q box", typ)
+	}
+	return nil
+}
+
+// parseMvhd returns the timescale and duration of a movie header.
+func parseMvhd(b []byte) (timescale uint32, duration uint64, err error) {
+	if b == nil {
+		return 0, 0, nil
+	}
+	r := boxReader{b: b}
+	if r.u8() == 1 {
+		r.skip(3 + 16)
+		timescale, duration = r.u32(), r.u64()
+	} else {
+		r.skip(3 + 8)
+		timescale, duration = r.u32(), uint64(r.u32())
+	}
+	return timescale, duration, r.err("mvhd")
+}
+
+// parseMehd returns the fragment duration of a movie extends header.
+func parseMehd(b []byte) (uint64, error) {
+	if b == nil {
+		return 0, nil
+	}
+	r := boxReader{b: b}
+	var d uint64
+	if r.u8() == 1 {
+		r.skip(3)
+		d = r.u64()
+	} else {
+		r.skip(3)
+		d = uint64(r.u32())
+	}
+	return d, r.err("mehd")
+}
+
+// mp4Track is what ParseMP4 reads from a trak box.
+type mp4Track struct {
+	id       uint32
+	handler  string
+	entry    string
+	language string
+	enabled  bool
+	rotation int
+	// width and height are the presentation size of the track header.
+	width, height int
+
+	timescale uint32
+	duration  uint64
+	// samples, sampleTime and sampleBytes total the sample table, and
+	// commonDelta is the duration of the most samples, counted by deltas.
+	samples     uint64
+	sampleTime  uint64
+	sampleBytes uint64
+	commonDelta uint32
+	deltas      map[uint32]uint64
+
+	channels   int
+	sampleRate int
+	visual     mp4Visual
+}
+
+// codec returns the FFmpeg codec name of the track's sample entry.
+func (t mp4Track) codec() string {
+	if c, ok := mp4Codecs[t.entry]; ok {
+		return c
+	}
+	return strings.TrimSpace(t.entry)
+}
+
+// bitrate returns the average bitrate of the track in kbps, or 0 if its
+// sample table is empty.
+func (t mp4Track) bitrate() int {
+	if t.timescale == 0 || t.duration == 0 || t.sampleBytes == 0 {
+		return 0
+	}
+	seconds := float64(t.duration) / float64(t.timescale)
+	return int(math.Round(float64(t.sampleBytes) * 8 / seconds / 1000))
+}
+
+func parseTrak(b []byte) (mp4Track, error) {
+	var t mp4Track
+	boxes, err := mp4Children(b)
+	if err != nil {
+		return t, err
+	}
+	if err := t.parseTkhd(mp4First(boxes, "tkhd")); err != nil {
+		return t, err
+	}
+
+	mdia, err := mp4Children(mp4First(boxes, "mdia"))
+	if err != nil {
+		return t, err
+	}
+	if err := t.parseMdhd(mp4First(mdia, "mdhd")); err != nil {
+		return t, err
+	}
+	if hdlr := mp4First(mdia, "hdlr"); hdlr != nil {
+		r := boxReader{b: hdlr}
+		r.skip(8)
+		t.handler = string(r.next(4))
+		if err := r.err("hdlr"); err != nil {
+			return t, err
+		}
+	}
+
+	stbl, err := mp4Path(mp4First(mdia, "minf"), "stbl")
+	if err != nil {
+		return t, err
+	}
+	if err := t.parseStsd(mp4First(stbl, "stsd")); err != nil {
+		return t, err
+	}
+	if err := t.parseStts(mp4First(stbl, "stts")); err != nil {
+		return t, err
+	}
+	return t, t.parseStsz(mp4First(stbl, "stsz"))
+}
+
+func (t *mp4Track) parseTkhd(b []byte) error {
+	if b == nil {
+		return nil
+	}
+	r := boxReader{b: b}
+	version := r.u8()
+	flags := uint32(r.u8())<<16 | uint32(r.u16())
+	if version == 1 {
+		r.skip(8 + 8)
+		t.id = r.u32()
+		r.skip(4 + 8)
+	} else {
+		r.skip(4 + 4)
+		t.id = r.u32()
+		r.skip(4 + 4)
+	}
+	r.skip(8 + 2 + 2 + 2 + 2)
+	// The display matrix is {a, b, u, c, d, v, x, y, w} in 16.16 fixed point;
+	// FFmpeg reports the rotation counterclockwise.
+	ma, mb := int32(r.u32()), int32(r.u32())
+	r.skip(7 * 4)
+	t.width, t.height = int(r.u32()>>16), int(r.u32()>>16)
+	t.enabled = flags&1 != 0
+	t.rotation = normalizeRotation(-int(math.Round(math.Atan2(float64(mb), float64(ma)) * 180 / math.Pi)))
+	return r.err("tkhd")
+}
+
+func (t *mp4Track) parseMdhd(b []byte) error {
+	if b == nil {
+		return nil
+	}
+	r := boxReader{b: b}
+	if r.u8() == 1 {
+		r.skip(3 + 16)
+		t.timescale, t.duration = r.u32(), r.u64()
+	} else {
+		r.skip(3 + 8)
+		t.timescale, t.duration = r.u32(), uint64(r.u32())
+	}
+	t.language = mp4Language(r.u16())
+	return r.err("mdhd")
+}
+
+// mp4Language decodes the packed ISO 639-2 code of a media header. Older
+// QuickTime files use Macintosh language codes instead, which are ignored.
+func mp4Language(code uint16) string {
+	if code < 0x400 {
+		return ""
+	}
+	lang := []byte{byte(code>>10&31) + 0x60, byte(code>>5&31) + 0x60, byte(code&31) + 0x60}
+	for _, c := range lang {
+		if c < 'a' || c > 'z' {
+			return ""
+		}
+	}
+	return string(lang)
+}
+
+// parseStsd reads the first sample entry of a sample description.
+func (t *mp4Track) parseStsd(b []byte) error {
+	if b == nil {
+		return nil
+	}
+	r := boxReader{b: b}
+	r.skip(8)
+	if err := r.err("stsd"); err != nil {
+		return err
+	}
+	entries, err := mp4Children(r.rest())
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+	e := entries[0]
+	t.entry = e.typ
+	switch t.handler {
+	case "vide":
+		return t.visual.parse(e)
+	case "soun":
+		return t.parseAudioEntry(e)
+	}
+	return nil
+}
+
+// parseAudioEntry reads an AudioSampleEntry, or a QuickTime sound
+// description of version 1 or 2.
+func (t *mp4Track) parseAudioEntry(e mp4Box) error {
+	r := boxReader{b: e.data}
+	r.skip(6 + 2)
+	version := r.u16()
+	r.skip(2 + 4)
+	t.channels = int(r.u16())
+	r.skip(2 + 2 + 2)
+	t.sampleRate = int(r.u32() >> 16)
+	if version == 2 {
+		r.skip(4)
+		t.sampleRate = int(math.Round(math.Float64frombits(r.u64())))
+		t.channels = int(r.u32())
+	}
+	return r.err(e.typ)
+}
+
+// parseStts totals the samples and their durations from a decoding time
+// table.
+func (t *mp4Track) parseStts(b []byte) error {
+	if b == nil {
+		return nil
+	}
+	r := boxReader{b: b}
+	r.skip(4)
+	n := r.u32()
+	if uint64(n)*8 > uint64(len(b)) {
+		return fmt.Errorf("mp4: truncated %q box", "stts")
+	}
+	for range n {
+		count, delta := r.u32(), r.u32()
+		t.addSamples(uint64(count), delta)
+	}
+	return r.err("stts")
+}
+
+// addSamples adds count samples of duration delta to the track's timing.
+func (t *mp4Track) addSamples(count uint64, delta uint32) {
+	if t.deltas == nil {
+		t.deltas = make(map[uint32]uint64)
+	}
+	t.samples += count
+	t.sampleTime += count * uint64(delta)
+	t.deltas[delta] += count
+	if delta > 0 && (t.commonDelta == 0 || t.deltas[delta] > t.deltas[t.commonDelta]) {
+		t.commonDelta = delta
+	}
+}
+
+// parseTrex returns the default sample duration of each track from the track
+// extends boxes of a movie extends box.
+func parseTrex(mvex []mp4Box) (map[uint32]uint32, error) {
+	durations := make(map[uint32]uint32)
+	for _, b := range mvex {
+		if b.typ != "trex" {
+			continue
+		}
+		r := boxReader{b: b.data}
+		r.skip(4)
+		id := r.u32()
+		r.skip(4)
+		durations[id] = r.u32()
+		if err := r.err("trex"); err != nil {
+			return nil, err
+		}
+	}
+	return durations, nil
+}
+
+// parseMoof adds the samples of the track's runs in a movie fragment to its
+// timing. Samples without a duration of their own take the default of the
+// track fragment header, or else defaultDuration from the track extends box.
+func (t *mp4Track) parseMoof(moof []byte, defaultDuration uint32) error {
+	boxes, err := mp4Children(moof)
+	if err != nil {
+		return err
+	}
+	for _, b := range boxes {
+		if b.typ != "traf" {
+			continue
+		}
+		traf, err := mp4Children(b.data)
+		if err != nil {
+			return err
+		}
+		r := boxReader{b: mp4First(traf, "tfhd")}
+		flags := r.u32() & 0xffffff
+		if r.u32() != t.id {
+			continue
+		}
+		duration := defaultDuration
+		if flags&0x01 != 0 {
+			r.skip(8)
+		}
+		if flags&0x02 != 0 {
+			r.skip(4)
+		}
+		if flags&0x08 != 0 {
+			duration = r.u32()
+		}
+		if err := r.err("tfhd"); err != nil {
+			return err
+		}
+		for _, run := range traf {
+			if run.typ != "trun" {
+				continue
+			}
+			if err := t.parseTrun(run.data, duration); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseTrun adds the samples of a track run to the track's timing.
+func (t *mp4Track) parseTrun(b []byte, defaultDuration uint32) error {
+	r := boxReader{b: b}
+	flags := r.u32() & 0xffffff
+	n := r.u32()
+	if flags&0x01 != 0 {
+		r.skip(4)
+	}
+	if flags&0x04 != 0 {
+		r.skip(4)
+	}
+	// Each sample may carry a duration, size, flags and composition offset.
+	fields := 0
+	for _, f := range []uint32{0x100, 0x200, 0x400, 0x800} {
+		if flags&f != 0 {
+			fields++
+		}
+	}
+	if r.short || uint64(n)*uint64(fields)*4 > uint64(len(b)-r.off) {
+		return fmt.Errorf("mp4: truncated %q box", "trun")
+	}
+	if flags&0x100 == 0 {
+		t.addSamples(uint64(n), defaultDuration)
+		return nil
+	}
+	for range n {
+		t.addSamples(1, r.u32())
+		r.skip((fields - 1) * 4)
+	}
+	return r.err("trun")
+}
+
+// parseStsz totals the sample sizes of a sample size table.
+func (t *mp4Track) parseStsz(b []byte) error {
+	if b == nil {
+		return nil
+	}
+	r := boxReader{b: b}
+	r.skip(4)
+	size, count := r.u32(), r.u32()
+	if size != 0 {
+		t.sampleBytes = uint64(size) * uint64(count)
+		return r.err("stsz")
+	}
+	if uint64(count)*4 > uint64(len(b)) {
+		return fmt.Errorf("mp4: truncated %q box", "stsz")
+	}
+	for range count {
+		t.sampleBytes += uint64(r.u32())
+	}
+	return r.err("stsz")
+}
+
+// mp4Visual is what ParseMP4 reads from a VisualSampleEntry and the boxes
+// inside it.
+type mp4Visual struct {
+	width, height int
+	profile       string
+	level         int
+	bitDepth      int
+	fieldOrder    string
+	sar           AspectRatio
+
+	primaries, transfer, matrix, colorRange string
+
+	mastering *MasteringDisplay
+	light     *ContentLightLevel
+}
+
+func (v *mp4Visual) parse(e mp4Box) error {
+	r := boxReader{b: e.data}
+	r.skip(6 + 2 + 16)
+	v.width, v.height = int(r.u16()), int(r.u16())
+	r.skip(4 + 4 + 4 + 2 + 32 + 2 + 2)
+	if err := r.err(e.typ); err != nil {
+		return err
+	}
+	boxes, err := mp4Children(r.rest())
+	if err != nil {
+		return err
+	}
+
+	for _, b := range boxes {
+		r := boxReader{b: b.data}
+		switch b.typ {
+		case "avcC":
+			r.skip(1)
+			idc, compat := r.u8(), r.u8()
+			v.profile, v.level = avcProfile(idc, compat), int(r.u8())
+			v.bitDepth = avcBitDepth(b.data, idc)
+		case "hvcC":
+			r.skip(1)
+			v.profile = hevcProfiles[r.u8()&31]
+			r.skip(4 + 6)
+			v.level = int(r.u8())
+			r.skip(2 + 1 + 1)
+			v.bitDepth = int(r.u8()&7) + 8
+		case "av1C":
+			r.skip(1)
+			b1, b2 := r.u8(), r.u8()
+			v.profile, v.level = av1Profiles[b1>>5], int(b1&31)
+			switch {
+			case b2&0x40 == 0:
+				v.bitDepth = 8
+			case b2&0x20 != 0:
+				v.bitDepth = 12
+			default:
+				v.bitDepth = 10
+			}
+		case "pasp":
+			v.sar = AspectRatio{Num: int(r.u32()), Den: int(r.u32())}
+		case "colr":
+			kind := string(r.next(4))
+			if kind != "nclx" && kind != "nclc" {
+				continue
+			}
+			p, t, m := r.u16(), r.u16(), r.u16()
+			v.primaries, v.transfer, v.matrix = mp4Primaries[p], mp4Transfers[t], mp4Matrices[m]
+			if kind == "nclx" {
+				v.colorRange = "tv"
+				if r.u8()&0x80 != 0 {
+					v.colorRange = "pc"
+				}
+			}
+		case "fiel":
+			fields, detail := r.u8(), r.u8()
+			switch fields {
+			case 1:
+				v.fieldOrder = "progressive"
+			case 2:
+				v.fieldOrder = mp4FieldOrders[detail]
+			}
+		case "mdcv":
+			// Primaries are in the order green, blue, red, in units of 0.00002,
+			// and luminance in units of 0.0001 cd/m².
+			var xy [8]float64
+			for i := range xy {
+				xy[i] = float64(r.u16()) * 0.00002
+			}
+			maxLum, minLum := float64(r.u32())*0.0001, float64(r.u32())*0.0001
+			v.mastering = &MasteringDisplay{
+				GreenX: xy[0], GreenY: xy[1], BlueX: xy[2], BlueY: xy[3], RedX: xy[4], RedY: xy[5],
+				WhiteX: xy[6], WhiteY: xy[7], MinLuminance: minLum, MaxLuminance: maxLum,
+			}
+		case "clli":
+			v.light = &ContentLightLevel{MaxCLL: int(r.u16()), MaxFALL: int(r.u16())}
+		}
+		if err := r.err(b.typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	hevcProfiles = map[uint8]string{1: "Main", 2: "Main 10", 3: "Main Still Picture", 4: "Rext"}
+	av1Profiles  = map[uint8]string{0: "Main", 1: "High", 2: "Professional"}
+)
+
+// avcProfile names an H.264 profile_idc as FFmpeg does.
+func avcProfile(idc, compat uint8) string {
+	switch idc {
+	case 66:
+		// constraint_set1_flag
+		if compat&0x40 != 0 {
+			return "Constrained Baseline"
+		}
+		return "Baseline"
+	case 77:
+		return "Main"
+	case 88:
+		return "Extended"
+	case 100:
+		return "High"
+	case 110:
+		return "High 10"
+	case 122:
+		return "High 4:2:2"
+	case 244:
+		return "High 4:4:4 Predictive"
+	default:
+		return ""
+	}
+}
+
+// avcBitDepth reads the luma bit depth from the extension of an avcC box that
+// follows its parameter sets in the High profiles, or returns 8.
+func avcBitDepth(b []byte, idc uint8) int {
+	switch idc {
+	case 100, 110, 122, 144, 244:
+	default:
+		return 8
+	}
+	r := boxReader{b: b}
+	r.skip(5)
+	for _, mask := range []uint8{31, 255} {
+		for range r.u8() & mask {
+			r.skip(int(r.u16()))
+		}
+	}
+	r.skip(1)
+	depth := int(r.u8()&7) + 8
+	if r.short {
+		return 8
+	}
+	return depth
+}
+
+// channelLayout names the layout of mono and stereo tracks; others depend
+// on the codec configuration and are left empty.
+func channelLayout(channels int) string {
+	switch channels {
+	case 1:
+		return "mono"
+	case 2:
+		return "stereo"
+	default:
+		return ""
+	}
+}
+
+// reduceRatio returns num:den in lowest terms.
+func reduceRatio(num, den int) AspectRatio {
+	if num <= 0 || den <= 0 {
+		return AspectRatio{}
+	}
+	a, b := num, den
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return AspectRatio{Num: num / a, Den: den / a}
+}
@@ -0,0 +1,369 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/farshidrezaei/mosaic/internal/executor"
+)
+
+// mp4Test builds an ISO base media box of type typ around its payload parts.
+func mp4Test(typ string, parts ...[]byte) []byte {
+	payload := slices.Concat(parts...)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(b, typ...), payload...)
+}
+
+// be encodes big-endian fields: uint8, uint16, uint32 and uint64 values, and
+// ints as uint32.
+func be(fields ...any) []byte {
+	var b []byte
+	for _, f := range fields {
+		switch v := f.(type) {
+		case uint8:
+			b = append(b, v)
+		case uint16:
+			b = binary.BigEndian.AppendUint16(b, v)
+		case uint32:
+			b = binary.BigEndian.AppendUint32(b, v)
+		case uint64:
+			b = binary.BigEndian.AppendUint64(b, v)
+		case int:
+			b = binary.BigEndian.AppendUint32(b, uint32(v))
+		}
+	}
+	return b
+}
+
+// tkhdTest builds a version 0 track header with a display matrix of
+// {a, b, c, d} and a presentation size.
+func tkhdTest(flags uint32, a, b, c, d int32, width, height int) []byte {
+	matrix := be(uint32(a), uint32(b), uint32(0), uint32(c), uint32(d), uint32(0), uint32(0), uint32(0), uint32(0x40000000))
+	return mp4Test("tkhd", be(flags), make([]byte, 20), make([]byte, 16), matrix, be(width<<16, height<<16))
+}
+
+// trakTest builds a track with a version 0 media header (timescale,
+// duration, packed language), a handler, a sample entry and its sample table.
+func trakTest(tkhd []byte, timescale, duration uint32, lang uint16, handler string, entry, stts, stsz []byte) []byte {
+	mdhd := mp4Test("mdhd", make([]byte, 12), be(timescale, duration, lang, uint16(0)))
+	hdlr := mp4Test("hdlr", make([]byte, 8), []byte(handler), make([]byte, 13))
+	stbl := mp4Test("stbl", mp4Test("stsd", be(uint32(0), uint32(1)), entry), stts, stsz)
+	return mp4Test("trak", tkhd, mp4Test("mdia", mdhd, hdlr, mp4Test("minf", stbl)))
+}
+
+// visualTest builds a VisualSampleEntry of the given size and child boxes.
+func visualTest(typ string, width, height uint16, boxes ...[]byte) []byte {
+	head := slices.Concat(make([]byte, 8+16), be(width, height), make([]byte, 4+4+4+2+32+2+2))
+	return mp4Test(typ, append([][]byte{head}, boxes...)...)
+}
+
+// audioTest builds a version 0 AudioSampleEntry.
+func audioTest(typ string, channels uint16, rate uint32) []byte {
+	return mp4Test(typ, make([]byte, 8), be(uint16(0), uint16(0), uint32(0), channels, uint16(16), uint16(0), uint16(0), rate<<16))
+}
+
+// mp4Lang packs an ISO 639-2 code as a media header stores it.
+func mp4Lang(s string) uint16 {
+	return uint16(s[0]-0x60)<<10 | uint16(s[1]-0x60)<<5 | uint16(s[2]-0x60)
+}
+
+// testMP4 is a 10 s file with a rotated, anamorphic, HDR10 HEVC track at
+// 30 fps, an English AAC track and a French mov_text track.
+func testMP4() []byte {
+	ftyp := mp4Test("ftyp", []byte("isom"), be(uint32(512)), []byte("isomiso2"))
+	mvhd := mp4Test("mvhd", make([]byte, 12), be(uint32(1000), uint32(10000)), make([]byte, 80))
+
+	hvcC := mp4Test("hvcC", be(uint8(1), uint8(2)), make([]byte, 10), be(uint8(123)), make([]byte, 4), be(uint8(2), uint8(0xfa), uint8(0xfa)), make([]byte, 4))
+	colr := mp4Test("colr", []byte("nclx"), be(uint16(9), uint16(16), uint16(9), uint8(0)))
+	mdcv := mp4Test("mdcv", be(uint16(13250), uint16(34500), uint16(7500), uint16(3000), uint16(34000), uint16(16000), uint16(15635), uint16(16450), uint32(10000000), uint32(50)))
+	clli := mp4Test("clli", be(uint16(1000), uint16(400)))
+	pasp := mp4Test("pasp", be(uint32(4), uint32(3)))
+	fiel := mp4Test("fiel", be(uint8(1), uint8(0)))
+	// 300 frames of 1/30 s in about 1.25 MB: 1000 kbps.
+	video := trakTest(
+		tkhdTest(3, 0, 0x10000, -0x10000, 0, 1920, 1080),
+		30000, 300000, mp4Lang("und"), "vide",
+		visualTest("hvc1", 1440, 1080, hvcC, colr, mdcv, clli, pasp, fiel),
+		mp4Test("stts", be(uint32(0), uint32(1), uint32(300), uint32(1000))),
+		mp4Test("stsz", be(uint32(0), uint32(4166), uint32(300))),
+	)
+	audio := trakTest(
+		tkhdTest(1, 0x10000, 0, 0, 0x10000, 0, 0),
+		48000, 480000, mp4Lang("eng"), "soun",
+		audioTest("mp4a", 2, 48000),
+		mp4Test("stts", be(uint32(0), uint32(1), uint32(469), uint32(1024))),
+		mp4Test("stsz", be(uint32(0), uint32(0), uint32(2)), be(uint32(80000), uint32(80000))),
+	)
+	subs := trakTest(
+		tkhdTest(0, 0x10000, 0, 0, 0x10000, 0, 0),
+		1000, 10000, mp4Lang("fra"), "sbtl",
+		mp4Test("tx3g", make([]byte, 8)),
+		nil, nil,
+	)
+	moov := mp4Test("moov", mvhd, video, audio, subs)
+	return slices.Concat(ftyp, moov, mp4Test("mdat", make([]byte, 64)))
+}
+
+func TestParseMP4(t *testing.T) {
+	file := testMP4()
+	got, err := ParseMP4(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("ParseMP4() error = %v", err)
+	}
+
+	if got.Format != "mov,mp4,m4a,3gp,3g2,mj2" || got.Duration != 10 || got.FormatBitrate != int(math.Round(float64(len(file))*8/10/1000)) {
+		t.Errorf("format: got %q %v %d", got.Format, got.Duration, got.FormatBitrate)
+	}
+	if got.Width != 1440 || got.Height != 1080 || got.Rotation != 270 {
+		t.Errorf("size: got %dx%d rotated %d, want 1440x1080 rotated 270", got.Width, got.Height, got.Rotation)
+	}
+	if got.SampleAspectRatio != (AspectRatio{4, 3}) || got.DisplayAspectRatio != (AspectRatio{16, 9}) {
+		t.Errorf("aspect ratios: got SAR %v DAR %v", got.SampleAspectRatio, got.DisplayAspectRatio)
+	}
+	if got.DisplayWidth() != 1080 || got.DisplayHeight() != 1920 {
+		t.Errorf("display size: got %dx%d, want 1080x1920", got.DisplayWidth(), got.DisplayHeight())
+	}
+	if got.Codec != "hevc" || got.Profile != "Main 10" || got.LevelName() != "4.1" || got.BitDepth != 10 {
+		t.Errorf("codec: got %q %q %q %d-bit", got.Codec, got.Profile, got.LevelName(), got.BitDepth)
+	}
+	if got.FPS != 30 || got.BaseFPS != 30 || got.FieldOrder != "progressive" || got.Bitrate != 1000 {
+		t.Errorf("timing: got %v fps, base %v, %q, %d kbps", got.FPS, got.BaseFPS, got.FieldOrder, got.Bitrate)
+	}
+	if got.VideoRange() != "PQ" || got.ColorPrimaries != "bt2020" || got.ColorSpace != "bt2020nc" || got.ColorRange != "tv" {
+		t.Errorf("color: got %s %q %q %q", got.VideoRange(), got.ColorPrimaries, got.ColorSpace, got.ColorRange)
+	}
+	wantMastering := MasteringDisplay{
+		RedX: 0.68, RedY: 0.32, GreenX: 0.265, GreenY: 0.69, BlueX: 0.15, BlueY: 0.06,
+		WhiteX: 0.3127, WhiteY: 0.329, MinLuminance: 0.005, MaxLuminance: 1000,
+	}
+	if got.MasteringDisplay == nil || !approxMastering(*got.MasteringDisplay, wantMastering) {
+		t.Errorf("MasteringDisplay: got %+v, want %+v", got.MasteringDisplay, wantMastering)
+	}
+	if got.ContentLightLevel == nil || *got.ContentLightLevel != (ContentLightLevel{MaxCLL: 1000, MaxFALL: 400}) {
+		t.Errorf("ContentLightLevel: got %+v", got.ContentLightLevel)
+	}
+
+	wantAudio := []AudioStream{{Index: 1, Codec: "aac", Language: "eng", Channels: 2, ChannelLayout: "stereo", Bitrate: 128, SampleRate: 48000, Default: true}}
+	if !got.HasAudio || !slices.Equal(got.AudioStreams, wantAudio) {
+		t.Errorf("AudioStreams: got %+v, want %+v", got.AudioStreams, wantAudio)
+	}
+	wantSubs := []SubtitleStream{{Index: 2, Codec: "mov_text", Language: "fra"}}
+	if !slices.Equal(got.SubtitleStreams, wantSubs) {
+		t.Errorf("SubtitleStreams: got %+v, want %+v", got.SubtitleStreams, wantSubs)
+	}
+}
+
+func approxMastering(a, b MasteringDisplay) bool {
+	x := []float64{a.RedX, a.RedY, a.GreenX, a.GreenY, a.BlueX, a.BlueY, a.WhiteX, a.WhiteY, a.MinLuminance, a.MaxLuminance}
+	y := []float64{b.RedX, b.RedY, b.GreenX, b.GreenY, b.BlueX, b.BlueY, b.WhiteX, b.WhiteY, b.MinLuminance, b.MaxLuminance}
+	for i := range x {
+		if d := x[i] - y[i]; d > 1e-9 || d < -1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseMP4Video(t *testing.T) {
+	// H.264 High 4.0 at 29.97 fps with five dropped frames, 8-bit.
+	avcC := mp4Test("avcC", be(uint8(1), uint8(100), uint8(0), uint8(40), uint8(0xff), uint8(0xe1), uint16(2), uint16(0)), be(uint8(1), uint16(1), uint8(0), uint8(1), uint8(0xf8), uint8(0xf8), uint8(0)))
+	stts := mp4Test("stts", be(uint32(0), uint32(2), uint32(90), uint32(1001), uint32(5), uint32(2002)))
+
+	tests := []struct {
+		name  string
+		tkhd  []byte
+		entry []byte
+		stts  []byte
+		check func(t *testing.T, got VideoInfo)
+		// mvex, if set, replaces the movie duration with a movie extends box
+		// of these boxes, and moof follows the movie box.
+		mvex []byte
+		moof []byte
+	}{
+		{
+			name:  "h264 variable frame rate",
+			tkhd:  tkhdTest(1, 0x10000, 0, 0, 0x10000, 1920, 1080),
+			entry: visualTest("avc1", 1920, 1080, avcC),
+			stts:  stts,
+			check: func(t *testing.T, got VideoInfo) {
+				if got.Codec != "h264" || got.Profile != "High" || got.LevelName() != "4.0" || got.BitDepth != 8 {
+					t.Errorf("codec: got %q %q %q %d-bit", got.Codec, got.Profile, got.LevelName(), got.BitDepth)
+				}
+				if got.BaseFPS != 30000.0/1001 || !got.VariableFrameRate() || got.Rotation != 0 {
+					t.Errorf("timing: got %v fps, base %v, rotation %d", got.FPS, got.BaseFPS, got.Rotation)
+				}
+				if got.HasAudio || got.SampleAspectRatio != (AspectRatio{}) || got.FieldOrder != "" {
+					t.Errorf("got audio %v, SAR %v, field order %q", got.HasAudio, got.SampleAspectRatio, got.FieldOrder)
+				}
+			},
+		},
+		{
+			name:  "upside down with track header size",
+			tkhd:  tkhdTest(1, -0x10000, 0, 0, -0x10000, 1280, 720),
+			entry: visualTest("av01", 0, 0, mp4Test("av1C", be(uint8(0x81), uint8(8), uint8(0x40), uint8(0)))),
+			check: func(t *testing.T, got VideoInfo) {
+				if got.Width != 1280 || got.Height != 720 || got.Rotation != 180 {
+					t.Errorf("size: got %dx%d rotated %d", got.Width, got.Height, got.Rotation)
+				}
+				if got.Codec != "av1" || got.Profile != "Main" || got.LevelName() != "4.0" || got.BitDepth != 10 {
+					t.Errorf("codec: got %q %q %q %d-bit", got.Codec, got.Profile, got.LevelName(), got.BitDepth)
+				}
+				if got.FPS != 0 || got.BaseFPS != 0 {
+					t.Errorf("unknown frame rate: got %v, base %v", got.FPS, got.BaseFPS)
+				}
+			},
+		},
+		{
+			name:  "fragmented",
+			tkhd:  tkhdTest(1, 0x10000, 0, 0, 0x10000, 640, 360),
+			entry: visualTest("apcn", 640, 360, mp4Test("fiel", be(uint8(2), uint8(6)))),
+			mvex:  mp4Test("trex", be(uint32(0), uint32(0), uint32(1), uint32(1200), uint32(0), uint32(0))),
+			check: func(t *testing.T, got VideoInfo) {
+				if got.Codec != "prores" || got.Duration != 4 || got.Bitrate != got.FormatBitrate || got.Bitrate == 0 {
+					t.Errorf("got %q, %v s, %d kbps of %d", got.Codec, got.Duration, got.Bitrate, got.FormatBitrate)
+				}
+				if !got.Interlaced() || got.FieldOrder != "bb" {
+					t.Errorf("FieldOrder: got %q", got.FieldOrder)
+				}
+				if got.FPS != 25 || got.BaseFPS != 25 {
+					t.Errorf("default sample duration: got %v fps, base %v", got.FPS, got.BaseFPS)
+				}
+			},
+		},
+		{
+			name:  "fragment sample durations",
+			tkhd:  tkhdTest(1, 0x10000, 0, 0, 0x10000, 640, 360),
+			entry: visualTest("avc1", 640, 360, avcC),
+			mvex:  mp4Test("trex", be(uint32(0), uint32(0), uint32(1), uint32(1200), uint32(0), uint32(0))),
+			moof: slices.Concat(
+				mp4Test("mfhd", be(uint32(0), uint32(1))),
+				// Another track's samples are ignored.
+				mp4Test("traf",
+					mp4Test("tfhd", be(uint32(0), uint32(2))),
+					mp4Test("trun", be(uint32(0x100), uint32(2), uint32(1), uint32(1))),
+				),
+				mp4Test("traf",
+					mp4Test("tfhd", be(uint32(0x08), uint32(0), uint32(1001))),
+					mp4Test("trun", be(uint32(0x01), uint32(3), uint32(0))),
+					mp4Test("trun", be(uint32(0x300), uint32(2), uint32(2002), uint32(100), uint32(2002), uint32(100))),
+				),
+			),
+			check: func(t *testing.T, got VideoInfo) {
+				if got.FPS != 5*30000.0/7007 || got.BaseFPS != 30000.0/1001 {
+					t.Errorf("timing: got %v fps, base %v", got.FPS, got.BaseFPS)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mvhd := mp4Test("mvhd", make([]byte, 12), be(uint32(1000), uint32(3336)), make([]byte, 80))
+			if tt.mvex != nil {
+				mvhd = slices.Concat(
+					mp4Test("mvhd", make([]byte, 12), be(uint32(1000), uint32(0)), make([]byte, 80)),
+					mp4Test("mvex", mp4Test("mehd", be(uint32(0), uint32(4000))), tt.mvex),
+				)
+			}
+			trak := trakTest(tt.tkhd, 30000, 100100, 0, "vide", tt.entry, tt.stts, nil)
+			file := mp4Test("moov", mvhd, trak)
+			if tt.moof != nil {
+				file = append(file, mp4Test("moof", tt.moof)...)
+			}
+			got, err := ParseMP4(bytes.NewReader(file), int64(len(file)))
+			if err != nil {
+				t.Fatalf("ParseMP4() error = %v", err)
+			}
+			tt.check(t, got)
+		})
+	}
+}
+
+func TestParseMP4Errors(t *testing.T) {
+	file := testMP4()
+	audioOnly := mp4Test("moov", mp4Test("mvhd", make([]byte, 12), be(uint32(1000), uint32(1000)), make([]byte, 80)),
+		trakTest(tkhdTest(1, 0x10000, 0, 0, 0x10000, 0, 0), 48000, 48000, 0, "soun", audioTest("mp4a", 2, 48000), nil, nil))
+	fragmented := mp4Test("moov", mp4Test("mvhd", make([]byte, 12), be(uint32(1000), uint32(0)), make([]byte, 80)),
+		trakTest(tkhdTest(1, 0x10000, 0, 0, 0x10000, 640, 360), 30000, 0, 0, "vide", visualTest("avc1", 640, 360), nil, nil))
+	bad := slices.Clone(file)
+	// Point the size of the ftyp box past the end of the file.
+	binary.BigEndian.PutUint32(bad, uint32(len(file)+1))
+
+	tests := []struct {
+		name   string
+		file   []byte
+		notMP4 bool
+	}{
+		{name: "matroska", file: []byte{0x1a, 0x45, 0xdf, 0xa3, 0x93, 0x42, 0x82, 0x88, 'm', 'a', 't', 'r'}, notMP4: true},
+		{name: "empty", file: nil, notMP4: true},
+		{name: "truncated upload", file: file[:len(file)-10]},
+		{name: "no moov", file: mp4Test("ftyp", []byte("isom"), be(uint32(0)))},
+		{name: "bad box size", file: bad},
+		{name: "truncated track header", file: mp4Test("moov", mp4Test("trak", mp4Test("tkhd", be(uint32(0)))))},
+		{name: "no video", file: audioOnly},
+		{name: "truncated track run", file: slices.Concat(fragmented, mp4Test("moof", mp4Test("traf", mp4Test("tfhd", be(uint32(0), uint32(0))), mp4Test("trun", be(uint32(0x100), uint32(3), uint32(1001))))))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMP4(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if errors.Is(err, ErrNotMP4) != tt.notMP4 {
+				t.Errorf("errors.Is(%v, ErrNotMP4) = %v, want %v", err, !tt.notMP4, tt.notMP4)
+			}
+		})
+	}
+}
+
+func TestMP4Probe(t *testing.T) {
+	dir := t.TempDir()
+	mp4 := filepath.Join(dir, "in.mp4")
+	mkv := filepath.Join(dir, "in.mkv")
+	if err := os.WriteFile(mp4, testMP4(), 0o644); err != nil {
+		t.Fatalf("write mp4: %v", err)
+	}
+	if err := os.WriteFile(mkv, []byte{0x1a, 0x45, 0xdf, 0xa3, 0, 0, 0, 0}, 0o644); err != nil {
+		t.Fatalf("write mkv: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		input     string
+		wantCodec string
+		wantCalls int
+		wantErr   bool
+	}{
+		{name: "mp4 without ffprobe", input: mp4, wantCodec: "hevc"},
+		{name: "other container falls back", input: mkv, wantCodec: "vp9", wantCalls: 1},
+		{name: "url falls back", input: "https://example.com/in.mp4", wantCodec: "vp9", wantCalls: 1},
+		{name: "missing file", input: filepath.Join(dir, "missing.mp4"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffprobe"] = executor.MockResponse{Output: []byte(`{"streams":[{"codec_type":"video","codec_name":"vp9","width":1920,"height":1080}]}`)}
+
+			var p Prober = MP4{Fallback: FFprobe{Executor: mock}}
+			got, err := p.Probe(context.Background(), tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Probe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.Codec != tt.wantCodec {
+				t.Errorf("Codec: got %q, want %q", got.Codec, tt.wantCodec)
+			}
+			if calls := mock.GetCallCount("ffprobe"); calls != tt.wantCalls {
+				t.Errorf("ffprobe calls: got %d, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -2,16 +2,18 @@ package probe
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"math"
+	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/farshidrezaei/mosaic/config"
 	"github.com/farshidrezaei/mosaic/internal/executor"
 )
 
-// VideoInfo contains technical metadata about a video file extracted via ffprobe.
+// VideoInfo contains technical metadata about a video file extracted via
+// ffprobe or, for MP4 and MOV files, ParseMP4.
 type VideoInfo struct {
 	// Width is the horizontal resolution in pixels.
 	Width int
@@ -19,28 +21,287 @@ type VideoInfo struct {
 	Height int
 	// FPS is the average frame rate of the video (e.g., 23.976, 30.0, 60.0).
 	FPS float64
+	// BaseFPS is the base frame rate of the video (ffprobe r_frame_rate): the
+	// lowest rate that represents every timestamp, or 0 if unknown. It differs
+	// from FPS for variable frame rate sources.
+	BaseFPS float64
+	// FieldOrder is the field order of the video: "progressive", "tt" or "bb"
+	// (top or bottom field first), "tb" or "bt", or empty if unknown.
+	FieldOrder string
+	// Codec is the FFmpeg video codec name (e.g., "h264", "hevc", "prores").
+	Codec string
+	// Profile is the codec profile name (e.g., "High", "Main 10").
+	Profile string
+	// Level is the codec level as ffprobe reports it (41 for H.264 level 4.1,
+	// 123 for HEVC level 4.1), or 0 if unknown. AV1 levels are the
+	// seq_level_idx, where 0 is level 2.0 and 31 is unknown. LevelName formats
+	// it.
+	Level int
+	// Format is the container format as ffprobe names it, e.g.
+	// "mov,mp4,m4a,3gp,3g2,mj2" or "matroska,webm".
+	Format string
+	// FormatBitrate is the overall container bitrate in kbps, or 0 if unknown.
+	FormatBitrate int
+	// Duration is the container duration in seconds, or 0 if unknown.
+	Duration float64
+	// Bitrate is the video stream bitrate in kbps, or 0 if unknown. When the
+	// stream does not declare it, it is estimated from the container bitrate
+	// minus the declared audio bitrates.
+	Bitrate int
 	// HasAudio is true if the video file contains at least one audio stream.
 	HasAudio bool
+	// ClosedCaptions is true if the video stream carries embedded CEA-608/708
+	// captions (ATSC A/53 user data in the H.264/HEVC SEI).
+	ClosedCaptions bool
+	// PixelFormat is the FFmpeg pixel format (e.g., "yuv420p", "yuv420p10le").
+	PixelFormat string
+	// ColorSpace is the matrix coefficients name (e.g., "bt709", "bt2020nc").
+	ColorSpace string
+	// ColorTransfer is the transfer characteristics name (e.g., "bt709",
+	// "smpte2084" for PQ, "arib-std-b67" for HLG).
+	ColorTransfer string
+	// ColorPrimaries is the color primaries name (e.g., "bt709", "bt2020").
+	ColorPrimaries string
+	// ColorRange is "tv" (limited) or "pc" (full), empty if unspecified.
+	ColorRange string
+	// BitDepth is the luma sample bit depth (8, 10 or 12).
+	BitDepth int
+	// MasteringDisplay is the SMPTE ST 2086 mastering display color volume,
+	// or nil if the container does not carry it.
+	MasteringDisplay *MasteringDisplay
+	// ContentLightLevel is the CTA-861.3 content light level, or nil if the
+	// container does not carry it.
+	ContentLightLevel *ContentLightLevel
+	// SampleAspectRatio is the shape of a stored pixel, e.g. 64:45 for
+	// anamorphic PAL widescreen, or zero if unknown.
+	SampleAspectRatio AspectRatio
+	// DisplayAspectRatio is the aspect ratio of the displayed frame before
+	// rotation, e.g. 16:9, or zero if unknown.
+	DisplayAspectRatio AspectRatio
 	// Rotation is the normalized clockwise rotation in degrees (0, 90, 180, 270).
 	Rotation int
+	// AudioStreams lists every audio stream in the file, in stream order.
+	AudioStreams []AudioStream
+	// SubtitleStreams lists every subtitle stream in the file, in stream order,
+	// including bitmap formats that cannot be converted to text.
+	SubtitleStreams []SubtitleStream
 }
 
-// DisplayWidth returns the effective display width after applying rotation metadata.
+// AudioStream describes a single audio stream of the source file.
+type AudioStream struct {
+	// Codec is the FFmpeg codec name (e.g., "aac", "ac3").
+	Codec string
+	// Language is the ISO 639-2 language tag (e.g., "eng"), empty if untagged.
+	Language string
+	// Title is the stream title tag, empty if untagged.
+	Title string
+	// ChannelLayout is the FFmpeg channel layout name (e.g., "stereo", "5.1").
+	ChannelLayout string
+	// Index is the absolute stream index within the file.
+	Index int
+	// Channels is the number of audio channels.
+	Channels int
+	// Bitrate is the stream bitrate in kbps, or 0 if unknown.
+	Bitrate int
+	// SampleRate is the sample rate in Hz (e.g., 48000), or 0 if unknown.
+	SampleRate int
+	// Default is true if the stream carries the default disposition.
+	Default bool
+}
+
+// MasteringDisplay is the SMPTE ST 2086 mastering display color volume of an HDR stream.
+type MasteringDisplay struct {
+	// RedX through WhiteY are CIE 1931 xy chromaticity coordinates.
+	RedX, RedY     float64
+	GreenX, GreenY float64
+	BlueX, BlueY   float64
+	WhiteX, WhiteY float64
+	// MinLuminance and MaxLuminance are in cd/m².
+	MinLuminance float64
+	MaxLuminance float64
+}
+
+// ContentLightLevel is the CTA-861.3 content light level of an HDR stream.
+type ContentLightLevel struct {
+	// MaxCLL is the maximum content light level in cd/m².
+	MaxCLL int
+	// MaxFALL is the maximum frame-average light level in cd/m².
+	MaxFALL int
+}
+
+// AspectRatio is a width:height ratio such as 16:9. The zero value means
+// unknown.
+type AspectRatio struct {
+	Num int
+	Den int
+}
+
+// Float returns the ratio as a number, or 0 if it is unknown.
+func (r AspectRatio) Float() float64 {
+	if r.Num <= 0 || r.Den <= 0 {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+// String formats the ratio as "num:den".
+func (r AspectRatio) String() string {
+	return fmt.Sprintf("%d:%d", r.Num, r.Den)
+}
+
+// SubtitleStream describes a single subtitle stream of the source file.
+type SubtitleStream struct {
+	// Codec is the FFmpeg codec name (e.g., "subrip", "mov_text", "hdmv_pgs_subtitle").
+	Codec string
+	// Language is the ISO 639-2 language tag (e.g., "eng"), empty if untagged.
+	Language string
+	// Title is the stream title tag, empty if untagged.
+	Title string
+	// Index is the absolute stream index within the file.
+	Index int
+	// Default is true if the stream carries the default disposition.
+	Default bool
+	// Forced is true if the stream carries the forced disposition.
+	Forced bool
+	// HearingImpaired is true if the stream is flagged as SDH.
+	HearingImpaired bool
+}
+
+// textSubtitleCodecs are the subtitle codecs FFmpeg can convert to WebVTT.
+var textSubtitleCodecs = map[string]bool{
+	"ass":      true,
+	"mov_text": true,
+	"ssa":      true,
+	"subrip":   true,
+	"text":     true,
+	"webvtt":   true,
+}
+
+// IsText reports whether the stream is text-based and can be converted to WebVTT.
+func (s SubtitleStream) IsText() bool {
+	return textSubtitleCodecs[s.Codec]
+}
+
+// DisplayWidth returns the effective display width after applying the pixel
+// aspect ratio and rotation metadata.
 func (v VideoInfo) DisplayWidth() int {
 	if v.Rotation%180 != 0 {
 		return v.Height
 	}
-	return v.Width
+	return v.squareWidth()
 }
 
-// DisplayHeight returns the effective display height after applying rotation metadata.
+// DisplayHeight returns the effective display height after applying the pixel
+// aspect ratio and rotation metadata.
 func (v VideoInfo) DisplayHeight() int {
 	if v.Rotation%180 != 0 {
-		return v.Width
+		return v.squareWidth()
 	}
 	return v.Height
 }
 
+// Anamorphic reports whether the stored pixels are not square, so the stored
+// and display sizes differ.
+func (v VideoInfo) Anamorphic() bool {
+	return v.squareWidth() != v.Width
+}
+
+// squareWidth returns the stored width stretched to square pixels: by the
+// sample aspect ratio or, when only the display aspect ratio is known, from
+// the height. Anamorphic sources keep their height, so 720x576 at 64:45 is
+// 1024x576.
+func (v VideoInfo) squareWidth() int {
+	if sar := v.SampleAspectRatio.Float(); sar > 0 {
+		return int(math.Round(float64(v.Width) * sar))
+	}
+	if dar := v.DisplayAspectRatio.Float(); dar > 0 && v.Height > 0 {
+		return int(math.Round(float64(v.Height) * dar))
+	}
+	return v.Width
+}
+
+// VideoRange returns the dynamic range signalled by the color transfer.
+func (v VideoInfo) VideoRange() config.VideoRange {
+	switch v.ColorTransfer {
+	case "smpte2084":
+		return config.VideoRangePQ
+	case "arib-std-b67":
+		return config.VideoRangeHLG
+	default:
+		return config.VideoRangeSDR
+	}
+}
+
+// Interlaced reports whether the video is stored as interlaced fields.
+func (v VideoInfo) Interlaced() bool {
+	switch v.FieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return true
+	default:
+		return false
+	}
+}
+
+// vfrTolerance is the relative difference between the average and base frame
+// rates above which a source is taken to have a variable frame rate.
+const vfrTolerance = 0.01
+
+// VariableFrameRate reports whether the average frame rate differs from the
+// base frame rate, as it does for phone recordings and screen captures with
+// dropped or irregular frames. It is false when either rate is unknown.
+func (v VideoInfo) VariableFrameRate() bool {
+	if v.FPS <= 0 || v.BaseFPS <= 0 {
+		return false
+	}
+	return math.Abs(v.FPS-v.BaseFPS) > vfrTolerance*v.BaseFPS
+}
+
+// av1UnknownLevel is the AV1 seq_level_idx of an unknown level.
+const av1UnknownLevel = 31
+
+// streamLevel returns the Level of a stream, mapping the negative level
+// ffprobe reports when it does not know one to the codec's unknown level.
+func streamLevel(s Stream) int {
+	if s.Level >= 0 {
+		return s.Level
+	}
+	if s.CodecName == "av1" {
+		return av1UnknownLevel
+	}
+	return 0
+}
+
+// LevelName formats Level in the notation of the codec: "4.1" for H.264 and
+// AV1, "4" or "4.1" for HEVC. It returns the bare number for other codecs and
+// an empty string if the level is unknown.
+func (v VideoInfo) LevelName() string {
+	if v.Codec == "av1" {
+		if v.Level < 0 || v.Level >= av1UnknownLevel {
+			return ""
+		}
+		// seq_level_idx counts four minor levels per major level from 2.0.
+		return fmt.Sprintf("%d.%d", 2+v.Level/4, v.Level%4)
+	}
+	if v.Level <= 0 {
+		return ""
+	}
+	switch v.Codec {
+	case "h264":
+		if v.Level == 9 {
+			return "1b"
+		}
+		return fmt.Sprintf("%d.%d", v.Level/10, v.Level%10)
+	case "hevc":
+		// general_level_idc is 30 times the level.
+		if v.Level%30 == 0 {
+			return strconv.Itoa(v.Level / 30)
+		}
+		return fmt.Sprintf("%d.%d", v.Level/30, v.Level%30/3)
+	default:
+		return strconv.Itoa(v.Level)
+	}
+}
+
 // IsPortrait reports whether the video is portrait in display orientation.
 func (v VideoInfo) IsPortrait() bool {
 	return v.DisplayHeight() > v.DisplayWidth()
@@ -54,67 +315,160 @@ func Input(ctx context.Context, input string) (VideoInfo, error) {
 
 // InputWithExecutor is like Input but allows providing a custom CommandExecutor.
 func InputWithExecutor(ctx context.Context, input string, exec executor.CommandExecutor) (VideoInfo, error) {
-	// Probe video stream
-	args := []string{
-		"-v", "error",
-		"-select_streams", "v:0",
-		"-show_entries", "stream=width,height,avg_frame_rate:stream_tags=rotate:stream_side_data=rotation",
-		"-of", "json",
-		input,
-	}
-	out, _, err := exec.Execute(ctx, "ffprobe", args...)
+	r, err := RunWithExecutor(ctx, input, exec)
 	if err != nil {
 		return VideoInfo{}, err
 	}
+	return r.VideoInfo()
+}
 
-	var data struct {
-		Streams []struct {
-			FPS  string `json:"avg_frame_rate"`
-			Tags struct {
-				Rotate string `json:"rotate"`
-			} `json:"tags"`
-			SideDataList []struct {
-				Rotation float64 `json:"rotation"`
-			} `json:"side_data_list"`
-			Width  int `json:"width"`
-			Height int `json:"height"`
-		} `json:"streams"`
-	}
+// Prober describes the video file or URL input.
+type Prober interface {
+	Probe(ctx context.Context, input string) (VideoInfo, error)
+}
 
-	err = json.Unmarshal(out, &data)
-	if err != nil {
-		return VideoInfo{}, err
+// FFprobe is the default Prober: it describes any input ffprobe can read, as
+// InputWithExecutor does.
+type FFprobe struct {
+	// Executor runs ffprobe. Nil uses the default command executor.
+	Executor executor.CommandExecutor
+}
+
+// Probe implements Prober.
+func (p FFprobe) Probe(ctx context.Context, input string) (VideoInfo, error) {
+	exec := p.Executor
+	if exec == nil {
+		exec = executor.DefaultExecutor
 	}
-	if len(data.Streams) == 0 {
+	return InputWithExecutor(ctx, input, exec)
+}
+
+// VideoInfo describes the first video stream of the result, with the
+// container, audio and subtitle streams around it.
+func (r Result) VideoInfo() (VideoInfo, error) {
+	s, ok := r.VideoStream()
+	if !ok {
 		return VideoInfo{}, fmt.Errorf("no video stream found")
 	}
 
 	info := VideoInfo{
-		Width:  data.Streams[0].Width,
-		Height: data.Streams[0].Height,
-		FPS:    parseFPS(data.Streams[0].FPS),
-		Rotation: detectRotation(
-			data.Streams[0].Tags.Rotate,
-			data.Streams[0].SideDataList,
-		),
-	}
-
-	// audio check
-	aout, _, err := exec.Execute(
-		ctx,
-		"ffprobe",
-		"-v", "error",
-		"-select_streams", "a:0",
-		"-show_entries", "stream=index",
-		"-of", "csv=p=0",
-		input,
-	)
-	_ = err // Ignore audio probe errors
-	info.HasAudio = strings.TrimSpace(string(aout)) != ""
+		Width:          s.Width,
+		Height:         s.Height,
+		FPS:            parseFPS(s.AvgFrameRate),
+		BaseFPS:        parseRational(s.RFrameRate),
+		FieldOrder:     s.FieldOrder,
+		Codec:          s.CodecName,
+		Profile:        s.Profile,
+		Level:          streamLevel(s),
+		Format:         r.Format.FormatName,
+		FormatBitrate:  parseBitrate(r.Format.BitRate),
+		Duration:       parseDuration(r.Format.Duration),
+		Bitrate:        parseBitrate(s.BitRate),
+		ClosedCaptions: s.ClosedCaptions == 1,
+		PixelFormat:    s.PixFmt,
+		ColorSpace:     s.ColorSpace,
+		ColorTransfer:  s.ColorTransfer,
+		ColorPrimaries: s.ColorPrimaries,
+		ColorRange:     s.ColorRange,
+		BitDepth:       parseBitDepth(s.PixFmt, s.BitsPerRawSample),
+		Rotation:       s.Rotation(),
+
+		SampleAspectRatio:  parseAspectRatio(s.SampleAspectRatio),
+		DisplayAspectRatio: parseAspectRatio(s.DisplayAspectRatio),
+	}
+	info.MasteringDisplay, info.ContentLightLevel = parseHDRSideData(s.SideDataList)
+	info.AudioStreams, info.SubtitleStreams = r.streams()
+	info.HasAudio = len(info.AudioStreams) > 0
+
+	// Matroska declares stream bitrates in tags; other containers only for
+	// the whole file.
+	if info.Bitrate == 0 {
+		info.Bitrate = parseBitrate(s.Tags["BPS"])
+	}
+	if info.Bitrate == 0 {
+		info.Bitrate = residualBitrate(info.FormatBitrate, info.AudioStreams)
+	}
 
 	return info, nil
 }
 
+// residualBitrate estimates the video bitrate in kbps as the container
+// bitrate minus the declared audio bitrates.
+func residualBitrate(format int, audio []AudioStream) int {
+	for _, a := range audio {
+		format -= a.Bitrate
+	}
+	return max(format, 0)
+}
+
+// AudioInfo contains technical metadata about an audio-only file, such as a sidecar dub track.
+type AudioInfo struct {
+	// Streams lists every audio stream in the file, in stream order.
+	Streams []AudioStream
+	// Duration is the container duration in seconds, or 0 if unknown.
+	Duration float64
+}
+
+// Audio returns technical metadata for the given audio file or URL.
+// It uses the default command executor to run ffprobe.
+func Audio(ctx context.Context, input string) (AudioInfo, error) {
+	return AudioWithExecutor(ctx, input, executor.DefaultExecutor)
+}
+
+// AudioWithExecutor is like Audio but allows providing a custom CommandExecutor.
+func AudioWithExecutor(ctx context.Context, input string, exec executor.CommandExecutor) (AudioInfo, error) {
+	r, err := RunWithExecutor(ctx, input, exec)
+	if err != nil {
+		return AudioInfo{}, err
+	}
+	return r.AudioInfo()
+}
+
+// AudioInfo describes the audio streams of the result.
+func (r Result) AudioInfo() (AudioInfo, error) {
+	streams, _ := r.streams()
+	if len(streams) == 0 {
+		return AudioInfo{}, fmt.Errorf("no audio stream found")
+	}
+	return AudioInfo{
+		Streams:  streams,
+		Duration: parseDuration(r.Format.Duration),
+	}, nil
+}
+
+// streams splits the result into audio and subtitle streams.
+func (r Result) streams() ([]AudioStream, []SubtitleStream) {
+	var audio []AudioStream
+	var subs []SubtitleStream
+	for _, s := range r.Streams {
+		switch s.CodecType {
+		case "audio":
+			audio = append(audio, AudioStream{
+				Index:         s.Index,
+				Codec:         s.CodecName,
+				Language:      s.Tags["language"],
+				Title:         s.Tags["title"],
+				Channels:      s.Channels,
+				ChannelLayout: s.ChannelLayout,
+				Bitrate:       parseBitrate(s.BitRate),
+				SampleRate:    parseSampleRate(s.SampleRate),
+				Default:       s.Disposition["default"] == 1,
+			})
+		case "subtitle":
+			subs = append(subs, SubtitleStream{
+				Index:           s.Index,
+				Codec:           s.CodecName,
+				Language:        s.Tags["language"],
+				Title:           s.Tags["title"],
+				Default:         s.Disposition["default"] == 1,
+				Forced:          s.Disposition["forced"] == 1,
+				HearingImpaired: s.Disposition["hearing_impaired"] == 1,
+			})
+		}
+	}
+	return audio, subs
+}
+
 func parseFPS(rate string) float64 {
 	parts := strings.Split(rate, "/")
 	if len(parts) != 2 {
@@ -128,26 +482,108 @@ func parseFPS(rate string) float64 {
 	return n / d
 }
 
-func detectRotation(tagRotate string, sideData []struct {
-	Rotation float64 `json:"rotation"`
-}) int {
-	for _, sd := range sideData {
-		return normalizeRotation(int(math.Round(sd.Rotation)))
+func parseDuration(s string) float64 {
+	d, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// parseBitrate converts an ffprobe bitrate in bits per second to kbps.
+// "N/A" and malformed values yield 0.
+func parseBitrate(s string) int {
+	bps, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || bps <= 0 {
+		return 0
+	}
+	return int(math.Round(bps / 1000))
+}
+
+// parseSampleRate parses an ffprobe sample rate in Hz. Malformed values
+// yield 0.
+func parseSampleRate(s string) int {
+	rate, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || rate < 0 {
+		return 0
+	}
+	return rate
+}
+
+// parseAspectRatio parses an ffprobe aspect ratio ("64:45"). "0:1", "N/A"
+// and malformed values yield the zero AspectRatio.
+func parseAspectRatio(s string) AspectRatio {
+	n, d, ok := strings.Cut(s, ":")
+	num, err1 := strconv.Atoi(n)
+	den, err2 := strconv.Atoi(d)
+	if !ok || err1 != nil || err2 != nil || num <= 0 || den <= 0 {
+		return AspectRatio{}
 	}
+	return AspectRatio{Num: num, Den: den}
+}
 
-	if strings.TrimSpace(tagRotate) != "" {
-		if r, err := strconv.Atoi(strings.TrimSpace(tagRotate)); err == nil {
-			return normalizeRotation(r)
+// parseHDRSideData extracts the mastering display and content light level
+// metadata from stream side data.
+func parseHDRSideData(list []SideData) (*MasteringDisplay, *ContentLightLevel) {
+	var md *MasteringDisplay
+	var cll *ContentLightLevel
+	for _, sd := range list {
+		switch sd.SideDataType {
+		case "Mastering display metadata":
+			if sd.MaxLuminance == "" {
+				continue
+			}
+			md = &MasteringDisplay{
+				RedX:         parseRational(sd.RedX),
+				RedY:         parseRational(sd.RedY),
+				GreenX:       parseRational(sd.GreenX),
+				GreenY:       parseRational(sd.GreenY),
+				BlueX:        parseRational(sd.BlueX),
+				BlueY:        parseRational(sd.BlueY),
+				WhiteX:       parseRational(sd.WhitePointX),
+				WhiteY:       parseRational(sd.WhitePointY),
+				MinLuminance: parseRational(sd.MinLuminance),
+				MaxLuminance: parseRational(sd.MaxLuminance),
+			}
+		case "Content light level metadata":
+			cll = &ContentLightLevel{MaxCLL: sd.MaxContent, MaxFALL: sd.MaxAverage}
 		}
 	}
+	return md, cll
+}
 
-	return 0
+// parseRational parses an ffprobe rational ("13250/50000") or plain number.
+func parseRational(s string) float64 {
+	n, d, ok := strings.Cut(s, "/")
+	num, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+	if err != nil {
+		return 0
+	}
+	if !ok {
+		return num
+	}
+	den, err := strconv.ParseFloat(strings.TrimSpace(d), 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
 }
 
-func normalizeRotation(deg int) int {
-	deg = deg % 360
-	if deg < 0 {
-		deg += 360
+var pixFmtDepthPattern = regexp.MustCompile(`p(\d{2})(le|be)?$`)
+
+// parseBitDepth derives the sample bit depth from the pixel format, falling
+// back to bits_per_raw_sample and then to 8.
+func parseBitDepth(pixFmt, bitsPerRawSample string) int {
+	if pixFmt == "p010le" || pixFmt == "p010be" {
+		return 10
+	}
+	if m := pixFmtDepthPattern.FindStringSubmatch(pixFmt); m != nil {
+		if d, err := strconv.Atoi(m[1]); err == nil {
+			return d
+		}
+	}
+	if d, err := strconv.Atoi(bitsPerRawSample); err == nil && d > 0 {
+		return d
 	}
-	return deg
+	return 8
 }
@@ -3,9 +3,9 @@ package probe
 import (
 	"context"
 	"errors"
-	"fmt"
 	"testing"
 
+	"github.com/farshidrezaei/mosaic/config"
 	"github.com/farshidrezaei/mosaic/internal/executor"
 )
 
@@ -20,7 +20,7 @@ func TestInputWithExecutor(t *testing.T) {
 			name: "1080p video with audio",
 			responses: map[string]executor.MockResponse{
 				"ffprobe": {
-					Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`),
+					Output: []byte(`{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1"},{"index":1,"codec_type":"audio","codec_name":"aac","channels":2}]}`),
 					Err:    nil,
 				},
 			},
@@ -28,7 +28,7 @@ func TestInputWithExecutor(t *testing.T) {
 				Width:    1920,
 				Height:   1080,
 				FPS:      30.0,
-				HasAudio: true, // Second call returns non-empty
+				HasAudio: true,
 			},
 			wantErr: false,
 		},
@@ -36,15 +36,17 @@ func TestInputWithExecutor(t *testing.T) {
 			name: "720p video without audio",
 			responses: map[string]executor.MockResponse{
 				"ffprobe": {
-					Output: []byte(`{"streams":[{"width":1280,"height":720,"avg_frame_rate":"25/1"}]}`),
+					Output: []byte(`{"streams":[{"codec_type":"video","width":1280,"height":720,"avg_frame_rate":"25/1","closed_captions":1}],"format":{"duration":"12.500000"}}`),
 					Err:    nil,
 				},
 			},
 			wantInfo: VideoInfo{
-				Width:    1280,
-				Height:   720,
-				FPS:      25.0,
-				HasAudio: false, // No audio stream returned
+				Width:          1280,
+				Height:         720,
+				FPS:            25.0,
+				Duration:       12.5,
+				ClosedCaptions: true,
+				HasAudio:       false, // No audio stream returned
 			},
 			wantErr: false,
 		},
@@ -52,7 +54,7 @@ func TestInputWithExecutor(t *testing.T) {
 			name: "29.97 fps NTSC video",
 			responses: map[string]executor.MockResponse{
 				"ffprobe": {
-					Output: []byte(`{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30000/1001"}]}`),
+					Output: []byte(`{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30000/1001"}]}`),
 					Err:    nil,
 				},
 			},
@@ -98,38 +100,22 @@ func TestInputWithExecutor(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if tt.wantErr {
-				// For error cases, use simple mock that fails
-				mock := executor.NewMockExecutor()
-				mock.Responses = tt.responses
-
-				_, err := InputWithExecutor(context.Background(), "test.mp4", mock)
+			mock := executor.NewMockExecutor()
+			mock.Responses = tt.responses
 
+			gotInfo, err := InputWithExecutor(context.Background(), "test.mp4", mock)
+			if tt.wantErr {
 				if err == nil {
 					t.Error("expected error but got none")
 				}
 				return
 			}
-
-			// For success cases, use custom executor with sequential responses
-			customMock := &customMockExecutor{
-				videoResponse: tt.responses["ffprobe"],
-				audioResponse: executor.MockResponse{
-					Output: []byte("0"), // Audio stream exists by default
-					Err:    nil,
-				},
-			}
-
-			// Adjust audio response based on test case
-			if !tt.wantInfo.HasAudio {
-				customMock.audioResponse.Output = []byte("") // No audio
-			}
-
-			gotInfo, err := InputWithExecutor(context.Background(), "test.mp4", customMock)
-
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
+			if calls := mock.GetCallCount("ffprobe"); calls != 1 {
+				t.Errorf("ffprobe calls: got %d, want 1", calls)
+			}
 
 			if gotInfo.Width != tt.wantInfo.Width {
 				t.Errorf("Width: got %d, want %d", gotInfo.Width, tt.wantInfo.Width)
@@ -143,6 +129,12 @@ func TestInputWithExecutor(t *testing.T) {
 			if gotInfo.HasAudio != tt.wantInfo.HasAudio {
 				t.Errorf("HasAudio: got %v, want %v", gotInfo.HasAudio, tt.wantInfo.HasAudio)
 			}
+			if gotInfo.Duration != tt.wantInfo.Duration {
+				t.Errorf("Duration: got %v, want %v", gotInfo.Duration, tt.wantInfo.Duration)
+			}
+			if gotInfo.ClosedCaptions != tt.wantInfo.ClosedCaptions {
+				t.Errorf("ClosedCaptions: got %v, want %v", gotInfo.ClosedCaptions, tt.wantInfo.ClosedCaptions)
+			}
 		})
 	}
 }
@@ -158,15 +150,23 @@ func TestInputWithExecutorOrientation(t *testing.T) {
 	}{
 		{
 			name:         "rotation in side_data_list",
-			videoJSON:    `{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1","side_data_list":[{"rotation":90}]}]}`,
+			videoJSON:    `{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1","side_data_list":[{"rotation":90}]}]}`,
 			wantRotation: 90,
 			wantPortrait: true,
 			wantDispW:    1080,
 			wantDispH:    1920,
 		},
+		{
+			name:         "rotation after HDR side data",
+			videoJSON:    `{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1","side_data_list":[{"side_data_type":"Content light level metadata","max_content":1000,"max_average":400},{"side_data_type":"Display Matrix","rotation":-90}]}]}`,
+			wantRotation: 270,
+			wantPortrait: true,
+			wantDispW:    1080,
+			wantDispH:    1920,
+		},
 		{
 			name:         "rotation in tags",
-			videoJSON:    `{"streams":[{"width":1920,"height":1080,"avg_frame_rate":"30/1","tags":{"rotate":"-90"}}]}`,
+			videoJSON:    `{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1","tags":{"rotate":"-90"}}]}`,
 			wantRotation: 270,
 			wantPortrait: true,
 			wantDispW:    1080,
@@ -174,7 +174,7 @@ func TestInputWithExecutorOrientation(t *testing.T) {
 		},
 		{
 			name:         "natural portrait without rotation",
-			videoJSON:    `{"streams":[{"width":720,"height":1280,"avg_frame_rate":"30/1"}]}`,
+			videoJSON:    `{"streams":[{"codec_type":"video","width":720,"height":1280,"avg_frame_rate":"30/1"}]}`,
 			wantRotation: 0,
 			wantPortrait: true,
 			wantDispW:    720,
@@ -184,16 +184,10 @@ func TestInputWithExecutorOrientation(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			customMock := &customMockExecutor{
-				videoResponse: executor.MockResponse{
-					Output: []byte(tt.videoJSON),
-				},
-				audioResponse: executor.MockResponse{
-					Output: []byte(""),
-				},
-			}
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffprobe"] = executor.MockResponse{Output: []byte(tt.videoJSON)}
 
-			got, err := InputWithExecutor(context.Background(), "test.mp4", customMock)
+			got, err := InputWithExecutor(context.Background(), "test.mp4", mock)
 			if err != nil {
 				t.Fatalf("unexpected error: %v", err)
 			}
@@ -214,27 +208,399 @@ func TestInputWithExecutorOrientation(t *testing.T) {
 	}
 }
 
-// customMockExecutor handles the two sequential calls (video probe, audio probe)
-type customMockExecutor struct {
-	videoResponse executor.MockResponse
-	audioResponse executor.MockResponse
+func TestInputWithExecutorColor(t *testing.T) {
+	tests := []struct {
+		name          string
+		videoJSON     string
+		wantRange     config.VideoRange
+		wantMastering *MasteringDisplay
+		wantLight     *ContentLightLevel
+		wantDepth     int
+	}{
+		{
+			name:      "SDR",
+			videoJSON: `{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1","pix_fmt":"yuv420p","color_space":"bt709","color_transfer":"bt709","color_primaries":"bt709"}]}`,
+			wantRange: config.VideoRangeSDR,
+			wantDepth: 8,
+		},
+		{
+			name: "HDR10",
+			videoJSON: `{"streams":[{"codec_type":"video","width":3840,"height":2160,"avg_frame_rate":"24/1","pix_fmt":"yuv420p10le","color_range":"tv","color_space":"bt2020nc","color_transfer":"smpte2084","color_primaries":"bt2020","side_data_list":[
+				{"side_data_type":"Mastering display metadata","red_x":"34000/50000","red_y":"16000/50000","green_x":"13250/50000","green_y":"34500/50000","blue_x":"7500/50000","blue_y":"3000/50000","white_point_x":"15635/50000","white_point_y":"16450/50000","min_luminance":"50/10000","max_luminance":"10000000/10000"},
+				{"side_data_type":"Content light level metadata","max_content":1000,"max_average":400}
+			]}]}`,
+			wantRange: config.VideoRangePQ,
+			wantMastering: &MasteringDisplay{
+				RedX: 0.68, RedY: 0.32, GreenX: 0.265, GreenY: 0.69, BlueX: 0.15, BlueY: 0.06,
+				WhiteX: 0.3127, WhiteY: 0.329, MinLuminance: 0.005, MaxLuminance: 1000,
+			},
+			wantLight: &ContentLightLevel{MaxCLL: 1000, MaxFALL: 400},
+			wantDepth: 10,
+		},
+		{
+			name:      "HLG from hardware pixel format",
+			videoJSON: `{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"50/1","pix_fmt":"p010le","color_transfer":"arib-std-b67","color_primaries":"bt2020"}]}`,
+			wantRange: config.VideoRangeHLG,
+			wantDepth: 10,
+		},
+		{
+			name:      "bit depth from raw sample size",
+			videoJSON: `{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"25/1","bits_per_raw_sample":"12"}]}`,
+			wantRange: config.VideoRangeSDR,
+			wantDepth: 12,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffprobe"] = executor.MockResponse{Output: []byte(tt.videoJSON)}
+
+			got, err := InputWithExecutor(context.Background(), "test.mp4", mock)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got.VideoRange() != tt.wantRange {
+				t.Errorf("VideoRange: got %s, want %s", got.VideoRange(), tt.wantRange)
+			}
+			if got.BitDepth != tt.wantDepth {
+				t.Errorf("BitDepth: got %d, want %d", got.BitDepth, tt.wantDepth)
+			}
+			if (got.MasteringDisplay == nil) != (tt.wantMastering == nil) ||
+				got.MasteringDisplay != nil && *got.MasteringDisplay != *tt.wantMastering {
+				t.Errorf("MasteringDisplay: got %+v, want %+v", got.MasteringDisplay, tt.wantMastering)
+			}
+			if (got.ContentLightLevel == nil) != (tt.wantLight == nil) ||
+				got.ContentLightLevel != nil && *got.ContentLightLevel != *tt.wantLight {
+				t.Errorf("ContentLightLevel: got %+v, want %+v", got.ContentLightLevel, tt.wantLight)
+			}
+		})
+	}
 }
 
-func (m *customMockExecutor) Execute(ctx context.Context, name string, args ...string) ([]byte, *executor.Usage, error) {
-	return m.ExecuteWithProgress(ctx, nil, name, args...)
+func TestInputWithExecutorStreamDetails(t *testing.T) {
+	tests := []struct {
+		name      string
+		videoJSON string
+		want      VideoInfo
+	}{
+		{
+			name: "interlaced H.264 in MPEG-TS",
+			videoJSON: `{"streams":[{"codec_type":"video","codec_name":"h264","profile":"High","level":40,"width":1920,"height":1080,"avg_frame_rate":"25/1","r_frame_rate":"50/1","field_order":"tt"}],
+				"format":{"format_name":"mpegts","duration":"60.000000","bit_rate":"8000000"}}`,
+			want: VideoInfo{
+				Codec: "h264", Profile: "High", Level: 40, FPS: 25, BaseFPS: 50, FieldOrder: "tt",
+				Format: "mpegts", FormatBitrate: 8000, Duration: 60,
+			},
+		},
+		{
+			name: "progressive HEVC in MP4",
+			videoJSON: `{"streams":[{"codec_type":"video","codec_name":"hevc","profile":"Main 10","level":153,"width":3840,"height":2160,"avg_frame_rate":"24000/1001","r_frame_rate":"24000/1001","field_order":"progressive"}],
+				"format":{"format_name":"mov,mp4,m4a,3gp,3g2,mj2","bit_rate":"N/A"}}`,
+			want: VideoInfo{
+				Codec: "hevc", Profile: "Main 10", Level: 153, FPS: 24000.0 / 1001, BaseFPS: 24000.0 / 1001, FieldOrder: "progressive",
+				Format: "mov,mp4,m4a,3gp,3g2,mj2",
+			},
+		},
+		{
+			name:      "unknown level and frame rates",
+			videoJSON: `{"streams":[{"codec_type":"video","codec_name":"prores","level":-99,"width":1920,"height":1080,"avg_frame_rate":"0/0","r_frame_rate":"0/0"}]}`,
+			want:      VideoInfo{Codec: "prores", FPS: 30},
+		},
+		{
+			name:      "unknown AV1 level",
+			videoJSON: `{"streams":[{"codec_type":"video","codec_name":"av1","profile":"Main","level":-99,"width":1920,"height":1080,"avg_frame_rate":"30/1"}]}`,
+			want:      VideoInfo{Codec: "av1", Profile: "Main", Level: 31, FPS: 30},
+		},
+		{
+			name:      "AV1 level 2.0",
+			videoJSON: `{"streams":[{"codec_type":"video","codec_name":"av1","profile":"Main","level":0,"width":640,"height":360,"avg_frame_rate":"30/1"}]}`,
+			want:      VideoInfo{Codec: "av1", Profile: "Main", Level: 0, FPS: 30},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffprobe"] = executor.MockResponse{Output: []byte(tt.videoJSON)}
+
+			got, err := InputWithExecutor(context.Background(), "test.mp4", mock)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Codec != tt.want.Codec || got.Profile != tt.want.Profile || got.Level != tt.want.Level {
+				t.Errorf("codec: got %q %q %d, want %q %q %d", got.Codec, got.Profile, got.Level, tt.want.Codec, tt.want.Profile, tt.want.Level)
+			}
+			if got.FPS != tt.want.FPS || got.BaseFPS != tt.want.BaseFPS || got.FieldOrder != tt.want.FieldOrder {
+				t.Errorf("timing: got %v %v %q, want %v %v %q", got.FPS, got.BaseFPS, got.FieldOrder, tt.want.FPS, tt.want.BaseFPS, tt.want.FieldOrder)
+			}
+			if got.Format != tt.want.Format || got.FormatBitrate != tt.want.FormatBitrate || got.Duration != tt.want.Duration {
+				t.Errorf("format: got %q %d %v, want %q %d %v", got.Format, got.FormatBitrate, got.Duration, tt.want.Format, tt.want.FormatBitrate, tt.want.Duration)
+			}
+		})
+	}
 }
 
-func (m *customMockExecutor) ExecuteWithProgress(ctx context.Context, progress chan<- string, name string, args ...string) ([]byte, *executor.Usage, error) {
-	if progress != nil {
-		close(progress)
+func TestInputWithExecutorBitrate(t *testing.T) {
+	tests := []struct {
+		name      string
+		videoJSON string
+		want      int
+	}{
+		{
+			name:      "stream bitrate",
+			videoJSON: `{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1","bit_rate":"1500000"}],"format":{"bit_rate":"1700000"}}`,
+			want:      1500,
+		},
+		{
+			name:      "matroska BPS tag",
+			videoJSON: `{"streams":[{"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1","tags":{"BPS":"2499600"}}],"format":{"bit_rate":"2700000"}}`,
+			want:      2500,
+		},
+		{
+			name: "container minus audio",
+			videoJSON: `{"streams":[{"codec_type":"video","width":1280,"height":720,"avg_frame_rate":"25/1","bit_rate":"N/A"},
+				{"index":1,"codec_type":"audio","codec_name":"aac","bit_rate":"128000"},{"index":2,"codec_type":"audio","codec_name":"ac3","bit_rate":"N/A"}],"format":{"bit_rate":"1256000"}}`,
+			want: 1128,
+		},
+		{
+			name:      "unknown",
+			videoJSON: `{"streams":[{"codec_type":"video","width":1280,"height":720,"avg_frame_rate":"25/1"}]}`,
+			want:      0,
+		},
 	}
-	for _, arg := range args {
-		if arg == "v:0" {
-			return m.videoResponse.Output, m.videoResponse.Usage, m.videoResponse.Err
-		}
-		if arg == "a:0" {
-			return m.audioResponse.Output, m.audioResponse.Usage, m.audioResponse.Err
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffprobe"] = executor.MockResponse{Output: []byte(tt.videoJSON)}
+
+			got, err := InputWithExecutor(context.Background(), "test.mp4", mock)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.Bitrate != tt.want {
+				t.Errorf("Bitrate: got %d, want %d", got.Bitrate, tt.want)
+			}
+		})
+	}
+}
+
+func TestInputWithExecutorAspectRatio(t *testing.T) {
+	tests := []struct {
+		name          string
+		videoJSON     string
+		wantSAR       AspectRatio
+		wantDAR       AspectRatio
+		displayWidth  int
+		displayHeight int
+	}{
+		{
+			name:          "anamorphic PAL widescreen",
+			videoJSON:     `{"streams":[{"codec_type":"video","width":720,"height":576,"sample_aspect_ratio":"64:45","display_aspect_ratio":"16:9","avg_frame_rate":"25/1"}]}`,
+			wantSAR:       AspectRatio{Num: 64, Den: 45},
+			wantDAR:       AspectRatio{Num: 16, Den: 9},
+			displayWidth:  1024,
+			displayHeight: 576,
+		},
+		{
+			name:          "NTSC 4:3",
+			videoJSON:     `{"streams":[{"codec_type":"video","width":720,"height":480,"sample_aspect_ratio":"8:9","display_aspect_ratio":"4:3","avg_frame_rate":"30000/1001"}]}`,
+			wantSAR:       AspectRatio{Num: 8, Den: 9},
+			wantDAR:       AspectRatio{Num: 4, Den: 3},
+			displayWidth:  640,
+			displayHeight: 480,
+		},
+		{
+			name:          "display aspect ratio only",
+			videoJSON:     `{"streams":[{"codec_type":"video","width":1440,"height":1080,"sample_aspect_ratio":"0:1","display_aspect_ratio":"16:9","avg_frame_rate":"25/1"}]}`,
+			wantDAR:       AspectRatio{Num: 16, Den: 9},
+			displayWidth:  1920,
+			displayHeight: 1080,
+		},
+		{
+			name:          "rotated anamorphic",
+			videoJSON:     `{"streams":[{"codec_type":"video","width":1440,"height":1080,"sample_aspect_ratio":"4:3","avg_frame_rate":"25/1","tags":{"rotate":"90"}}]}`,
+			wantSAR:       AspectRatio{Num: 4, Den: 3},
+			displayWidth:  1080,
+			displayHeight: 1920,
+		},
+		{
+			name:          "square pixels",
+			videoJSON:     `{"streams":[{"codec_type":"video","width":1920,"height":1080,"sample_aspect_ratio":"1:1","display_aspect_ratio":"16:9","avg_frame_rate":"30/1"}]}`,
+			wantSAR:       AspectRatio{Num: 1, Den: 1},
+			wantDAR:       AspectRatio{Num: 16, Den: 9},
+			displayWidth:  1920,
+			displayHeight: 1080,
+		},
+		{
+			name:          "unknown",
+			videoJSON:     `{"streams":[{"codec_type":"video","width":1280,"height":720,"sample_aspect_ratio":"N/A","avg_frame_rate":"25/1"}]}`,
+			displayWidth:  1280,
+			displayHeight: 720,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffprobe"] = executor.MockResponse{Output: []byte(tt.videoJSON)}
+
+			got, err := InputWithExecutor(context.Background(), "test.mp4", mock)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.SampleAspectRatio != tt.wantSAR || got.DisplayAspectRatio != tt.wantDAR {
+				t.Errorf("aspect ratios: got SAR %v DAR %v, want SAR %v DAR %v",
+					got.SampleAspectRatio, got.DisplayAspectRatio, tt.wantSAR, tt.wantDAR)
+			}
+			if got.DisplayWidth() != tt.displayWidth || got.DisplayHeight() != tt.displayHeight {
+				t.Errorf("display size: got %dx%d, want %dx%d",
+					got.DisplayWidth(), got.DisplayHeight(), tt.displayWidth, tt.displayHeight)
+			}
+		})
+	}
+}
+
+func TestInputWithExecutorAudioStreams(t *testing.T) {
+	const video = `{"index":0,"codec_type":"video","width":1920,"height":1080,"avg_frame_rate":"30/1"}`
+	tests := []struct {
+		name      string
+		streams   string
+		want      []AudioStream
+		wantAudio bool
+	}{
+		{
+			name: "multiple languages",
+			streams: video + `,
+				{"index":1,"codec_type":"audio","codec_name":"aac","channels":2,"channel_layout":"stereo","sample_rate":"48000","tags":{"language":"eng","title":"English"},"disposition":{"default":1}},
+				{"index":2,"codec_type":"audio","codec_name":"ac3","channels":6,"channel_layout":"5.1(side)","sample_rate":"N/A","tags":{"language":"spa"},"disposition":{"default":0}}`,
+			want: []AudioStream{
+				{Index: 1, Codec: "aac", Language: "eng", Title: "English", Channels: 2, ChannelLayout: "stereo", SampleRate: 48000, Default: true},
+				{Index: 2, Codec: "ac3", Language: "spa", Channels: 6, ChannelLayout: "5.1(side)"},
+			},
+			wantAudio: true,
+		},
+		{
+			name:    "no audio",
+			streams: video,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffprobe"] = executor.MockResponse{Output: []byte(`{"streams":[` + tt.streams + `]}`)}
+
+			got, err := InputWithExecutor(context.Background(), "test.mp4", mock)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got.HasAudio != tt.wantAudio {
+				t.Errorf("HasAudio: got %v, want %v", got.HasAudio, tt.wantAudio)
+			}
+			if len(got.AudioStreams) != len(tt.want) {
+				t.Fatalf("AudioStreams: got %d, want %d", len(got.AudioStreams), len(tt.want))
+			}
+			for i, a := range got.AudioStreams {
+				if a != tt.want[i] {
+					t.Errorf("audio stream %d mismatch:\nexpected: %+v\ngot:      %+v", i, tt.want[i], a)
+				}
+			}
+		})
+	}
+}
+
+func TestInputWithExecutorSubtitleStreams(t *testing.T) {
+	mock := executor.NewMockExecutor()
+	mock.Responses["ffprobe"] = executor.MockResponse{Output: []byte(`{"streams":[
+		{"index":0,"codec_type":"video","codec_name":"h264","width":1920,"height":1080,"avg_frame_rate":"30/1"},
+		{"index":1,"codec_type":"audio","codec_name":"aac","channels":2},
+		{"index":2,"codec_type":"subtitle","codec_name":"subrip","tags":{"language":"eng"},"disposition":{"default":1,"forced":0,"hearing_impaired":1}},
+		{"index":3,"codec_type":"subtitle","codec_name":"hdmv_pgs_subtitle","tags":{"language":"fra","title":"Forced"},"disposition":{"default":0,"forced":1,"hearing_impaired":0}}
+	]}`)}
+
+	got, err := InputWithExecutor(context.Background(), "test.mkv", mock)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(got.AudioStreams) != 1 {
+		t.Errorf("AudioStreams: got %d, want 1", len(got.AudioStreams))
+	}
+	want := []SubtitleStream{
+		{Index: 2, Codec: "subrip", Language: "eng", Default: true, HearingImpaired: true},
+		{Index: 3, Codec: "hdmv_pgs_subtitle", Language: "fra", Title: "Forced", Forced: true},
+	}
+	if len(got.SubtitleStreams) != len(want) {
+		t.Fatalf("SubtitleStreams: got %d, want %d", len(got.SubtitleStreams), len(want))
+	}
+	for i, s := range got.SubtitleStreams {
+		if s != want[i] {
+			t.Errorf("subtitle stream %d mismatch:\nexpected: %+v\ngot:      %+v", i, want[i], s)
 		}
 	}
-	return nil, nil, fmt.Errorf("unexpected call to Execute: %s %v", name, args)
+	if !got.SubtitleStreams[0].IsText() || got.SubtitleStreams[1].IsText() {
+		t.Error("expected subrip to be text and PGS to be bitmap")
+	}
+}
+
+func TestAudioWithExecutor(t *testing.T) {
+	tests := []struct {
+		name         string
+		response     executor.MockResponse
+		wantStreams  int
+		wantDuration float64
+		wantErr      bool
+	}{
+		{
+			name:         "audio file",
+			response:     executor.MockResponse{Output: []byte(`{"streams":[{"index":0,"codec_type":"audio","codec_name":"pcm_s16le","channels":2}],"format":{"duration":"61.440000"}}`)},
+			wantStreams:  1,
+			wantDuration: 61.44,
+		},
+		{
+			name:        "unknown duration",
+			response:    executor.MockResponse{Output: []byte(`{"streams":[{"index":0,"codec_type":"audio","codec_name":"aac","channels":2}],"format":{"duration":"N/A"}}`)},
+			wantStreams: 1,
+		},
+		{
+			name:     "no audio stream",
+			response: executor.MockResponse{Output: []byte(`{"streams":[],"format":{"duration":"10.0"}}`)},
+			wantErr:  true,
+		},
+		{
+			name:     "ffprobe fails",
+			response: executor.MockResponse{Err: errors.New("ffprobe failed")},
+			wantErr:  true,
+		},
+		{
+			name:     "malformed output",
+			response: executor.MockResponse{Output: []byte("not json")},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := executor.NewMockExecutor()
+			mock.Responses["ffprobe"] = tt.response
+
+			got, err := AudioWithExecutor(context.Background(), "dub.wav", mock)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AudioWithExecutor() err=%v wantErr=%v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got.Streams) != tt.wantStreams {
+				t.Errorf("Streams: got %d, want %d", len(got.Streams), tt.wantStreams)
+			}
+			if got.Duration != tt.wantDuration {
+				t.Errorf("Duration: got %v, want %v", got.Duration, tt.wantDuration)
+			}
+		})
+	}
 }
@@ -2,6 +2,7 @@ package probe
 
 import (
 	"context"
+	"fmt"
 	"testing"
 )
 
@@ -33,6 +34,66 @@ func TestParseFPS(t *testing.T) {
 	}
 }
 
+func TestLevelName(t *testing.T) {
+	tests := []struct {
+		codec string
+		level int
+		want  string
+	}{
+		{"h264", 41, "4.1"},
+		{"h264", 30, "3.0"},
+		{"h264", 9, "1b"},
+		{"hevc", 120, "4"},
+		{"hevc", 123, "4.1"},
+		{"hevc", 186, "6.2"},
+		{"av1", 8, "4.0"},
+		{"av1", 13, "5.1"},
+		{"av1", 0, "2.0"},
+		{"av1", 31, ""},
+		{"vp9", 41, "41"},
+		{"h264", 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d", tt.codec, tt.level), func(t *testing.T) {
+			if got := (VideoInfo{Codec: tt.codec, Level: tt.level}).LevelName(); got != tt.want {
+				t.Errorf("LevelName() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterlaced(t *testing.T) {
+	for order, want := range map[string]bool{"progressive": false, "": false, "unknown": false, "tt": true, "bb": true, "tb": true, "bt": true} {
+		if got := (VideoInfo{FieldOrder: order}).Interlaced(); got != want {
+			t.Errorf("Interlaced(%q) = %v, want %v", order, got, want)
+		}
+	}
+}
+
+func TestVariableFrameRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		fps     float64
+		baseFPS float64
+		want    bool
+	}{
+		{"constant", 30, 30, false},
+		{"NTSC rounding", 29.97, 30000.0 / 1001, false},
+		{"phone recording", 29.2, 30, true},
+		{"screen capture", 12.5, 60, true},
+		{"unknown base rate", 29.2, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := (VideoInfo{FPS: tt.fps, BaseFPS: tt.baseFPS}).VariableFrameRate(); got != tt.want {
+				t.Errorf("VariableFrameRate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestInput(t *testing.T) {
 	// This test verifies the wrapper function delegates to InputWithExecutor
 	// Will fail without real ffprobe, which is expected